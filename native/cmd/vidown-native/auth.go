@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/job"
+)
+
+// requestTarget reads a message's url and headers, turning its username
+// and password (or credentials embedded in the URL) into an Authorization
+// header. The credentials never stay in the URL, which ffmpeg and our own
+// logs print.
+func requestTarget(msg ipc.Msg) (string, map[string]string) {
+	url := ipc.GetString(msg, "url")
+	headers := ipc.GetStringMap(ipc.GetMap(msg, "headers"))
+
+	url, user, password, embedded := fetch.StripUserinfo(url)
+	if _, ok := msg["username"]; ok {
+		user = ipc.GetString(msg, "username")
+		password = ipc.GetString(msg, "password")
+	} else if !embedded {
+		return url, headers
+	}
+	return url, fetch.WithBasicAuth(headers, user, password)
+}
+
+// probeErrorCode tells a server refusing credentials apart from other
+// probe failures
+func probeErrorCode(err error) string {
+	if job.IsUnauthorized(err) {
+		return "auth_required"
+	}
+	return "probe_failed"
+}
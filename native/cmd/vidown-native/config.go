@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/job"
+)
+
+// hostConfig holds host-wide settings changed through setConfig
+type hostConfig struct {
+	mu sync.Mutex
+
+	// Keep running active jobs after the extension's port drops so a new
+	// host process can reattach to them
+	keepAliveOnDisconnect bool
+
+	// Directory bare output filenames are placed in, overriding the
+	// platform's Downloads folder (for users who relocated it)
+	downloadsDir string
+
+	// Shut down after this long without messages or active jobs
+	// (0 = never)
+	idleTimeoutDur time.Duration
+
+	// How long the rest of a message may take once its first bytes
+	// arrived (0 = no deadline)
+	readTimeoutDur time.Duration
+
+	// Number of finished jobs kept for the history command
+	historySize int
+
+	// Where downloads and conversions write their temp files before the
+	// move to the output (empty = next to the output)
+	scratch job.ScratchDirs
+
+	// Folders besides the downloads folder that local-file commands such
+	// as convertFile may read from
+	libraryDirs []string
+
+	// Leftover temp files older than this are reported as stale
+	staleAge time.Duration
+
+	// Create a missing parent directory of a job's output instead of
+	// failing with parent_missing (jobs can override it)
+	createParentDirs bool
+
+	// Caps on how much of a remote file a probe reads and analyzes when
+	// the probe message doesn't set its own (0 = ffprobe's default)
+	probeSizeBytes  int64
+	probeAnalyzeDur time.Duration
+
+	// How often a probe failing transiently (timeout, 5xx, dropped
+	// connection) is tried again when the probe message doesn't say
+	probeRetries int
+}
+
+var config = &hostConfig{
+	readTimeoutDur: 30 * time.Second,
+	historySize:    job.DefaultHistorySize,
+	staleAge:       job.DefaultStaleAge,
+
+	probeSizeBytes:  2 << 20,
+	probeAnalyzeDur: 5 * time.Second,
+	probeRetries:    2,
+}
+
+func (c *hostConfig) keepAlive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keepAliveOnDisconnect
+}
+
+func (c *hostConfig) idleTimeout() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idleTimeoutDur
+}
+
+func (c *hostConfig) readTimeout() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readTimeoutDur
+}
+
+func (c *hostConfig) historyLimit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.historySize
+}
+
+func (c *hostConfig) scratchDirs() job.ScratchDirs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scratch
+}
+
+func (c *hostConfig) libraries() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.libraryDirs...)
+}
+
+func (c *hostConfig) staleAfter() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.staleAge
+}
+
+func (c *hostConfig) createDirs() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.createParentDirs
+}
+
+// probeRetryCount returns how often a failed probe is retried by default
+func (c *hostConfig) probeRetryCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probeRetries
+}
+
+// probeLimits returns the default probe caps for network inputs
+func (c *hostConfig) probeLimits() (int64, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probeSizeBytes, c.probeAnalyzeDur
+}
+
+func (c *hostConfig) downloadsDirectory() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.downloadsDir
+}
+
+// apply updates the settings present in msg, leaving others untouched.
+// Nothing is changed if any setting is invalid.
+func (c *hostConfig) apply(msg ipc.Msg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	downloadsDir := c.downloadsDir
+	if _, ok := msg["downloadsDir"]; ok {
+		downloadsDir = ipc.GetString(msg, "downloadsDir")
+		if downloadsDir != "" {
+			if !filepath.IsAbs(downloadsDir) {
+				return fmt.Errorf("downloadsDir must be an absolute path")
+			}
+			if info, err := os.Stat(downloadsDir); err != nil || !info.IsDir() {
+				return fmt.Errorf("downloadsDir %s is not a directory", downloadsDir)
+			}
+		}
+	}
+
+	idleTimeout := c.idleTimeoutDur
+	if _, ok := msg["idleTimeoutSec"]; ok {
+		sec := ipc.GetFloat64(msg, "idleTimeoutSec")
+		if sec < 0 {
+			return fmt.Errorf("idleTimeoutSec must be >= 0")
+		}
+		idleTimeout = time.Duration(sec * float64(time.Second))
+	}
+
+	readTimeout := c.readTimeoutDur
+	if _, ok := msg["readTimeoutSec"]; ok {
+		sec := ipc.GetFloat64(msg, "readTimeoutSec")
+		if sec < 0 {
+			return fmt.Errorf("readTimeoutSec must be >= 0")
+		}
+		readTimeout = time.Duration(sec * float64(time.Second))
+	}
+
+	historySize := c.historySize
+	if _, ok := msg["historySize"]; ok {
+		historySize = int(ipc.GetInt64(msg, "historySize"))
+		if historySize < 0 {
+			return fmt.Errorf("historySize must be >= 0")
+		}
+	}
+
+	staleAge := c.staleAge
+	if _, ok := msg["staleAfterHours"]; ok {
+		hours := ipc.GetFloat64(msg, "staleAfterHours")
+		if hours <= 0 {
+			return fmt.Errorf("staleAfterHours must be > 0")
+		}
+		staleAge = time.Duration(hours * float64(time.Hour))
+	}
+
+	probeSize := c.probeSizeBytes
+	if _, ok := msg["probeSizeBytes"]; ok {
+		probeSize = ipc.GetInt64(msg, "probeSizeBytes")
+		if probeSize != 0 && probeSize < 32 {
+			return fmt.Errorf("probeSizeBytes must be 0 or >= 32")
+		}
+	}
+
+	probeAnalyze := c.probeAnalyzeDur
+	if _, ok := msg["probeAnalyzeSec"]; ok {
+		sec := ipc.GetFloat64(msg, "probeAnalyzeSec")
+		if sec < 0 {
+			return fmt.Errorf("probeAnalyzeSec must be >= 0")
+		}
+		probeAnalyze = time.Duration(sec * float64(time.Second))
+	}
+
+	probeRetries := c.probeRetries
+	if _, ok := msg["probeRetries"]; ok {
+		probeRetries = int(ipc.GetInt64(msg, "probeRetries"))
+		if probeRetries < 0 || probeRetries > ff.MaxProbeRetries {
+			return fmt.Errorf("probeRetries must be between 0 and %d", ff.MaxProbeRetries)
+		}
+	}
+
+	scratch := c.scratch
+	for key, dir := range map[string]*string{
+		"downloadScratchDir": &scratch.Download,
+		"convertScratchDir":  &scratch.Convert,
+	} {
+		if _, ok := msg[key]; !ok {
+			continue
+		}
+		*dir = ipc.GetString(msg, key)
+		if *dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(*dir) {
+			return fmt.Errorf("%s must be an absolute path", key)
+		}
+		if err := fsutil.CheckWritable(*dir); err != nil {
+			return fmt.Errorf("%s %s is not writable: %v", key, *dir, err)
+		}
+	}
+
+	libraryDirs := c.libraryDirs
+	if _, ok := msg["libraryDirs"]; ok {
+		libraryDirs = ipc.GetStrings(msg, "libraryDirs")
+		for _, dir := range libraryDirs {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("libraryDirs entries must be absolute paths")
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				return fmt.Errorf("library dir %s is not a directory", dir)
+			}
+		}
+	}
+
+	var ffmpegEnv map[string]string
+	if _, ok := msg["ffmpegEnv"]; ok {
+		envMap := ipc.GetMap(msg, "ffmpegEnv")
+		for k, v := range envMap {
+			if _, ok := v.(string); !ok || k == "" || strings.ContainsAny(k, "=\x00") {
+				return fmt.Errorf("ffmpegEnv must map variable names to strings")
+			}
+		}
+		ffmpegEnv = ipc.GetStringMap(envMap)
+	}
+
+	logs, err := logSettings(msg, logWriter.Settings())
+	if err != nil {
+		return err
+	}
+	if err := logWriter.Configure(logs); err != nil {
+		return fmt.Errorf("can't open log file %s: %v", logs.Path, err)
+	}
+
+	if ffmpegEnv != nil {
+		ff.SetBaseEnv(ffmpegEnv)
+	}
+	if _, ok := msg["keepAliveOnDisconnect"]; ok {
+		c.keepAliveOnDisconnect = ipc.GetBool(msg, "keepAliveOnDisconnect")
+	}
+	if _, ok := msg["createParentDirs"]; ok {
+		c.createParentDirs = ipc.GetBool(msg, "createParentDirs")
+	}
+	c.downloadsDir = downloadsDir
+	c.idleTimeoutDur = idleTimeout
+	c.readTimeoutDur = readTimeout
+	c.historySize = historySize
+	c.staleAge = staleAge
+	c.probeSizeBytes = probeSize
+	c.probeAnalyzeDur = probeAnalyze
+	c.probeRetries = probeRetries
+	c.scratch = scratch
+	c.libraryDirs = libraryDirs
+
+	return nil
+}
+
+func (c *hostConfig) msg() ipc.Msg {
+	logs := logWriter.Settings()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ipc.Msg{
+		"type":                  "config",
+		"keepAliveOnDisconnect": c.keepAliveOnDisconnect,
+		"downloadsDir":          c.downloadsDir,
+		"idleTimeoutSec":        c.idleTimeoutDur.Seconds(),
+		"readTimeoutSec":        c.readTimeoutDur.Seconds(),
+		"historySize":           c.historySize,
+		"staleAfterHours":       c.staleAge.Hours(),
+		"createParentDirs":      c.createParentDirs,
+		"probeSizeBytes":        c.probeSizeBytes,
+		"probeAnalyzeSec":       c.probeAnalyzeDur.Seconds(),
+		"probeRetries":          c.probeRetries,
+		"downloadScratchDir":    c.scratch.Download,
+		"convertScratchDir":     c.scratch.Convert,
+		"libraryDirs":           append([]string{}, c.libraryDirs...),
+		"logFile":               logs.Path,
+		"logMaxSizeMB":          float64(logs.MaxSize) / (1024 * 1024),
+		"logKeep":               logs.Keep,
+		"ffmpegEnv":             ff.BaseEnv(),
+	}
+}
+
+func handleSetConfig(msg ipc.Msg, jobManager *job.Manager) {
+	if err := config.apply(msg); err != nil {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"code": "invalid_config",
+			"msg":  err.Error(),
+		})
+		return
+	}
+
+	jobManager.SetHistorySize(config.historyLimit())
+	jobManager.SetScratchDirs(config.scratchDirs())
+	ipc.Send(config.msg())
+}
+
+// createDirs is whether a job creates its output's missing parent: as the
+// message says, else the host setting
+func createDirs(msg ipc.Msg) bool {
+	if _, ok := msg["createDirs"]; ok {
+		return ipc.GetBool(msg, "createDirs")
+	}
+	return config.createDirs()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/job"
+)
+
+// handleConvertFile re-encodes a finished download from the user's library
+// into a new file, through the same job pipeline as downloads (progress,
+// output validation, atomic rename) but without touching the network
+func handleConvertFile(msg ipc.Msg, jobManager *job.Manager) {
+	id := ipc.GetString(msg, "id")
+
+	sendErr := func(code string, err error) {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": code,
+			"msg":  err.Error(),
+		})
+	}
+
+	input, err := checkLocalInput(ipc.GetString(msg, "input"))
+	if err != nil {
+		sendErr("invalid_input", err)
+		return
+	}
+
+	convert := job.ParseConvertOpts(ipc.GetMap(msg, "convert"))
+	if convert == nil || convert.Container == "copy" {
+		sendErr("invalid_convert", fmt.Errorf("convertFile needs a target container"))
+		return
+	}
+
+	// Default to the input's name with the new container's extension
+	out := ipc.GetString(msg, "out")
+	if out == "" {
+		out = ff.ReplaceExt(input, convert.Container)
+	}
+	out = resolveOutput(out)
+	if filepath.Clean(out) == input {
+		sendErr("invalid_output", fmt.Errorf("output would overwrite the input"))
+		return
+	}
+
+	log.Printf("[NATIVE] Starting conversion: id=%s, input=%s, out=%s", id, input, out)
+	jobManager.Start(&job.Job{
+		ID:       id,
+		Mode:     "convert",
+		URL:      input,
+		Out:      out,
+		Convert:  convert,
+		Debug:    ipc.GetBool(msg, "debug"),
+		Priority: ipc.GetString(msg, "priority"),
+		Context:  jobContext(msg),
+
+		ExitPolicy: ipc.GetString(msg, "exitPolicy"),
+		OnExisting: ipc.GetString(msg, "onExisting"),
+
+		KeepTempOnError: ipc.GetBool(msg, "keepTempOnError"),
+		Preallocate:     ipc.GetBool(msg, "preallocate"),
+	})
+}
+
+// handleTranscodeEstimate predicts how long a conversion would take and
+// how big its output would be, by encoding a short sample of the source
+// (a local input from the library, or a url) with the convert options
+func handleTranscodeEstimate(msg ipc.Msg) {
+	id := ipc.GetString(msg, "id")
+
+	sendErr := func(code string, err error) {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": code,
+			"msg":  err.Error(),
+		})
+	}
+
+	var source string
+	var headers map[string]string
+	if _, ok := msg["input"]; ok {
+		input, err := checkLocalInput(ipc.GetString(msg, "input"))
+		if err != nil {
+			sendErr("invalid_input", err)
+			return
+		}
+		source = input
+	} else {
+		source, headers = requestTarget(msg)
+	}
+
+	convert := job.ParseConvertOpts(ipc.GetMap(msg, "convert"))
+	if convert == nil || convert.Container == "copy" {
+		sendErr("invalid_convert", fmt.Errorf("transcodeEstimate needs a target container"))
+		return
+	}
+	err := ff.ValidateConvert(convert.Container, convert.VCodec, convert.ACodec)
+	if err == nil {
+		err = ff.ValidateThreads(convert.Threads)
+	}
+	if err != nil {
+		sendErr("invalid_convert", err)
+		return
+	}
+
+	sample := time.Duration(ipc.GetFloat64(msg, "benchmarkSec") * float64(time.Second))
+	estimate, err := ff.EstimateTranscode(context.Background(), source, headers, convert.Container, convert.VCodec, convert.ACodec, convert.Threads, sample)
+	if err != nil {
+		sendErr("estimate_failed", err)
+		return
+	}
+
+	log.Printf("[NATIVE] Transcode estimate for %s: %.1fx realtime, ~%.0fs, ~%d bytes",
+		id, estimate.Speed, estimate.EstimatedSec, estimate.EstimatedBytes)
+	ipc.Send(ipc.Msg{
+		"type":     "transcode-estimate",
+		"id":       id,
+		"estimate": estimate,
+	})
+}
+
+// handleCanConvert tells whether the local ffmpeg build can run a
+// conversion, so the extension can gray out targets it can't, with the
+// reasons when it can't. The source is described by its codecs ("source":
+// {"vcodec", "acodec"}, ffprobe names), or probed from a local input or
+// url; without one, copied codecs aren't checked.
+func handleCanConvert(msg ipc.Msg) {
+	id := ipc.GetString(msg, "id")
+
+	sendErr := func(code string, err error) {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": code,
+			"msg":  err.Error(),
+		})
+	}
+
+	convert := job.ParseConvertOpts(ipc.GetMap(msg, "convert"))
+	if convert == nil || convert.Container == "copy" {
+		sendErr("invalid_convert", fmt.Errorf("canConvert needs a target container"))
+		return
+	}
+
+	var streams []ff.ProbeStream
+	switch {
+	case msg["source"] != nil:
+		src := ipc.GetMap(msg, "source")
+		streams = []ff.ProbeStream{}
+		if v := ipc.GetString(src, "vcodec"); v != "" {
+			streams = append(streams, ff.ProbeStream{CodecType: "video", CodecName: v})
+		}
+		if a := ipc.GetString(src, "acodec"); a != "" {
+			streams = append(streams, ff.ProbeStream{CodecType: "audio", CodecName: a})
+		}
+
+	case msg["input"] != nil || msg["url"] != nil:
+		var source string
+		var headers map[string]string
+		if _, ok := msg["input"]; ok {
+			input, err := checkLocalInput(ipc.GetString(msg, "input"))
+			if err != nil {
+				sendErr("invalid_input", err)
+				return
+			}
+			source = input
+		} else {
+			source, headers = requestTarget(msg)
+		}
+		probe, err := ff.ProbeURL(source, headers)
+		if err != nil {
+			sendErr(probeErrorCode(err), err)
+			return
+		}
+		streams = probe.Streams
+	}
+
+	problems := ff.CheckConvert(convert.Container, convert.VCodec, convert.ACodec, streams)
+	if problems == nil {
+		problems = []ff.ConvertProblem{}
+	}
+	ipc.Send(ipc.Msg{
+		"type":    "can-convert",
+		"id":      id,
+		"ok":      len(problems) == 0,
+		"reasons": problems,
+	})
+}
+
+// checkLocalInput resolves a local file a command wants to read and checks
+// it against the allow-list: the downloads folder and the configured
+// library folders. Symlinks are resolved first so they can't point out.
+func checkLocalInput(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("no input path given")
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("input must be an absolute path")
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("input %s: %w", path, err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("input %s is not a regular file", path)
+	}
+
+	for _, dir := range inputRoots() {
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("input %s is outside the downloads and library folders", path)
+}
+
+// inputRoots are the folders local inputs may come from: the downloads
+// folder and the configured library folders
+func inputRoots() []string {
+	return append([]string{getDownloadsDir()}, config.libraries()...)
+}
+
+// handleCanDecode tells the extension whether the local ffmpeg build can
+// decode a codec, named as in probe results, before it downloads a stream
+// the build couldn't play or convert
+func handleCanDecode(msg ipc.Msg) {
+	id := ipc.GetString(msg, "id")
+	codec := strings.ToLower(ipc.GetString(msg, "codec"))
+	if codec == "" {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": "invalid_codec",
+			"msg":  "canDecode needs a codec",
+		})
+		return
+	}
+
+	decoders := ff.Decoders(codec)
+	software, hardware := false, false
+	for _, d := range decoders {
+		software = software || !d.Hardware
+		hardware = hardware || d.Hardware
+	}
+	ipc.Send(ipc.Msg{
+		"type":     "can-decode",
+		"id":       id,
+		"codec":    codec,
+		"ok":       len(decoders) > 0,
+		"software": software,
+		"hardware": hardware,
+		"decoders": append([]ff.Decoder{}, decoders...),
+	})
+}
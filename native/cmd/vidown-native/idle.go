@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/job"
+)
+
+// idleCheckInterval is how often the idle timeout is evaluated
+const idleCheckInterval = time.Second
+
+// idleTracker records the last sign of use: a received message (including
+// heartbeats) or a running job
+type idleTracker struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newIdleTracker() *idleTracker {
+	return &idleTracker{last: time.Now()}
+}
+
+func (t *idleTracker) touch() {
+	t.mu.Lock()
+	t.last = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *idleTracker) idleFor() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.last)
+}
+
+// watch calls shutdown once the host has been unused for the configured
+// idle timeout, for the main loop to return as on a shutdown message.
+// Active jobs keep the host alive and restart the clock.
+func (t *idleTracker) watch(jobManager *job.Manager, shutdown func()) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if jobManager.Active() > 0 {
+			t.touch()
+			continue
+		}
+
+		timeout := config.idleTimeout()
+		if timeout <= 0 {
+			continue
+		}
+
+		if idle := t.idleFor(); idle >= timeout {
+			log.Printf("[NATIVE] Idle for %s, shutting down", idle.Round(time.Second))
+			ipc.Send(ipc.Msg{
+				"type":   "shutdown",
+				"reason": "idle",
+			})
+			shutdown()
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/logfile"
+	"github.com/thecturner/vidown-native/internal/state"
+)
+
+// logWriter mirrors the log to a rotating file once one is configured
+var logWriter = logfile.New()
+
+// setupLogging sends the log to stderr plus the log file requested by
+// launch flags, which can also be set up later through setConfig:
+//
+//	--log-file[=path]  mirror the log (default path in the state dir)
+//	--log-max-mb=N     rotate at N MB
+//	--log-keep=N       keep N rotated files
+//
+// Unknown arguments are ignored; the browser passes its own.
+func setupLogging(args []string) {
+	log.SetOutput(io.MultiWriter(os.Stderr, logWriter))
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+
+	settings := logfile.Settings{MaxSize: logfile.DefaultMaxSize, Keep: logfile.DefaultKeep}
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--log-file":
+			settings.Path = value
+			if !hasValue || value == "" {
+				settings.Path = defaultLogPath()
+			}
+		case "--log-max-mb":
+			if mb, err := strconv.ParseFloat(value, 64); err == nil && mb > 0 {
+				settings.MaxSize = int64(mb * 1024 * 1024)
+			}
+		case "--log-keep":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				settings.Keep = n
+			}
+		}
+	}
+
+	if settings.Path != "" {
+		if err := logWriter.Configure(settings); err != nil {
+			log.Printf("[NATIVE] Can't open log file %s: %v", settings.Path, err)
+		}
+	}
+}
+
+// defaultLogPath is the log file in the host's state dir
+func defaultLogPath() string {
+	path, err := state.Path("vidown-native.log")
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// logSettings works out the log file settings a setConfig message asks for.
+// logFile is a path, true for the default path, or ""/false to stop.
+func logSettings(msg ipc.Msg, current logfile.Settings) (logfile.Settings, error) {
+	settings := current
+	if settings.MaxSize == 0 {
+		// Never configured
+		settings = logfile.Settings{MaxSize: logfile.DefaultMaxSize, Keep: logfile.DefaultKeep}
+	}
+
+	if v, ok := msg["logFile"]; ok {
+		switch v := v.(type) {
+		case bool:
+			settings.Path = ""
+			if v {
+				settings.Path = defaultLogPath()
+			}
+		case string:
+			if v != "" && !filepath.IsAbs(v) {
+				return current, fmt.Errorf("logFile must be an absolute path")
+			}
+			settings.Path = v
+		default:
+			return current, fmt.Errorf("logFile must be a path or a boolean")
+		}
+	}
+	if _, ok := msg["logMaxSizeMB"]; ok {
+		mb := ipc.GetFloat64(msg, "logMaxSizeMB")
+		if mb <= 0 {
+			return current, fmt.Errorf("logMaxSizeMB must be > 0")
+		}
+		settings.MaxSize = int64(mb * 1024 * 1024)
+	}
+	if _, ok := msg["logKeep"]; ok {
+		settings.Keep = int(ipc.GetInt64(msg, "logKeep"))
+		if settings.Keep < 0 {
+			return current, fmt.Errorf("logKeep must be >= 0")
+		}
+	}
+	return settings, nil
+}
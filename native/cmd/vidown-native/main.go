@@ -1,31 +1,56 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/thecturner/vidown-native/internal/dash"
+	"github.com/thecturner/vidown-native/internal/fdlimit"
+	"github.com/thecturner/vidown-native/internal/fetch"
 	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/hls"
 	"github.com/thecturner/vidown-native/internal/ipc"
 	"github.com/thecturner/vidown-native/internal/job"
+	"github.com/thecturner/vidown-native/internal/session"
 )
 
 func main() {
-	// Set up logging to stderr (stdout is used for Native Messaging)
-	log.SetOutput(os.Stderr)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	// Set up logging to stderr (stdout is used for Native Messaging),
+	// mirrored to a log file if one is requested
+	setupLogging(os.Args[1:])
+
+	// A closed port must fail writes, not kill the host mid-job
+	ipc.IgnoreSIGPIPE()
 
 	// Send hello message
 	log.Println("[NATIVE] Starting vidown-native...")
+	if soft, hard, err := fdlimit.Raise(); err != nil {
+		log.Printf("[NATIVE] Could not raise fd limit: %v (soft=%d, hard=%d)", err, soft, hard)
+	} else if soft > 0 {
+		log.Printf("[NATIVE] File descriptor limit: %d (hard %d)", soft, hard)
+	}
 	ffmpegInfo := ff.ProbeFFmpeg()
 	log.Printf("[NATIVE] FFmpeg found: %v, version: %s", ffmpegInfo.Found, ffmpegInfo.Version)
 
+	token := session.NewToken()
+
 	if err := ipc.Send(ipc.Msg{
-		"type":   "hello",
-		"ok":     true,
-		"ffmpeg": ffmpegInfo,
+		"type":         "hello",
+		"ok":           true,
+		"ffmpeg":       ffmpegInfo,
+		"session":      token,
+		"capabilities": capabilities,
 	}); err != nil {
 		log.Fatal("Failed to send hello:", err)
 	}
@@ -34,36 +59,223 @@ func main() {
 	// Create job manager
 	jobManager := job.NewManager()
 
-	// Read messages from stdin
-	reader := bufio.NewReader(os.Stdin)
+	// Offer to clean up temp files a crashed session left behind
+	go sendStaleFiles(jobManager)
+
+	// and batches it didn't get to finish
+	go sendResumableBatches(jobManager)
+
+	// Shut down when unused for the configured idle timeout
+	idle := newIdleTracker()
+	idleExpired := make(chan struct{})
+	go idle.watch(jobManager, func() { close(idleExpired) })
+
+	// The port can close under a write before stdin shows it; stop the
+	// jobs rather than run on headless, writing files nobody asked for
+	// anymore
+	var disconnectOnce sync.Once
+	disconnected := func() {
+		disconnectOnce.Do(func() { disconnect(token, jobManager) })
+	}
+	go func() {
+		<-ipc.Broken()
+		log.Println("[NATIVE] Extension's port is closed (stdout broken)")
+		disconnected()
+		os.Exit(0)
+	}()
+
+	// Read messages from stdin; a frame the extension abandons halfway
+	// times out instead of hanging the host
+	reader := ipc.NewFrameReader(os.Stdin)
 
 	log.Println("[NATIVE] Waiting for messages...")
 
+	// Messages are read on their own goroutine so the idle timeout can end
+	// the loop while stdin blocks
+	type readResult struct {
+		msg ipc.Msg
+		err error
+	}
+	reads := make(chan readResult)
+	go func() {
+		for {
+			msg, err := reader.Read(config.readTimeout())
+			reads <- readResult{msg, err}
+			if err != nil && err != ipc.ErrEmptyFrame {
+				return
+			}
+		}
+	}()
+
 	for {
-		msg, err := ipc.ReadMsg(reader)
+		var msg ipc.Msg
+		var err error
+		select {
+		case r := <-reads:
+			msg, err = r.msg, r.err
+		case <-idleExpired:
+			stopJobs(jobManager)
+			return
+		}
+		if err == ipc.ErrEmptyFrame {
+			log.Println("[NATIVE] Protocol error: ignoring a zero-length message")
+			continue
+		}
 		if err != nil {
-			log.Println("[NATIVE] Read error:", err)
+			switch {
+			case err == io.EOF:
+				log.Println("[NATIVE] Extension closed stdin")
+			case errors.Is(err, ipc.ErrTruncatedFrame):
+				log.Printf("[NATIVE] Protocol error: %v (the extension likely crashed)", err)
+			case err == ipc.ErrFrameTimeout:
+				log.Println("[NATIVE] Extension stopped mid-message, giving up on stdin")
+			default:
+				log.Println("[NATIVE] Read error:", err)
+			}
+			disconnected()
 			return
 		}
 
+		idle.touch()
+
 		msgType := ipc.GetString(msg, "type")
 		log.Printf("[NATIVE] Received message: type=%s", msgType)
 
 		switch msgType {
 		case "shutdown":
 			log.Println("[NATIVE] Shutdown requested")
+			stopJobs(jobManager)
 			return
 
+		case "ping":
+			// Heartbeat from the extension; receiving it resets the idle timer
+			ipc.Send(ipc.Msg{"type": "pong"})
+
 		case "probe":
-			handleProbe(msg)
+			// ffprobe can take seconds on a slow stream; identical probes
+			// in flight share one run
+			go handleProbe(msg)
+
+		case "probeVariants":
+			handleProbeVariants(msg)
+
+		case "warmup":
+			go handleWarmup(msg)
+
+		case "perceptualHash":
+			// Decoding frames takes a while; don't hold up other commands
+			go handlePerceptualHash(msg)
 
 		case "download":
 			handleDownload(msg, jobManager)
 
+		case "smartDownload":
+			// Looking at the playlist takes a request or two
+			go handleSmartDownload(msg, jobManager)
+
+		case "downloadBatch":
+			handleDownloadBatch(msg, jobManager)
+
+		case "cancelBatch":
+			handleCancelBatch(msg, jobManager)
+
+		case "resumableBatches":
+			sendBatchList(jobManager.ResumableBatches())
+
+		case "resumeBatch":
+			handleResumeBatch(msg, jobManager)
+
+		case "discardBatch":
+			handleDiscardBatch(msg, jobManager)
+
+		case "switchVariant":
+			handleSwitchVariant(msg, jobManager)
+
+		case "audioClip":
+			handleAudioClip(msg, jobManager)
+
+		case "convertFile":
+			handleConvertFile(msg, jobManager)
+
+		case "listFormats":
+			// The first call runs ffmpeg; later ones hit the cache
+			go handleListFormats(msg)
+
+		case "listMediaFormats":
+			// Loads a playlist or probes the source
+			go handleListMediaFormats(msg)
+
+		case "canConvert":
+			// Probing a url source takes a moment
+			go handleCanConvert(msg)
+
+		case "canDecode":
+			// The first call runs ffmpeg; later ones hit the cache
+			go handleCanDecode(msg)
+
+		case "transcodeEstimate":
+			// The benchmark encode takes seconds; don't hold up other commands
+			go handleTranscodeEstimate(msg)
+
+		case "cleanupStale":
+			handleCleanupStale(msg, jobManager)
+
 		case "cancel":
 			id := ipc.GetString(msg, "id")
-			log.Printf("[NATIVE] Cancel requested for job: %s", id)
-			jobManager.Cancel(id)
+			graceful := ipc.GetBool(msg, "graceful")
+			log.Printf("[NATIVE] Cancel requested for job: %s (graceful=%v)", id, graceful)
+			jobManager.Cancel(id, graceful)
+
+		case "cancelContext":
+			handleCancelContext(msg, jobManager)
+
+		case "listJobs":
+			ipc.Send(ipc.Msg{
+				"type": "list-jobs",
+				"jobs": jobManager.Snapshots(),
+			})
+
+		case "enableCapabilities":
+			handleEnableCapabilities(msg, jobManager)
+
+		case "history":
+			ipc.Send(ipc.Msg{
+				"type": "history",
+				"jobs": jobManager.History(),
+			})
+
+		case "speedStats":
+			ipc.Send(ipc.Msg{
+				"type":  "speed-stats",
+				"hosts": jobManager.SpeedStats(),
+			})
+
+		case "reattach":
+			session.Reattach(ipc.GetString(msg, "session"))
+
+		case "fdUsage":
+			open, limit, ok := fdlimit.Usage()
+			ipc.Send(ipc.Msg{
+				"type":      "fd-usage",
+				"supported": ok,
+				"openFds":   open,
+				"limit":     limit,
+				"pressure":  fdlimit.UnderPressure(),
+			})
+
+		case "setConfig":
+			handleSetConfig(msg, jobManager)
+
+		case "setGlobalRateLimit":
+			handleSetGlobalRateLimit(msg, jobManager)
+
+		case "getGlobalRateLimit":
+			sendGlobalRateLimit(jobManager)
+
+		case "setDebug":
+			enabled := ipc.GetBool(msg, "enabled")
+			log.Printf("[NATIVE] Debug mode: %v", enabled)
+			jobManager.SetDebug(enabled)
 
 		default:
 			log.Printf("[NATIVE] Unknown command: %s", msgType)
@@ -77,16 +289,123 @@ func main() {
 	}
 }
 
+// disconnect handles the extension's port going away, seen as stdin
+// ending or stdout breaking: the jobs keep running detached when the user
+// asked for that, otherwise they are stopped
+func disconnect(token string, jobManager *job.Manager) {
+	if n := jobManager.Active(); n > 0 && config.keepAlive() {
+		log.Printf("[NATIVE] Port dropped with %d active job(s), detaching", n)
+		session.Detach(token, jobManager)
+		return
+	}
+	stopJobs(jobManager)
+}
+
+// stopGrace is how long stopJobs waits for canceled jobs to clean up
+const stopGrace = 5 * time.Second
+
+// stopJobs cancels the jobs still running when the port is gone, so their
+// ffmpeg processes don't outlive the host, and gives them a moment to
+// remove their temp files. Only their canceled events are still sent, in
+// case the extension is reading yet; a broken stdout drops those too.
+func stopJobs(jobManager *job.Manager) {
+	ipc.Quiesce()
+	defer ipc.Detach()
+	n := jobManager.CancelAll()
+	if n == 0 {
+		return
+	}
+	log.Printf("[NATIVE] Canceling %d active job(s)", n)
+
+	done := make(chan struct{})
+	go func() {
+		jobManager.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopGrace):
+		log.Printf("[NATIVE] Jobs still stopping after %s, exiting", stopGrace)
+	}
+}
+
+// layoutTimeout bounds the ranged read of a remote file's head before a probe
+const layoutTimeout = 10 * time.Second
+
 func handleProbe(msg ipc.Msg) {
-	url := ipc.GetString(msg, "url")
-	headersMap := ipc.GetMap(msg, "headers")
-	headers := ipc.GetStringMap(headersMap)
+	url, headers := requestTarget(msg)
 
-	result, err := ff.ProbeURL(url, headers)
+	opts := ff.ProbeOptions{
+		AnalyzeDuration: time.Duration(ipc.GetFloat64(msg, "probeAnalyzeDuration") * float64(time.Second)),
+		ProbeSize:       ipc.GetInt64(msg, "probeSize"),
+		Timeout:         time.Duration(ipc.GetFloat64(msg, "probeTimeout") * float64(time.Second)),
+	}
+	sections, err := probeSections(ipc.GetMap(msg, "probeOptions"))
+	if err != nil {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"code": "invalid_probe_options",
+			"msg":  err.Error(),
+			"url":  url,
+		})
+		return
+	}
+	opts.Sections = sections
+
+	var layout fetch.Layout
+	if scheme := ff.URLScheme(url); scheme == "http" || scheme == "https" {
+		// Remote files are capped so a probe doesn't download them whole
+		size, analyze := config.probeLimits()
+		if msg["probeSize"] == nil {
+			opts.ProbeSize = size
+		}
+		if msg["probeAnalyzeDuration"] == nil {
+			opts.AnalyzeDuration = analyze
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), layoutTimeout)
+		var err error
+		layout, err = fetch.MP4Layout(ctx, url, headers)
+		cancel()
+		if err != nil {
+			log.Printf("[NATIVE] Layout check of %s failed: %v", ff.RedactURL(url), err)
+		}
+		opts.Seekable = layout.Ranges
+		if layout.MoovAtEnd && !layout.Ranges {
+			ipc.Send(ipc.Msg{
+				"type": "error",
+				"code": "probe_needs_full_file",
+				"msg":  "the file's index is at its end and the server doesn't take Range requests, so probing it means downloading all of it",
+				"url":  url,
+			})
+			return
+		}
+	}
+
+	// Transient failures (timeouts, 5xx, dropped connections) are retried
+	retries := config.probeRetryCount()
+	if msg["probeRetries"] != nil {
+		retries = min(max(int(ipc.GetInt64(msg, "probeRetries")), 0), ff.MaxProbeRetries)
+	}
+	retry := ff.ProbeRetry{
+		Retries: retries,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			ipc.Send(ipc.Msg{
+				"type":       "probe-retrying",
+				"url":        url,
+				"attempt":    attempt,
+				"maxRetries": retries,
+				"delaySec":   delay.Seconds(),
+				"msg":        err.Error(),
+			})
+		},
+	}
+
+	result, err := ff.ProbeURLWithRetry(url, headers, opts, retry)
 	if err != nil {
 		ipc.Send(ipc.Msg{
 			"type":  "error",
-			"code":  "probe_failed",
+			"code":  probeErrorCode(err),
 			"msg":   err.Error(),
 			"url":   url,
 		})
@@ -94,36 +413,810 @@ func handleProbe(msg ipc.Msg) {
 	}
 
 	ipc.Send(ipc.Msg{
-		"type":   "probe-result",
-		"url":    url,
-		"result": result,
+		"type":      "probe-result",
+		"url":       url,
+		"result":    result,
+		"partial":   result.Partial,
+		"moovAtEnd": layout.MoovAtEnd,
+	})
+}
+
+// probeSections reads a probe's probeOptions, e.g. {"chapters": true,
+// "packets": 20}. Only the allow-listed sections are accepted, and packets
+// and frames need a bounded count.
+func probeSections(opts ipc.Msg) (ff.ProbeSections, error) {
+	var s ff.ProbeSections
+	for key, v := range opts {
+		switch key {
+		case "chapters", "programs":
+			on, ok := v.(bool)
+			if !ok {
+				return s, fmt.Errorf("probeOptions.%s must be true or false", key)
+			}
+			if key == "chapters" {
+				s.Chapters = on
+			} else {
+				s.Programs = on
+			}
+		case "packets", "frames":
+			n, ok := v.(float64)
+			if !ok || n != float64(int(n)) {
+				return s, fmt.Errorf("probeOptions.%s must be a count", key)
+			}
+			if key == "packets" {
+				s.Packets = int(n)
+			} else {
+				s.Frames = int(n)
+			}
+		default:
+			return s, fmt.Errorf("probeOptions.%s isn't an allowed section (chapters, programs, packets, frames)", key)
+		}
+	}
+	return s, s.Validate()
+}
+
+func handleProbeVariants(msg ipc.Msg) {
+	url, headers := requestTarget(msg)
+
+	data, base, err := fetch.Get(context.Background(), url, headers)
+	if err == nil && dash.IsMPD(data) {
+		var m *dash.Manifest
+		if m, err = dash.Parse(data, base); err == nil {
+			// Representation IDs can be passed back as download's representations
+			ipc.Send(ipc.Msg{
+				"type": "probe-variants",
+				"url":  url,
+				"representations": ipc.Msg{
+					"video": nonNil(m.Video),
+					"audio": nonNil(m.Audio),
+				},
+			})
+			return
+		}
+	}
+
+	var master *hls.Master
+	if err == nil {
+		if !hls.IsMaster(data) {
+			err = fmt.Errorf("not a master playlist")
+		} else {
+			master, err = hls.ParseMaster(bytes.NewReader(data), base)
+		}
+	}
+	if err != nil {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"code": probeErrorCode(err),
+			"msg":  err.Error(),
+			"url":  url,
+		})
+		return
+	}
+
+	audio := master.AudioRenditions()
+	if audio == nil {
+		audio = []hls.Rendition{}
+	}
+
+	ipc.Send(ipc.Msg{
+		"type":     "probe-variants",
+		"url":      url,
+		"variants": master.Variants,
+		"audio":    audio,
+	})
+}
+
+// mediaFormatsTimeout bounds loading the playlists behind a format list
+const mediaFormatsTimeout = 30 * time.Second
+
+// handleListMediaFormats lists what a source can be downloaded as, yt-dlp
+// -F style: id, resolution, fps, codecs, bitrate and estimated size of each
+// HLS variant and audio rendition, DASH representation, or of the source
+// itself. The chosen formatId goes back to download as format.
+func handleListMediaFormats(msg ipc.Msg) {
+	id := ipc.GetString(msg, "id")
+	url, headers := requestTarget(msg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaFormatsTimeout)
+	defer cancel()
+
+	list, err := job.ListMediaFormats(ctx, url, headers, ipc.GetString(msg, "mode"))
+	if err != nil {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": probeErrorCode(err),
+			"msg":  err.Error(),
+			"url":  url,
+		})
+		return
+	}
+
+	ipc.Send(ipc.Msg{
+		"type":        "media-formats",
+		"id":          id,
+		"url":         url,
+		"protocol":    list.Protocol,
+		"durationSec": list.DurationSec,
+		"live":        list.Live,
+		"formats":     list.Formats,
+	})
+}
+
+// nonNil keeps an empty representation list a JSON array
+func nonNil(reps []dash.Representation) []dash.Representation {
+	if reps == nil {
+		return []dash.Representation{}
+	}
+	return reps
+}
+
+// warmupTimeout bounds a warmup request
+const warmupTimeout = 15 * time.Second
+
+// handleWarmup opens a connection to a video host (and optionally fetches
+// its manifest) ahead of a download, reporting how long each phase took so
+// the extension can show readiness
+func handleWarmup(msg ipc.Msg) {
+	id := ipc.GetString(msg, "id")
+	url, headers := requestTarget(msg)
+	prefetch := ipc.GetBool(msg, "prefetch")
+
+	sendErr := func(code string, err error) {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": code,
+			"msg":  err.Error(),
+			"url":  url,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+
+	if addr := ipc.GetString(msg, "sourceAddress"); addr != "" {
+		src, err := fetch.ResolveSource(addr)
+		if err != nil {
+			sendErr("bind_failed", err)
+			return
+		}
+		ctx = fetch.WithSource(ctx, src)
+	}
+
+	timing, err := fetch.Warmup(ctx, url, headers, prefetch)
+	if err != nil {
+		sendErr("warmup_failed", err)
+		return
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	ipc.Send(ipc.Msg{
+		"type":        "warmup",
+		"id":          id,
+		"url":         url,
+		"status":      timing.Status,
+		"dnsMs":       ms(timing.DNS),
+		"connectMs":   ms(timing.Connect),
+		"tlsMs":       ms(timing.TLS),
+		"firstByteMs": ms(timing.FirstByte),
+		"reused":      timing.Reused,
+		"prefetched":  prefetch,
+	})
+}
+
+// handlePerceptualHash hashes sampled frames of a local file so the
+// extension can detect near-duplicate downloads (see ff.PerceptualHash)
+func handlePerceptualHash(msg ipc.Msg) {
+	id := ipc.GetString(msg, "id")
+	path := resolveOutput(ipc.GetString(msg, "path"))
+	samples := int(ipc.GetInt64(msg, "samples"))
+
+	hashes, err := ff.PerceptualHash(context.Background(), path, samples)
+	if err != nil {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": "hash_failed",
+			"msg":  err.Error(),
+			"path": path,
+		})
+		return
+	}
+
+	ipc.Send(ipc.Msg{
+		"type":      "perceptual-hash",
+		"id":        id,
+		"path":      path,
+		"algorithm": ff.HashAlgorithm,
+		"hashes":    hashes,
+	})
+}
+
+// handleListFormats reports the container formats the local ffmpeg build
+// can read and write, optionally only those it can write
+func handleListFormats(msg ipc.Msg) {
+	formats := []ff.Format{}
+	for _, f := range ff.ListFormats() {
+		if f.Mux || !ipc.GetBool(msg, "muxersOnly") {
+			formats = append(formats, f)
+		}
+	}
+
+	ipc.Send(ipc.Msg{
+		"type":    "formats",
+		"id":      ipc.GetString(msg, "id"),
+		"formats": formats,
 	})
 }
 
 func handleDownload(msg ipc.Msg, jobManager *job.Manager) {
+	j := parseDownload(msg)
+
+	log.Printf("[NATIVE] Starting download: id=%s, mode=%s, url=%s, out=%s", j.ID, j.Mode, j.URL, j.Out)
+	jobManager.Start(j)
+}
+
+// smartPlanTimeout bounds smartDownload's look at the source
+const smartPlanTimeout = 30 * time.Second
+
+// handleSmartDownload downloads a source into one file with one message:
+// it picks the HLS variant and audio or DASH representations by quality
+// ("best", "worst", "720p") and audioLanguage, reports its choices in a
+// smart-plan event, then runs the download. Every download option applies.
+func handleSmartDownload(msg ipc.Msg, jobManager *job.Manager) {
+	j := parseDownload(msg)
+
+	sendErr := func(code string, err error) {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   j.ID,
+			"code": code,
+			"msg":  err.Error(),
+			"url":  j.URL,
+		})
+	}
+
+	pref, err := job.ParseSmartPreference(msg)
+	if err != nil {
+		sendErr("invalid_quality", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), smartPlanTimeout)
+	plan, err := job.PlanSmartDownload(ctx, j.URL, j.Headers, pref)
+	cancel()
+	if err != nil {
+		sendErr(probeErrorCode(err), err)
+		return
+	}
+	plan.Apply(j)
+
+	ipc.Send(ipc.Msg{
+		"type": "smart-plan",
+		"id":   j.ID,
+		"plan": plan,
+	})
+
+	log.Printf("[NATIVE] Starting smart download: id=%s, mode=%s, format=%v, url=%s", j.ID, j.Mode, j.Format, j.URL)
+	jobManager.Start(j)
+}
+
+func handleDownloadBatch(msg ipc.Msg, jobManager *job.Manager) {
+	batchID := ipc.GetString(msg, "batchId")
+	if batchID == "" {
+		batchID = fmt.Sprintf("batch-%d", time.Now().UnixNano())
+	}
+
+	specs, _ := msg["jobs"].([]interface{})
+	jobs := make([]*job.Job, 0, len(specs))
+	for i, spec := range specs {
+		specMap, ok := spec.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		j := parseDownload(specMap)
+		if j.ID == "" {
+			j.ID = fmt.Sprintf("%s-%d", batchID, i+1)
+			specMap["id"] = j.ID
+		}
+		j.Spec = specMap
+		jobs = append(jobs, j)
+	}
+
+	log.Printf("[NATIVE] Starting batch %s with %d job(s)", batchID, len(jobs))
+	if err := jobManager.StartBatch(batchID, jobs); err != nil {
+		ipc.Send(ipc.Msg{
+			"type":    "error",
+			"code":    "batch_rejected",
+			"msg":     err.Error(),
+			"batchId": batchID,
+		})
+	}
+}
+
+// sendResumableBatches offers the batches a previous run left unfinished
+func sendResumableBatches(jobManager *job.Manager) {
+	batches := jobManager.ResumableBatches()
+	if len(batches) == 0 {
+		return
+	}
+	log.Printf("[NATIVE] Found %d unfinished batch(es)", len(batches))
+	sendBatchList(batches)
+}
+
+func sendBatchList(batches []job.BatchRecord) {
+	list := make([]ipc.Msg, len(batches))
+	for i, b := range batches {
+		list[i] = b.Summary()
+	}
+	ipc.Send(ipc.Msg{
+		"type":    "resumable-batches",
+		"batches": list,
+	})
+}
+
+// handleResumeBatch downloads the files of a journaled batch that weren't
+// done, as a batch of the same ID
+func handleResumeBatch(msg ipc.Msg, jobManager *job.Manager) {
+	batchID := ipc.GetString(msg, "batchId")
+	rec, ok := jobManager.ResumableBatch(batchID)
+	if !ok {
+		ipc.Send(ipc.Msg{
+			"type":    "error",
+			"code":    "unknown_batch",
+			"msg":     "no unfinished batch with this id",
+			"batchId": batchID,
+		})
+		return
+	}
+
+	var jobs []*job.Job
+	for _, f := range rec.Files {
+		if f.State == job.StateDone {
+			continue
+		}
+		j := parseDownload(f.Spec)
+		j.Spec = f.Spec
+		jobs = append(jobs, j)
+	}
+
+	log.Printf("[NATIVE] Resuming batch %s with %d job(s)", batchID, len(jobs))
+	if err := jobManager.ResumeBatch(rec, jobs); err != nil {
+		ipc.Send(ipc.Msg{
+			"type":    "error",
+			"code":    "batch_rejected",
+			"msg":     err.Error(),
+			"batchId": batchID,
+		})
+	}
+}
+
+func handleDiscardBatch(msg ipc.Msg, jobManager *job.Manager) {
+	batchID := ipc.GetString(msg, "batchId")
+	if !jobManager.DiscardBatch(batchID) {
+		ipc.Send(ipc.Msg{
+			"type":    "error",
+			"code":    "unknown_batch",
+			"msg":     "no unfinished batch with this id",
+			"batchId": batchID,
+		})
+		return
+	}
+	ipc.Send(ipc.Msg{
+		"type":    "batch-discarded",
+		"batchId": batchID,
+	})
+}
+
+func handleCancelBatch(msg ipc.Msg, jobManager *job.Manager) {
+	batchID := ipc.GetString(msg, "batchId")
+	log.Printf("[NATIVE] Cancel requested for batch: %s", batchID)
+
+	ids, ok := jobManager.CancelBatch(batchID)
+	if !ok {
+		ipc.Send(ipc.Msg{
+			"type":    "error",
+			"code":    "unknown_batch",
+			"msg":     "no active batch with this id",
+			"batchId": batchID,
+		})
+		return
+	}
+
+	ipc.Send(ipc.Msg{
+		"type":    "batch-canceled",
+		"batchId": batchID,
+		"ids":     ids,
+	})
+}
+
+// handleCancelContext cancels the jobs a context (a tab, say) started,
+// for when the extension is done with it
+func handleCancelContext(msg ipc.Msg, jobManager *job.Manager) {
+	tag := jobContext(msg)
+	if tag == "" {
+		// An empty context would match every untagged job
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"code": "invalid_context",
+			"msg":  "cancelContext needs a context or tabId",
+		})
+		return
+	}
+	log.Printf("[NATIVE] Cancel requested for context: %s", tag)
+
+	ipc.Send(ipc.Msg{
+		"type":    "context-canceled",
+		"context": tag,
+		"ids":     jobManager.CancelContext(tag),
+	})
+}
+
+// jobContext reads the opaque context a job is tagged with: context, or
+// the extension's tabId, a number, as a string
+func jobContext(msg ipc.Msg) string {
+	if tag := ipc.GetString(msg, "context"); tag != "" {
+		return tag
+	}
+	switch tab := msg["tabId"].(type) {
+	case string:
+		return tab
+	case float64:
+		return strconv.FormatFloat(tab, 'f', -1, 64)
+	}
+	return ""
+}
+
+// handleSwitchVariant moves a running HLS download to another variant,
+// given as {uri?, bandwidth?, height?} matching an entry of probeVariants.
+// The job reports the switch itself once the segment in flight is written.
+func handleSwitchVariant(msg ipc.Msg, jobManager *job.Manager) {
+	id := ipc.GetString(msg, "id")
+	v := ipc.GetMap(msg, "variant")
+	sel := hls.VariantSelector{
+		URI:       ipc.GetString(v, "uri"),
+		Bandwidth: ipc.GetInt64(v, "bandwidth"),
+		Height:    int(ipc.GetInt64(v, "height")),
+	}
+	log.Printf("[NATIVE] Variant switch requested for job %s: %+v", id, sel)
+
+	if err := jobManager.SwitchVariant(id, sel); err != nil {
+		code := "switch_failed"
+		if errors.Is(err, job.ErrUnknownJob) {
+			code = "unknown_job"
+		}
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": code,
+			"msg":  err.Error(),
+		})
+	}
+}
+
+// capabilities lists the opt-in protocol features offered in hello; the
+// extension turns them on with enableCapabilities
+var capabilities = []string{job.CapProgressDeltas}
+
+// handleEnableCapabilities turns on the capabilities the extension asks for
+// and replies with the ones enabled
+func handleEnableCapabilities(msg ipc.Msg, jobManager *job.Manager) {
+	enabled := []string{}
+	for _, c := range ipc.GetStrings(msg, "capabilities") {
+		switch c {
+		case job.CapProgressDeltas:
+			jobManager.SetProgressDeltas(true)
+			enabled = append(enabled, c)
+		default:
+			log.Printf("[NATIVE] Ignoring unknown capability %q", c)
+		}
+	}
+
+	ipc.Send(ipc.Msg{
+		"type":    "capabilities",
+		"enabled": enabled,
+	})
+}
+
+// parseDownload builds a job from a download message (or one batch entry)
+func parseDownload(msg ipc.Msg) *job.Job {
 	id := ipc.GetString(msg, "id")
 	mode := ipc.GetString(msg, "mode")
-	url := ipc.GetString(msg, "url")
+	url, headers := requestTarget(msg)
+	if ff.IsLiveURL(url) && mode != "live" {
+		// Only ffmpeg's live capture can read these
+		log.Printf("[NATIVE] %s is a live stream URL, using mode live instead of %q", ff.URLScheme(url), mode)
+		mode = "live"
+	}
 	out := ipc.GetString(msg, "out")
 	expTotal := ipc.GetInt64(msg, "expectedTotalBytes")
-
-	headersMap := ipc.GetMap(msg, "headers")
-	headers := ipc.GetStringMap(headersMap)
+	engine := ipc.GetString(msg, "engine")
+	debug := ipc.GetBool(msg, "debug")
 
 	convertMap := ipc.GetMap(msg, "convert")
 	convert := job.ParseConvertOpts(convertMap)
 
-	// If out is just a filename, prepend Downloads directory
+	// embedThumbnail is true to grab a frame, or the path/URL of an image
+	var thumbnail *job.ThumbnailOpts
+	if ipc.GetBool(msg, "embedThumbnail") {
+		thumbnail = &job.ThumbnailOpts{}
+	} else if image := ipc.GetString(msg, "embedThumbnail"); image != "" {
+		thumbnail = &job.ThumbnailOpts{Image: image}
+	}
+
+	// extractCaptions is true for an SRT sidecar, or "srt"/"vtt"
+	captions := strings.ToLower(ipc.GetString(msg, "extractCaptions"))
+	if ipc.GetBool(msg, "extractCaptions") {
+		captions = "srt"
+	}
+	if captions != "" && captions != "srt" && captions != "vtt" {
+		log.Printf("[NATIVE] Unknown caption format %q, using srt", captions)
+		captions = "srt"
+	}
+
+	var audio *hls.AudioSelector
+	if _, ok := msg["audioRendition"]; ok {
+		audioMap := ipc.GetMap(msg, "audioRendition")
+		audio = &hls.AudioSelector{
+			GroupID:  ipc.GetString(audioMap, "groupId"),
+			Name:     ipc.GetString(audioMap, "name"),
+			Language: ipc.GetString(audioMap, "language"),
+		}
+	}
+
+	// subtitles: {src, language?, style?}; burnSubtitles renders them in
+	var subtitles *job.SubtitleOpts
+	if _, ok := msg["subtitles"]; ok {
+		subsMap := ipc.GetMap(msg, "subtitles")
+		subtitles = &job.SubtitleOpts{
+			Src:      ipc.GetString(subsMap, "src"),
+			Language: ipc.GetString(subsMap, "language"),
+			Style:    ipc.GetString(subsMap, "style"),
+			Burn:     ipc.GetBool(msg, "burnSubtitles"),
+		}
+	}
+
+	// representations: {video?, audio?} DASH representation IDs
+	var representations *dash.Selection
+	if _, ok := msg["representations"]; ok {
+		repMap := ipc.GetMap(msg, "representations")
+		representations = &dash.Selection{
+			Video: ipc.GetString(repMap, "video"),
+			Audio: ipc.GetString(repMap, "audio"),
+		}
+	}
+
+	out = resolveOutput(out)
+
+	// multiOutput: [{out, container?, vcodec?, acodec?}, ...]
+	var outputs []job.ExtraOutput
+	specs, _ := msg["multiOutput"].([]interface{})
+	for _, spec := range specs {
+		specMap, ok := spec.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		o := job.ExtraOutput{
+			Out:       ipc.GetString(specMap, "out"),
+			Container: ipc.GetString(specMap, "container"),
+			VCodec:    ipc.GetString(specMap, "vcodec"),
+			ACodec:    ipc.GetString(specMap, "acodec"),
+		}
+		if o.Out != "" {
+			o.Out = resolveOutput(o.Out)
+		}
+		outputs = append(outputs, o)
+	}
+
+	return &job.Job{
+		ID:        id,
+		Mode:      mode,
+		URL:       url,
+		Out:       out,
+		Headers:   headers,
+		ExpTotal:  expTotal,
+		Convert:   convert,
+		Engine:    engine,
+		Debug:     debug,
+		Audio:     audio,
+		Thumbnail: thumbnail,
+		Captions:  captions,
+		Parts:     ipc.GetStrings(msg, "urls"),
+		Priority:  ipc.GetString(msg, "priority"),
+		Context:   jobContext(msg),
+
+		ExitPolicy: ipc.GetString(msg, "exitPolicy"),
+		OnExisting: ipc.GetString(msg, "onExisting"),
+		Outputs:    outputs,
+		Retry:      job.ParseRetryPolicy(ipc.GetMap(msg, "retry")),
+
+		SourceAddress: ipc.GetString(msg, "sourceAddress"),
+		SourceFile:    ipc.GetString(msg, "sourceFile"),
+		CreationTime:  ipc.GetString(msg, "creationTime"),
+		FileMode:      ipc.GetString(msg, "fileMode"),
+		Subtitles:     subtitles,
+		Proxy:         ipc.GetString(msg, "proxy"),
+
+		NormalizeAudio:  job.ParseLoudnessOpts(msg["normalizeAudio"]),
+		Representations: representations,
+		DataBudget:      int64(ipc.GetFloat64(msg, "dataBudgetMB") * (1 << 20)),
+		CreateDirs:      createDirs(msg),
+		AllowProtocols:  ipc.GetStrings(msg, "allowProtocols"),
+		Live:            job.ParseLiveOpts(ipc.GetMap(msg, "live")),
+		RawSegments:     ipc.GetBool(msg, "rawSegments"),
+		Decrypt:         ipc.GetBool(msg, "decrypt"),
+
+		SegmentConcurrency: int(ipc.GetInt64(msg, "segmentConcurrency")),
+		MaxBufferBytes:     int64(ipc.GetFloat64(msg, "maxBufferMB") * (1 << 20)),
+
+		AutoFallbackQuality: ipc.GetBool(msg, "autoFallbackQuality"),
+		IncludeProbe:        ipc.GetBool(msg, "includeProbe"),
+		Format:              formatIDs(msg),
+
+		WorkDir:    ipc.GetString(msg, "workDir"),
+		InputRoots: inputRoots(),
+
+		AudioTracks: job.ParseAudioTracks(msg["audioTracks"]),
+
+		FixFaststart:    ipc.GetBool(msg, "fixFaststart"),
+		KeepTempOnError: ipc.GetBool(msg, "keepTempOnError"),
+		Preallocate:     ipc.GetBool(msg, "preallocate"),
+	}
+}
+
+// formatIDs reads download's format: a format ID from listMediaFormats, or
+// a video and an audio one joined with "+"
+func formatIDs(msg ipc.Msg) []string {
+	format := strings.TrimSpace(ipc.GetString(msg, "format"))
+	if format == "" {
+		return nil
+	}
+	ids := strings.Split(format, "+")
+	for i := range ids {
+		ids[i] = strings.TrimSpace(ids[i])
+	}
+	return ids
+}
+
+func handleAudioClip(msg ipc.Msg, jobManager *job.Manager) {
+	id := ipc.GetString(msg, "id")
+	url, headers := requestTarget(msg)
+	out := ipc.GetString(msg, "out")
+
+	clip := &ff.ClipOptions{
+		FadeIn:  ipc.GetFloat64(msg, "fadeIn"),
+		FadeOut: ipc.GetFloat64(msg, "fadeOut"),
+		Format:  strings.ToLower(ipc.GetString(msg, "format")),
+	}
+
+	var err error
+	if clip.Start, err = getSeconds(msg, "start"); err == nil {
+		clip.End, err = getSeconds(msg, "end")
+	}
+	// Optional: part length for podcast apps, e.g. "30:00"
+	if _, ok := msg["splitEvery"]; ok && err == nil {
+		clip.SplitEvery, err = getSeconds(msg, "splitEvery")
+	}
+
+	// Default the format from the output extension, then make them agree
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(out), "."))
+	if clip.Format == "" {
+		clip.Format = "m4a"
+		if ext == "mp3" {
+			clip.Format = "mp3"
+		}
+	}
+	if ext != clip.Format {
+		switch ext {
+		case "m4a", "mp3", "aac":
+			out = strings.TrimSuffix(out, filepath.Ext(out))
+		}
+		out += "." + clip.Format
+	}
+
+	if err == nil {
+		// Duration is checked again against the probed source once running
+		err = clip.Validate(0)
+	}
+	if err != nil {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   id,
+			"code": "invalid_clip",
+			"msg":  err.Error(),
+		})
+		return
+	}
+
+	out = resolveOutput(out)
+
+	// Cover art is kept unless asked not to
+	keepCover := true
+	if v, ok := msg["keepCoverArt"].(bool); ok {
+		keepCover = v
+	}
+
+	log.Printf("[NATIVE] Starting audio clip: id=%s, url=%s, out=%s", id, url, out)
+	jobManager.Start(&job.Job{
+		ID:      id,
+		Mode:    "audioclip",
+		URL:     url,
+		Out:     out,
+		Headers: headers,
+		Debug:   ipc.GetBool(msg, "debug"),
+		Clip:    clip,
+		Context: jobContext(msg),
+
+		KeepCoverArt: keepCover,
+	})
+}
+
+// getSeconds reads a time given either as a number of seconds or a timecode
+func getSeconds(msg ipc.Msg, key string) (float64, error) {
+	if s := ipc.GetString(msg, key); s != "" {
+		return ff.ParseTimecode(s)
+	}
+	if _, ok := msg[key]; !ok {
+		return 0, fmt.Errorf("missing %s", key)
+	}
+	return ipc.GetFloat64(msg, key), nil
+}
+
+// resolveOutput places bare filenames in the Downloads directory
+func resolveOutput(out string) string {
+	// Left alone so the job can reject it by name
+	if ff.IsStdoutTarget(out) {
+		return out
+	}
 	if !filepath.IsAbs(out) {
+		// Join drops a trailing separator; keep it so the job can tell
+		// a directory was named
+		dirOnly := out == "" || os.IsPathSeparator(out[len(out)-1])
 		downloadsDir := getDownloadsDir()
 		out = filepath.Join(downloadsDir, out)
+		if dirOnly {
+			out += string(filepath.Separator)
+		}
+	}
+	return out
+}
+
+func handleSetGlobalRateLimit(msg ipc.Msg, jobManager *job.Manager) {
+	limit := ipc.GetInt64(msg, "limitBps")
+	if limit < 0 {
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"code": "invalid_rate_limit",
+			"msg":  "limitBps must be >= 0",
+		})
+		return
 	}
 
-	log.Printf("[NATIVE] Starting download: id=%s, mode=%s, url=%s, out=%s", id, mode, url, out)
-	jobManager.Start(id, mode, url, out, headers, convert, expTotal)
+	log.Printf("[NATIVE] Global rate limit set to %d B/s", limit)
+	jobManager.SetGlobalRateLimit(limit)
+	sendGlobalRateLimit(jobManager)
+}
+
+func sendGlobalRateLimit(jobManager *job.Manager) {
+	limit, users, share := jobManager.GlobalRateLimit()
+	ipc.Send(ipc.Msg{
+		"type":       "global-rate-limit",
+		"limitBps":   limit,
+		"activeJobs": users,
+		"perJobBps":  share,
+	})
 }
 
 func getDownloadsDir() string {
+	// A folder configured by the user always wins
+	if dir := config.downloadsDirectory(); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("VIDOWN_DOWNLOADS_DIR"); dir != "" {
+		return dir
+	}
+
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "."
@@ -135,8 +1228,14 @@ func getDownloadsDir() string {
 		// Windows: %USERPROFILE%\Downloads
 		return filepath.Join(homeDir, "Downloads")
 	case "darwin":
-		// macOS: ~/Downloads
-		return filepath.Join(homeDir, "Downloads")
+		// macOS: ~/Downloads on disk regardless of the localized name
+		// shown in Finder. Users who move the folder usually leave a
+		// symlink behind, so follow it to the real location.
+		downloads := filepath.Join(homeDir, "Downloads")
+		if resolved, err := filepath.EvalSymlinks(downloads); err == nil {
+			return resolved
+		}
+		return downloads
 	case "linux":
 		// Linux: ~/Downloads (or XDG_DOWNLOAD_DIR)
 		xdgDownload := os.Getenv("XDG_DOWNLOAD_DIR")
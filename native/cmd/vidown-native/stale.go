@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/job"
+)
+
+// staleDirs are the folders scanned for temp files left by crashed runs:
+// the downloads folder, the scratch folders and those failed jobs kept
+// their temps in (keepTempOnError)
+func staleDirs() []string {
+	scratch := config.scratchDirs()
+	dirs := []string{getDownloadsDir(), scratch.Download, scratch.Convert}
+	return append(dirs, job.KeptTempDirs()...)
+}
+
+// sendStaleFiles tells the extension about leftover temp files so it can
+// offer to delete them. Nothing is sent when there are none.
+func sendStaleFiles(jobManager *job.Manager) {
+	age := config.staleAfter()
+	files := jobManager.FindStale(staleDirs(), age)
+	if len(files) == 0 {
+		return
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	log.Printf("[NATIVE] Found %d stale temp file(s), %d bytes", len(files), total)
+	ipc.Send(ipc.Msg{
+		"type":           "stale-files",
+		"files":          files,
+		"totalBytes":     total,
+		"olderThanHours": age.Hours(),
+	})
+}
+
+// handleCleanupStale deletes leftover temp files: the listed paths, or
+// every stale file when none are listed. With dryRun it only re-sends the
+// stale-files list.
+func handleCleanupStale(msg ipc.Msg, jobManager *job.Manager) {
+	dirs := staleDirs()
+	age := config.staleAfter()
+
+	if ipc.GetBool(msg, "dryRun") {
+		sendStaleFiles(jobManager)
+		return
+	}
+
+	paths := ipc.GetStrings(msg, "paths")
+	if _, ok := msg["paths"]; !ok {
+		for _, f := range jobManager.FindStale(dirs, age) {
+			paths = append(paths, f.Path)
+		}
+	}
+
+	removed, failed := jobManager.RemoveStale(paths, dirs, age)
+	skipped := make([]ipc.Msg, 0, len(failed))
+	for path, err := range failed {
+		skipped = append(skipped, ipc.Msg{"path": path, "msg": err.Error()})
+	}
+	log.Printf("[NATIVE] Removed %d stale temp file(s), skipped %d", len(removed), len(skipped))
+
+	ipc.Send(ipc.Msg{
+		"type":    "stale-cleaned",
+		"removed": append([]string{}, removed...),
+		"skipped": skipped,
+	})
+}
@@ -0,0 +1,22 @@
+package dash
+
+// FormatID returns the representation's format ID: "dash-" and its ID
+func (r *Representation) FormatID() string {
+	return "dash-" + r.ID
+}
+
+// FormatRepresentation returns the representation with format ID id and
+// whether it is a video one
+func (m *Manifest) FormatRepresentation(id string) (rep *Representation, video bool, ok bool) {
+	for i := range m.Video {
+		if m.Video[i].FormatID() == id {
+			return &m.Video[i], true, true
+		}
+	}
+	for i := range m.Audio {
+		if m.Audio[i].FormatID() == id {
+			return &m.Audio[i], false, true
+		}
+	}
+	return nil, false, false
+}
@@ -0,0 +1,23 @@
+package dash
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+)
+
+// Load fetches and parses an MPD
+func Load(ctx context.Context, url string, headers map[string]string) (*Manifest, error) {
+	data, base, err := fetch.Get(ctx, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, base)
+}
+
+// IsMPD reports whether data looks like a DASH manifest rather than an HLS
+// playlist
+func IsMPD(data []byte) bool {
+	return bytes.Contains(data, []byte("<MPD"))
+}
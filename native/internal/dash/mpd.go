@@ -0,0 +1,347 @@
+// Package dash parses MPEG-DASH manifests into per-representation segment
+// lists for the native download engine.
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Representation is one selectable stream of a manifest with its segments
+// expanded to absolute URLs
+type Representation struct {
+	ID        string `json:"id"`
+	Bandwidth int64  `json:"bandwidth"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Codecs    string `json:"codecs,omitempty"`
+	MimeType  string `json:"mimeType,omitempty"`
+	Language  string `json:"language,omitempty"`
+
+	// Init is the Initialization segment of fragmented MP4 (CMAF) streams.
+	// Media segments aren't playable without it, so it has to be written
+	// first. Empty for single-file representations that include it.
+	Init     string   `json:"-"`
+	Segments []string `json:"-"`
+}
+
+// Container returns the container the concatenated segments form
+func (r *Representation) Container() string {
+	switch {
+	case strings.HasSuffix(r.MimeType, "/webm"):
+		return "webm"
+	case r.MimeType == "audio/mp4":
+		return "m4a"
+	case strings.HasSuffix(r.MimeType, "/mp2t"):
+		return "ts"
+	default:
+		return "mp4"
+	}
+}
+
+// Manifest is a parsed MPD. Only the first period is used.
+type Manifest struct {
+	Duration time.Duration
+	Live     bool // type="dynamic"
+	Video    []Representation
+	Audio    []Representation
+}
+
+type mpdXML struct {
+	Type     string      `xml:"type,attr"`
+	Duration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURL  string      `xml:"BaseURL"`
+	Periods  []periodXML `xml:"Period"`
+}
+
+type periodXML struct {
+	Duration       string          `xml:"duration,attr"`
+	BaseURL        string          `xml:"BaseURL"`
+	AdaptationSets []adaptationXML `xml:"AdaptationSet"`
+}
+
+type adaptationXML struct {
+	MimeType        string              `xml:"mimeType,attr"`
+	ContentType     string              `xml:"contentType,attr"`
+	Lang            string              `xml:"lang,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *segmentTemplateXML `xml:"SegmentTemplate"`
+	SegmentList     *segmentListXML     `xml:"SegmentList"`
+	Representations []representationXML `xml:"Representation"`
+}
+
+type representationXML struct {
+	ID              string              `xml:"id,attr"`
+	Bandwidth       int64               `xml:"bandwidth,attr"`
+	Width           int                 `xml:"width,attr"`
+	Height          int                 `xml:"height,attr"`
+	Codecs          string              `xml:"codecs,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *segmentTemplateXML `xml:"SegmentTemplate"`
+	SegmentList     *segmentListXML     `xml:"SegmentList"`
+}
+
+type segmentTemplateXML struct {
+	Media          string       `xml:"media,attr"`
+	Initialization string       `xml:"initialization,attr"`
+	StartNumber    *int64       `xml:"startNumber,attr"`
+	Timescale      int64        `xml:"timescale,attr"`
+	Duration       int64        `xml:"duration,attr"`
+	Timeline       *timelineXML `xml:"SegmentTimeline"`
+}
+
+type timelineXML struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type segmentListXML struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media string `xml:"media,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// Parse parses an MPD, resolving segment URLs against base. Representations
+// are returned highest bandwidth first.
+func Parse(data []byte, base *url.URL) (*Manifest, error) {
+	var doc mpdXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse mpd: %w", err)
+	}
+	if len(doc.Periods) == 0 {
+		return nil, fmt.Errorf("mpd has no periods")
+	}
+
+	m := &Manifest{Live: doc.Type == "dynamic"}
+	m.Duration, _ = ParseDuration(doc.Duration)
+
+	period := doc.Periods[0]
+	if d, err := ParseDuration(period.Duration); err == nil && d > 0 {
+		m.Duration = d
+	}
+
+	base = join(join(base, doc.BaseURL), period.BaseURL)
+
+	for _, as := range period.AdaptationSets {
+		asBase := join(base, as.BaseURL)
+		for _, rx := range as.Representations {
+			rep := Representation{
+				ID:        rx.ID,
+				Bandwidth: rx.Bandwidth,
+				Width:     rx.Width,
+				Height:    rx.Height,
+				Codecs:    rx.Codecs,
+				MimeType:  rx.MimeType,
+				Language:  as.Lang,
+			}
+			if rep.MimeType == "" {
+				rep.MimeType = as.MimeType
+			}
+
+			tmpl, list := rx.SegmentTemplate, rx.SegmentList
+			if tmpl == nil {
+				tmpl = as.SegmentTemplate
+			}
+			if list == nil {
+				list = as.SegmentList
+			}
+
+			if err := rep.expand(join(asBase, rx.BaseURL), tmpl, list, m.Duration); err != nil {
+				return nil, fmt.Errorf("representation %s: %w", rx.ID, err)
+			}
+
+			switch kind(as.ContentType, rep.MimeType) {
+			case "video":
+				m.Video = append(m.Video, rep)
+			case "audio":
+				m.Audio = append(m.Audio, rep)
+			}
+		}
+	}
+
+	for _, reps := range [][]Representation{m.Video, m.Audio} {
+		sort.SliceStable(reps, func(i, k int) bool {
+			return reps[i].Bandwidth > reps[k].Bandwidth
+		})
+	}
+
+	return m, nil
+}
+
+func kind(contentType, mimeType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	t, _, _ := strings.Cut(mimeType, "/")
+	return t
+}
+
+// expand fills in Init and Segments from whichever addressing scheme the
+// representation uses
+func (r *Representation) expand(base *url.URL, tmpl *segmentTemplateXML, list *segmentListXML, duration time.Duration) error {
+	switch {
+	case tmpl != nil:
+		return r.expandTemplate(base, tmpl, duration)
+
+	case list != nil:
+		if list.Initialization != nil && list.Initialization.SourceURL != "" {
+			r.Init = resolve(base, list.Initialization.SourceURL)
+		}
+		for _, s := range list.SegmentURLs {
+			r.Segments = append(r.Segments, resolve(base, s.Media))
+		}
+
+	default:
+		// SegmentBase or a bare BaseURL: the whole file, init included
+		if base == nil {
+			return fmt.Errorf("no segments or BaseURL")
+		}
+		r.Segments = []string{base.String()}
+	}
+
+	if len(r.Segments) == 0 {
+		return fmt.Errorf("no segments")
+	}
+	return nil
+}
+
+func (r *Representation) expandTemplate(base *url.URL, tmpl *segmentTemplateXML, duration time.Duration) error {
+	timescale := tmpl.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+	number := int64(1)
+	if tmpl.StartNumber != nil {
+		number = *tmpl.StartNumber
+	}
+
+	if tmpl.Initialization != "" {
+		r.Init = resolve(base, r.fill(tmpl.Initialization, 0, 0))
+	}
+
+	if tmpl.Timeline != nil {
+		end := int64(duration.Seconds() * float64(timescale))
+		var t int64
+		for i, s := range tmpl.Timeline.S {
+			if s.T != nil {
+				t = *s.T
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// Repeat until the next S element or the end of the period
+				limit := end
+				if i+1 < len(tmpl.Timeline.S) && tmpl.Timeline.S[i+1].T != nil {
+					limit = *tmpl.Timeline.S[i+1].T
+				}
+				if s.D <= 0 || limit <= t {
+					return fmt.Errorf("open-ended timeline without a known end")
+				}
+				repeat = (limit-t+s.D-1)/s.D - 1
+			}
+			for n := int64(0); n <= repeat; n++ {
+				r.Segments = append(r.Segments, resolve(base, r.fill(tmpl.Media, number, t)))
+				number++
+				t += s.D
+			}
+		}
+		return nil
+	}
+
+	if tmpl.Duration <= 0 || duration <= 0 {
+		return fmt.Errorf("segment template without duration or timeline")
+	}
+	count := int64(math.Ceil(duration.Seconds() * float64(timescale) / float64(tmpl.Duration)))
+	for n := int64(0); n < count; n++ {
+		r.Segments = append(r.Segments, resolve(base, r.fill(tmpl.Media, number+n, n*tmpl.Duration)))
+	}
+	return nil
+}
+
+var templateVar = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)(%0\d+d)?\$|\$\$`)
+
+// fill substitutes SegmentTemplate identifiers, including printf widths
+// such as $Number%05d$
+func (r *Representation) fill(s string, number, t int64) string {
+	return templateVar.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		sub := templateVar.FindStringSubmatch(match)
+		format := sub[2]
+		if format == "" {
+			format = "%d"
+		}
+		switch sub[1] {
+		case "RepresentationID":
+			return r.ID
+		case "Number":
+			return fmt.Sprintf(format, number)
+		case "Bandwidth":
+			return fmt.Sprintf(format, r.Bandwidth)
+		default:
+			return fmt.Sprintf(format, t)
+		}
+	})
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses an ISO 8601 duration as used by MPDs (PT1H2M3.5S)
+func ParseDuration(s string) (time.Duration, error) {
+	match := isoDuration.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil || s == "P" || s == "PT" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if match[i+1] == "" {
+			continue
+		}
+		v, _ := strconv.ParseFloat(match[i+1], 64)
+		d += time.Duration(v * float64(unit))
+	}
+	return d, nil
+}
+
+// join resolves a BaseURL element against its parent
+func join(base *url.URL, ref string) *url.URL {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base
+	}
+	if base == nil {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return base
+	}
+	return u
+}
+
+func resolve(base *url.URL, ref string) string {
+	if u := join(base, ref); u != nil {
+		return u.String()
+	}
+	return ref
+}
@@ -0,0 +1,51 @@
+package dash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownRepresentation is returned when a requested representation ID
+// isn't in the manifest
+var ErrUnknownRepresentation = errors.New("representation not in manifest")
+
+// Selection names the representations to download by ID. An empty field
+// keeps the automatic pick, the highest bandwidth one.
+type Selection struct {
+	Video string
+	Audio string
+}
+
+// Select returns the video and audio representations to download, either
+// of which is nil when the manifest has none of that kind
+func (m *Manifest) Select(sel Selection) (video, audio *Representation, err error) {
+	if video, err = pick("video", m.Video, sel.Video); err != nil {
+		return nil, nil, err
+	}
+	if audio, err = pick("audio", m.Audio, sel.Audio); err != nil {
+		return nil, nil, err
+	}
+	return video, audio, nil
+}
+
+func pick(kind string, reps []Representation, id string) (*Representation, error) {
+	if id == "" {
+		if len(reps) == 0 {
+			return nil, nil
+		}
+		return &reps[0], nil
+	}
+
+	ids := make([]string, len(reps))
+	for i := range reps {
+		if reps[i].ID == id {
+			return &reps[i], nil
+		}
+		ids[i] = reps[i].ID
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: %s %q, the manifest has no %s", ErrUnknownRepresentation, kind, id, kind)
+	}
+	return nil, fmt.Errorf("%w: %s %q (available: %s)", ErrUnknownRepresentation, kind, id, strings.Join(ids, ", "))
+}
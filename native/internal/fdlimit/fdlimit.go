@@ -0,0 +1,15 @@
+package fdlimit
+
+// PressureRatio is the fraction of the soft limit at which new work is held
+// back until descriptors are released
+const PressureRatio = 0.9
+
+// UnderPressure reports whether open descriptors are close to the limit.
+// It is always false where usage can't be measured.
+func UnderPressure() bool {
+	open, limit, ok := Usage()
+	if !ok || limit == 0 {
+		return false
+	}
+	return float64(open) >= float64(limit)*PressureRatio
+}
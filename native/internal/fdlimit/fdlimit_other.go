@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fdlimit
+
+// Raise is a no-op on platforms without RLIMIT_NOFILE
+func Raise() (soft, hard uint64, err error) {
+	return 0, 0, nil
+}
+
+// Usage can't be measured on this platform
+func Usage() (open int, limit uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package fdlimit
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// darwinOpenMax is the largest soft limit macOS accepts (OPEN_MAX) even
+// when the hard limit reports unlimited
+const darwinOpenMax = 10240
+
+// Raise lifts the soft RLIMIT_NOFILE towards the hard limit and returns
+// the resulting soft and hard limits
+func Raise() (soft, hard uint64, err error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+
+	target := rl.Max
+	if runtime.GOOS == "darwin" && target > darwinOpenMax {
+		target = darwinOpenMax
+	}
+
+	if rl.Cur < target {
+		raised := syscall.Rlimit{Cur: target, Max: rl.Max}
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err != nil {
+			return rl.Cur, rl.Max, err
+		}
+		rl.Cur = target
+	}
+
+	return rl.Cur, rl.Max, nil
+}
+
+// Usage returns the number of open descriptors and the soft limit
+func Usage() (open int, limit uint64, ok bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, false
+	}
+
+	dir := "/proc/self/fd"
+	if runtime.GOOS == "darwin" {
+		dir = "/dev/fd"
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, rl.Cur, false
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return 0, rl.Cur, false
+	}
+
+	// Don't count the descriptor used to list the directory
+	return len(names) - 1, rl.Cur, true
+}
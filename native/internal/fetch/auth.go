@@ -0,0 +1,34 @@
+package fetch
+
+import (
+	"encoding/base64"
+	neturl "net/url"
+	"strings"
+)
+
+// WithBasicAuth returns a copy of headers carrying an Authorization: Basic
+// header for user and password, replacing any Authorization already set
+func WithBasicAuth(headers map[string]string, user, password string) map[string]string {
+	out := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		if !strings.EqualFold(k, "Authorization") {
+			out[k] = v
+		}
+	}
+	creds := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	out["Authorization"] = "Basic " + creds
+	return out
+}
+
+// StripUserinfo removes "user:password@" from a URL, returning the URL
+// without it and the credentials it held. ok is false when there were none.
+func StripUserinfo(raw string) (clean, user, password string, ok bool) {
+	u, err := neturl.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw, "", "", false
+	}
+	user = u.User.Username()
+	password, _ = u.User.Password()
+	u.User = nil
+	return u.String(), user, password, true
+}
@@ -0,0 +1,47 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Range is a byte range of a resource, for segments that are slices of one
+// file (HLS EXT-X-BYTERANGE)
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// getRange issues a GET for a byte range of url, or the whole resource when
+// r is nil. A server that ignores the Range header and sends the whole file
+// is handled by skipping to the range in its body.
+func getRange(ctx context.Context, url string, headers map[string]string, r *Range) (*http.Response, error) {
+	if r == nil {
+		return get(ctx, url, headers)
+	}
+
+	ranged := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		ranged[k] = v
+	}
+	ranged["Range"] = fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+
+	resp, err := get(ctx, url, ranged)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(io.Discard, resp.Body, r.Offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("byte range %d@%d is past the end of the response", r.Length, r.Offset)
+		}
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, r.Length), resp.Body}
+	resp.ContentLength = r.Length
+	return resp, nil
+}
@@ -0,0 +1,70 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetRange(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+
+	servers := map[string]http.HandlerFunc{
+		"honors Range": func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(data))
+		},
+		"ignores Range": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		},
+	}
+
+	tests := []struct {
+		name string
+		r    *Range
+		want string
+	}{
+		{"whole file", nil, string(data)},
+		{"start", &Range{Offset: 0, Length: 4}, "0123"},
+		{"middle", &Range{Offset: 10, Length: 5}, "abcde"},
+		{"end", &Range{Offset: 15, Length: 5}, "fghij"},
+	}
+
+	for name, handler := range servers {
+		srv := httptest.NewServer(handler)
+		for _, tt := range tests {
+			t.Run(name+", "+tt.name, func(t *testing.T) {
+				resp, err := getRange(context.Background(), srv.URL, nil, tt.r)
+				if err != nil {
+					t.Fatalf("getRange: %v", err)
+				}
+				defer resp.Body.Close()
+				got, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("body = %q, want %q", got, tt.want)
+				}
+				if tt.r != nil && resp.ContentLength != tt.r.Length {
+					t.Errorf("ContentLength = %d, want %d", resp.ContentLength, tt.r.Length)
+				}
+			})
+		}
+		srv.Close()
+	}
+}
+
+func TestGetRangePastEnd(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("short"))
+	}))
+	defer srv.Close()
+
+	if _, err := getRange(context.Background(), srv.URL, nil, &Range{Offset: 100, Length: 10}); err == nil {
+		t.Error("getRange past the end of a server ignoring Range succeeded, want an error")
+	}
+}
@@ -0,0 +1,255 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ratelimit"
+)
+
+const userAgent = "Vidown/1.0 (Native Companion)"
+
+// StatusError is returned when the server answers with a non-2xx status
+type StatusError struct {
+	StatusCode int
+
+	// RetryAfter is the server's Retry-After delay, 0 when it sent none
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// ProgressCallback is called with the bytes written so far and the total
+// reported by the server (0 if unknown)
+type ProgressCallback func(bytesWritten, total int64)
+
+// Options configures a Go-based HTTP download
+type Options struct {
+	Headers    map[string]string
+	Limiter    *ratelimit.Limiter
+	OnProgress ProgressCallback
+
+	// Range limits Download to a slice of the resource; nil = all of it
+	Range *Range
+
+	// Preallocate reserves the output's size on disk before Download
+	// writes it: the server's Content-Length, else ExpectedSize. A disk
+	// too full for it fails the download before a byte is fetched.
+	Preallocate   bool
+	ExpectedSize  int64
+	OnPreallocate func(fsutil.PreallocateResult)
+}
+
+// Download fetches url into output using net/http instead of ffmpeg
+func Download(ctx context.Context, url, output string, opts Options) error {
+	resp, err := getRange(ctx, url, opts.Headers, opts.Range)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	total := resp.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	reserved := int64(0)
+	if opts.Preallocate {
+		if reserved, err = preallocate(f, total, opts); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if opts.OnProgress != nil {
+		// Known before the first byte arrives, so percent is exact from 0
+		opts.OnProgress(0, total)
+	}
+
+	written, err := copyBody(ctx, f, resp.Body, opts.Limiter, func(written int64) {
+		if opts.OnProgress != nil {
+			opts.OnProgress(written, total)
+		}
+	})
+	if err == nil && reserved > written {
+		// Give back what an expected size too big reserved past the end
+		err = f.Truncate(written)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// preallocate reserves the download's size in f, when it's known, and
+// returns what it reserved. Only running out of space is an error; a
+// platform or filesystem without preallocation just writes as usual.
+func preallocate(f *os.File, total int64, opts Options) (int64, error) {
+	size := total
+	if size <= 0 {
+		size = opts.ExpectedSize
+	}
+	if size <= 0 {
+		if opts.OnPreallocate != nil {
+			opts.OnPreallocate(fsutil.PreallocateResult{Reason: "size unknown"})
+		}
+		return 0, nil
+	}
+
+	err := fsutil.Preallocate(f, size)
+	if opts.OnPreallocate != nil {
+		opts.OnPreallocate(fsutil.NewPreallocateResult(size, err))
+	}
+	switch {
+	case err == nil:
+		return size, nil
+	case fsutil.IsNoSpace(err):
+		return 0, fmt.Errorf("not enough disk space for %d bytes: %w", size, err)
+	}
+	return 0, nil
+}
+
+// get issues a GET with the given headers and fails on non-2xx statuses
+func get(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+	var traced *tracedRequest
+	if s := netStatsFor(ctx); s != nil {
+		ctx, traced = s.trace(ctx)
+	}
+
+	req, err := newRequest(ctx, http.MethodGet, url, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := clientFor(ctx).Do(req)
+	if err != nil {
+		if traced != nil {
+			traced.finish()
+		}
+		return nil, err
+	}
+	if traced != nil {
+		resp.Body = traced.body(resp.Body)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return resp, nil
+}
+
+// newRequest builds a request carrying the download's headers
+func newRequest(ctx context.Context, method, url string, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	return req, nil
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// copyBody copies body to w through the limiter, calling onChunk with the
+// running byte count after every write
+func copyBody(ctx context.Context, w io.Writer, body io.Reader, limiter *ratelimit.Limiter, onChunk func(written int64)) (int64, error) {
+	if limiter != nil {
+		body = limiter.Reader(ctx, body)
+	}
+
+	var written int64
+	buf := make([]byte, ratelimit.ChunkSize)
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+			onChunk(written)
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// maxBodySize bounds in-memory fetches (manifests, playlists)
+const maxBodySize = 16 * 1024 * 1024
+
+// Get fetches a small resource such as a manifest into memory. The final URL
+// after redirects is returned so relative references resolve correctly.
+// A response prefetched by Warmup moments ago is used instead of fetching.
+func Get(ctx context.Context, url string, headers map[string]string) ([]byte, *neturl.URL, error) {
+	if body, final, ok := takePrefetched(url, headers); ok {
+		return body, final, nil
+	}
+
+	resp, err := get(ctx, url, headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return readSmall(resp)
+}
+
+// readSmall reads and closes a response body bounded by maxBodySize
+func readSmall(resp *http.Response) ([]byte, *neturl.URL, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, nil, fmt.Errorf("response larger than %d bytes", maxBodySize)
+	}
+
+	return body, resp.Request.URL, nil
+}
@@ -0,0 +1,61 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentLength asks the server for the size of url without downloading
+// it: a HEAD, or a one-byte ranged GET for servers that refuse HEAD. It
+// returns 0 when the size is unknown, as for chunked responses.
+func ContentLength(ctx context.Context, url string, headers map[string]string) (int64, error) {
+	req, err := newRequest(ctx, http.MethodHead, url, headers)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := clientFor(ctx).Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 && resp.ContentLength > 0 {
+			return resp.ContentLength, nil
+		}
+	}
+
+	ranged := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		ranged[k] = v
+	}
+	ranged["Range"] = "bytes=0-0"
+	resp, err = get(ctx, url, ranged)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPartialContent {
+		io.Copy(io.Discard, resp.Body)
+		return contentRangeTotal(resp.Header.Get("Content-Range")), nil
+	}
+	// The range was ignored; the body is the whole file and is dropped
+	if resp.ContentLength > 0 {
+		return resp.ContentLength, nil
+	}
+	return 0, nil
+}
+
+// contentRangeTotal reads the complete length from a "bytes 0-0/1234"
+// Content-Range, 0 if it's missing or "*"
+func contentRangeTotal(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package fetch
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+)
+
+// layoutPeekSize is how much of a file's head MP4Layout reads
+const layoutPeekSize = 64 << 10
+
+// Layout is what the head of a remote file says about probing it
+type Layout struct {
+	// Ranges is set when the server answered a Range request with 206,
+	// so a reader can seek instead of streaming the whole file
+	Ranges bool
+	// MP4 is set when the file starts with an ISO BMFF box
+	MP4 bool
+	// MoovAtEnd is set for an MP4 whose media data comes before its index
+	// (not faststart), which a reader must fetch the tail of
+	MoovAtEnd bool
+}
+
+// MP4Layout reads the first bytes of url with a ranged GET and walks the
+// top-level MP4 boxes in them to find whether moov precedes mdat. Files
+// that aren't MP4 come back with only Ranges set.
+func MP4Layout(ctx context.Context, url string, headers map[string]string) (Layout, error) {
+	resp, err := getRange(ctx, url, headers, &Range{Length: layoutPeekSize})
+	if err != nil {
+		return Layout{}, err
+	}
+	defer resp.Body.Close()
+
+	head, err := io.ReadAll(resp.Body)
+	if err != nil && len(head) == 0 {
+		return Layout{}, err
+	}
+
+	layout := Layout{Ranges: resp.StatusCode == http.StatusPartialContent}
+	for off := int64(0); off+8 <= int64(len(head)); {
+		size := int64(binary.BigEndian.Uint32(head[off:]))
+		kind := string(head[off+4 : off+8])
+		if off == 0 {
+			if kind != "ftyp" && kind != "styp" {
+				return layout, nil
+			}
+			layout.MP4 = true
+		}
+		switch kind {
+		case "moov", "moof":
+			return layout, nil
+		case "mdat":
+			layout.MoovAtEnd = true
+			return layout, nil
+		}
+
+		switch size {
+		case 0: // runs to the end of the file
+			return layout, nil
+		case 1: // 64-bit size follows the type
+			if off+16 > int64(len(head)) {
+				return layout, nil
+			}
+			size = int64(binary.BigEndian.Uint64(head[off+8:]))
+		}
+		if size < 8 {
+			return layout, nil
+		}
+		off += size
+	}
+	return layout, nil
+}
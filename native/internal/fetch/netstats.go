@@ -0,0 +1,213 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxReportedConns bounds the connections a NetSnapshot lists
+const maxReportedConns = 32
+
+// NetStats follows the connections the requests of a download use, for
+// telling a slow server from a slow local network. Attach it with
+// WithNetStats; it costs an httptrace per request, so only debug runs do.
+type NetStats struct {
+	mu       sync.Mutex
+	conns    map[string]*ConnStats // by local address, unique per connection
+	opened   int
+	reused   int
+	active   int
+	peak     int
+	requests int
+	bytes    int64
+	ttfb     time.Duration // of the last request
+	ttfbSum  time.Duration
+	ttfbN    int
+	since    time.Time // previous Snapshot
+}
+
+// ConnStats is one connection's share of a download
+type ConnStats struct {
+	Remote   string `json:"remote"`
+	Requests int    `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+	Active   bool   `json:"active"`
+
+	inFlight int
+	lastUsed time.Time
+}
+
+// NetSnapshot is NetStats at one point in time. Reconnects counts the
+// connections opened beyond the most ever used at once: with keep-alive
+// working it stays 0, a server dropping connections makes it climb.
+type NetSnapshot struct {
+	ActiveConnections int         `json:"activeConnections"`
+	ConnectionsOpened int         `json:"connectionsOpened"`
+	ConnectionsReused int         `json:"connectionsReused"`
+	Reconnects        int         `json:"reconnects"`
+	Requests          int         `json:"requests"`
+	Bytes             int64       `json:"bytes"`
+	TTFBMs            float64     `json:"ttfbMs"`
+	AvgTTFBMs         float64     `json:"avgTtfbMs"`
+	Connections       []ConnStats `json:"connections"` // in use since the last snapshot
+}
+
+// NewNetStats returns empty stats
+func NewNetStats() *NetStats {
+	return &NetStats{conns: make(map[string]*ConnStats), since: time.Now()}
+}
+
+type netStatsKey struct{}
+
+// WithNetStats traces every request made with the returned context into s
+func WithNetStats(ctx context.Context, s *NetStats) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, netStatsKey{}, s)
+}
+
+func netStatsFor(ctx context.Context) *NetStats {
+	s, _ := ctx.Value(netStatsKey{}).(*NetStats)
+	return s
+}
+
+// Snapshot returns the stats so far
+func (s *NetStats) Snapshot() NetSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := NetSnapshot{
+		ConnectionsOpened: s.opened,
+		ConnectionsReused: s.reused,
+		Reconnects:        max(s.opened-s.peak, 0),
+		Requests:          s.requests,
+		Bytes:             s.bytes,
+		TTFBMs:            float64(s.ttfb.Microseconds()) / 1000,
+		Connections:       []ConnStats{},
+	}
+	if s.ttfbN > 0 {
+		snap.AvgTTFBMs = float64((s.ttfbSum / time.Duration(s.ttfbN)).Microseconds()) / 1000
+	}
+	for _, c := range s.conns {
+		if c.inFlight > 0 {
+			snap.ActiveConnections++
+		}
+		if c.inFlight > 0 || c.lastUsed.After(s.since) {
+			conn := *c
+			conn.Active = c.inFlight > 0
+			snap.Connections = append(snap.Connections, conn)
+		}
+	}
+	sort.Slice(snap.Connections, func(i, j int) bool {
+		return snap.Connections[i].Bytes > snap.Connections[j].Bytes
+	})
+	if len(snap.Connections) > maxReportedConns {
+		snap.Connections = snap.Connections[:maxReportedConns]
+	}
+	s.since = time.Now()
+	return snap
+}
+
+// tracedRequest is one request as NetStats sees it
+type tracedRequest struct {
+	s    *NetStats
+	conn *ConnStats
+	sent time.Time
+	done bool
+}
+
+// trace hooks a request's connection and first byte into s
+func (s *NetStats) trace(ctx context.Context) (context.Context, *tracedRequest) {
+	r := &tracedRequest{s: s}
+	trace := &httptrace.ClientTrace{
+		GotConn:      r.gotConn,
+		WroteRequest: func(httptrace.WroteRequestInfo) { r.sent = time.Now() },
+		GotFirstResponseByte: func() {
+			if r.sent.IsZero() {
+				return
+			}
+			d := time.Since(r.sent)
+			s.mu.Lock()
+			s.ttfb = d
+			s.ttfbSum += d
+			s.ttfbN++
+			s.mu.Unlock()
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), r
+}
+
+func (r *tracedRequest) gotConn(info httptrace.GotConnInfo) {
+	s := r.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := info.Conn.LocalAddr().String()
+	c, ok := s.conns[key]
+	if !ok {
+		c = &ConnStats{Remote: info.Conn.RemoteAddr().String()}
+		s.conns[key] = c
+	}
+	if info.Reused {
+		s.reused++
+	} else {
+		s.opened++
+	}
+	s.requests++
+	c.Requests++
+	c.inFlight++
+	c.lastUsed = time.Now()
+	s.active++
+	s.peak = max(s.peak, s.active)
+	r.conn = c
+}
+
+// finish ends the request's use of its connection
+func (r *tracedRequest) finish() {
+	s := r.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.done || r.conn == nil {
+		return
+	}
+	r.done = true
+	r.conn.inFlight--
+	r.conn.lastUsed = time.Now()
+	s.active--
+}
+
+// body counts what's read from a response body against its connection and
+// ends the request when the body is closed
+func (r *tracedRequest) body(body io.ReadCloser) io.ReadCloser {
+	return &countingBody{ReadCloser: body, r: r}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	r *tracedRequest
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		s := b.r.s
+		s.mu.Lock()
+		s.bytes += int64(n)
+		if b.r.conn != nil {
+			b.r.conn.Bytes += int64(n)
+			b.r.conn.lastUsed = time.Now()
+		}
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.r.finish()
+	return b.ReadCloser.Close()
+}
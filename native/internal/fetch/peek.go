@@ -0,0 +1,22 @@
+package fetch
+
+import (
+	"context"
+	"io"
+)
+
+// Peek returns up to n bytes from the start of url, asked for with a
+// ranged GET so a big file isn't downloaded just to look at its head
+func Peek(ctx context.Context, url string, headers map[string]string, n int64) ([]byte, error) {
+	resp, err := getRange(ctx, url, headers, &Range{Length: n})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	head, err := io.ReadAll(resp.Body)
+	if err != nil && len(head) == 0 {
+		return nil, err
+	}
+	return head, nil
+}
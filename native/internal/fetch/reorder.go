@@ -0,0 +1,148 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// DefaultMaxBufferBytes bounds the reorder buffer of a concurrent segment
+// download when SegmentOptions doesn't set MaxBufferBytes
+const DefaultMaxBufferBytes = 64 << 20
+
+// BufferStats is the occupancy of a concurrent download's reorder buffer:
+// segments fetched, or being fetched, ahead of the one the output waits on
+type BufferStats struct {
+	Bytes    int64 `json:"bytes"`
+	Segments int   `json:"segments"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// fetchedEntry is a segment fetched into memory by a worker
+type fetchedEntry struct {
+	index int
+	data  []byte
+	err   error
+}
+
+// downloadOrdered fetches entries from..len-1 with opts.Concurrency workers
+// and writes them to f in order. Entries that finish ahead of their turn
+// wait in memory; no further-ahead entry is started while those and the
+// ones in flight hold MaxBufferBytes, so a slow early segment stalls the
+// workers instead of letting memory grow. The entry the output waits on is
+// always fetched, whatever the buffer holds.
+func downloadOrdered(ctx context.Context, f *os.File, entries []string, ranges []*Range, media, segments int, from Checkpoint, opts SegmentOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limit := opts.MaxBufferBytes
+	if limit <= 0 {
+		limit = DefaultMaxBufferBytes
+	}
+
+	var inflight atomic.Int64 // bytes read by workers so far
+	var held int64            // bytes of finished entries waiting their turn
+	waiting := make(map[int][]byte)
+	results := make(chan fetchedEntry)
+
+	report := func() {
+		if opts.OnBuffer != nil {
+			opts.OnBuffer(BufferStats{Bytes: held + inflight.Load(), Segments: len(waiting), MaxBytes: limit})
+		}
+	}
+
+	fetch := func(i int) {
+		var buf bytes.Buffer
+		err := func() error {
+			if opts.BeforeSegment != nil {
+				if err := opts.BeforeSegment(ctx); err != nil {
+					return err
+				}
+			}
+			resp, err := getRange(ctx, entries[i], opts.Headers, ranges[i])
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			var last int64
+			_, err = copyBody(ctx, &buf, resp.Body, opts.Limiter, func(n int64) {
+				inflight.Add(n - last)
+				last = n
+			})
+			inflight.Add(-last)
+			return err
+		}()
+		results <- fetchedEntry{index: i, data: buf.Bytes(), err: err}
+	}
+
+	written := from.Bytes
+	next := from.Done // next entry to start
+	turn := from.Done // next entry to write
+	active := 0
+	var firstErr error
+	for turn < len(entries) || active > 0 {
+		for firstErr == nil && active < opts.Concurrency && next < len(entries) &&
+			(next == turn || held+inflight.Load() < limit) {
+			go fetch(next)
+			next++
+			active++
+		}
+		if active == 0 {
+			break
+		}
+
+		r := <-results
+		active--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = segmentError(r.index, media, segments, r.err)
+				cancel()
+			}
+			continue
+		}
+		if firstErr != nil {
+			continue
+		}
+
+		waiting[r.index] = r.data
+		held += int64(len(r.data))
+		for data, ok := waiting[turn]; ok; data, ok = waiting[turn] {
+			if _, err := f.Write(data); err != nil {
+				firstErr = err
+				cancel()
+				break
+			}
+			delete(waiting, turn)
+			held -= int64(len(data))
+			written += int64(len(data))
+			turn++
+
+			if opts.OnProgress != nil {
+				opts.OnProgress(written, 0)
+			}
+			if opts.OnCheckpoint != nil {
+				opts.OnCheckpoint(Checkpoint{Done: turn, Bytes: written})
+			}
+			if turn > media && opts.OnSegment != nil {
+				opts.OnSegment(turn-media, segments)
+			}
+		}
+		report()
+	}
+
+	if firstErr != nil {
+		f.Close()
+		return firstErr
+	}
+	return f.Close()
+}
+
+// segmentError names the entry a segmented download failed on
+func segmentError(i, media, segments int, err error) error {
+	if i < media {
+		return fmt.Errorf("init segment: %w", err)
+	}
+	return fmt.Errorf("segment %d of %d: %w", i-media+1, segments, err)
+}
@@ -0,0 +1,161 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SegmentOptions configures DownloadSegments
+type SegmentOptions struct {
+	Options
+
+	// OnSegment is called after each segment is written with the number
+	// written so far and the total
+	OnSegment func(done, count int)
+
+	// Resume continues a partial output instead of starting over: the
+	// entries it counts are kept and the file is cut back to its Bytes
+	Resume *Checkpoint
+
+	// OnCheckpoint is called whenever the output ends on an entry boundary
+	OnCheckpoint func(Checkpoint)
+
+	// InitRange and Ranges address the init segment and each media segment
+	// as a slice of their URL; nil (or a short Ranges) means whole files
+	InitRange *Range
+	Ranges    []*Range
+
+	// Concurrency is how many segments are fetched at once; up to 1
+	// streams them one at a time straight into the output. Above that,
+	// segments are fetched into memory and written in order, holding at
+	// most MaxBufferBytes ahead of the output (0 = DefaultMaxBufferBytes).
+	Concurrency    int
+	MaxBufferBytes int64
+
+	// OnBuffer is called with the reorder buffer's occupancy whenever a
+	// concurrent download's segment arrives
+	OnBuffer func(BufferStats)
+
+	// BeforeSegment is called before each entry is requested and may hold
+	// it back, e.g. while file descriptors run short; an error stops the
+	// download
+	BeforeSegment func(ctx context.Context) error
+}
+
+// Checkpoint is a point a segmented download can resume from
+type Checkpoint struct {
+	Done  int   `json:"done"`  // entries fully written, the init segment included
+	Bytes int64 `json:"bytes"` // output length after them
+}
+
+// ErrResumeMismatch is returned when a partial output is shorter than its
+// checkpoint says, so resuming would leave a gap in the stream
+var ErrResumeMismatch = errors.New("partial file doesn't match its checkpoint")
+
+// DownloadSegments fetches a segmented stream into a single file: the init
+// segment first (fragmented MP4 needs it ahead of any media segment), then
+// the media segments in order. OnProgress reports the bytes written to
+// output; the total is unknown and passed as 0.
+func DownloadSegments(ctx context.Context, init string, segments []string, output string, opts SegmentOptions) error {
+	var entries []string
+	var ranges []*Range
+	if init != "" {
+		entries = append(entries, init)
+		ranges = append(ranges, opts.InitRange)
+	}
+	entries = append(entries, segments...)
+	for i := range segments {
+		var r *Range
+		if i < len(opts.Ranges) {
+			r = opts.Ranges[i]
+		}
+		ranges = append(ranges, r)
+	}
+
+	f, from, err := openSegmentOutput(output, opts.Resume, len(entries))
+	if err != nil {
+		return err
+	}
+
+	media := len(entries) - len(segments) // index of the first media segment
+	if from.Done > media && opts.OnSegment != nil {
+		opts.OnSegment(from.Done-media, len(segments))
+	}
+	if opts.Concurrency > 1 {
+		return downloadOrdered(ctx, f, entries, ranges, media, len(segments), from, opts)
+	}
+
+	written := from.Bytes
+	fetchInto := func(url string, r *Range) error {
+		if opts.BeforeSegment != nil {
+			if err := opts.BeforeSegment(ctx); err != nil {
+				return err
+			}
+		}
+		resp, err := getRange(ctx, url, opts.Headers, r)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		offset := written
+		n, err := copyBody(ctx, f, resp.Body, opts.Limiter, func(n int64) {
+			if opts.OnProgress != nil {
+				opts.OnProgress(offset+n, 0)
+			}
+		})
+		written += n
+		return err
+	}
+
+	for i := from.Done; i < len(entries); i++ {
+		if err := fetchInto(entries[i], ranges[i]); err != nil {
+			f.Close()
+			return segmentError(i, media, len(segments), err)
+		}
+		if opts.OnCheckpoint != nil {
+			opts.OnCheckpoint(Checkpoint{Done: i + 1, Bytes: written})
+		}
+		if i >= media && opts.OnSegment != nil {
+			opts.OnSegment(i-media+1, len(segments))
+		}
+	}
+
+	return f.Close()
+}
+
+// openSegmentOutput opens output for writing at the checkpoint, or creates
+// it afresh when there is none, and returns the point writing starts from
+func openSegmentOutput(output string, resume *Checkpoint, entries int) (*os.File, Checkpoint, error) {
+	if resume == nil || resume.Done <= 0 {
+		f, err := os.Create(output)
+		return f, Checkpoint{}, err
+	}
+	if resume.Done > entries {
+		return nil, Checkpoint{}, fmt.Errorf("%w: %d entries done of %d", ErrResumeMismatch, resume.Done, entries)
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, Checkpoint{}, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() < resume.Bytes {
+		err = fmt.Errorf("%w: %d bytes, expected %d", ErrResumeMismatch, info.Size(), resume.Bytes)
+	}
+	if err == nil {
+		// Drop whatever of an unfinished entry got written past the boundary
+		err = f.Truncate(resume.Bytes)
+	}
+	if err == nil {
+		_, err = f.Seek(resume.Bytes, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, Checkpoint{}, err
+	}
+	return f, *resume, nil
+}
@@ -0,0 +1,170 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Source is a local address downloads are bound to, so they leave through
+// a chosen interface (e.g. the LAN rather than a VPN) instead of the default
+// route
+type Source struct {
+	IP        net.IP
+	Interface string
+}
+
+// ResolveSource checks spec, an IP address or an interface name, against
+// the machine's interfaces. An IP must be assigned to a local interface; an
+// interface name resolves to its first IPv4 address, else its first IPv6.
+func ResolveSource(spec string) (*Source, error) {
+	if want := net.ParseIP(spec); want != nil {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, fmt.Errorf("list interfaces: %w", err)
+		}
+		for _, iface := range ifaces {
+			for _, ip := range interfaceIPs(&iface) {
+				if ip.Equal(want) {
+					return &Source{IP: want, Interface: iface.Name}, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("%s is not an address of any local interface", spec)
+	}
+
+	iface, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("no interface named %q", spec)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", spec)
+	}
+
+	var v6 net.IP
+	for _, ip := range interfaceIPs(iface) {
+		if ip.To4() != nil {
+			return &Source{IP: ip, Interface: iface.Name}, nil
+		}
+		if v6 == nil && !ip.IsLinkLocalUnicast() {
+			v6 = ip
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", spec)
+	}
+	return &Source{IP: v6, Interface: iface.Name}, nil
+}
+
+func interfaceIPs(iface *net.Interface) []net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
+
+// route is how a job's requests leave the machine: from a bound source
+// address, through a proxy, or both
+type route struct {
+	ip    net.IP
+	proxy *url.URL
+}
+
+// routeClients holds one client per route, so connections opened by one
+// request (or a warmup) are pooled for the next
+var (
+	routeMu      sync.Mutex
+	routeClients = make(map[string]*http.Client)
+)
+
+// client returns an HTTP client for the route. Binding failures surface as
+// dial errors instead of quietly falling back to the default route.
+func (r route) client() *http.Client {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+
+	key := r.ip.String()
+	if r.proxy != nil {
+		key += " via " + r.proxy.String()
+	}
+	if c, ok := routeClients[key]; ok {
+		return c
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if r.ip != nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			LocalAddr: &net.TCPAddr{IP: r.ip},
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	if r.proxy != nil {
+		transport.Proxy = http.ProxyURL(r.proxy)
+	}
+	c := &http.Client{Transport: transport}
+	routeClients[key] = c
+	return c
+}
+
+type routeKey struct{}
+
+func routeFrom(ctx context.Context) route {
+	r, _ := ctx.Value(routeKey{}).(route)
+	return r
+}
+
+// WithSource binds every request made with the returned context to src
+func WithSource(ctx context.Context, src *Source) context.Context {
+	if src == nil {
+		return ctx
+	}
+	r := routeFrom(ctx)
+	r.ip = src.IP
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+// WithProxy sends every request made with the returned context through
+// proxy, see ParseProxy
+func WithProxy(ctx context.Context, proxy *url.URL) context.Context {
+	if proxy == nil {
+		return ctx
+	}
+	r := routeFrom(ctx)
+	r.proxy = proxy
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+// ParseProxy checks a job's proxy, an http(s) URL such as
+// "http://host:3128" as ffmpeg's http_proxy takes it
+func ParseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("proxy must be an http(s) URL, got %q", s)
+	}
+	return u, nil
+}
+
+// clientFor returns the client requests under ctx should use
+func clientFor(ctx context.Context) *http.Client {
+	r, ok := ctx.Value(routeKey{}).(route)
+	if !ok {
+		return http.DefaultClient
+	}
+	return r.client()
+}
@@ -0,0 +1,54 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithProxy(t *testing.T) {
+	var seen string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.RequestURI
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	u, err := ParseProxy(proxy.URL)
+	if err != nil {
+		t.Fatalf("ParseProxy(%q): %v", proxy.URL, err)
+	}
+	ctx := WithProxy(context.Background(), u)
+
+	// The origin doesn't resolve, only the proxy can answer for it
+	resp, err := getRange(ctx, "http://origin.invalid/video.mp4", nil, nil)
+	if err != nil {
+		t.Fatalf("getRange through proxy: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "via proxy" || seen != "http://origin.invalid/video.mp4" {
+		t.Errorf("proxy saw %q and answered %q", seen, body)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{"http://proxy:3128", false},
+		{"https://user:pw@proxy:443", false},
+		{"socks5://proxy:1080", true},
+		{"proxy:3128", true},
+		{"http://", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseProxy(tt.in); (err != nil) != tt.wantErr {
+			t.Errorf("ParseProxy(%q) = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+	}
+}
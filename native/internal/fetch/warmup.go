@@ -0,0 +1,137 @@
+package fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing breaks down how long setting up a request took. Phases that didn't
+// happen (cached DNS, a reused connection, plain HTTP) are zero.
+type Timing struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration // from sending the request
+	Reused    bool          // an idle pooled connection was used
+	Status    int
+}
+
+// prefetchTTL is how long a prefetched manifest is served from memory
+const prefetchTTL = 30 * time.Second
+
+type prefetched struct {
+	body    []byte
+	final   *neturl.URL
+	fetched time.Time
+}
+
+var (
+	prefetchMu    sync.Mutex
+	prefetchCache = make(map[string]prefetched)
+)
+
+// Warmup opens a connection to url's host ahead of a download, so the
+// download finds DNS resolved and a TLS connection in the pool. With
+// prefetch the resource (a manifest) is fetched and the next Get for it
+// within prefetchTTL is served from memory; otherwise a HEAD is sent.
+// Requests use the client and headers a download under ctx would.
+func Warmup(ctx context.Context, url string, headers map[string]string, prefetch bool) (*Timing, error) {
+	t := &Timing{}
+	var dnsStart, connStart, tlsStart, sent time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.DNS = time.Since(dnsStart) },
+		ConnectStart: func(_, _ string) {
+			if connStart.IsZero() {
+				connStart = time.Now()
+			}
+		},
+		ConnectDone:       func(_, _ string, _ error) { t.Connect = time.Since(connStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.TLS = time.Since(tlsStart) },
+		GotConn:           func(info httptrace.GotConnInfo) { t.Reused = info.Reused },
+		WroteRequest:      func(httptrace.WroteRequestInfo) { sent = time.Now() },
+		GotFirstResponseByte: func() {
+			if !sent.IsZero() {
+				t.FirstByte = time.Since(sent)
+			}
+		},
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace)
+
+	if !prefetch {
+		req, err := newRequest(ctx, http.MethodHead, url, headers)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := clientFor(ctx).Do(req)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		t.Status = resp.StatusCode
+		return t, nil
+	}
+
+	resp, err := get(ctx, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	t.Status = resp.StatusCode
+	body, final, err := readSmall(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	prefetchMu.Lock()
+	prefetchCache[prefetchKey(url, headers)] = prefetched{body: body, final: final, fetched: time.Now()}
+	prefetchMu.Unlock()
+	return t, nil
+}
+
+// takePrefetched returns and forgets a fresh prefetched response. Entries
+// are used once, so a manifest that changes is never served stale twice.
+func takePrefetched(url string, headers map[string]string) ([]byte, *neturl.URL, bool) {
+	prefetchMu.Lock()
+	defer prefetchMu.Unlock()
+
+	for key, p := range prefetchCache {
+		if time.Since(p.fetched) > prefetchTTL {
+			delete(prefetchCache, key)
+		}
+	}
+
+	key := prefetchKey(url, headers)
+	p, ok := prefetchCache[key]
+	if !ok {
+		return nil, nil, false
+	}
+	delete(prefetchCache, key)
+	return p.body, p.final, true
+}
+
+// prefetchKey identifies a request by URL and headers, since headers such
+// as cookies can change what the server returns
+func prefetchKey(url string, headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(url)
+	for _, k := range keys {
+		b.WriteString("\n" + strings.ToLower(k) + ": " + headers[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package ff
+
+import "strconv"
+
+// AudioTrack is an audio input muxed into the output as its own track
+type AudioTrack struct {
+	URL      string
+	Language string // ISO 639 code for the track's language tag
+	Title    string // name players show for the track
+	Default  bool   // the track players pick first
+}
+
+// PrimaryAudioTrack returns the index of the track marked default: the
+// first one asking to be, else the first
+func PrimaryAudioTrack(tracks []AudioTrack) int {
+	for i, t := range tracks {
+		if t.Default {
+			return i
+		}
+	}
+	return 0
+}
+
+// BuildMultiAudioArgs constructs ffmpeg args muxing the video of videoURL
+// with every track in tracks as a separate, selectable audio track, none
+// re-encoded. The source's own audio is left out; list it as a track to
+// keep it. Exactly one track is marked default, see PrimaryAudioTrack.
+func BuildMultiAudioArgs(videoURL string, tracks []AudioTrack, output, container string, headers map[string]string, protocols []string) []string {
+	args := networkInputArgs(videoURL, headers, protocols)
+	for _, t := range tracks {
+		args = append(args, networkInputArgs(t.URL, headers, protocols)...)
+	}
+
+	args = append(args, "-map", "0:v:0")
+	for i := range tracks {
+		args = append(args, "-map", strconv.Itoa(i+1)+":a:0")
+	}
+	args = append(args, "-c", "copy")
+
+	primary := PrimaryAudioTrack(tracks)
+	for i, t := range tracks {
+		stream := "s:a:" + strconv.Itoa(i)
+		if t.Language != "" {
+			args = append(args, "-metadata:"+stream, "language="+t.Language)
+		}
+		if t.Title != "" {
+			args = append(args, "-metadata:"+stream, "title="+t.Title)
+		}
+		disposition := "0"
+		if i == primary {
+			disposition = "default"
+		}
+		args = append(args, "-disposition:a:"+strconv.Itoa(i), disposition)
+	}
+
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
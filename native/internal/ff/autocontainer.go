@@ -0,0 +1,70 @@
+package ff
+
+import (
+	"errors"
+	"strings"
+)
+
+// muxIncompatibleLines are what ffmpeg's muxers log when a stream's codec
+// can't go in the output container
+var muxIncompatibleLines = []string{
+	"Could not find tag for codec",
+	"not currently supported in container",
+	"incompatible with output codec id",
+	"are supported for WebM",
+	"Only audio streams can be muxed",
+}
+
+// MuxIncompatibility returns the line a failed ffmpeg run logged when it
+// stopped because the output container can't hold one of its streams'
+// codecs, and false when it failed for another reason
+func MuxIncompatibility(err error) (string, bool) {
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		return "", false
+	}
+	for _, line := range exitErr.Stderr {
+		for _, s := range muxIncompatibleLines {
+			if strings.Contains(line, s) {
+				return line, true
+			}
+		}
+	}
+	return "", false
+}
+
+// nearContainers are, by container, the ones closest to it in players and
+// features, tried in order when it can't hold the output's codecs
+var nearContainers = map[string][]string{
+	"mp4": {"mov"},
+	"mov": {"mp4"},
+	"m4a": {"mp4"},
+	"ts":  {"mp4"},
+	"mp3": {"m4a"},
+}
+
+// NearestContainer picks the container closest to want that can hold the
+// vcodec and acodec (ffprobe names, empty for an absent stream) and that
+// the local ffmpeg can write. mkv, which holds anything, is the last resort.
+func NearestContainer(want, vcodec, acodec string) string {
+	for _, c := range nearContainers[want] {
+		if Compatible(c, vcodec, acodec) && HasMuxer(c) {
+			return c
+		}
+	}
+	return "mkv"
+}
+
+// ConvertedCodecs returns the codecs a conversion with the vcodec and
+// acodec options writes from the source streams: the option's codec where
+// it re-encodes, the source's where it copies
+func ConvertedCodecs(vcodec, acodec string, source []ProbeStream) (string, string) {
+	outVideo, outAudio := StreamCodecs(source)
+	if name, ok := convertCodecs[vcodec]; ok && outVideo != "" {
+		outVideo = name
+	}
+	if name, ok := convertCodecs[acodec]; ok && outAudio != "" {
+		outAudio = name
+	}
+	return outVideo, outAudio
+}
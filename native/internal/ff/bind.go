@@ -0,0 +1,34 @@
+package ff
+
+import "strings"
+
+// networkSchemes are the input protocols that connect over TCP, where
+// ffmpeg's local_addr option applies
+var networkSchemes = []string{"http://", "https://", "tcp://", "tls://"}
+
+func isNetworkInput(input string) bool {
+	lower := strings.ToLower(input)
+	for _, scheme := range networkSchemes {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// BindInputs inserts -local_addr ahead of every network input so ffmpeg's
+// connections originate from addr. ffmpeg builds without the option fail
+// with an unknown-option error rather than using the default route.
+func BindInputs(args []string, addr string) []string {
+	if addr == "" {
+		return args
+	}
+	bound := make([]string, 0, len(args)+4)
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) && isNetworkInput(args[i+1]) {
+			bound = append(bound, "-local_addr", addr)
+		}
+		bound = append(bound, arg)
+	}
+	return bound
+}
@@ -0,0 +1,84 @@
+package ff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lowest bitrate caps accepted, below which encoders produce mush
+const (
+	minVideoBitrateCap = 100_000
+	minAudioBitrateCap = 16_000
+)
+
+// ValidateBitrateCaps checks convert bitrate caps (bits per second, 0 for
+// none). A cap needs its stream re-encoded.
+func ValidateBitrateCaps(vcodec, acodec string, maxVideo, maxAudio int64) error {
+	switch {
+	case maxVideo < 0 || maxAudio < 0:
+		return fmt.Errorf("bitrate caps can't be negative")
+	case maxVideo > 0 && (vcodec == "" || vcodec == "copy"):
+		return fmt.Errorf("maxVideoBitrate needs the video re-encoded, set vcodec")
+	case maxAudio > 0 && (acodec == "" || acodec == "copy"):
+		return fmt.Errorf("maxAudioBitrate needs the audio re-encoded, set acodec")
+	case maxVideo > 0 && maxVideo < minVideoBitrateCap:
+		return fmt.Errorf("maxVideoBitrate must be at least %d", minVideoBitrateCap)
+	case maxAudio > 0 && maxAudio < minAudioBitrateCap:
+		return fmt.Errorf("maxAudioBitrate must be at least %d", minAudioBitrateCap)
+	}
+	return nil
+}
+
+// ApplyBitrateCaps constrains the encoders of convert args (see
+// BuildConvertArgs) to bitrate ceilings. The video encoders keep their CRF
+// and get a VBV ceiling (-maxrate, with a two second -bufsize), which makes
+// it constrained quality: CRF quality until the cap. libvpx and libaom do
+// that through -b:v, which their constant quality mode leaves at 0. The
+// audio bitrate is lowered to the cap when above it. A copied stream is left
+// alone, as there is no encoder to cap.
+func ApplyBitrateCaps(args []string, maxVideo, maxAudio int64) []string {
+	if maxVideo <= 0 && maxAudio <= 0 {
+		return args
+	}
+	out := append([]string(nil), args...)
+	for i := 0; i+1 < len(out); i++ {
+		switch out[i] {
+		case "-c:v":
+			if out[i+1] == "copy" {
+				maxVideo = 0
+			}
+		case "-b:v":
+			if maxVideo > 0 && out[i+1] == "0" {
+				out[i+1] = strconv.FormatInt(maxVideo, 10)
+			}
+		case "-b:a":
+			if maxAudio > 0 && parseBitrate(out[i+1]) > maxAudio {
+				out[i+1] = strconv.FormatInt(maxAudio, 10)
+			}
+		}
+	}
+	if maxVideo > 0 {
+		out = InsertOutputOptions(out,
+			"-maxrate:v", strconv.FormatInt(maxVideo, 10),
+			"-bufsize:v", strconv.FormatInt(2*maxVideo, 10))
+	}
+	return out
+}
+
+// parseBitrate reads an ffmpeg bitrate such as "128k" or "2M" in bits per
+// second, 0 if it can't
+func parseBitrate(s string) int64 {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1000_000, strings.TrimSuffix(s, "M")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n * mult
+}
@@ -0,0 +1,102 @@
+package ff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyBitrateCaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		maxVideo int64
+		maxAudio int64
+		want     []string
+	}{
+		{
+			name:     "no caps",
+			args:     []string{"-i", "in", "-c:v", "libx264", "-crf", "23", "out"},
+			maxVideo: 0, maxAudio: 0,
+			want: []string{"-i", "in", "-c:v", "libx264", "-crf", "23", "out"},
+		},
+		{
+			name:     "crf encoder keeps crf under a vbv ceiling",
+			args:     []string{"-i", "in", "-c:v", "libx264", "-crf", "23", "-preset", "medium", "-c:a", "aac", "-b:a", "128k", "out"},
+			maxVideo: 2_000_000,
+			want: []string{"-i", "in", "-c:v", "libx264", "-crf", "23", "-preset", "medium", "-c:a", "aac", "-b:a", "128k",
+				"-maxrate:v", "2000000", "-bufsize:v", "4000000", "out"},
+		},
+		{
+			name:     "constant quality vp9 gets the cap as -b:v",
+			args:     []string{"-i", "in", "-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "out"},
+			maxVideo: 1_000_000,
+			want: []string{"-i", "in", "-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "1000000",
+				"-maxrate:v", "1000000", "-bufsize:v", "2000000", "out"},
+		},
+		{
+			name:     "encoder without crf keeps its bitrate",
+			args:     []string{"-i", "in", "-c:v", "mpeg4", "-b:v", "800k", "out"},
+			maxVideo: 1_000_000,
+			want: []string{"-i", "in", "-c:v", "mpeg4", "-b:v", "800k",
+				"-maxrate:v", "1000000", "-bufsize:v", "2000000", "out"},
+		},
+		{
+			name:     "audio above the cap is lowered",
+			args:     []string{"-i", "in", "-c:a", "libmp3lame", "-b:a", "192k", "out"},
+			maxAudio: 96_000,
+			want:     []string{"-i", "in", "-c:a", "libmp3lame", "-b:a", "96000", "out"},
+		},
+		{
+			name:     "audio below the cap is kept",
+			args:     []string{"-i", "in", "-c:a", "aac", "-b:a", "128k", "out"},
+			maxAudio: 192_000,
+			want:     []string{"-i", "in", "-c:a", "aac", "-b:a", "128k", "out"},
+		},
+		{
+			name:     "copied video is left alone",
+			args:     []string{"-i", "in", "-c:v", "copy", "-c:a", "aac", "-b:a", "128k", "out"},
+			maxVideo: 1_000_000, maxAudio: 64_000,
+			want: []string{"-i", "in", "-c:v", "copy", "-c:a", "aac", "-b:a", "64000", "out"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ApplyBitrateCaps(tt.args, tt.maxVideo, tt.maxAudio)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ApplyBitrateCaps() =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBitrateCaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		vcodec   string
+		acodec   string
+		maxVideo int64
+		maxAudio int64
+		wantErr  bool
+	}{
+		{name: "no caps on copy", vcodec: "copy", acodec: "copy"},
+		{name: "video cap with encoder", vcodec: "h264", acodec: "copy", maxVideo: 2_000_000},
+		{name: "audio cap with encoder", vcodec: "copy", acodec: "opus", maxAudio: 64_000},
+		{name: "video cap on copied video", vcodec: "copy", acodec: "aac", maxVideo: 2_000_000, wantErr: true},
+		{name: "video cap without vcodec", acodec: "aac", maxVideo: 2_000_000, wantErr: true},
+		{name: "audio cap on copied audio", vcodec: "h264", acodec: "copy", maxAudio: 64_000, wantErr: true},
+		{name: "negative cap", vcodec: "h264", acodec: "aac", maxVideo: -1, wantErr: true},
+		{name: "video cap too low", vcodec: "h264", maxVideo: minVideoBitrateCap - 1, wantErr: true},
+		{name: "audio cap too low", acodec: "aac", maxAudio: minAudioBitrateCap - 1, wantErr: true},
+		{name: "caps at the minimum", vcodec: "vp9", acodec: "opus", maxVideo: minVideoBitrateCap, maxAudio: minAudioBitrateCap},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBitrateCaps(tt.vcodec, tt.acodec, tt.maxVideo, tt.maxAudio)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateBitrateCaps() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package ff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConvertProblem is one reason a conversion can't run
+type ConvertProblem struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// HasMuxer reports whether the local ffmpeg build can write the container
+func HasMuxer(container string) bool {
+	muxer := Muxer(container)
+	for _, f := range ListFormats() {
+		if !f.Mux {
+			continue
+		}
+		for _, name := range strings.Split(f.Name, ",") {
+			if name == muxer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encoderName returns the encoder a convert codec option runs, from the
+// -c:v/-c:a value of its args; "" for copy
+func encoderName(args []string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if (args[i] == "-c:v" || args[i] == "-c:a") && args[i+1] != "copy" {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// CheckConvert reports why a conversion to container with the vcodec and
+// acodec options can't run on the local ffmpeg build: unknown options, a
+// missing muxer or encoder, or codecs the container can't hold. Copied
+// codecs are checked against the source streams when they are given, and
+// codec options for a stream the source doesn't have are ignored. An empty
+// list means the conversion can run.
+func CheckConvert(container, vcodec, acodec string, source []ProbeStream) []ConvertProblem {
+	var problems []ConvertProblem
+	add := func(code, format string, a ...interface{}) {
+		problems = append(problems, ConvertProblem{Code: code, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	srcVideo, srcAudio := StreamCodecs(source)
+	hasVideo, hasAudio := source == nil || srcVideo != "", source == nil || srcAudio != ""
+
+	if _, ok := containerCodecs[container]; !ok {
+		add("unsupported_container", "unsupported container: %s", container)
+		return problems
+	}
+	if !HasMuxer(container) {
+		add("missing_muxer", "this ffmpeg build can't write %s (%s muxer)", container, Muxer(container))
+	}
+
+	// The codecs the output ends up with, to check against the container
+	outVideo, outAudio := srcVideo, srcAudio
+	for _, c := range []struct {
+		kind, option string
+		present      bool
+		out          *string
+		args         func(string) []string
+	}{
+		{"video", vcodec, hasVideo, &outVideo, videoCodecArgs},
+		{"audio", acodec, hasAudio, &outAudio, audioCodecArgs},
+	} {
+		if c.option == "" || c.option == "copy" || !c.present {
+			continue
+		}
+		name, ok := convertCodecs[c.option]
+		if !ok {
+			add("unsupported_codec", "unsupported %s codec: %s", c.kind, c.option)
+			continue
+		}
+		*c.out = name
+		if enc := encoderName(c.args(c.option)); !HasEncoder(enc) {
+			add("missing_encoder", "this ffmpeg build has no %s encoder for %s", enc, c.option)
+		}
+	}
+
+	if !Compatible(container, outVideo, outAudio) {
+		add("incompatible_container", "%s can't hold %s", container, strings.Trim(outVideo+"/"+outAudio, "/"))
+	}
+	return problems
+}
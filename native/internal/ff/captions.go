@@ -0,0 +1,41 @@
+package ff
+
+import (
+	"strings"
+)
+
+// HasClosedCaptions reports whether any video stream carries embedded
+// CEA-608/708 captions
+func HasClosedCaptions(streams []ProbeStream) bool {
+	for _, s := range streams {
+		if s.CodecType == "video" && s.ClosedCaptions > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildCaptionsArgs constructs ffmpeg args pulling embedded closed captions
+// out of a local file into a sidecar. Captions live inside the video
+// bitstream, so they're exposed through the movie source's subcc output.
+func BuildCaptionsArgs(input, output, format string) []string {
+	muxer := "srt"
+	if format == "vtt" {
+		muxer = "webvtt"
+	}
+
+	return []string{
+		"-f", "lavfi",
+		"-i", "movie=" + EscapeFilterPath(input) + "[out+subcc]",
+		"-map", "0:s:0",
+		"-f", muxer,
+		output,
+	}
+}
+
+// EscapeFilterPath escapes a file path for use as a filter option inside a
+// filtergraph: once for the option value, once for the graph itself
+func EscapeFilterPath(path string) string {
+	value := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(path)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
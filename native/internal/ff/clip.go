@@ -0,0 +1,136 @@
+package ff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClipOptions describes an audio range to extract
+type ClipOptions struct {
+	Start   float64 // seconds
+	End     float64 // seconds
+	FadeIn  float64 // seconds, 0 = none
+	FadeOut float64 // seconds, 0 = none
+	Format  string  // "m4a" or "mp3"
+
+	// CoverArt is the input stream specifier ("0:3") of cover art to
+	// carry into the clip and CoverCodec its codec; empty drops any cover
+	CoverArt   string
+	CoverCodec string
+
+	// SplitEvery cuts the clip into parts of this many seconds, for
+	// podcast and audiobook apps; 0 writes a single file
+	SplitEvery float64
+}
+
+// Duration returns the length of the clip in seconds
+func (c ClipOptions) Duration() float64 {
+	return c.End - c.Start
+}
+
+// Validate checks the range and fades against the source duration
+// (pass 0 when the duration is unknown)
+func (c ClipOptions) Validate(sourceDuration float64) error {
+	if c.Start < 0 {
+		return fmt.Errorf("start must be >= 0")
+	}
+	if c.End <= c.Start {
+		return fmt.Errorf("end (%.3f) must be after start (%.3f)", c.End, c.Start)
+	}
+	if sourceDuration > 0 && c.End > sourceDuration {
+		return fmt.Errorf("end (%.3f) is past the source duration (%.3f)", c.End, sourceDuration)
+	}
+	if c.FadeIn < 0 || c.FadeOut < 0 {
+		return fmt.Errorf("fades must be >= 0")
+	}
+	if c.FadeIn+c.FadeOut > c.Duration() {
+		return fmt.Errorf("fades (%.3f) are longer than the clip (%.3f)", c.FadeIn+c.FadeOut, c.Duration())
+	}
+	switch c.Format {
+	case "m4a", "mp3":
+	default:
+		return fmt.Errorf("unsupported clip format: %s", c.Format)
+	}
+	return c.validateSplit()
+}
+
+// BuildAudioClipArgs constructs ffmpeg args to extract an audio range.
+// Seeking happens on the input so remote sources aren't read from the start;
+// output timestamps then begin at 0, which the fade offsets rely on.
+func BuildAudioClipArgs(input, output string, headers map[string]string, clip ClipOptions) []string {
+	args := []string{}
+
+	if strings.Contains(input, "://") {
+		args = append(args, "-user_agent", "Vidown/1.0 (Native Companion)")
+		if len(headers) > 0 {
+			args = append(args, "-headers", buildHeaderString(headers))
+		}
+	}
+
+	args = append(args,
+		"-ss", formatSeconds(clip.Start),
+		"-i", input,
+		"-t", formatSeconds(clip.Duration()),
+	)
+	if clip.CoverArt != "" {
+		args = append(args, coverArtArgs(clip.CoverArt, clip.CoverCodec)...)
+	} else {
+		args = append(args, "-vn")
+	}
+
+	var filters []string
+	if clip.FadeIn > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=in:st=0:d=%s", formatSeconds(clip.FadeIn)))
+	}
+	if clip.FadeOut > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%s:d=%s",
+			formatSeconds(clip.Duration()-clip.FadeOut), formatSeconds(clip.FadeOut)))
+	}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+
+	// The output is written to a temp name, so the muxer must be explicit
+	switch clip.Format {
+	case "mp3":
+		args = append(args, "-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3")
+	default:
+		args = append(args, "-c:a", "aac", "-b:a", "192k", "-movflags", "+faststart", "-f", "mp4")
+	}
+
+	return append(args, output)
+}
+
+// ParseTimecode parses seconds ("83.5") or a timecode ("HH:MM:SS.mmm", "MM:SS")
+func ParseTimecode(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+
+	var total float64
+	for _, part := range strings.Split(s, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time: %q", s)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
+
+// FormatTimecode formats a media time as "HH:MM:SS.mmm", the form
+// ParseTimecode reads back
+func FormatTimecode(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func formatSeconds(sec float64) string {
+	return strconv.FormatFloat(sec, 'f', 3, 64)
+}
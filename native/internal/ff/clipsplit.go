@@ -0,0 +1,111 @@
+package ff
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxClipParts caps how many parts a split clip may produce
+const MaxClipParts = 999
+
+// Parts returns how many files a split clip produces, 1 when unsplit. A
+// last part under a millisecond is rounding, not a part.
+func (c ClipOptions) Parts() int {
+	if c.SplitEvery <= 0 {
+		return 1
+	}
+	return int(math.Ceil(c.Duration()/c.SplitEvery - 0.001/c.SplitEvery))
+}
+
+// validateSplit checks the split duration against the clip's length
+func (c ClipOptions) validateSplit() error {
+	switch {
+	case c.SplitEvery == 0:
+		return nil
+	case c.SplitEvery < 1:
+		return fmt.Errorf("split duration must be at least 1 second")
+	case c.SplitEvery >= c.Duration():
+		return fmt.Errorf("split duration (%.3f) must be shorter than the clip (%.3f)", c.SplitEvery, c.Duration())
+	case c.Parts() > MaxClipParts:
+		return fmt.Errorf("splitting every %.3fs makes %d parts, at most %d are allowed", c.SplitEvery, c.Parts(), MaxClipParts)
+	}
+	return nil
+}
+
+// PartName names part index (1-based) of total next to output:
+// "Episode - Part 01.m4a", numbered wide enough to sort
+func PartName(output string, index, total int) string {
+	width := len(strconv.Itoa(total))
+	if width < 2 {
+		width = 2
+	}
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s - Part %0*d%s", strings.TrimSuffix(output, ext), width, index, ext)
+}
+
+// SegmentPattern is the segment muxer's output pattern for the parts of
+// a clip written under the temp name base. A % in base is doubled so the
+// muxer takes it literally; fmt.Sprintf reads the pattern the same way, so
+// it names the files written.
+func SegmentPattern(base string) string {
+	return strings.ReplaceAll(base, "%", "%%") + ".%03d"
+}
+
+// BuildAudioSplitArgs constructs ffmpeg args cutting an extracted clip into
+// SplitEvery-long parts with the segment muxer. The audio is copied, so the
+// cuts land on packet boundaries; cover art is left out since the muxer
+// would only put it in the first part (see BuildPartTagArgs).
+func BuildAudioSplitArgs(input, pattern string, clip ClipOptions) []string {
+	args := []string{
+		"-i", input,
+		"-map", "0:a:0",
+		"-c", "copy",
+		"-map_chapters", "-1",
+		"-f", "segment",
+		"-segment_time", formatSeconds(clip.SplitEvery),
+		"-reset_timestamps", "1",
+	}
+	switch clip.Format {
+	case "mp3":
+		args = append(args, "-segment_format", "mp3")
+	default:
+		args = append(args, "-segment_format", "mp4", "-segment_format_options", "movflags=+faststart")
+	}
+	return append(args, pattern)
+}
+
+// PartTags is the metadata one part of a split clip carries
+type PartTags struct {
+	Title string
+	Index int // 1-based
+	Total int
+}
+
+// BuildPartTagArgs constructs ffmpeg args stamping a part with its own
+// track number and title, for podcast and audiobook players that order
+// and label files by them. whole is the unsplit clip, the source of the
+// cover art when the clip kept one.
+func BuildPartTagArgs(part, whole, output string, clip ClipOptions, tags PartTags) []string {
+	args := []string{"-i", part}
+	if clip.CoverArt != "" {
+		args = append(args, "-i", whole, "-map", "0:a:0", "-map", "1:v:0", "-disposition:v:0", "attached_pic")
+	} else {
+		args = append(args, "-map", "0:a:0")
+	}
+	args = append(args,
+		"-c", "copy",
+		"-map_chapters", "-1",
+		"-metadata", "title="+tags.Title,
+		"-metadata", fmt.Sprintf("track=%d/%d", tags.Index, tags.Total),
+	)
+	switch clip.Format {
+	case "mp3":
+		args = append(args, "-id3v2_version", "3", "-f", "mp3")
+	default:
+		args = append(args, "-movflags", "+faststart", "-f", "mp4")
+	}
+	return append(args, output)
+}
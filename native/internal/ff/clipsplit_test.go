@@ -0,0 +1,22 @@
+package ff
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSegmentPattern(t *testing.T) {
+	tests := []struct {
+		base string
+		want string // part 2
+	}{
+		{"/tmp/Episode.m4a.part", "/tmp/Episode.m4a.part.002"},
+		{"/tmp/100% Pure.m4a.part", "/tmp/100% Pure.m4a.part.002"},
+		{"/tmp/%d %s %%.mp3.part", "/tmp/%d %s %%.mp3.part.002"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf(SegmentPattern(tt.base), 2); got != tt.want {
+			t.Errorf("part 2 of SegmentPattern(%q) = %q, want %q", tt.base, got, tt.want)
+		}
+	}
+}
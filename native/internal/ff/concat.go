@@ -0,0 +1,131 @@
+package ff
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConcatCompatible reports whether probed parts can be joined with the
+// concat demuxer without re-encoding: the same stream layout, codecs and
+// frame size throughout. On false it also returns why.
+func ConcatCompatible(parts []*ProbeResult) (bool, string) {
+	if len(parts) == 0 {
+		return false, "no parts"
+	}
+
+	first := concatSignature(parts[0].Streams)
+	for i, p := range parts[1:] {
+		if sig := concatSignature(p.Streams); sig != first {
+			return false, fmt.Sprintf("part %d has %s, part 1 has %s", i+2, sig, first)
+		}
+	}
+	return true, ""
+}
+
+// concatSignature summarises the streams that must match for copy-concat
+func concatSignature(streams []ProbeStream) string {
+	var sig []string
+	for _, s := range streams {
+		switch s.CodecType {
+		case "video":
+			sig = append(sig, fmt.Sprintf("%s %dx%d", s.CodecName, s.Width, s.Height))
+		case "audio":
+			sig = append(sig, s.CodecName)
+		}
+	}
+	if len(sig) == 0 {
+		return "no streams"
+	}
+	return strings.Join(sig, ", ")
+}
+
+// WriteConcatList writes a concat demuxer list file for the given paths
+func WriteConcatList(path string, files []string) error {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for _, f := range files {
+		// Single quotes can't be escaped inside a quoted string, so close
+		// the quote, emit an escaped one and reopen
+		b.WriteString("file '" + strings.ReplaceAll(f, "'", `'\''`) + "'\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// BuildConcatCopyArgs joins the parts listed in list without re-encoding
+func BuildConcatCopyArgs(list, output, container string) []string {
+	args := []string{"-f", "concat", "-safe", "0", "-i", list, "-map", "0", "-c", "copy"}
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
+
+// ConcatLayout describes the output of a re-encoding concat
+type ConcatLayout struct {
+	Video         bool
+	Audio         bool
+	Width, Height int // frame size every part is scaled/padded to
+}
+
+// BuildConcatEncodeArgs joins parts whose codecs or sizes differ with the
+// concat filter, letterboxing video to a common frame size and encoding
+// with codecs the container can hold
+func BuildConcatEncodeArgs(inputs []string, output, container string, layout ConcatLayout) []string {
+	var args []string
+	for _, in := range inputs {
+		args = append(args, "-i", in)
+	}
+
+	var filter, pads strings.Builder
+	for i := range inputs {
+		if layout.Video {
+			w, h := strconv.Itoa(layout.Width), strconv.Itoa(layout.Height)
+			fmt.Fprintf(&filter, "[%d:v:0]scale=%s:%s:force_original_aspect_ratio=decrease,pad=%s:%s:(ow-iw)/2:(oh-ih)/2,setsar=1[v%d];",
+				i, w, h, w, h, i)
+			fmt.Fprintf(&pads, "[v%d]", i)
+		}
+		if layout.Audio {
+			fmt.Fprintf(&pads, "[%d:a:0]", i)
+		}
+	}
+
+	v, a := 0, 0
+	if layout.Video {
+		v = 1
+	}
+	if layout.Audio {
+		a = 1
+	}
+	fmt.Fprintf(&filter, "%sconcat=n=%d:v=%d:a=%d", pads.String(), len(inputs), v, a)
+	if layout.Video {
+		filter.WriteString("[v]")
+	}
+	if layout.Audio {
+		filter.WriteString("[a]")
+	}
+
+	args = append(args, "-filter_complex", filter.String())
+
+	if layout.Video {
+		args = append(args, "-map", "[v]")
+		if container == "webm" {
+			args = append(args, "-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "-row-mt", "1")
+		} else {
+			args = append(args, "-c:v", "libx264", "-crf", "23", "-preset", "medium")
+		}
+	}
+	if layout.Audio {
+		args = append(args, "-map", "[a]")
+		switch container {
+		case "webm":
+			args = append(args, "-c:a", "libopus", "-b:a", "128k")
+		case "mp3":
+			args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+		default:
+			args = append(args, "-c:a", "aac", "-b:a", "192k")
+		}
+	}
+
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
@@ -0,0 +1,232 @@
+package ff
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// codecSet lists the ffprobe codec names a container can carry as-is
+type codecSet struct {
+	video map[string]bool
+	audio map[string]bool
+	any   bool // accepts every codec (matroska)
+}
+
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+var containerCodecs = map[string]codecSet{
+	"mp4": {
+		video: set("h264", "hevc", "av1", "mpeg4", "vp9"),
+		audio: set("aac", "mp3", "ac3", "eac3", "alac", "flac", "opus"),
+	},
+	"mov": {
+		video: set("h264", "hevc", "mpeg4", "prores", "mjpeg"),
+		audio: set("aac", "mp3", "ac3", "alac", "pcm_s16le", "pcm_s24le"),
+	},
+	"m4a": {
+		audio: set("aac", "alac", "mp3"),
+	},
+	"webm": {
+		video: set("vp8", "vp9", "av1"),
+		audio: set("opus", "vorbis"),
+	},
+	"ts": {
+		video: set("h264", "hevc", "mpeg2video"),
+		audio: set("aac", "mp3", "ac3", "eac3", "mp2"),
+	},
+	"mp3": {
+		audio: set("mp3"),
+	},
+	"mkv": {any: true},
+}
+
+// Muxers maps a container name to the ffmpeg muxer for -f
+var muxers = map[string]string{
+	"mp4":  "mp4",
+	"mov":  "mov",
+	"m4a":  "ipod",
+	"webm": "webm",
+	"ts":   "mpegts",
+	"mp3":  "mp3",
+	"mkv":  "matroska",
+}
+
+// ContainerFromPath returns the container implied by a file extension,
+// or "" when the extension is missing or unknown
+func ContainerFromPath(path string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "m4v":
+		return "mp4"
+	case "mka":
+		return "mkv"
+	}
+	if _, ok := muxers[ext]; ok {
+		return ext
+	}
+	return ""
+}
+
+// Muxer returns the ffmpeg muxer name for a container (matroska if unknown)
+func Muxer(container string) string {
+	if m, ok := muxers[container]; ok {
+		return m
+	}
+	return "matroska"
+}
+
+// Compatible reports whether the container can hold the given codecs
+// without re-encoding. Empty codec names mean the stream is absent.
+func Compatible(container, vcodec, acodec string) bool {
+	cs, ok := containerCodecs[container]
+	if !ok {
+		return false
+	}
+	if cs.any {
+		return true
+	}
+	if vcodec != "" && !cs.video[vcodec] {
+		return false
+	}
+	if acodec != "" && !cs.audio[acodec] {
+		return false
+	}
+	return true
+}
+
+// convertCodecs maps BuildConvertArgs codec options to ffprobe codec names
+var convertCodecs = map[string]string{
+	"h264": "h264",
+	"hevc": "hevc",
+	"vp9":  "vp9",
+	"av1":  "av1",
+	"aac":  "aac",
+	"opus": "opus",
+	"mp3":  "mp3",
+}
+
+// ValidateConvert checks that a conversion target can hold the requested
+// codecs. "copy" codecs depend on the source and aren't checked here.
+func ValidateConvert(container, vcodec, acodec string) error {
+	if _, ok := containerCodecs[container]; !ok {
+		return fmt.Errorf("unsupported container: %s", container)
+	}
+
+	v, a := convertCodecs[vcodec], convertCodecs[acodec]
+	if vcodec != "copy" && vcodec != "" && v == "" {
+		return fmt.Errorf("unsupported video codec: %s", vcodec)
+	}
+	if acodec != "copy" && acodec != "" && a == "" {
+		return fmt.Errorf("unsupported audio codec: %s", acodec)
+	}
+
+	if !Compatible(container, v, a) {
+		return fmt.Errorf("%s can't hold %s", container, strings.Trim(v+"/"+a, "/"))
+	}
+	return nil
+}
+
+// StreamCodecs returns the first video and audio codec names in a probe
+func StreamCodecs(streams []ProbeStream) (vcodec, acodec string) {
+	for _, s := range streams {
+		switch s.CodecType {
+		case "video":
+			if vcodec == "" {
+				vcodec = s.CodecName
+			}
+		case "audio":
+			if acodec == "" {
+				acodec = s.CodecName
+			}
+		}
+	}
+	return vcodec, acodec
+}
+
+// PickContainer chooses a container able to carry the probed streams as-is:
+// webm for VP8/VP9/AV1 + Opus/Vorbis (mp4 could hold VP9 and Opus, but
+// that's webm's home and what players expect them in), mp4 where possible
+// otherwise, mkv for the rest
+func PickContainer(streams []ProbeStream) (container, reason string) {
+	vcodec, acodec := StreamCodecs(streams)
+	codecs := strings.Trim(vcodec+"/"+acodec, "/")
+
+	switch {
+	case vcodec == "" && acodec == "":
+		return "mkv", "no streams detected, using mkv as universal fallback"
+	case vcodec == "" && Compatible("m4a", "", acodec):
+		return "m4a", codecs + " audio fits m4a"
+	case Compatible("webm", vcodec, acodec):
+		return "webm", codecs + " fits webm"
+	case Compatible("mp4", vcodec, acodec):
+		return "mp4", codecs + " fits mp4"
+	default:
+		return "mkv", codecs + " not supported by mp4/webm, using mkv"
+	}
+}
+
+// ReplaceExt swaps a known container extension on path for the given
+// container's, or appends it when path has none. A name that already
+// carries the container's extension under the swapped one ("a.mkv.mp4")
+// isn't given it twice.
+func ReplaceExt(path, container string) string {
+	if ContainerFromPath(path) != "" {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+		if strings.EqualFold(filepath.Ext(path), "."+container) {
+			return path
+		}
+	}
+	return path + "." + container
+}
+
+// FixExt makes sure path ends in an extension for container, keeping one
+// that already matches (including case variants and aliases like .m4v)
+func FixExt(path, container string) string {
+	if container == "" || ContainerFromPath(path) == container {
+		return path
+	}
+	return ReplaceExt(path, container)
+}
+
+// DetectContainer names the container of a probed file from its demuxer,
+// telling apart the formats ffprobe reports under a shared name
+func DetectContainer(probe *ProbeResult) string {
+	vcodec, acodec := StreamCodecs(probe.Streams)
+	names := strings.Split(probe.Format.FormatName, ",")
+
+	switch names[0] {
+	case "mov":
+		if vcodec == "" {
+			return "m4a"
+		}
+		return "mp4"
+	case "matroska":
+		if (vcodec != "" || acodec != "") && Compatible("webm", vcodec, acodec) {
+			return "webm"
+		}
+		return "mkv"
+	case "mpegts":
+		return "ts"
+	case "mp3":
+		return "mp3"
+	}
+	return ""
+}
+
+// muxArgs returns the output format args for a container. The job writes to
+// temp names ffmpeg can't infer a muxer from, so -f is always explicit.
+func muxArgs(container string) []string {
+	var args []string
+	switch container {
+	case "mp4", "mov", "m4a":
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "-f", Muxer(container))
+}
@@ -0,0 +1,103 @@
+package ff
+
+import "testing"
+
+func TestPickContainer(t *testing.T) {
+	stream := func(kind, codec string) ProbeStream {
+		return ProbeStream{CodecType: kind, CodecName: codec}
+	}
+
+	tests := []struct {
+		name    string
+		streams []ProbeStream
+		want    string
+	}{
+		{"h264 aac", []ProbeStream{stream("video", "h264"), stream("audio", "aac")}, "mp4"},
+		{"vp9 opus", []ProbeStream{stream("video", "vp9"), stream("audio", "opus")}, "webm"},
+		{"av1 opus", []ProbeStream{stream("video", "av1"), stream("audio", "opus")}, "webm"},
+		{"av1 aac", []ProbeStream{stream("video", "av1"), stream("audio", "aac")}, "mp4"},
+		{"vp9 aac", []ProbeStream{stream("video", "vp9"), stream("audio", "aac")}, "mp4"},
+		{"aac only", []ProbeStream{stream("audio", "aac")}, "m4a"},
+		{"opus only", []ProbeStream{stream("audio", "opus")}, "webm"},
+		{"h264 vorbis", []ProbeStream{stream("video", "h264"), stream("audio", "vorbis")}, "mkv"},
+		{"nothing", nil, "mkv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := PickContainer(tt.streams)
+			if got != tt.want {
+				t.Errorf("PickContainer() = %q (%s), want %q", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerFromPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"clip.mp4", "mp4"},
+		{"Clip.MP4", "mp4"},
+		{"clip.m4v", "mp4"},
+		{"clip.MKA", "mkv"},
+		{"clip.webm", "webm"},
+		{"clip", ""},
+		{"clip.txt", ""},
+		{"clip.mp4.part", ""},
+		{"show.s01.e02.webm", "webm"},
+		{"/downloads/v1.2/clip", ""},
+		{"/downloads/v1.mp4/clip", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ContainerFromPath(tt.path); got != tt.want {
+			t.Errorf("ContainerFromPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceExt(t *testing.T) {
+	tests := []struct {
+		path, container, want string
+	}{
+		{"clip.mp4", "mkv", "clip.mkv"},
+		{"Clip.MP4", "webm", "Clip.webm"},
+		{"clip", "mp4", "clip.mp4"},
+		{"/downloads/v1.2/clip", "mp4", "/downloads/v1.2/clip.mp4"},
+		{"clip.txt", "mp4", "clip.txt.mp4"},
+		{"show.s01.webm", "mp4", "show.s01.mp4"},
+		{"clip.mkv.mp4", "mkv", "clip.mkv"},
+		{"clip.MKV.mp4", "mkv", "clip.MKV"},
+		{"clip.webm.mp4", "mkv", "clip.webm.mkv"},
+	}
+
+	for _, tt := range tests {
+		if got := ReplaceExt(tt.path, tt.container); got != tt.want {
+			t.Errorf("ReplaceExt(%q, %q) = %q, want %q", tt.path, tt.container, got, tt.want)
+		}
+	}
+}
+
+func TestFixExt(t *testing.T) {
+	tests := []struct {
+		path, container, want string
+	}{
+		{"clip.mp4", "mp4", "clip.mp4"},
+		{"Clip.MP4", "mp4", "Clip.MP4"},
+		{"clip.m4v", "mp4", "clip.m4v"},
+		{"clip.mp4", "webm", "clip.webm"},
+		{"CLIP.MKV", "mp4", "CLIP.mp4"},
+		{"clip", "mkv", "clip.mkv"},
+		{"clip.tar.mp4", "m4a", "clip.tar.m4a"},
+		{"clip.mp4.webm", "mp4", "clip.mp4"},
+		{"clip.mp4", "", "clip.mp4"},
+		{"clip", "", "clip"},
+	}
+
+	for _, tt := range tests {
+		if got := FixExt(tt.path, tt.container); got != tt.want {
+			t.Errorf("FixExt(%q, %q) = %q, want %q", tt.path, tt.container, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package ff
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	decodersOnce sync.Once
+	decoders     []codecEntry
+)
+
+// hwDecoderSuffixes mark decoders that run on a GPU or media engine
+var hwDecoderSuffixes = []string{"_cuvid", "_qsv", "_mediacodec", "_v4l2m2m", "_mmal", "_rkmpp", "_amf", "_vaapi", "_videotoolbox", "_d3d11va", "_dxva2", "_ohcodec"}
+
+// Decoder is a decoder of the local ffmpeg build
+type Decoder struct {
+	Name     string `json:"name"`
+	Hardware bool   `json:"hardware"`
+}
+
+// Decoders returns the local ffmpeg build's decoders for a codec as ffprobe
+// names it (codec_name), software ones first. The decoder list is read once
+// and cached.
+func Decoders(codec string) []Decoder {
+	decodersOnce.Do(func() {
+		decoders = listCodecEntries("-decoders")
+	})
+
+	var sw, hw []Decoder
+	for _, e := range decoders {
+		if e.codec != codec {
+			continue
+		}
+		d := Decoder{Name: e.name, Hardware: hwDecoder(e.name)}
+		if d.Hardware {
+			hw = append(hw, d)
+		} else {
+			sw = append(sw, d)
+		}
+	}
+	return append(sw, hw...)
+}
+
+func hwDecoder(name string) bool {
+	for _, suffix := range hwDecoderSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package ff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+)
+
+var (
+	encodersOnce sync.Once
+	encoders     map[string]bool
+)
+
+// HasEncoder reports whether the local ffmpeg build has the named encoder.
+// The encoder list is read once and cached.
+func HasEncoder(name string) bool {
+	encodersOnce.Do(func() {
+		encoders = listCodecs("-encoders")
+	})
+	return encoders[name]
+}
+
+// listCodecs parses `ffmpeg -encoders`/`-decoders` output into the set of
+// names listed
+func listCodecs(flag string) map[string]bool {
+	names := make(map[string]bool)
+	for _, c := range listCodecEntries(flag) {
+		names[c.name] = true
+	}
+	return names
+}
+
+// codecEntry is an encoder or decoder and the codec it handles
+type codecEntry struct {
+	name  string
+	codec string
+}
+
+// listCodecEntries parses `ffmpeg -encoders`/`-decoders` output. Entries
+// follow a " ------" separator as "<flags> <name> <description>"; the
+// description ends in "(codec <codec>)" when the name isn't the codec's.
+func listCodecEntries(flag string) []codecEntry {
+	out, err := command(context.Background(), GetFFmpegPath(), []string{"-hide_banner", flag}, EnvOptions{}).Output()
+	if err != nil {
+		return nil
+	}
+
+	var entries []codecEntry
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	inList := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "------") {
+			inList = true
+			continue
+		}
+		if !inList {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		e := codecEntry{name: fields[1], codec: fields[1]}
+		if i := strings.LastIndex(line, "(codec "); i >= 0 && strings.HasSuffix(line, ")") {
+			e.codec = line[i+len("(codec ") : len(line)-1]
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,111 @@
+package ff
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// inheritedEnv are the only host variables ffmpeg and ffprobe get: what they
+// need to find libraries, fonts and temp space. Everything else (LANG,
+// proxies, FFREPORT, AV_LOG_FORCE_*) is dropped so results don't depend on
+// how the browser happened to be started.
+var inheritedEnv = []string{
+	"PATH", "HOME", "USER", "TMPDIR", "TEMP", "TMP",
+	"SYSTEMROOT", "WINDIR", "USERPROFILE", "LOCALAPPDATA", "APPDATA", "PROGRAMDATA",
+	"XDG_CONFIG_HOME", "XDG_CACHE_HOME", "XDG_DATA_HOME", "XDG_DATA_DIRS", "XDG_RUNTIME_DIR",
+	"FONTCONFIG_FILE", "FONTCONFIG_PATH", "LD_LIBRARY_PATH", "DYLD_LIBRARY_PATH",
+}
+
+var (
+	envMu    sync.Mutex
+	extraEnv map[string]string
+)
+
+// SetBaseEnv sets variables added to every ffmpeg/ffprobe environment on
+// top of the minimal one, e.g. a system proxy. An empty value removes the
+// variable, including the defaults such as LC_ALL.
+func SetBaseEnv(vars map[string]string) {
+	envMu.Lock()
+	defer envMu.Unlock()
+
+	extraEnv = make(map[string]string, len(vars))
+	for k, v := range vars {
+		extraEnv[k] = v
+	}
+}
+
+// BaseEnv returns the variables set with SetBaseEnv
+func BaseEnv() map[string]string {
+	envMu.Lock()
+	defer envMu.Unlock()
+
+	vars := make(map[string]string, len(extraEnv))
+	for k, v := range extraEnv {
+		vars[k] = v
+	}
+	return vars
+}
+
+// EnvOptions are the per-run parts of the environment
+type EnvOptions struct {
+	Proxy  string // http_proxy/https_proxy for network inputs
+	Report bool   // pass the host's FFREPORT through (debug runs)
+	Dir    string // working directory relative inputs resolve against, "" = the host's
+}
+
+// Env builds the controlled environment ffmpeg runs with: the inherited
+// basics, the C locale (so numbers in progress output always use a dot),
+// the run's proxy, and the base variables from SetBaseEnv
+func Env(opts EnvOptions) []string {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			continue
+		}
+		for _, keep := range inheritedEnv {
+			// Windows names are case-insensitive (SystemRoot, windir)
+			if strings.EqualFold(name, keep) {
+				vars[name] = value
+			}
+		}
+		if opts.Report && name == "FFREPORT" {
+			vars[name] = value
+		}
+	}
+
+	vars["LC_ALL"] = "C"
+	vars["LANG"] = "C"
+	if opts.Proxy != "" {
+		vars["http_proxy"] = opts.Proxy
+		vars["https_proxy"] = opts.Proxy
+	}
+
+	for k, v := range BaseEnv() {
+		if v == "" {
+			delete(vars, k)
+		} else {
+			vars[k] = v
+		}
+	}
+
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// command prepares an ffmpeg or ffprobe invocation with the controlled
+// environment
+func command(ctx context.Context, path string, args []string, opts EnvOptions) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = Env(opts)
+	cmd.Dir = opts.Dir
+	return cmd
+}
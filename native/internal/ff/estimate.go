@@ -0,0 +1,99 @@
+package ff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultBenchmarkDuration is how much of the source a transcode
+	// estimate encodes when not specified
+	DefaultBenchmarkDuration = 10 * time.Second
+	// MaxBenchmarkDuration bounds the benchmark encode
+	MaxBenchmarkDuration = 2 * time.Minute
+)
+
+// TranscodeEstimate predicts a conversion from a short benchmark encode of
+// the start of the source. It is approximate: the opening seconds may be
+// easier or harder to encode than the rest, and the machine's load can
+// change while the real conversion runs.
+type TranscodeEstimate struct {
+	Approximate bool    `json:"approximate"`
+	DurationSec float64 `json:"durationSec"` // source duration
+	SampleSec   float64 `json:"sampleSec"`   // media encoded by the benchmark
+	BenchSec    float64 `json:"benchSec"`    // wall time the benchmark took
+	Speed       float64 `json:"speed"`       // encode speed as a multiple of real time
+
+	EstimatedSec   float64 `json:"estimatedSec"`
+	EstimatedBytes int64   `json:"estimatedBytes"`
+}
+
+// EstimateTranscode encodes the first sample of input with the convert
+// options (thread limit included) and extrapolates the time and output size to its full duration.
+// The benchmark output is a temp file removed before returning.
+func EstimateTranscode(ctx context.Context, input string, headers map[string]string, container, vcodec, acodec string, threads int, sample time.Duration) (*TranscodeEstimate, error) {
+	if err := ValidateConvert(container, vcodec, acodec); err != nil {
+		return nil, err
+	}
+	if err := ValidateThreads(threads); err != nil {
+		return nil, err
+	}
+	if sample <= 0 {
+		sample = DefaultBenchmarkDuration
+	}
+	if sample > MaxBenchmarkDuration {
+		sample = MaxBenchmarkDuration
+	}
+
+	probe, err := ProbeURL(input, headers)
+	if err != nil {
+		return nil, fmt.Errorf("probe: %w", err)
+	}
+	duration, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	if duration <= 0 {
+		return nil, fmt.Errorf("source duration is unknown")
+	}
+	sampleSec := sample.Seconds()
+	if sampleSec > duration {
+		sampleSec = duration
+	}
+
+	tmp, err := os.CreateTemp("", "vidown-bench-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{"-hide_banner", "-v", "error", "-nostdin", "-y"}
+	if len(headers) > 0 {
+		args = append(args, "-headers", buildHeaderString(headers))
+	}
+	args = append(args, "-t", strconv.FormatFloat(sampleSec, 'f', 3, 64))
+	args = append(args, BuildConvertArgs(input, tmp.Name(), container, vcodec, acodec, threads)...)
+
+	start := time.Now()
+	if _, err := command(ctx, GetFFmpegPath(), args, EnvOptions{}).Output(); err != nil {
+		return nil, fmt.Errorf("benchmark encode: %w", captured(err))
+	}
+	bench := time.Since(start).Seconds()
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	speed := sampleSec / bench
+	return &TranscodeEstimate{
+		Approximate:    true,
+		DurationSec:    duration,
+		SampleSec:      sampleSec,
+		BenchSec:       bench,
+		Speed:          speed,
+		EstimatedSec:   duration / speed,
+		EstimatedBytes: int64(float64(info.Size()) * duration / sampleSec),
+	}, nil
+}
@@ -0,0 +1,95 @@
+package ff
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stderrTailLines is how many trailing stderr lines a failed run keeps
+const stderrTailLines = 20
+
+// ExitError is a failed ffmpeg run together with the last lines it wrote
+// to stderr, which carry the actual reason (exit codes say very little)
+type ExitError struct {
+	Err    error
+	Stderr []string // redacted, oldest first
+}
+
+func (e *ExitError) Error() string {
+	if len(e.Stderr) == 0 {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Stderr[len(e.Stderr)-1]
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// captured wraps the error of a command run with Output, whose stderr
+// exec kept, into an ExitError carrying that stderr
+func captured(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return &ExitError{Err: err, Stderr: readStderr(bytes.NewReader(exitErr.Stderr), RunOptions{})}
+	}
+	return err
+}
+
+var (
+	httpStatusLine = regexp.MustCompile(`(?:Server returned|HTTP error) (\d{3})\b`)
+	urlInLine      = regexp.MustCompile(`https?://[^\s'"]+`)
+)
+
+// HTTPStatus returns the HTTP status ffmpeg reported for an input, or 0.
+// ffmpeg only names some codes: other 5xx replies come out as "5XX" and are
+// reported as 500, other 4xx ones as "4XX" and reported as 400.
+func (e *ExitError) HTTPStatus() int {
+	for i := len(e.Stderr) - 1; i >= 0; i-- {
+		line := e.Stderr[i]
+		if m := httpStatusLine.FindStringSubmatch(line); m != nil {
+			code, _ := strconv.Atoi(m[1])
+			return code
+		}
+		switch {
+		case strings.Contains(line, "Server returned 5XX"):
+			return 500
+		case strings.Contains(line, "Server returned 4XX"):
+			return 400
+		}
+	}
+	return 0
+}
+
+// networkFailureMessages are what ffmpeg prints when the connection to an
+// input fails, rather than the server answering with an error
+var networkFailureMessages = []string{
+	"Connection reset by peer",
+	"Connection timed out",
+	"Operation timed out",
+	"Connection refused",
+	"Network is unreachable",
+	"Stream ends prematurely",
+}
+
+// NetworkFailure reports whether ffmpeg failed on a broken, refused or
+// timed out connection, or an input cut short
+func (e *ExitError) NetworkFailure() bool {
+	for _, line := range e.Stderr {
+		for _, msg := range networkFailureMessages {
+			if strings.Contains(line, msg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactLine masks credentials in URLs quoted by an ffmpeg message
+func redactLine(line string) string {
+	return urlInLine.ReplaceAllStringFunc(line, RedactURL)
+}
@@ -0,0 +1,77 @@
+package ff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotMP4 is returned by MoovAtEnd for a file that isn't ISO BMFF
+var ErrNotMP4 = errors.New("not an MP4 file")
+
+// MoovAtEnd walks the top-level boxes of a local MP4 and reports whether
+// its index (moov) comes after the media data (mdat), i.e. whether it needs
+// a faststart rewrite before it can be played while still downloading.
+// Only box headers are read, so it's cheap whatever the file's size.
+func MoovAtEnd(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	header := make([]byte, 16)
+	mdat := false
+	for off := int64(0); off+8 <= info.Size(); {
+		if _, err := f.ReadAt(header, off); err != nil && err != io.EOF {
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		if off == 0 && kind != "ftyp" && kind != "styp" {
+			return false, ErrNotMP4
+		}
+
+		switch kind {
+		case "moov":
+			return mdat, nil
+		case "moof":
+			// Fragmented: each fragment carries its own index
+			return false, nil
+		case "mdat":
+			mdat = true
+		}
+
+		switch size {
+		case 0: // runs to the end of the file
+			size = info.Size() - off
+		case 1: // 64-bit size follows the type
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < 8 {
+			return false, fmt.Errorf("corrupt %q box at offset %d", kind, off)
+		}
+		off += size
+	}
+	return false, fmt.Errorf("no moov box found")
+}
+
+// BuildFaststartArgs constructs ffmpeg args remuxing an MP4 or MOV with its
+// moov atom moved to the front. Every stream is copied as is.
+func BuildFaststartArgs(input, output, container string) []string {
+	args := []string{
+		"-i", input,
+		"-map", "0",
+		"-c", "copy",
+		"-map_metadata", "0",
+	}
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
@@ -0,0 +1,98 @@
+package ff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+)
+
+// Format is one entry of `ffmpeg -formats`
+type Format struct {
+	Name        string `json:"name"` // may list aliases: "matroska,webm"
+	Description string `json:"description"`
+	Demux       bool   `json:"demux"`
+	Mux         bool   `json:"mux"`
+	Device      bool   `json:"device,omitempty"`
+
+	// Container is the convert container name writing this format, if
+	// conversions support it
+	Container string `json:"container,omitempty"`
+}
+
+var (
+	formatsOnce sync.Once
+	formats     []Format
+)
+
+// ListFormats returns the formats the local ffmpeg build supports. The list
+// is read once and cached; it is empty if ffmpeg can't be run.
+func ListFormats() []Format {
+	formatsOnce.Do(func() {
+		out, err := command(context.Background(), GetFFmpegPath(), []string{"-hide_banner", "-formats"}, EnvOptions{}).Output()
+		if err == nil {
+			formats = parseFormats(out)
+		}
+	})
+	return formats
+}
+
+// parseFormats parses `ffmpeg -formats` output. A legend (" D. = Demuxing
+// supported", " .E = ...", newer builds add " ..d = Is a device") gives the
+// width of the flag column, then " --" separates it from entries of the
+// form " <flags> <name> <description>". Older builds pad missing flags with
+// spaces rather than dots, so the flags are read by column, not as a word.
+func parseFormats(out []byte) []Format {
+	var list []Format
+	width := 2
+	inList := false
+
+	containers := make(map[string]string, len(muxers))
+	for container, muxer := range muxers {
+		if _, taken := containers[muxer]; !taken || container == muxer {
+			containers[muxer] = container
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if !inList {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "--") {
+				inList = true
+			} else if flags, _, ok := strings.Cut(trimmed, " = "); ok && len(flags) > width {
+				width = len(flags)
+			}
+			continue
+		}
+
+		if len(line) < 1+width+1 {
+			continue
+		}
+		flags := line[1 : 1+width]
+		fields := strings.Fields(line[1+width:])
+		if len(fields) == 0 {
+			continue
+		}
+
+		f := Format{
+			Name:        fields[0],
+			Description: strings.Join(fields[1:], " "),
+			Demux:       strings.Contains(flags, "D"),
+			Mux:         strings.Contains(flags, "E"),
+			Device:      strings.Contains(flags, "d"),
+		}
+		if f.Mux {
+			for _, name := range strings.Split(f.Name, ",") {
+				if c, ok := containers[name]; ok {
+					f.Container = c
+					break
+				}
+			}
+		}
+		list = append(list, f)
+	}
+	return list
+}
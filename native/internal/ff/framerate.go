@@ -0,0 +1,92 @@
+package ff
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// vfrTolerance is how far, relatively, a stream's average frame rate may
+// drift from its base rate before it counts as variable. Constant streams
+// agree exactly or to rounding; a phone's VFR recording drifts by percents.
+const vfrTolerance = 0.01
+
+// cfrSnapTolerance is how close a VFR source's average frame rate must be
+// to a standard rate for a CFR output to use that rate
+const cfrSnapTolerance = 0.05
+
+// standardFrameRates are the rates a CFR output snaps to when the
+// source's average is close to one
+var standardFrameRates = []string{"24000/1001", "24", "25", "30000/1001", "30", "50", "60000/1001", "60"}
+
+// parseRate reads an ffprobe rational such as "30000/1001"; 0 when it's
+// missing or "0/0"
+func parseRate(s string) float64 {
+	num, den, found := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// variableFrameRate tells a VFR video stream by its average frame rate
+// differing from its base rate (r_frame_rate, the lowest rate all its
+// timestamps fit). Cover art and streams without both rates don't count.
+func (s ProbeStream) variableFrameRate() bool {
+	if s.CodecType != "video" || (s.Disposition != nil && s.Disposition.AttachedPic != 0) {
+		return false
+	}
+	base, avg := parseRate(s.RFrameRate), parseRate(s.AvgFrameRate)
+	if base <= 0 || avg <= 0 {
+		return false
+	}
+	return math.Abs(base-avg)/base > vfrTolerance
+}
+
+// VFRStream returns the first variable frame rate video stream, nil if
+// there is none
+func VFRStream(streams []ProbeStream) *ProbeStream {
+	for i := range streams {
+		if streams[i].VFR {
+			return &streams[i]
+		}
+	}
+	return nil
+}
+
+// CFRTarget is the frame rate a constant-rate re-encode of s uses: the
+// standard rate nearest its average when one is within cfrSnapTolerance,
+// else the average itself, as an ffmpeg -r value
+func CFRTarget(s ProbeStream) string {
+	avg := parseRate(s.AvgFrameRate)
+	best, bestOff := "", cfrSnapTolerance
+	for _, rate := range standardFrameRates {
+		r := parseRate(rate)
+		if off := math.Abs(r-avg) / r; off <= bestOff {
+			best, bestOff = rate, off
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return strconv.FormatFloat(avg, 'f', 3, 64)
+}
+
+// BuildFrameRateArgs returns the output options for a re-encode of a VFR
+// source: with cfr, frames duplicated or dropped to a constant fps, which
+// keeps editors and some players in sync with the audio; otherwise the
+// source's timestamps passed through as they are
+func BuildFrameRateArgs(cfr bool, fps string) []string {
+	if cfr {
+		return []string{"-vsync", "cfr", "-r", fps}
+	}
+	return []string{"-vsync", "vfr"}
+}
@@ -0,0 +1,83 @@
+package ff
+
+import "strings"
+
+// HDR formats reported in ProbeStream.HDR
+const (
+	HDR10       = "hdr10"
+	HLG         = "hlg"
+	DolbyVision = "dolby_vision"
+)
+
+// hdrFormat tells the stream's HDR format from its transfer function, and
+// Dolby Vision from its configuration record or codec tag
+func (s ProbeStream) hdrFormat() string {
+	if s.CodecType != "video" {
+		return ""
+	}
+	for _, sd := range s.SideData {
+		if strings.HasPrefix(sd.Type, "DOVI configuration") {
+			return DolbyVision
+		}
+	}
+	switch s.CodecTag {
+	case "dvh1", "dvhe", "dav1", "dva1", "dvav":
+		return DolbyVision
+	}
+	switch s.ColorTransfer {
+	case "smpte2084":
+		return HDR10
+	case "arib-std-b67":
+		return HLG
+	}
+	return ""
+}
+
+// HDRStream returns the first HDR video stream, nil if there is none
+func HDRStream(streams []ProbeStream) *ProbeStream {
+	for i := range streams {
+		if streams[i].HDR != "" {
+			return &streams[i]
+		}
+	}
+	return nil
+}
+
+// HDRPreservable reports whether a convert video codec can carry HDR:
+// the 10-bit capable encoders used for hevc, vp9 and av1
+func HDRPreservable(vcodec string) bool {
+	switch vcodec {
+	case "hevc", "vp9", "av1":
+		return true
+	}
+	return false
+}
+
+// BuildHDRArgs returns output options keeping an HDR stream's look through
+// a re-encode: 10-bit output tagged with the source's primaries, transfer
+// and matrix. Static mastering metadata and Dolby Vision's dynamic
+// metadata aren't carried over; Dolby Vision ends up as plain HDR10 or HLG.
+func BuildHDRArgs(s ProbeStream) []string {
+	args := []string{"-pix_fmt", "yuv420p10le"}
+	for _, c := range []struct{ flag, value string }{
+		{"-color_primaries", s.ColorPrimaries},
+		{"-color_trc", s.ColorTransfer},
+		{"-colorspace", s.ColorSpace},
+	} {
+		if c.value != "" && c.value != "unknown" {
+			args = append(args, c.flag, c.value)
+		}
+	}
+	return args
+}
+
+// InsertOutputOptions adds options to an ffmpeg command line ending in its
+// output, just ahead of the output
+func InsertOutputOptions(args []string, opts ...string) []string {
+	if len(args) == 0 {
+		return opts
+	}
+	out := append([]string(nil), args[:len(args)-1]...)
+	out = append(out, opts...)
+	return append(out, args[len(args)-1])
+}
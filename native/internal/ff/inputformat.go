@@ -0,0 +1,49 @@
+package ff
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// InputCallback receives the demuxer ffmpeg picked for an input, as the
+// comma-separated names it prints (e.g. "hls" or "mov,mp4,m4a,3gp,3g2,mj2")
+type InputCallback func(index int, formats []string)
+
+var (
+	// With -v level+..., every line carries its level after any context
+	// prefix: "[hls @ 0x5581] [info] Opening ..."
+	levelTag  = regexp.MustCompile(`\[(trace|debug|verbose|info|warning|error|fatal|panic)\] `)
+	inputLine = regexp.MustCompile(`^Input #(\d+), (.+?), from '`)
+)
+
+// splitLevel removes the level tag from a stderr line and returns it, or ""
+// for untagged lines
+func splitLevel(line string) (level, msg string) {
+	loc := levelTag.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return "", line
+	}
+	return line[loc[2]:loc[3]], line[:loc[0]] + line[loc[1]:]
+}
+
+// isChatter reports whether a level only shows up because input detection
+// raised the verbosity; such lines are never part of an error report
+func isChatter(level string) bool {
+	switch level {
+	case "trace", "debug", "verbose", "info":
+		return true
+	}
+	return false
+}
+
+// parseInputLine extracts the input index and demuxer names from ffmpeg's
+// "Input #0, hls, from '...':" header
+func parseInputLine(msg string) (int, []string, bool) {
+	m := inputLine.FindStringSubmatch(msg)
+	if m == nil {
+		return 0, nil, false
+	}
+	index, _ := strconv.Atoi(m[1])
+	return index, strings.Split(m[2], ","), true
+}
@@ -0,0 +1,43 @@
+package ff
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+)
+
+// maxLineSize bounds one line of ffmpeg output held in memory. The default
+// Scanner limit (64KB) is easily exceeded by error lines quoting long URLs
+// or filter graphs, and hitting it stops the scanner for good, dropping the
+// real error and leaving ffmpeg blocked on a full pipe.
+const maxLineSize = 1024 * 1024
+
+// newLineScanner returns a line scanner that truncates over-long lines to
+// maxLineSize instead of failing, so reading always continues to EOF
+func newLineScanner(r io.Reader, name string) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	discarding := false
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if discarding {
+			// Skip the remainder of a truncated line
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				discarding = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= maxLineSize {
+			log.Printf("[FFMPEG] %s line longer than %d bytes, truncating", name, maxLineSize)
+			discarding = true
+			return len(data), data, nil
+		}
+		return advance, token, err
+	})
+
+	return scanner
+}
@@ -0,0 +1,42 @@
+package ff
+
+import (
+	"strconv"
+	"time"
+)
+
+// liveTimeout is how long a live capture waits on a silent connection
+// before giving up (-rw_timeout)
+const liveTimeout = 15 * time.Second
+
+// IsLiveURL reports whether url is an RTMP, RTSP or SRT stream, which
+// can only be captured for as long as it's watched
+func IsLiveURL(url string) bool {
+	switch URLScheme(url) {
+	case "rtmp", "rtmps", "rtsp", "srt":
+		return true
+	}
+	return false
+}
+
+// BuildLiveArgs constructs ffmpeg args capturing a live RTMP/RTSP/SRT
+// stream as it arrives, stopping after duration (0 = until ffmpeg is
+// asked to quit). RTSP is pulled over TCP: UDP drops packets through most
+// NATs and firewalls.
+func BuildLiveArgs(url, output, container string, duration time.Duration) []string {
+	scheme := URLScheme(url)
+	args := []string{
+		"-protocol_whitelist", ProtocolWhitelist([]string{scheme}),
+		"-rw_timeout", strconv.FormatInt(liveTimeout.Microseconds(), 10),
+	}
+	if scheme == "rtsp" {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, "-i", url)
+	if duration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(duration.Seconds(), 'f', 3, 64))
+	}
+	args = append(args, "-map", "0:v?", "-map", "0:a?", "-c", "copy")
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
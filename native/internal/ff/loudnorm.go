@@ -0,0 +1,134 @@
+package ff
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// DefaultLoudnessTarget is the integrated loudness audio is normalized
+	// to when not specified, in LUFS (what most streaming services use)
+	DefaultLoudnessTarget = -16.0
+
+	// loudnessPeak and loudnessRange are loudnorm's true peak (dBTP) and
+	// loudness range (LU) targets
+	loudnessPeak  = -1.5
+	loudnessRange = 11.0
+)
+
+// LoudnessStats are loudnorm's EBU R128 measurements of a file
+type LoudnessStats struct {
+	InputI       float64 `json:"inputI"`      // integrated loudness, LUFS
+	InputTP      float64 `json:"inputTP"`     // true peak, dBTP
+	InputLRA     float64 `json:"inputLRA"`    // loudness range, LU
+	InputThresh  float64 `json:"inputThresh"` // gating threshold, LUFS
+	TargetOffset float64 `json:"targetOffset"`
+}
+
+// loudnormJSON is the block loudnorm prints with print_format=json. Values
+// are strings, and "-inf" for silence.
+type loudnormJSON struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// MeasureLoudness runs loudnorm's analysis pass over the audio of path
+func MeasureLoudness(ctx context.Context, path string, target float64) (*LoudnessStats, error) {
+	args := []string{
+		"-hide_banner", "-nostdin", "-v", "info",
+		"-i", path,
+		"-map", "0:a:0",
+		"-af", loudnormFilter(target) + ":print_format=json",
+		"-f", "null", "-",
+	}
+	cmd := command(ctx, GetFFmpegPath(), args, EnvOptions{})
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("measure loudness: %w", err)
+	}
+	return parseLoudnorm(stderr.Bytes())
+}
+
+// parseLoudnorm extracts the measurements from loudnorm's output: the last
+// {...} block, printed after the "[Parsed_loudnorm_0 @ ...]" line
+func parseLoudnorm(out []byte) (*LoudnessStats, error) {
+	start := bytes.LastIndexByte(out, '{')
+	if start < 0 {
+		return nil, fmt.Errorf("no loudnorm measurements in ffmpeg output")
+	}
+	end := bytes.IndexByte(out[start:], '}')
+	if end < 0 {
+		return nil, fmt.Errorf("truncated loudnorm measurements")
+	}
+
+	var raw loudnormJSON
+	if err := json.Unmarshal(out[start:start+end+1], &raw); err != nil {
+		return nil, fmt.Errorf("parse loudnorm measurements: %w", err)
+	}
+
+	var stats LoudnessStats
+	for _, v := range []struct {
+		s   string
+		dst *float64
+	}{
+		{raw.InputI, &stats.InputI},
+		{raw.InputTP, &stats.InputTP},
+		{raw.InputLRA, &stats.InputLRA},
+		{raw.InputThresh, &stats.InputThresh},
+		{raw.TargetOffset, &stats.TargetOffset},
+	} {
+		f, err := strconv.ParseFloat(v.s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad loudnorm value %q", v.s)
+		}
+		*v.dst = f
+	}
+	// Silence measures as -inf, which can't be normalized (or sent as JSON)
+	if stats.InputI < -99 {
+		return nil, fmt.Errorf("audio is silent")
+	}
+	return &stats, nil
+}
+
+func loudnormFilter(target float64) string {
+	return fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f:LRA=%.1f", target, loudnessPeak, loudnessRange)
+}
+
+// BuildLoudnormArgs constructs ffmpeg args applying loudnorm's second pass
+// with the first pass's measurements, which lets it normalize linearly
+// instead of compressing dynamics. Video and other streams are copied; the
+// audio is re-encoded with acodec ("" or "copy" = the container's usual
+// codec), and resampled to 48kHz since loudnorm works at 192kHz internally.
+func BuildLoudnormArgs(input, output, container, acodec string, target float64, stats *LoudnessStats) []string {
+	filter := loudnormFilter(target) + fmt.Sprintf(
+		":measured_I=%.2f:measured_TP=%.2f:measured_LRA=%.2f:measured_thresh=%.2f:offset=%.2f:linear=true",
+		stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh, stats.TargetOffset)
+
+	if acodec == "" || acodec == "copy" {
+		acodec = "aac"
+		switch container {
+		case "webm":
+			acodec = "opus"
+		case "mp3":
+			acodec = "mp3"
+		}
+	}
+
+	args := []string{
+		"-i", input,
+		"-map", "0",
+		"-c", "copy",
+		"-af", filter,
+		"-ar", "48000",
+	}
+	args = append(args, audioCodecArgs(acodec)...)
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
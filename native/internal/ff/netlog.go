@@ -0,0 +1,40 @@
+package ff
+
+import (
+	"net"
+	"regexp"
+)
+
+// NetEvent is a network step ffmpeg logged while reading its inputs
+type NetEvent struct {
+	Kind string // one of the NetEvent* kinds
+	Addr string // "host:port" for NetEventConnected
+}
+
+// NetEvent kinds
+const (
+	NetEventRequest   = "request"   // a URL opened: a playlist, segment or file
+	NetEventConnected = "connected" // a TCP connection established
+	NetEventReconnect = "reconnect" // an HTTP read retried after an error
+)
+
+var (
+	openingLine   = regexp.MustCompile(`Opening '.+' for reading`)
+	connectedLine = regexp.MustCompile(`Successfully connected to (\S+) port (\d+)`)
+	reconnectLine = regexp.MustCompile(`Will reconnect at \d+ in \d+ second`)
+)
+
+// parseNetLine recognizes the lines of ffmpeg's http, tcp and hls code
+// that describe its connections. The tcp ones are only logged at verbose.
+func parseNetLine(line string) (NetEvent, bool) {
+	switch {
+	case openingLine.MatchString(line):
+		return NetEvent{Kind: NetEventRequest}, true
+	case reconnectLine.MatchString(line):
+		return NetEvent{Kind: NetEventReconnect}, true
+	}
+	if m := connectedLine.FindStringSubmatch(line); m != nil {
+		return NetEvent{Kind: NetEventConnected, Addr: net.JoinHostPort(m[1], m[2])}, true
+	}
+	return NetEvent{}, false
+}
@@ -0,0 +1,51 @@
+package ff
+
+// OutputSpec describes an additional output written by the same ffmpeg
+// command as the main one, e.g. an mp3 extracted alongside the video
+type OutputSpec struct {
+	Path      string
+	Container string
+	VCodec    string // convert codec option, "" or "copy" = copy
+	ACodec    string
+}
+
+// AudioOnly reports whether the output's container can't hold video
+func (o OutputSpec) AudioOnly() bool {
+	c, ok := containerCodecs[o.Container]
+	return ok && !c.any && len(c.video) == 0
+}
+
+// DefaultACodec picks the audio codec for an output that didn't name one:
+// an encode for audio-only containers (a copied codec rarely fits them),
+// otherwise a copy
+func (o OutputSpec) DefaultACodec() string {
+	switch o.Container {
+	case "mp3":
+		return "mp3"
+	case "m4a":
+		return "aac"
+	}
+	return "copy"
+}
+
+// AppendOutputs adds extra outputs to args that already end in the main
+// output, so one download feeds all of them. Each output gets its own codec
+// and muxer options, which ffmpeg applies per output file.
+func AppendOutputs(args []string, outputs []OutputSpec) []string {
+	for _, o := range outputs {
+		acodec := o.ACodec
+		if acodec == "" {
+			acodec = o.DefaultACodec()
+		}
+
+		if o.AudioOnly() {
+			args = append(args, "-vn")
+		} else {
+			args = append(args, videoCodecArgs(o.VCodec)...)
+		}
+		args = append(args, audioCodecArgs(acodec)...)
+		args = append(args, muxArgs(o.Container)...)
+		args = append(args, o.Path)
+	}
+	return args
+}
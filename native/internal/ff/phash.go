@@ -0,0 +1,115 @@
+package ff
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Perceptual hashes let the extension spot the same video downloaded twice
+// from different URLs, qualities or containers.
+//
+// The algorithm is dHash: a frame is scaled to 9x8 grayscale and each of
+// the 64 bits is set when a pixel is brighter than its right neighbour,
+// row by row from the top left, first bit most significant. A hash is the
+// 16 lowercase hex digits of that 64-bit value. Frames are sampled at the
+// midpoints of equal slices of the duration, so re-encodes of one video
+// sample the same moments; compare two videos by the Hamming distance of
+// their hashes in order (a few bits per frame means near-duplicate).
+const (
+	HashAlgorithm = "dhash"
+
+	// DefaultHashSamples is how many frames are hashed when not specified
+	DefaultHashSamples = 5
+	// MaxHashSamples bounds the decoding work for one request
+	MaxHashSamples = 64
+
+	hashWidth  = 9
+	hashHeight = 8
+
+	// hashFrameTimeout bounds decoding a single sampled frame
+	hashFrameTimeout = 30 * time.Second
+)
+
+// FrameHash is the hash of one sampled frame
+type FrameHash struct {
+	Time float64 `json:"time"` // seconds into the video
+	Hash string  `json:"hash"`
+}
+
+// PerceptualHash samples frames from a local video and returns their
+// dHashes in time order. Without a known duration only the first frame is
+// hashed.
+func PerceptualHash(ctx context.Context, path string, samples int) ([]FrameHash, error) {
+	if samples <= 0 {
+		samples = DefaultHashSamples
+	}
+	if samples > MaxHashSamples {
+		samples = MaxHashSamples
+	}
+
+	probe, err := ProbeURL(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("probe: %w", err)
+	}
+	if vcodec, _ := StreamCodecs(probe.Streams); vcodec == "" {
+		return nil, fmt.Errorf("no video stream to hash")
+	}
+
+	duration, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	if duration <= 0 {
+		samples = 1
+	}
+
+	hashes := make([]FrameHash, 0, samples)
+	for i := 0; i < samples; i++ {
+		at := duration * (float64(i) + 0.5) / float64(samples)
+		pixels, err := grayFrame(ctx, path, at)
+		if err != nil {
+			return nil, fmt.Errorf("frame at %.2fs: %w", at, err)
+		}
+		hashes = append(hashes, FrameHash{Time: at, Hash: fmt.Sprintf("%016x", dHash(pixels))})
+	}
+	return hashes, nil
+}
+
+// grayFrame decodes the frame at the given second as raw 9x8 8-bit gray
+func grayFrame(ctx context.Context, path string, at float64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, hashFrameTimeout)
+	defer cancel()
+
+	args := []string{
+		"-hide_banner", "-v", "error", "-nostdin",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-an", "-sn",
+		"-vf", fmt.Sprintf("scale=%d:%d:flags=area,format=gray", hashWidth, hashHeight),
+		"-f", "rawvideo",
+		"pipe:1",
+	}
+	out, err := command(ctx, GetFFmpegPath(), args, EnvOptions{}).Output()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) < hashWidth*hashHeight {
+		return nil, fmt.Errorf("decoded %d bytes, want %d", len(out), hashWidth*hashHeight)
+	}
+	return out[:hashWidth*hashHeight], nil
+}
+
+// dHash computes the difference hash of a 9x8 gray frame
+func dHash(pixels []byte) uint64 {
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		row := pixels[y*hashWidth : (y+1)*hashWidth]
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if row[x] > row[x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
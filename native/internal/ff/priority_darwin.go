@@ -0,0 +1,17 @@
+package ff
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// LowPrioritySupported reports whether low-priority jobs run nice here
+const LowPrioritySupported = true
+
+func prepareLowPriority(cmd *exec.Cmd) {}
+
+// lowerPriority renices the started process. macOS has no per-process I/O
+// priority syscall in package syscall; nice alone already demotes its I/O.
+func lowerPriority(pid int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, lowNice)
+}
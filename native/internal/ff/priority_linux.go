@@ -0,0 +1,31 @@
+package ff
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// LowPrioritySupported reports whether low-priority jobs run nice here
+const LowPrioritySupported = true
+
+// ioprio_set(2) constants: best-effort class at its lowest level
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2
+	ioprioClassShift = 13
+	ioprioLowest     = 7
+)
+
+func prepareLowPriority(cmd *exec.Cmd) {}
+
+// lowerPriority renices the started process and drops its I/O priority
+func lowerPriority(pid int) error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, lowNice); err != nil {
+		return err
+	}
+	prio := ioprioClassBE<<ioprioClassShift | ioprioLowest
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package ff
+
+import "os/exec"
+
+// LowPrioritySupported reports whether low-priority jobs run nice here
+const LowPrioritySupported = false
+
+func prepareLowPriority(cmd *exec.Cmd) {}
+
+func lowerPriority(pid int) error {
+	return nil
+}
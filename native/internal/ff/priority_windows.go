@@ -0,0 +1,24 @@
+package ff
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// LowPrioritySupported reports whether low-priority jobs run nice here
+const LowPrioritySupported = true
+
+const belowNormalPriorityClass = 0x00004000
+
+// prepareLowPriority creates the process in the BELOW_NORMAL priority
+// class, which also lowers its I/O priority
+func prepareLowPriority(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+}
+
+func lowerPriority(pid int) error {
+	return nil
+}
@@ -1,10 +1,15 @@
 package ff
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var ffmpegPath string
@@ -97,12 +102,21 @@ func GetFFprobePath() string {
 type ProbeResult struct {
 	Format  ProbeFormat  `json:"format"`
 	Streams []ProbeStream `json:"streams"`
+
+	// Partial is set when the full probe hit its deadline and this result
+	// comes from a quick, shallow pass instead
+	Partial bool `json:"partial,omitempty"`
+
+	// Extra holds the sections requested through ProbeOptions.Sections,
+	// keyed and shaped as ffprobe printed them
+	Extra map[string]json.RawMessage `json:"extra,omitempty"`
 }
 
 type ProbeFormat struct {
-	Duration string `json:"duration"`
-	Size     string `json:"size"`
-	BitRate  string `json:"bit_rate"`
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	Size       string `json:"size"`
+	BitRate    string `json:"bit_rate"`
 }
 
 type ProbeStream struct {
@@ -110,16 +124,150 @@ type ProbeStream struct {
 	CodecName string `json:"codec_name"`
 	Width     int    `json:"width,omitempty"`
 	Height    int    `json:"height,omitempty"`
+
+	ClosedCaptions int `json:"closed_captions,omitempty"`
+
+	Disposition *ProbeDisposition `json:"disposition,omitempty"`
+
+	// Color description, for telling HDR sources apart
+	PixFmt         string          `json:"pix_fmt,omitempty"`
+	ColorPrimaries string          `json:"color_primaries,omitempty"`
+	ColorTransfer  string          `json:"color_transfer,omitempty"`
+	ColorSpace     string          `json:"color_space,omitempty"`
+	CodecTag       string            `json:"codec_tag_string,omitempty"`
+	SideData       []ProbeSideData   `json:"side_data_list,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+
+	// HDR is "hdr10", "hlg" or "dolby_vision", derived from the above
+	// after probing; empty for SDR
+	HDR string `json:"hdr,omitempty"`
+
+	// Rotation is how far players turn the video clockwise for display,
+	// from its display matrix or rotate tag: 0, 90, 180 or 270
+	Rotation int `json:"rotation,omitempty"`
+
+	// Frame rates as ffprobe rationals: the base rate and the average
+	RFrameRate   string `json:"r_frame_rate,omitempty"`
+	AvgFrameRate string `json:"avg_frame_rate,omitempty"`
+
+	// VFR is set for a variable frame rate video, derived from the above
+	VFR bool `json:"vfr,omitempty"`
 }
 
+// ProbeDisposition holds the stream disposition flags that matter here
+type ProbeDisposition struct {
+	AttachedPic int `json:"attached_pic"` // cover art rather than video
+}
+
+// ProbeSideData is an entry of a stream's side data list
+type ProbeSideData struct {
+	Type     string  `json:"side_data_type"`
+	Rotation float64 `json:"rotation,omitempty"` // display matrix, counter-clockwise
+}
+
+// ProbeOptions bounds how much work ffprobe does on a slow stream
+type ProbeOptions struct {
+	AnalyzeDuration time.Duration // -analyzeduration, 0 = ffprobe default
+	ProbeSize       int64         // -probesize in bytes, 0 = ffprobe default
+	Timeout         time.Duration // overall deadline, 0 = none
+	LocalAddr       string        // source address for network inputs
+	Proxy           string        // http(s) proxy for network inputs
+	Seekable        bool          // server takes Range requests; seek, don't stream
+	Sections        ProbeSections // extra sections, left out of a quick fallback
+}
+
+// quickProbeTimeout bounds the shallow fallback pass after a timeout
+const quickProbeTimeout = 5 * time.Second
+
+// errProbeTimeout is a probe whose quick fallback pass failed too after
+// the deadline
+var errProbeTimeout = errors.New("probe timed out")
+
 // ProbeURL uses ffprobe to get stream information
 func ProbeURL(url string, headers map[string]string) (*ProbeResult, error) {
+	return ProbeURLWithOptions(url, headers, ProbeOptions{})
+}
+
+// ProbeURLWithOptions probes with analysis limits and a deadline. If the
+// deadline hits, a quick shallow probe is attempted and its result returned
+// with Partial set, so callers still get basic info rather than an error.
+// Identical probes running at the same time share one ffprobe.
+func ProbeURLWithOptions(url string, headers map[string]string, opts ProbeOptions) (*ProbeResult, error) {
+	return probeOnce(probeKey(url, headers, opts), func() (*ProbeResult, error) {
+		result, err := probeURL(url, headers, opts)
+		if err == nil {
+			rememberProbe(url, headers, result)
+		}
+		return result, err
+	})
+}
+
+func probeURL(url string, headers map[string]string, opts ProbeOptions) (*ProbeResult, error) {
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var limits []string
+	if opts.AnalyzeDuration > 0 {
+		limits = append(limits, "-analyzeduration", strconv.FormatInt(opts.AnalyzeDuration.Microseconds(), 10))
+	}
+	if opts.ProbeSize > 0 {
+		limits = append(limits, "-probesize", strconv.FormatInt(opts.ProbeSize, 10))
+	}
+
+	if opts.LocalAddr != "" && isNetworkInput(url) {
+		limits = append(limits, "-local_addr", opts.LocalAddr)
+	}
+	limits = append(limits, seekableArgs(url, opts)...)
+
+	result, err := runProbe(ctx, url, headers, limits, opts.Sections, opts.Proxy)
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return result, err
+	}
+
+	quickCtx, cancel := context.WithTimeout(context.Background(), quickProbeTimeout)
+	defer cancel()
+
+	quick := []string{
+		"-analyzeduration", "500000",
+		"-probesize", "500000",
+	}
+	if opts.LocalAddr != "" && isNetworkInput(url) {
+		quick = append(quick, "-local_addr", opts.LocalAddr)
+	}
+	quick = append(quick, seekableArgs(url, opts)...)
+	result, err = runProbe(quickCtx, url, headers, quick, ProbeSections{}, opts.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("%w after %s", errProbeTimeout, opts.Timeout)
+	}
+
+	result.Partial = true
+	return result, nil
+}
+
+// seekableArgs tells ffmpeg's http protocol the server can seek, so a
+// demuxer after a trailing index jumps there with a Range request instead
+// of reading the whole file on its way
+func seekableArgs(url string, opts ProbeOptions) []string {
+	scheme := URLScheme(url)
+	if !opts.Seekable || (scheme != "http" && scheme != "https") {
+		return nil
+	}
+	return []string{"-seekable", "1"}
+}
+
+func runProbe(ctx context.Context, url string, headers map[string]string, extra []string, sections ProbeSections, proxy string) (*ProbeResult, error) {
 	args := []string{
-		"-v", "quiet",
+		"-v", "error",
 		"-print_format", "json",
 		"-show_format",
 		"-show_streams",
 	}
+	args = append(args, sections.args()...)
+	args = append(args, extra...)
 
 	if len(headers) > 0 {
 		args = append(args, "-headers", buildHeaderString(headers))
@@ -127,16 +275,27 @@ func ProbeURL(url string, headers map[string]string) (*ProbeResult, error) {
 
 	args = append(args, url)
 
-	cmd := exec.Command(GetFFprobePath(), args...)
+	cmd := command(ctx, GetFFprobePath(), args, EnvOptions{Proxy: proxy})
 	out, err := cmd.Output()
 	if err != nil {
-		return nil, err
+		// Keep what ffprobe printed, e.g. the HTTP status that refused us
+		return nil, captured(err)
 	}
 
 	var result ProbeResult
 	if err := json.Unmarshal(out, &result); err != nil {
 		return nil, err
 	}
+	if sections != (ProbeSections{}) {
+		if result.Extra, err = sections.extraSections(out); err != nil {
+			return nil, err
+		}
+	}
+	for i := range result.Streams {
+		result.Streams[i].HDR = result.Streams[i].hdrFormat()
+		result.Streams[i].Rotation = result.Streams[i].rotation()
+		result.Streams[i].VFR = result.Streams[i].variableFrameRate()
+	}
 
 	return &result, nil
 }
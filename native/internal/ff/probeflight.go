@@ -0,0 +1,121 @@
+package ff
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeCacheTTL is how long a finished probe of a network URL is kept for
+// CachedProbe; a download usually follows its probe within seconds
+const probeCacheTTL = 5 * time.Minute
+
+// probeCall is a probe in progress that identical probes wait on instead
+// of starting ffprobe again
+type probeCall struct {
+	done   chan struct{}
+	result *ProbeResult
+	err    error
+}
+
+// cachedProbe is a finished probe kept for CachedProbe
+type cachedProbe struct {
+	result *ProbeResult
+	probed time.Time
+}
+
+var (
+	probesMu sync.Mutex
+	probes   = make(map[string]*probeCall)
+	recent   = make(map[string]cachedProbe) // by source, see sourceKey
+)
+
+// probeKey identifies probes that would run the same ffprobe command
+func probeKey(url string, headers map[string]string, opts ProbeOptions) string {
+	return fmt.Sprintf("%s\x00%+v", sourceKey(url, headers), opts)
+}
+
+// sourceKey identifies what a probe looked at, whatever its options
+func sourceKey(url string, headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(url)
+	for _, k := range names {
+		fmt.Fprintf(&b, "\x00%s: %s", k, headers[k])
+	}
+	return b.String()
+}
+
+// copyProbe returns a copy of r its caller may adjust
+func copyProbe(r *ProbeResult) *ProbeResult {
+	c := *r
+	if r.Streams != nil {
+		c.Streams = append(make([]ProbeStream, 0, len(r.Streams)), r.Streams...)
+	}
+	return &c
+}
+
+// rememberProbe keeps a complete probe of a network URL for CachedProbe.
+// Local files are left out, they change under the same path.
+func rememberProbe(url string, headers map[string]string, result *ProbeResult) {
+	if result.Partial || !isNetworkInput(url) {
+		return
+	}
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	for key, p := range recent {
+		if time.Since(p.probed) > probeCacheTTL {
+			delete(recent, key)
+		}
+	}
+	recent[sourceKey(url, headers)] = cachedProbe{result: copyProbe(result), probed: time.Now()}
+}
+
+// CachedProbe returns the latest complete probe of url with these headers
+// made in the last probeCacheTTL, whatever options it ran with
+func CachedProbe(url string, headers map[string]string) (*ProbeResult, bool) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	p, ok := recent[sourceKey(url, headers)]
+	if !ok || time.Since(p.probed) > probeCacheTTL {
+		return nil, false
+	}
+	return copyProbe(p.result), true
+}
+
+// probeOnce runs probe unless an identical one is already in flight, in
+// which case it waits for that one and returns a copy of its result. Only
+// concurrent probes are joined; finished ones are kept by ProbeURLWithOptions
+// for CachedProbe alone.
+func probeOnce(key string, probe func() (*ProbeResult, error)) (*ProbeResult, error) {
+	probesMu.Lock()
+	if call, ok := probes[key]; ok {
+		probesMu.Unlock()
+		log.Printf("[PROBE] Joining a probe already in flight")
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+		// Callers may adjust their result, so they don't share one
+		return copyProbe(call.result), nil
+	}
+	call := &probeCall{done: make(chan struct{})}
+	probes[key] = call
+	probesMu.Unlock()
+
+	call.result, call.err = probe()
+
+	probesMu.Lock()
+	delete(probes, key)
+	probesMu.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
@@ -0,0 +1,89 @@
+package ff
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	probeRetryBaseDelay = time.Second
+	probeRetryMaxDelay  = 10 * time.Second
+)
+
+// MaxProbeRetries bounds ProbeRetry.Retries
+const MaxProbeRetries = 5
+
+// ProbeRetry says how often a probe that fails transiently is tried again
+type ProbeRetry struct {
+	Retries int // after the first attempt, 0 = never retry
+
+	// OnRetry, if set, is told about each retry before its backoff
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// transientProbeLines are ffprobe errors a later attempt may not hit
+var transientProbeLines = []string{
+	"connection reset",
+	"connection timed out",
+	"connection refused",
+	"timed out",
+	"temporary failure in name resolution",
+	"broken pipe",
+	"end of file",
+	"i/o error",
+}
+
+// ProbeRetryable reports whether a failed probe is worth trying again:
+// timeouts, rate limiting, 5xx replies and dropped connections. A 404, an
+// auth failure, DRM or a format ffprobe can't read fail the same way every
+// time.
+func ProbeRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errProbeTimeout) {
+		return true
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	switch status := exitErr.HTTPStatus(); {
+	case status == 408 || status == 429 || status >= 500:
+		return true
+	case status != 0:
+		return false
+	}
+	for _, line := range exitErr.Stderr {
+		lower := strings.ToLower(line)
+		for _, transient := range transientProbeLines {
+			if strings.Contains(lower, transient) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProbeURLWithRetry probes like ProbeURLWithOptions, trying again after a
+// doubling backoff while the probe fails with a retryable error
+func ProbeURLWithRetry(url string, headers map[string]string, opts ProbeOptions, retry ProbeRetry) (*ProbeResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := ProbeURLWithOptions(url, headers, opts)
+		if err == nil || attempt > retry.Retries || !ProbeRetryable(err) {
+			return result, err
+		}
+
+		delay := probeRetryBaseDelay << (attempt - 1)
+		if delay > probeRetryMaxDelay {
+			delay = probeRetryMaxDelay
+		}
+		log.Printf("[PROBE] Attempt %d failed, retrying in %s: %v", attempt, delay, err)
+		if retry.OnRetry != nil {
+			retry.OnRetry(attempt, delay, err)
+		}
+		time.Sleep(delay)
+	}
+}
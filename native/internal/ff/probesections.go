@@ -0,0 +1,91 @@
+package ff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Upper bounds on the packets and frames a probe may list; frames are
+// decoded, so they're kept much shorter
+const (
+	MaxProbePackets = 1000
+	MaxProbeFrames  = 50
+)
+
+// ProbeSections asks ffprobe for sections beyond format and streams. They
+// come back unparsed in ProbeResult.Extra.
+type ProbeSections struct {
+	Chapters bool
+	Programs bool
+	Packets  int // first N packets, 0 = none
+	Frames   int // first N frames, 0 = none
+}
+
+// Validate rejects packet and frame counts outside 0..the maximum, so a
+// probe can't be asked to walk a whole file
+func (s ProbeSections) Validate() error {
+	if s.Packets < 0 || s.Packets > MaxProbePackets {
+		return fmt.Errorf("packets must be between 0 and %d", MaxProbePackets)
+	}
+	if s.Frames < 0 || s.Frames > MaxProbeFrames {
+		return fmt.Errorf("frames must be between 0 and %d", MaxProbeFrames)
+	}
+	return nil
+}
+
+// args returns the ffprobe options for the sections. -read_intervals stops
+// reading after the larger of the two counts; the smaller list is trimmed
+// to size afterwards.
+func (s ProbeSections) args() []string {
+	var args []string
+	if s.Chapters {
+		args = append(args, "-show_chapters")
+	}
+	if s.Programs {
+		args = append(args, "-show_programs")
+	}
+	if s.Packets > 0 {
+		args = append(args, "-show_packets")
+	}
+	if s.Frames > 0 {
+		args = append(args, "-show_frames")
+	}
+	if n := max(s.Packets, s.Frames); n > 0 {
+		args = append(args, "-read_intervals", "%+#"+strconv.Itoa(n))
+	}
+	return args
+}
+
+// extraSections collects every top-level section of ffprobe's output but
+// format and streams, trimming packet and frame lists to their counts.
+// Asked for together, packets and frames come interleaved in a single
+// packets_and_frames list, left as ffprobe wrote it.
+func (s ProbeSections) extraSections(out []byte) (map[string]json.RawMessage, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(out, &all); err != nil {
+		return nil, err
+	}
+	delete(all, "format")
+	delete(all, "streams")
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	for key, limit := range map[string]int{"packets": s.Packets, "frames": s.Frames} {
+		raw, ok := all[key]
+		if !ok || limit <= 0 {
+			continue
+		}
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil || len(list) <= limit {
+			continue
+		}
+		trimmed, err := json.Marshal(list[:limit])
+		if err != nil {
+			return nil, err
+		}
+		all[key] = trimmed
+	}
+	return all, nil
+}
@@ -0,0 +1,92 @@
+package ff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func collectProgress(t *testing.T, input string, oneByte bool) []ProgressUpdate {
+	t.Helper()
+	r := strings.NewReader(input)
+	var updates []ProgressUpdate
+	onProgress := func(u ProgressUpdate) { updates = append(updates, u) }
+	if oneByte {
+		parseProgress(iotest.OneByteReader(r), onProgress)
+	} else {
+		parseProgress(r, onProgress)
+	}
+	return updates
+}
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []ProgressUpdate
+	}{
+		{
+			name:  "full block",
+			input: "frame=10\nfps=25.0\ntotal_size=2048\nout_time_us=400000\nspeed=1.5x\nprogress=continue\n",
+			want: []ProgressUpdate{{
+				BytesWritten: 2048, OutTimeMs: 400000, Speed: 1.5, Frame: 10,
+				Reported: FieldSize | FieldOutTime | FieldSpeed | FieldFrame,
+			}},
+		},
+		{
+			name: "a later block without total_size doesn't repeat the old one",
+			input: "total_size=1000\nout_time_us=1000000\nprogress=continue\n" +
+				"out_time_us=2000000\nprogress=continue\n",
+			want: []ProgressUpdate{
+				{BytesWritten: 1000, OutTimeMs: 1000000, Reported: FieldSize | FieldOutTime},
+				{OutTimeMs: 2000000, Reported: FieldOutTime},
+			},
+		},
+		{
+			name:  "N/A values are left out",
+			input: "total_size=N/A\nout_time_us=N/A\nspeed=N/A\nframe=3\nprogress=continue\n",
+			want:  []ProgressUpdate{{Frame: 3, Reported: FieldFrame}},
+		},
+		{
+			name:  "repeated key, last wins",
+			input: "out_time_ms=500\nout_time_us=700\ntotal_size=1\ntotal_size=2\nprogress=end\n",
+			want:  []ProgressUpdate{{BytesWritten: 2, OutTimeMs: 700, Reported: FieldSize | FieldOutTime}},
+		},
+		{
+			name:  "unknown keys and stray lines are ignored",
+			input: "stream_0_0_q=28.0\nbitrate=1000kbits/s\nnot a pair\n\ndup_frames=0\ntotal_size=5\nprogress=continue\n",
+			want:  []ProgressUpdate{{BytesWritten: 5, Reported: FieldSize}},
+		},
+		{
+			name:  "negative out_time is dropped",
+			input: "out_time_us=-9223372036854775807\ntotal_size=0\nprogress=continue\n",
+			want:  []ProgressUpdate{{Reported: FieldSize}},
+		},
+		{
+			name:  "an unfinished block at EOF isn't reported",
+			input: "total_size=10\nprogress=continue\ntotal_size=20\nout_time_us=5",
+			want:  []ProgressUpdate{{BytesWritten: 10, Reported: FieldSize}},
+		},
+		{
+			name:  "empty block",
+			input: "progress=continue\n",
+			want:  []ProgressUpdate{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			name := tt.name
+			if oneByte {
+				name += ", split into single bytes"
+			}
+			t.Run(name, func(t *testing.T) {
+				got := collectProgress(t, tt.input, oneByte)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("parseProgress() =\n  %+v\nwant\n  %+v", got, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package ff
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// defaultProtocols are the protocols ffmpeg may open for a playlist and the
+// segments, keys and proxies it references
+var defaultProtocols = []string{"file", "crypto", "httpproxy", "http", "https", "tcp", "tls"}
+
+// optInProtocols are URL schemes a job can allow on top of the defaults,
+// with the protocols ffmpeg needs underneath each
+var optInProtocols = map[string][]string{
+	"ftp":   {"ftp", "tcp"},
+	"rtmp":  {"rtmp", "tcp"},
+	"rtmps": {"rtmps", "rtmp", "tcp", "tls"},
+	"rtsp":  {"rtsp", "rtp", "srtp", "udp", "tcp"},
+	"srt":   {"srt", "udp"},
+}
+
+// UnsupportedProtocolError is returned for a URL whose scheme isn't allowed
+type UnsupportedProtocolError struct {
+	Scheme string
+}
+
+func (e *UnsupportedProtocolError) Error() string {
+	if _, ok := optInProtocols[e.Scheme]; ok {
+		return fmt.Sprintf("%s URLs aren't allowed for this download, add %q to allowProtocols", e.Scheme, e.Scheme)
+	}
+	return fmt.Sprintf("%s URLs aren't supported", e.Scheme)
+}
+
+// ValidateProtocols checks a job's allowProtocols list against the schemes
+// that can be opted into
+func ValidateProtocols(allow []string) error {
+	for _, p := range allow {
+		if _, ok := optInProtocols[strings.ToLower(p)]; !ok {
+			known := make([]string, 0, len(optInProtocols))
+			for k := range optInProtocols {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("protocol %q can't be allowed, choose from %s", p, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// URLScheme returns the lowercased scheme of rawURL, "file" for paths
+func URLScheme(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	// One letter is a Windows drive ("C:\..."), not a scheme
+	if err != nil || len(u.Scheme) < 2 {
+		return "file"
+	}
+	return strings.ToLower(u.Scheme)
+}
+
+// CheckProtocol returns an UnsupportedProtocolError unless rawURL's scheme
+// is a default protocol or one of allow
+func CheckProtocol(rawURL string, allow []string) error {
+	scheme := URLScheme(rawURL)
+	for _, p := range defaultProtocols {
+		if scheme == p {
+			return nil
+		}
+	}
+	for _, p := range allow {
+		if strings.EqualFold(p, scheme) {
+			if _, ok := optInProtocols[scheme]; ok {
+				return nil
+			}
+		}
+	}
+	return &UnsupportedProtocolError{Scheme: scheme}
+}
+
+// ProtocolWhitelist returns the -protocol_whitelist value for the default
+// protocols plus the ones each allowed scheme needs
+func ProtocolWhitelist(allow []string) string {
+	list := append([]string(nil), defaultProtocols...)
+	seen := make(map[string]bool, len(list))
+	for _, p := range list {
+		seen[p] = true
+	}
+	for _, scheme := range allow {
+		for _, p := range optInProtocols[strings.ToLower(scheme)] {
+			if !seen[p] {
+				seen[p] = true
+				list = append(list, p)
+			}
+		}
+	}
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,82 @@
+package ff
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// reconnectDelayMax caps ffmpeg's backoff between reconnects, in seconds,
+// in line with the job-level retry's longest wait
+const reconnectDelayMax = "30"
+
+// hlsSegmentRetries is how many times ffmpeg's hls demuxer reloads a
+// segment that failed before giving up on it
+const hlsSegmentRetries = 3
+
+// ReconnectInputs adds ffmpeg's own retries ahead of every network input,
+// so the job only sees a failure, and retries the whole download, once
+// ffmpeg gives up:
+//   - http(s) inputs get the reconnect options, retrying a request that
+//     fails with one of statuses (codes such as "429" or classes such as
+//     "5xx") or on a dropped connection. That covers a progressive file
+//     and the playlist or manifest itself, but the hls and dash demuxers
+//     don't pass these options on to the segment requests they make.
+//   - HLS inputs (those forced to the hls demuxer with -f hls) also get
+//     -seg_max_retry, the demuxer's own retry of a failed segment, where
+//     the ffmpeg build has it. DASH segments have no such retry in ffmpeg;
+//     the go engine resumes a failed DASH download from its last segment.
+//
+// ffmpeg builds older than 4.4 lack reconnect_on_http_error and fail with
+// an unknown-option error.
+func ReconnectInputs(args []string, statuses []string) []string {
+	return reconnectInputs(args, statuses, HLSSegmentRetrySupported())
+}
+
+func reconnectInputs(args []string, statuses []string, segmentRetry bool) []string {
+	if len(statuses) == 0 {
+		return args
+	}
+	opts := []string{
+		"-reconnect", "1",
+		"-reconnect_on_network_error", "1",
+		"-reconnect_on_http_error", strings.Join(statuses, ","),
+		"-reconnect_delay_max", reconnectDelayMax,
+	}
+
+	out := make([]string, 0, len(args)+len(opts))
+	hls := false // the current input is forced to the hls demuxer
+	for i, arg := range args {
+		if arg == "-f" && i+1 < len(args) {
+			hls = args[i+1] == "hls"
+		}
+		if arg == "-i" && i+1 < len(args) {
+			if scheme := URLScheme(args[i+1]); scheme == "http" || scheme == "https" {
+				out = append(out, opts...)
+				if hls && segmentRetry {
+					out = append(out, "-seg_max_retry", strconv.Itoa(hlsSegmentRetries))
+				}
+			}
+			hls = false
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+var (
+	segmentRetryOnce      sync.Once
+	segmentRetrySupported bool
+)
+
+// HLSSegmentRetrySupported reports whether the local ffmpeg's hls demuxer
+// has the seg_max_retry option (ffmpeg 5.0 and later). It is checked once
+// and cached.
+func HLSSegmentRetrySupported() bool {
+	segmentRetryOnce.Do(func() {
+		out, err := command(context.Background(), GetFFmpegPath(), []string{"-hide_banner", "-h", "demuxer=hls"}, EnvOptions{}).Output()
+		segmentRetrySupported = err == nil && strings.Contains(string(out), "seg_max_retry")
+	})
+	return segmentRetrySupported
+}
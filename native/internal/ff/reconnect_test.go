@@ -0,0 +1,98 @@
+package ff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReconnectInputs(t *testing.T) {
+	reconnect := []string{
+		"-reconnect", "1",
+		"-reconnect_on_network_error", "1",
+		"-reconnect_on_http_error", "429,5xx",
+		"-reconnect_delay_max", reconnectDelayMax,
+	}
+	join := func(parts ...[]string) []string {
+		var out []string
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+	segRetry := []string{"-seg_max_retry", "3"}
+
+	tests := []struct {
+		name         string
+		args         []string
+		statuses     []string
+		segmentRetry bool
+		want         []string
+	}{
+		{
+			name:     "no statuses leaves args alone",
+			args:     []string{"-i", "https://x/a.mp4", "out"},
+			statuses: nil,
+			want:     []string{"-i", "https://x/a.mp4", "out"},
+		},
+		{
+			name:     "http input",
+			args:     []string{"-headers", "A: b", "-i", "https://x/a.mp4", "-c", "copy", "out"},
+			statuses: []string{"429", "5xx"},
+			want:     join([]string{"-headers", "A: b"}, reconnect, []string{"-i", "https://x/a.mp4", "-c", "copy", "out"}),
+		},
+		{
+			name:     "local input",
+			args:     []string{"-i", "/tmp/a.mp4", "out"},
+			statuses: []string{"429", "5xx"},
+			want:     []string{"-i", "/tmp/a.mp4", "out"},
+		},
+		{
+			name:         "hls input gets the segment retry",
+			args:         []string{"-f", "hls", "-i", "https://x/v.m3u8", "out"},
+			statuses:     []string{"429", "5xx"},
+			segmentRetry: true,
+			want:         join([]string{"-f", "hls"}, reconnect, segRetry, []string{"-i", "https://x/v.m3u8", "out"}),
+		},
+		{
+			name:         "hls input without seg_max_retry in ffmpeg",
+			args:         []string{"-f", "hls", "-i", "https://x/v.m3u8", "out"},
+			statuses:     []string{"429", "5xx"},
+			segmentRetry: false,
+			want:         join([]string{"-f", "hls"}, reconnect, []string{"-i", "https://x/v.m3u8", "out"}),
+		},
+		{
+			name:         "only the hls one of two inputs",
+			args:         []string{"-f", "hls", "-i", "https://x/v.m3u8", "-i", "https://x/a.mp4", "-f", "mp4", "out"},
+			statuses:     []string{"429", "5xx"},
+			segmentRetry: true,
+			want: join([]string{"-f", "hls"}, reconnect, segRetry, []string{"-i", "https://x/v.m3u8"},
+				reconnect, []string{"-i", "https://x/a.mp4", "-f", "mp4", "out"}),
+		},
+		{
+			name:         "dash input",
+			args:         []string{"-i", "https://x/m.mpd", "out"},
+			statuses:     []string{"429", "5xx"},
+			segmentRetry: true,
+			want:         join(reconnect, []string{"-i", "https://x/m.mpd", "out"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reconnectInputs(tt.args, tt.statuses, tt.segmentRetry)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconnectInputs() =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildHLSArgsForcesDemuxer(t *testing.T) {
+	args := BuildHLSArgs("https://x/v.m3u8", "out", "mp4", nil, nil)
+	for i := 0; i+3 < len(args); i++ {
+		if args[i] == "-f" && args[i+1] == "hls" && args[i+2] == "-i" && args[i+3] == "https://x/v.m3u8" {
+			return
+		}
+	}
+	t.Errorf("BuildHLSArgs() = %q, want -f hls right before its -i", args)
+}
@@ -0,0 +1,95 @@
+package ff
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// Header names whose values must never appear in events or logs
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+// Query parameters commonly carrying credentials or signatures
+var sensitiveParams = map[string]bool{
+	"token":                true,
+	"access_token":         true,
+	"auth":                 true,
+	"key":                  true,
+	"apikey":               true,
+	"api_key":              true,
+	"sig":                  true,
+	"signature":            true,
+	"policy":               true,
+	"key-pair-id":          true,
+	"password":             true,
+	"secret":               true,
+	"hdnts":                true,
+	"hdnea":                true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+}
+
+// RedactArgs returns a copy of ffmpeg args safe to show to the user: header
+// blocks have credential values masked and URLs have token params masked
+func RedactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case i > 0 && args[i-1] == "-headers":
+			out[i] = RedactHeaderString(arg)
+		case strings.Contains(arg, "://"):
+			out[i] = RedactURL(arg)
+		default:
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+// RedactHeaderString masks sensitive values in a CRLF-separated header block
+func RedactHeaderString(headers string) string {
+	lines := strings.Split(headers, "\r\n")
+	for i, line := range lines {
+		name, _, ok := strings.Cut(line, ":")
+		if ok && sensitiveHeaders[strings.ToLower(strings.TrimSpace(name))] {
+			lines[i] = name + ": " + redacted
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// RedactURL masks userinfo passwords and sensitive query parameters,
+// preserving the order of the remaining query string
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			k, _, _ := strings.Cut(p, "=")
+			if name, err := url.QueryUnescape(k); err == nil && sensitiveParams[strings.ToLower(name)] {
+				params[i] = k + "=" + redacted
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	return u.String()
+}
@@ -0,0 +1,70 @@
+package ff
+
+import (
+	"math"
+	"strconv"
+)
+
+// rotation reads a video stream's display rotation, clockwise. Newer
+// ffprobe reports a display matrix (whose angle is counter-clockwise),
+// older builds a rotate tag.
+func (s ProbeStream) rotation() int {
+	if s.CodecType != "video" {
+		return 0
+	}
+	deg := 0.0
+	found := false
+	for _, sd := range s.SideData {
+		if sd.Type == "Display Matrix" {
+			deg, found = -sd.Rotation, true
+			break
+		}
+	}
+	if !found {
+		if tag, err := strconv.ParseFloat(s.Tags["rotate"], 64); err == nil {
+			deg = tag
+		}
+	}
+	// Only quarter turns are meaningful for video
+	r := int(math.Round(deg/90)) * 90 % 360
+	if r < 0 {
+		r += 360
+	}
+	return r
+}
+
+// VideoRotation returns the rotation of the first video stream
+func VideoRotation(streams []ProbeStream) int {
+	for _, s := range streams {
+		if s.CodecType == "video" {
+			return s.Rotation
+		}
+	}
+	return 0
+}
+
+// BuildRotationArgs returns the options handling a rotated source in a
+// re-encode. Baking is left to ffmpeg's autorotation, which turns the
+// decoded frames upright and clears the rotation on the output, so it needs
+// no options. Preserving turns autorotation off so the frames are encoded
+// as stored, and keeps the rotation as metadata. The first slice goes
+// before the input, the second before the output.
+func BuildRotationArgs(rotation int, bake bool) (input, output []string) {
+	if bake {
+		return nil, nil
+	}
+	return []string{"-noautorotate"}, []string{"-metadata:s:v:0", "rotate=" + strconv.Itoa(rotation)}
+}
+
+// InsertInputOptions adds options ahead of the first -i of an ffmpeg
+// command line
+func InsertInputOptions(args []string, opts ...string) []string {
+	for i, a := range args {
+		if a == "-i" {
+			out := append([]string(nil), args[:i]...)
+			out = append(out, opts...)
+			return append(out, args[i:]...)
+		}
+	}
+	return append(opts, args...)
+}
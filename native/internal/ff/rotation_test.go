@@ -0,0 +1,27 @@
+package ff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildRotationArgs(t *testing.T) {
+	tests := []struct {
+		rotation int
+		bake     bool
+		wantIn   []string
+		wantOut  []string
+	}{
+		{90, true, nil, nil},
+		{270, true, nil, nil},
+		{90, false, []string{"-noautorotate"}, []string{"-metadata:s:v:0", "rotate=90"}},
+		{180, false, []string{"-noautorotate"}, []string{"-metadata:s:v:0", "rotate=180"}},
+	}
+	for _, tt := range tests {
+		in, out := BuildRotationArgs(tt.rotation, tt.bake)
+		if !reflect.DeepEqual(in, tt.wantIn) || !reflect.DeepEqual(out, tt.wantOut) {
+			t.Errorf("BuildRotationArgs(%d, %v) = %q, %q, want %q, %q",
+				tt.rotation, tt.bake, in, out, tt.wantIn, tt.wantOut)
+		}
+	}
+}
@@ -1,39 +1,165 @@
 package ff
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"log"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// ProgressUpdate contains ffmpeg progress information
+// ProgressUpdate contains ffmpeg progress information from one progress
+// block. Fields the block didn't carry (or carried as N/A) are zero and
+// missing from Reported, rather than left over from an earlier block.
 type ProgressUpdate struct {
 	BytesWritten int64
-	OutTimeMs    int64
+	OutTimeMs    int64 // despite ffmpeg's key name, in microseconds
 	Speed        float64
 	Frame        int64
+	Reported     ProgressFields
+}
+
+// ProgressFields is a set of ProgressUpdate fields
+type ProgressFields uint8
+
+const (
+	FieldSize ProgressFields = 1 << iota
+	FieldOutTime
+	FieldSpeed
+	FieldFrame
+)
+
+// Has reports whether all of fields are in the set
+func (f ProgressFields) Has(fields ProgressFields) bool {
+	return f&fields == fields
 }
 
 // ProgressCallback is called with progress updates
 type ProgressCallback func(ProgressUpdate)
 
+// RunOptions configures a single ffmpeg invocation
+type RunOptions struct {
+	OnProgress ProgressCallback
+
+	// OnCommand is called with the binary and full argument list right
+	// before the process starts (used for debug reporting)
+	OnCommand func(path string, args []string)
+
+	// Stop, when closed, asks ffmpeg to quit gracefully by sending "q" on
+	// stdin so it writes the trailer (moov atom) for what it has so far.
+	// If it hasn't exited after StopTimeout it is killed.
+	Stop <-chan struct{}
+
+	// LowPriority runs ffmpeg nice so background jobs don't compete with
+	// foreground apps for CPU and disk (no-op where unsupported)
+	LowPriority bool
+
+	// LocalAddr binds network inputs to this local address (see BindInputs)
+	LocalAddr string
+
+	// Reconnect lists the HTTP statuses ffmpeg retries a request on by
+	// itself (see ReconnectInputs); empty leaves reconnecting off
+	Reconnect []string
+
+	// Env holds the per-run parts of ffmpeg's environment (see Env)
+	Env EnvOptions
+
+	// OnInput, if set, is told which demuxer ffmpeg used for each input.
+	// ffmpeg only prints that at info level, so the run's verbosity is
+	// raised and the extra lines are filtered out of stderr.
+	OnInput InputCallback
+
+	// OnFinalizing, if set, is called when the mp4/mov muxer starts its
+	// faststart pass. Moving the moov atom to the front rewrites the whole
+	// file with no progress output, which looks like a stall on big files.
+	// Like OnInput it raises the run's verbosity.
+	OnFinalizing func()
+
+	// OnNet, if set, is told of the requests, connections and reconnects
+	// ffmpeg logs (see NetEvent). The connection lines are only printed at
+	// verbose level, so it raises the verbosity further.
+	OnNet func(NetEvent)
+}
+
+// lowNice is the niceness low-priority ffmpeg processes run at
+const lowNice = 10
+
+// StopTimeout is how long a graceful stop may take before ffmpeg is killed
+const StopTimeout = 15 * time.Second
+
+// ErrStopTimeout is returned when ffmpeg ignored a graceful stop
+var ErrStopTimeout = errors.New("ffmpeg did not stop in time and was killed")
+
+// ErrStdoutOutput is returned for an output that would go to ffmpeg's stdout
+var ErrStdoutOutput = errors.New("output to stdout isn't supported")
+
+// ErrNoOverwrite is returned for args containing -n. Run always passes -y:
+// outputs are job temp files, and the final name's policy is onExisting's
+// job. ffmpeg refuses the pair, and where it's left to ask about an
+// existing file it waits on stdin, which nobody answers.
+var ErrNoOverwrite = errors.New("-n conflicts with -y, use onExisting to protect existing files")
+
+// IsStdoutTarget reports whether ffmpeg would write an output named out to
+// its stdout. That can never be allowed here: ffmpeg's stdout carries the
+// -progress stream, which Run parses line by line, and the host's own stdout
+// is the native messaging channel. Media bytes there would corrupt both.
+func IsStdoutTarget(out string) bool {
+	switch out {
+	case "-", "/dev/stdout", "/dev/fd/1", "/proc/self/fd/1":
+		return true
+	}
+	return strings.HasPrefix(out, "pipe:")
+}
+
 // RunFFmpeg executes ffmpeg with progress monitoring
 func RunFFmpeg(ctx context.Context, args []string, onProgress ProgressCallback) error {
+	return Run(ctx, args, RunOptions{OnProgress: onProgress})
+}
+
+// Run executes ffmpeg with the given options
+func Run(ctx context.Context, args []string, opts RunOptions) error {
+	if len(args) > 0 && IsStdoutTarget(args[len(args)-1]) {
+		return ErrStdoutOutput
+	}
+	for _, a := range args {
+		if a == "-n" {
+			return ErrNoOverwrite
+		}
+	}
+
+	// Only outputs muxed with +faststart have a finalizing pass to watch
+	if !strings.Contains(strings.Join(args, " "), "+faststart") {
+		opts.OnFinalizing = nil
+	}
+
+	verbosity := "error"
+	switch {
+	case opts.OnNet != nil:
+		verbosity = "level+verbose"
+	case opts.OnInput != nil || opts.OnFinalizing != nil:
+		verbosity = "level+info"
+	}
+
 	// Prepend standard args
 	fullArgs := []string{
 		"-y",                  // overwrite
-		"-v", "error",         // only show errors
+		"-v", verbosity,       // only show errors (more if hooks need it)
+		"-hide_banner",        // no version/config dump at info level
 		"-nostats",            // no stats
 		"-progress", "pipe:1", // progress to stdout
 	}
-	fullArgs = append(fullArgs, args...)
+	if opts.Stop == nil {
+		// Nothing is written to stdin, so don't let ffmpeg wait on it
+		fullArgs = append(fullArgs, "-nostdin")
+	}
+	fullArgs = append(fullArgs, BindInputs(ReconnectInputs(args, opts.Reconnect), opts.LocalAddr)...)
 
-	cmd := exec.CommandContext(ctx, GetFFmpegPath(), fullArgs...)
+	path := GetFFmpegPath()
+	cmd := command(ctx, path, fullArgs, opts.Env)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -45,21 +171,85 @@ func RunFFmpeg(ctx context.Context, args []string, onProgress ProgressCallback)
 		return err
 	}
 
+	var stdin io.WriteCloser
+	if opts.Stop != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
+
+	if opts.OnCommand != nil {
+		opts.OnCommand(path, fullArgs)
+	}
+
+	if opts.LowPriority {
+		prepareLowPriority(cmd)
+	}
+
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 
+	if opts.LowPriority {
+		if err := lowerPriority(cmd.Process.Pid); err != nil {
+			log.Printf("[FFMPEG] Could not lower priority: %v", err)
+		}
+	}
+
 	// Parse progress from stdout
-	go parseProgress(stdout, onProgress)
+	go parseProgress(stdout, opts.OnProgress)
+
+	// Keep the tail of stderr for the error. It must be read to EOF before
+	// Wait closes the pipe, or the last (most useful) lines are lost.
+	var tail []string
+	stderrDone := make(chan struct{})
+	go func() {
+		tail = readStderr(stderr, opts)
+		close(stderrDone)
+	}()
+
+	wait := func() error {
+		<-stderrDone
+		if err := cmd.Wait(); err != nil {
+			return &ExitError{Err: err, Stderr: tail}
+		}
+		return nil
+	}
 
-	// Log stderr
-	go logStderr(stderr)
+	if opts.Stop == nil {
+		return wait()
+	}
+
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- wait()
+	}()
+
+	select {
+	case err := <-waitDone:
+		return err
+	case <-opts.Stop:
+	}
+
+	io.WriteString(stdin, "q")
+	stdin.Close()
 
-	return cmd.Wait()
+	timer := time.NewTimer(StopTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-waitDone:
+		// ffmpeg may exit non-zero after "q" but the trailer is written
+		return nil
+	case <-timer.C:
+		cmd.Process.Kill()
+		<-waitDone
+		return ErrStopTimeout
+	}
 }
 
 func parseProgress(r io.Reader, onProgress ProgressCallback) {
-	scanner := bufio.NewScanner(r)
+	scanner := newLineScanner(r, "progress")
 	var update ProgressUpdate
 
 	for scanner.Scan() {
@@ -75,50 +265,168 @@ func parseProgress(r io.Reader, onProgress ProgressCallback) {
 		}
 
 		key := parts[0]
-		value := parts[1]
+		value := strings.TrimSpace(parts[1])
 
+		// A repeated key within a block overwrites the earlier value; keys
+		// not listed here (fps, bitrate, stream_*_q, ...) are ignored
 		switch key {
 		case "total_size":
 			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
 				update.BytesWritten = n
+				update.Reported |= FieldSize
 			}
-		case "out_time_ms":
-			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		case "out_time_us", "out_time_ms":
+			// Both are microseconds; newer ffmpeg sends the correctly named
+			// out_time_us as well, so whichever comes last wins
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
 				update.OutTimeMs = n
+				update.Reported |= FieldOutTime
 			}
 		case "frame":
 			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
 				update.Frame = n
+				update.Reported |= FieldFrame
 			}
 		case "speed":
 			// Remove 'x' suffix
 			value = strings.TrimSuffix(value, "x")
 			if f, err := strconv.ParseFloat(value, 64); err == nil {
 				update.Speed = f
+				update.Reported |= FieldSpeed
 			}
 		case "progress":
 			// End of progress block, send update
 			if onProgress != nil {
 				onProgress(update)
 			}
+			update = ProgressUpdate{}
 		}
 	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("[FFMPEG] Progress read error: %v", err)
+	}
 }
 
-func logStderr(r io.Reader) {
-	scanner := bufio.NewScanner(r)
+// faststartMarker is what the mp4/mov muxer logs when its faststart pass
+// begins
+const faststartMarker = "Starting second pass: moving the moov atom"
+
+// readStderr consumes stderr to EOF and returns its last lines, redacted.
+// Info-level lines are only passed to the options' hooks, never kept.
+func readStderr(r io.Reader, opts RunOptions) []string {
+	var tail []string
+	scanner := newLineScanner(r, "stderr")
 	for scanner.Scan() {
-		// Could log to stderr or send to extension
-		// For now just consume it
-		_ = scanner.Text()
+		level, line := splitLevel(strings.TrimSpace(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if opts.OnNet != nil {
+			if event, ok := parseNetLine(line); ok {
+				opts.OnNet(event)
+			}
+		}
+		if isChatter(level) {
+			if index, formats, ok := parseInputLine(line); ok && opts.OnInput != nil {
+				opts.OnInput(index, formats)
+			}
+			if strings.Contains(line, faststartMarker) && opts.OnFinalizing != nil {
+				opts.OnFinalizing()
+			}
+			continue
+		}
+		if len(tail) == stderrTailLines {
+			tail = tail[1:]
+		}
+		tail = append(tail, redactLine(line))
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("[FFMPEG] Stderr read error: %v", err)
 	}
+	return tail
 }
 
 // BuildHLSArgs constructs ffmpeg args for HLS download
-func BuildHLSArgs(url, output string, headers map[string]string) []string {
+func BuildHLSArgs(url, output, container string, headers map[string]string, protocols []string) []string {
+	args := hlsInputArgs(url, headers, protocols)
+
+	args = append(args,
+		"-c:v", "copy",
+		"-c:a", "copy",
+	)
+	args = append(args, muxArgs(container)...)
+	args = append(args, output)
+
+	return args
+}
+
+// BuildHLSRenditionArgs constructs ffmpeg args muxing an HLS video variant
+// with an explicitly chosen alternate audio rendition
+func BuildHLSRenditionArgs(videoURL, audioURL, language, output, container string, headers map[string]string, protocols []string) []string {
+	args := hlsInputArgs(videoURL, headers, protocols)
+	args = append(args, hlsInputArgs(audioURL, headers, protocols)...)
+
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", "1:a:0",
+		"-c:v", "copy",
+		"-c:a", "copy",
+	)
+	if language != "" {
+		args = append(args, "-metadata:s:a:0", "language="+language)
+	}
+	args = append(args, muxArgs(container)...)
+	args = append(args, output)
+
+	return args
+}
+
+// BuildMuxTracksArgs constructs ffmpeg args muxing separately downloaded
+// video and audio tracks into one file without re-encoding
+func BuildMuxTracksArgs(video, audio, language, output, container string) []string {
+	args := []string{
+		"-i", video,
+		"-i", audio,
+		"-map", "0:v:0",
+		"-map", "1:a:0",
+		"-c", "copy",
+	}
+	if language != "" {
+		args = append(args, "-metadata:s:a:0", "language="+language)
+	}
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
+
+// hlsInputArgs returns the input options and -i for one HLS playlist,
+// read with the hls demuxer (which ReconnectInputs looks for).
+// protocols are schemes allowed beyond the default whitelist.
+func hlsInputArgs(url string, headers map[string]string, protocols []string) []string {
+	args := networkInputArgs(url, headers, protocols)
+	return append(args[:len(args)-2], "-f", "hls", "-i", url)
+}
+
+// networkInputArgs returns the input options and -i for a network input
+// of any kind, the demuxer left to ffmpeg to detect
+func networkInputArgs(url string, headers map[string]string, protocols []string) []string {
+	args := []string{
+		"-user_agent", "Vidown/1.0 (Native Companion)",
+		"-protocol_whitelist", ProtocolWhitelist(protocols),
+	}
+
+	if len(headers) > 0 {
+		args = append(args, "-headers", buildHeaderString(headers))
+	}
+
+	return append(args, "-i", url)
+}
+
+// BuildDASHArgs constructs ffmpeg args for DASH download
+func BuildDASHArgs(url, output, container string, headers map[string]string) []string {
 	args := []string{
 		"-user_agent", "Vidown/1.0 (Native Companion)",
-		"-protocol_whitelist", "file,crypto,httpproxy,http,https,tcp,tls",
 	}
 
 	if len(headers) > 0 {
@@ -129,18 +437,16 @@ func BuildHLSArgs(url, output string, headers map[string]string) []string {
 		"-i", url,
 		"-c:v", "copy",
 		"-c:a", "copy",
-		"-movflags", "+faststart",
-		output,
 	)
+	args = append(args, muxArgs(container)...)
+	args = append(args, output)
 
 	return args
 }
 
-// BuildDASHArgs constructs ffmpeg args for DASH download
-func BuildDASHArgs(url, output string, headers map[string]string) []string {
-	args := []string{
-		"-user_agent", "Vidown/1.0 (Native Companion)",
-	}
+// BuildHTTPArgs constructs ffmpeg args for a progressive HTTP download
+func BuildHTTPArgs(url, output, container string, headers map[string]string) []string {
+	args := []string{}
 
 	if len(headers) > 0 {
 		args = append(args, "-headers", buildHeaderString(headers))
@@ -148,48 +454,73 @@ func BuildDASHArgs(url, output string, headers map[string]string) []string {
 
 	args = append(args,
 		"-i", url,
-		"-c:v", "copy",
-		"-c:a", "copy",
-		"-movflags", "+faststart",
-		output,
+		"-c", "copy",
 	)
+	args = append(args, muxArgs(container)...)
+	args = append(args, output)
 
 	return args
 }
 
-// BuildConvertArgs constructs ffmpeg args for conversion
-func BuildConvertArgs(input, output string, vcodec, acodec string) []string {
+// BuildConvertArgs constructs ffmpeg args for conversion. threads caps the
+// encoders' threads, 0 leaves it to ffmpeg.
+func BuildConvertArgs(input, output, container string, vcodec, acodec string, threads int) []string {
 	args := []string{"-i", input}
+	args = append(args, videoCodecArgs(vcodec)...)
+	args = append(args, audioCodecArgs(acodec)...)
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	args = append(args, muxArgs(container)...)
+	args = append(args, output)
 
-	// Video codec
+	return args
+}
+
+// videoCodecArgs returns the encoder args for a convert video codec option
+func videoCodecArgs(vcodec string) []string {
 	switch vcodec {
-	case "copy":
-		args = append(args, "-c:v", "copy")
 	case "h264":
-		args = append(args, "-c:v", "libx264", "-crf", "23", "-preset", "medium")
+		return []string{"-c:v", "libx264", "-crf", "23", "-preset", "medium"}
 	case "hevc":
-		args = append(args, "-c:v", "libx265", "-crf", "28", "-preset", "medium")
+		return []string{"-c:v", "libx265", "-crf", "28", "-preset", "medium"}
+	case "vp9":
+		// Constant quality mode needs -b:v 0; row-mt makes it usably fast
+		return []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0",
+			"-row-mt", "1", "-deadline", "good", "-cpu-used", "2"}
+	case "av1":
+		// SVT-AV1 is far faster than libaom when the build has it
+		if HasEncoder("libsvtav1") {
+			return []string{"-c:v", "libsvtav1", "-crf", "35", "-preset", "8"}
+		}
+		return []string{"-c:v", "libaom-av1", "-crf", "30", "-b:v", "0",
+			"-cpu-used", "6", "-row-mt", "1"}
 	default:
-		args = append(args, "-c:v", "copy")
+		return []string{"-c:v", "copy"}
 	}
+}
 
-	// Audio codec
+// audioCodecArgs returns the encoder args for a convert audio codec option
+func audioCodecArgs(acodec string) []string {
 	switch acodec {
-	case "copy":
-		args = append(args, "-c:a", "copy")
 	case "aac":
-		args = append(args, "-c:a", "aac", "-b:a", "128k")
+		return []string{"-c:a", "aac", "-b:a", "128k"}
 	case "opus":
-		args = append(args, "-c:a", "libopus", "-b:a", "128k")
+		return []string{"-c:a", "libopus", "-b:a", "128k"}
 	case "mp3":
-		args = append(args, "-c:a", "libmp3lame", "-b:a", "192k")
+		return []string{"-c:a", "libmp3lame", "-b:a", "192k"}
 	default:
-		args = append(args, "-c:a", "copy")
+		return []string{"-c:a", "copy"}
 	}
+}
 
-	args = append(args, "-movflags", "+faststart", output)
-
-	return args
+// ValidateThreads checks a convert thread limit: 0 (ffmpeg's choice) up
+// to the number of CPUs
+func ValidateThreads(threads int) error {
+	if threads < 0 || threads > runtime.NumCPU() {
+		return fmt.Errorf("threads must be between 0 (auto) and %d, the number of CPUs", runtime.NumCPU())
+	}
+	return nil
 }
 
 // EstimateDuration tries to get duration from time-based progress
@@ -0,0 +1,179 @@
+package ff
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// withEncoders makes HasEncoder report exactly names for the test, instead
+// of asking the local ffmpeg
+func withEncoders(t *testing.T, names ...string) {
+	t.Helper()
+	encodersOnce.Do(func() {})
+	saved := encoders
+	encoders = make(map[string]bool)
+	for _, n := range names {
+		encoders[n] = true
+	}
+	t.Cleanup(func() { encoders = saved })
+}
+
+func TestBuildConvertArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		container string
+		vcodec    string
+		acodec    string
+		threads   int
+		encoders  []string
+		want      []string
+	}{
+		{
+			name:      "vp9 opus webm",
+			container: "webm", vcodec: "vp9", acodec: "opus",
+			want: []string{"-i", "in", "-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0",
+				"-row-mt", "1", "-deadline", "good", "-cpu-used", "2",
+				"-c:a", "libopus", "-b:a", "128k", "-f", "webm", "out"},
+		},
+		{
+			name:      "av1 with svt-av1",
+			container: "mkv", vcodec: "av1", acodec: "copy",
+			encoders: []string{"libsvtav1", "libaom-av1"},
+			want: []string{"-i", "in", "-c:v", "libsvtav1", "-crf", "35", "-preset", "8",
+				"-c:a", "copy", "-f", "matroska", "out"},
+		},
+		{
+			name:      "av1 with libaom only",
+			container: "webm", vcodec: "av1", acodec: "opus",
+			encoders: []string{"libaom-av1"},
+			want: []string{"-i", "in", "-c:v", "libaom-av1", "-crf", "30", "-b:v", "0",
+				"-cpu-used", "6", "-row-mt", "1",
+				"-c:a", "libopus", "-b:a", "128k", "-f", "webm", "out"},
+		},
+		{
+			name:      "h264 aac mp4 with threads",
+			container: "mp4", vcodec: "h264", acodec: "aac", threads: 2,
+			want: []string{"-i", "in", "-c:v", "libx264", "-crf", "23", "-preset", "medium",
+				"-c:a", "aac", "-b:a", "128k", "-threads", "2",
+				"-movflags", "+faststart", "-f", "mp4", "out"},
+		},
+		{
+			name:      "copy both",
+			container: "mkv", vcodec: "copy", acodec: "copy",
+			want: []string{"-i", "in", "-c:v", "copy", "-c:a", "copy", "-f", "matroska", "out"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEncoders(t, tt.encoders...)
+			got := BuildConvertArgs("in", "out", tt.container, tt.vcodec, tt.acodec, tt.threads)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildConvertArgs() =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConvert(t *testing.T) {
+	tests := []struct {
+		container, vcodec, acodec string
+		ok                        bool
+	}{
+		{"webm", "vp9", "opus", true},
+		{"webm", "av1", "opus", true},
+		{"webm", "vp9", "copy", true},
+		{"webm", "h264", "opus", false},
+		{"webm", "vp9", "aac", false},
+		{"mp4", "av1", "aac", true},
+		{"mp4", "vp9", "opus", true},
+		{"mp4", "h264", "aac", true},
+		{"mov", "vp9", "aac", false},
+		{"mkv", "av1", "mp3", true},
+		{"mkv", "theora", "copy", false},
+		{"mkv", "copy", "flac", false},
+		{"avi", "h264", "aac", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateConvert(tt.container, tt.vcodec, tt.acodec)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateConvert(%q, %q, %q) = %v, want ok = %v", tt.container, tt.vcodec, tt.acodec, err, tt.ok)
+		}
+	}
+}
+
+// fakeFFmpeg points Run at a script that leaves a marker file when it runs
+// and, like ffmpeg with -y, overwrites its output (the last arg). Help
+// queries (-h) are answered with nothing. It returns the marker's path.
+func fakeFFmpeg(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script for ffmpeg")
+	}
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	script := filepath.Join(dir, "ffmpeg")
+	body := "#!/bin/sh\ncase \" $* \" in *\" -h \"*) exit 0;; esac\n" +
+		"touch '" + marker + "'\nfor out; do :; done\necho new > \"$out\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	saved := ffmpegPath
+	ffmpegPath = script
+	t.Cleanup(func() { ffmpegPath = saved })
+	return marker
+}
+
+func TestRunRejectsNoOverwrite(t *testing.T) {
+	marker := fakeFFmpeg(t)
+	out := filepath.Join(t.TempDir(), "out.mp4")
+	if err := os.WriteFile(out, []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// ffmpeg itself would stop to ask about the existing file; Run must
+	// refuse up front rather than hang
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	commanded := false
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, []string{"-i", "in.mp4", "-c", "copy", "-n", out}, RunOptions{
+			OnCommand: func(string, []string) { commanded = true },
+		})
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run with -n hung")
+	}
+
+	if !errors.Is(err, ErrNoOverwrite) {
+		t.Errorf("Run with -n = %v, want ErrNoOverwrite", err)
+	}
+	if commanded {
+		t.Error("Run with -n got as far as building the command")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Run with -n started ffmpeg")
+	}
+	if b, _ := os.ReadFile(out); string(b) != "existing" {
+		t.Errorf("existing output = %q after Run with -n, want it untouched", b)
+	}
+
+	// The same args without -n do run it, so the checks above mean something
+	if err := Run(ctx, []string{"-i", "in.mp4", "-c", "copy", out}, RunOptions{}); err != nil {
+		t.Fatalf("Run without -n: %v", err)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "new\n" {
+		t.Errorf("output = %q after Run without -n, want it overwritten", b)
+	}
+}
@@ -0,0 +1,94 @@
+package ff
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSubtitleStyle is applied when burning plain-text subtitles (SRT,
+// WebVTT), whose libass defaults are small and thin on high-resolution
+// video. ASS/SSA files carry their own styles and are left alone.
+const DefaultSubtitleStyle = "FontName=Sans,FontSize=22,Outline=1.5,Shadow=0,MarginV=24"
+
+// subtitleCodec returns the subtitle codec a container stores soft subs as
+func subtitleCodec(container string) (string, error) {
+	switch container {
+	case "mp4", "m4v", "mov":
+		return "mov_text", nil
+	case "mkv":
+		return "copy", nil
+	case "webm":
+		return "webvtt", nil
+	}
+	return "", fmt.Errorf("%s can't carry a subtitle track", container)
+}
+
+// CanEmbedSubtitles reports whether container can hold a soft subtitle track
+func CanEmbedSubtitles(container string) bool {
+	_, err := subtitleCodec(container)
+	return err == nil
+}
+
+// BuildSubtitleArgs constructs ffmpeg args adding subs as a selectable
+// subtitle track, copying every original stream untouched
+func BuildSubtitleArgs(input, subs, output, container, language string) ([]string, error) {
+	codec, err := subtitleCodec(container)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"-i", input,
+		"-i", subs,
+		"-map", "0",
+		"-map", "1:0",
+		"-c", "copy",
+		"-c:s", codec,
+	}
+	if language != "" {
+		args = append(args, "-metadata:s:s:0", "language="+language)
+	}
+	args = append(args, muxArgs(container)...)
+	return append(args, output), nil
+}
+
+// BuildBurnSubtitlesArgs constructs ffmpeg args rendering subs into the
+// video picture. That needs a re-encode: vcodec picks the encoder as for
+// conversions, defaulting to what container usually holds. style, in ASS
+// force_style syntax, overrides DefaultSubtitleStyle for text subtitles.
+func BuildBurnSubtitlesArgs(input, subs, output, container, vcodec, style string) []string {
+	filter := "subtitles=filename=" + EscapeFilterPath(subs) + ":charenc=UTF-8"
+	if style == "" && !isASS(subs) {
+		style = DefaultSubtitleStyle
+	}
+	if style != "" {
+		filter += ":force_style=" + EscapeFilterPath(style)
+	}
+
+	if vcodec == "" || vcodec == "copy" {
+		vcodec = "h264"
+		if container == "webm" {
+			vcodec = "vp9"
+		}
+	}
+
+	args := []string{
+		"-i", input,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-vf", filter,
+	}
+	args = append(args, videoCodecArgs(vcodec)...)
+	args = append(args, "-c:a", "copy")
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
+
+func isASS(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ass", ".ssa":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package ff
+
+import (
+	"strconv"
+)
+
+// SupportsCoverArt reports whether a container can carry an attached_pic
+func SupportsCoverArt(container string) bool {
+	switch container {
+	case "mp4", "m4a", "mov":
+		return true
+	}
+	return false
+}
+
+// CoverArtStream returns the index of the first attached_pic stream among
+// a probe's streams (ffprobe lists them in index order), -1 for none
+func CoverArtStream(streams []ProbeStream) int {
+	for i, s := range streams {
+		if s.CodecType == "video" && s.Disposition != nil && s.Disposition.AttachedPic == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// coverArtArgs maps cover art into an audio output as its attached_pic.
+// JPEG and PNG covers are copied; other images are turned into JPEG, the
+// formats m4a and mp3 hold.
+func coverArtArgs(stream, codec string) []string {
+	args := []string{"-map", "0:a:0", "-map", stream}
+	if codec == "mjpeg" || codec == "png" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "mjpeg")
+	}
+	return append(args, "-disposition:v:0", "attached_pic")
+}
+
+// BuildThumbnailArgs constructs ffmpeg args grabbing a single JPEG frame at
+// the given offset (seconds)
+func BuildThumbnailArgs(input, output string, at float64) []string {
+	return []string{
+		"-ss", formatSeconds(at),
+		"-i", input,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		output,
+	}
+}
+
+// BuildEmbedThumbnailArgs constructs ffmpeg args attaching image as cover
+// art. The image is the second input; -map 0 keeps every original stream in
+// order, so the cover lands after the source's videoStreams video streams
+// and only that stream gets the attached_pic disposition.
+func BuildEmbedThumbnailArgs(input, image, output, container string, videoStreams int) []string {
+	cover := "v:" + strconv.Itoa(videoStreams)
+
+	args := []string{
+		"-i", input,
+		"-i", image,
+		"-map", "0",
+		"-map", "1:v:0",
+		"-c", "copy",
+		"-c:" + cover, "mjpeg",
+		"-disposition:" + cover, "attached_pic",
+	}
+	args = append(args, muxArgs(container)...)
+	return append(args, output)
+}
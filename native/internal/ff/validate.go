@@ -0,0 +1,41 @@
+package ff
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ErrNoValidator is returned by ValidateOutput when ffprobe isn't available,
+// so the caller has to go by the exit code alone
+var ErrNoValidator = errors.New("ffprobe not available to validate output")
+
+// validateTimeout bounds the quick probe of a finished output
+const validateTimeout = 15 * time.Second
+
+// ValidateOutput checks that ffmpeg's output exists, isn't empty and probes
+// with at least one stream
+func ValidateOutput(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("output missing: %w", err)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("output is empty")
+	}
+
+	if _, err := exec.LookPath(GetFFprobePath()); err != nil {
+		return ErrNoValidator
+	}
+
+	probe, err := ProbeURLWithOptions(path, nil, ProbeOptions{Timeout: validateTimeout})
+	if err != nil {
+		return fmt.Errorf("output doesn't probe: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return fmt.Errorf("output has no streams")
+	}
+	return nil
+}
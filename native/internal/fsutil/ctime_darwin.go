@@ -0,0 +1,52 @@
+//go:build darwin
+
+package fsutil
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// CreationTimeSupported reports whether SetCreationTime works here
+const CreationTimeSupported = true
+
+// From <sys/attr.h>
+const (
+	attrBitMapCount = 5
+	attrCmnCrtime   = 0x00000200
+	fsoptNoFollow   = 0x00000001
+)
+
+// attrList is struct attrlist
+type attrList struct {
+	bitmapCount uint16
+	reserved    uint16
+	commonAttr  uint32
+	volAttr     uint32
+	dirAttr     uint32
+	fileAttr    uint32
+	forkAttr    uint32
+}
+
+// SetCreationTime sets a file's creation (birth) time with setattrlist,
+// leaving its other times
+func SetCreationTime(path string, t time.Time) error {
+	name, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attrs := attrList{bitmapCount: attrBitMapCount, commonAttr: attrCmnCrtime}
+	created := syscall.NsecToTimespec(t.UnixNano())
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETATTRLIST,
+		uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&attrs)),
+		uintptr(unsafe.Pointer(&created)),
+		unsafe.Sizeof(created),
+		fsoptNoFollow, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
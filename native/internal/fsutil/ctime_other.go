@@ -0,0 +1,17 @@
+//go:build !windows && !darwin
+
+package fsutil
+
+import (
+	"errors"
+	"time"
+)
+
+// CreationTimeSupported reports whether SetCreationTime works here
+const CreationTimeSupported = false
+
+// SetCreationTime can't set a creation time here: Linux filesystems that
+// record one (statx btime) offer no way to change it
+func SetCreationTime(path string, t time.Time) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package fsutil
+
+import "os"
+
+// PermissionBitsSupported reports whether SetMode applies every permission
+// bit, rather than only the owner-write bit
+const PermissionBitsSupported = true
+
+// SetMode sets a file's permission bits and returns the mode it ends up
+// with
+func SetMode(path string, mode os.FileMode) (os.FileMode, error) {
+	if err := os.Chmod(path, mode); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Mode().Perm(), nil
+}
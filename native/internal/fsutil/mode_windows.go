@@ -0,0 +1,23 @@
+//go:build windows
+
+package fsutil
+
+import "os"
+
+// PermissionBitsSupported reports whether SetMode applies every permission
+// bit, rather than only the owner-write bit
+const PermissionBitsSupported = false
+
+// SetMode maps mode onto Windows's one permission: a mode without the
+// owner-write bit sets the read-only attribute, any other clears it. The
+// mode the file ends up with is returned.
+func SetMode(path string, mode os.FileMode) (os.FileMode, error) {
+	if err := os.Chmod(path, mode); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Mode().Perm(), nil
+}
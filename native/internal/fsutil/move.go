@@ -0,0 +1,128 @@
+package fsutil
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyChunk is the buffer size for cross-device copies
+const copyChunk = 1024 * 1024
+
+// ProgressFunc receives the bytes copied so far and the total
+type ProgressFunc func(copied, total int64)
+
+// Move renames src to dst. When they are on different filesystems (e.g. a
+// network share), it falls back to a cancelable copy into a temp file next
+// to dst, which is then renamed into place, and removes src afterwards.
+// onProgress is only called for the copy fallback.
+func Move(ctx context.Context, src, dst string, onProgress ProgressFunc) error {
+	return MoveWith(ctx, src, dst, MoveOptions{OnProgress: onProgress})
+}
+
+// MoveOptions tune the copy fallback of MoveWith
+type MoveOptions struct {
+	OnProgress ProgressFunc
+
+	// Preallocate reserves the whole file at the destination before the
+	// copy, and OnPreallocate learns how that went
+	Preallocate   bool
+	OnPreallocate func(PreallocateResult)
+}
+
+// MoveWith is Move with options for the copy fallback
+func MoveWith(ctx context.Context, src, dst string, opts MoveOptions) error {
+	err := os.Rename(src, dst)
+	if err == nil || !IsCrossDevice(err) {
+		return err
+	}
+
+	if err := copyFile(ctx, src, dst, opts); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(ctx context.Context, src, dst string, opts MoveOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	total := stat.Size()
+
+	// Unique so two moves to the same destination can't share a temp file
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.moving")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	if err := out.Chmod(stat.Mode().Perm()); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	fail := func(err error) error {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if opts.Preallocate {
+		// A share too full for the file fails now, not most of the way in
+		err := Preallocate(out, total)
+		if opts.OnPreallocate != nil {
+			opts.OnPreallocate(NewPreallocateResult(total, err))
+		}
+		if IsNoSpace(err) {
+			return fail(err)
+		}
+	}
+
+	var copied int64
+	buf := make([]byte, copyChunk)
+	for {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+
+		n, rerr := in.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				return fail(werr)
+			}
+			copied += int64(n)
+			if opts.OnProgress != nil {
+				opts.OnProgress(copied, total)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return fail(rerr)
+		}
+	}
+
+	// Make sure the data reached the share before the source is deleted
+	if err := out.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
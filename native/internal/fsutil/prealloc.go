@@ -0,0 +1,24 @@
+package fsutil
+
+import "errors"
+
+// ErrPreallocateUnsupported is returned by Preallocate where the platform
+// or the filesystem can't reserve space ahead of the writes
+var ErrPreallocateUnsupported = errors.New("preallocation not supported")
+
+// PreallocateResult is how reserving a file's space went, for the done
+// event: Applied, or the Reason it wasn't
+type PreallocateResult struct {
+	Bytes   int64  `json:"bytes"`
+	Applied bool   `json:"applied"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// NewPreallocateResult describes the outcome err of preallocating bytes
+func NewPreallocateResult(bytes int64, err error) PreallocateResult {
+	r := PreallocateResult{Bytes: bytes, Applied: err == nil}
+	if err != nil {
+		r.Reason = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: the blocks are reserved but the
+// file's length stays, so sequential writes and appends work as before
+const fallocKeepSize = 0x01
+
+// Preallocate reserves size bytes of disk for f in one extent-friendly
+// request, so a big download isn't scattered across the disk, and fails
+// with a disk-full error at once when it won't fit
+func Preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return ErrPreallocateUnsupported
+	}
+	return err
+}
+
+// IsNoSpace reports whether a write or preallocation failed because the
+// disk is full
+func IsNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !windows
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Preallocate is not implemented here; files grow as they're written
+func Preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return ErrPreallocateUnsupported
+}
+
+// IsNoSpace reports whether a write failed because the disk is full
+func IsNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
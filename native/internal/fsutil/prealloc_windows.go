@@ -0,0 +1,50 @@
+//go:build windows
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procSetFileInformationByHandle = syscall.NewLazyDLL("kernel32.dll").NewProc("SetFileInformationByHandle")
+
+// fileAllocationInfo is the FileAllocationInfo information class
+const fileAllocationInfo = 5
+
+const (
+	errorHandleDiskFull = syscall.Errno(39)  // ERROR_HANDLE_DISK_FULL
+	errorNotSupported   = syscall.Errno(50)  // ERROR_NOT_SUPPORTED
+	errorDiskFull       = syscall.Errno(112) // ERROR_DISK_FULL
+)
+
+// Preallocate reserves size bytes of disk for f by setting its allocation
+// size, so a big download isn't scattered across the disk, and fails with
+// a disk-full error at once when it won't fit. The end of file stays put.
+func Preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	if err := procSetFileInformationByHandle.Find(); err != nil {
+		return ErrPreallocateUnsupported
+	}
+
+	info := struct{ AllocationSize int64 }{size}
+	ok, _, err := procSetFileInformationByHandle.Call(f.Fd(), fileAllocationInfo,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if ok != 0 {
+		return nil
+	}
+	if errors.Is(err, errorNotSupported) {
+		return ErrPreallocateUnsupported
+	}
+	return err
+}
+
+// IsNoSpace reports whether a write or preallocation failed because the
+// disk is full
+func IsNoSpace(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
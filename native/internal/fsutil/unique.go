@@ -0,0 +1,34 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxUniqueTries bounds the "name (n).ext" search
+const maxUniqueTries = 1000
+
+// ReserveUnique finds a name based on path that doesn't exist yet, trying
+// "name (1).ext", "name (2).ext", ... and creates it empty so a concurrent
+// job can't pick the same name before the caller renames over it.
+func ReserveUnique(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	candidate := path
+	for n := 1; n <= maxUniqueTries; n++ {
+		f, err := os.OpenFile(candidate, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	return "", fmt.Errorf("no free name for %s after %d tries", path, maxUniqueTries)
+}
@@ -0,0 +1,16 @@
+package fsutil
+
+import "os"
+
+// CheckWritable verifies files can be created in dir by creating and
+// removing a hidden probe file. It returns the underlying error unchanged
+// so callers can tell permission problems from missing directories.
+func CheckWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".vidown-probe-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
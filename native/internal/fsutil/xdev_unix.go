@@ -0,0 +1,14 @@
+//go:build !windows
+
+package fsutil
+
+import (
+	"errors"
+	"syscall"
+)
+
+// IsCrossDevice reports whether a rename failed because source and
+// destination are on different filesystems
+func IsCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
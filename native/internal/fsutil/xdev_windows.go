@@ -0,0 +1,17 @@
+//go:build windows
+
+package fsutil
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE
+const errorNotSameDevice = syscall.Errno(17)
+
+// IsCrossDevice reports whether a rename failed because source and
+// destination are on different volumes
+func IsCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
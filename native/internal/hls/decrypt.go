@@ -0,0 +1,46 @@
+package hls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// IV returns the initialization vector for the segment: the key's IV when
+// the playlist gives one, else the media sequence number as a 128-bit
+// big-endian integer (RFC 8216, section 5.2)
+func (s Segment) IV() []byte {
+	if s.Key != nil && s.Key.IV != nil {
+		return s.Key.IV
+	}
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], uint64(s.Sequence))
+	return iv
+}
+
+// Decrypt decrypts a whole AES-128 segment (CBC with PKCS#7 padding).
+// SAMPLE-AES encrypts inside the media format and can't be undone here.
+func Decrypt(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("bad key: %w", err)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment is %d bytes, not a whole number of blocks", len(data))
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+		return nil, fmt.Errorf("bad padding, wrong key?")
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, fmt.Errorf("bad padding, wrong key?")
+		}
+	}
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,61 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VariantFormatIDs returns the format ID of each variant, in order: "hls-"
+// and the bandwidth in kbps, yt-dlp style, with "-2", "-3"... on variants
+// that would otherwise share one
+func (m *Master) VariantFormatIDs() []string {
+	ids := make([]string, len(m.Variants))
+	seen := make(map[string]int)
+	for i, v := range m.Variants {
+		id := fmt.Sprintf("hls-%d", (v.Bandwidth+500)/1000)
+		if seen[id]++; seen[id] > 1 {
+			id = fmt.Sprintf("%s-%d", id, seen[id])
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// FormatID returns the format ID of an audio rendition, from its group and
+// name: "hls-audio-<group>-<name>"
+func (r Rendition) FormatID() string {
+	return "hls-audio-" + formatIDPart(r.GroupID) + "-" + formatIDPart(r.Name)
+}
+
+// FormatVariant returns the variant with format ID id
+func (m *Master) FormatVariant(id string) (*Variant, bool) {
+	for i, vid := range m.VariantFormatIDs() {
+		if vid == id {
+			v := m.Variants[i]
+			return &v, true
+		}
+	}
+	return nil, false
+}
+
+// FormatAudio returns the audio rendition with format ID id; only
+// renditions with their own URI have one
+func (m *Master) FormatAudio(id string) (*Rendition, bool) {
+	for _, r := range m.AudioRenditions() {
+		if r.URI != "" && r.FormatID() == id {
+			return &r, true
+		}
+	}
+	return nil, false
+}
+
+// formatIDPart keeps a playlist attribute usable inside a format ID
+func formatIDPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,37 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+)
+
+// ErrNotMaster is returned by LoadMaster for a media playlist
+var ErrNotMaster = errors.New("not a master playlist")
+
+// LoadMaster fetches and parses a master playlist
+func LoadMaster(ctx context.Context, url string, headers map[string]string) (*Master, error) {
+	data, base, err := fetch.Get(ctx, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	if !IsMaster(data) {
+		return nil, ErrNotMaster
+	}
+	return ParseMaster(bytes.NewReader(data), base)
+}
+
+// LoadMedia fetches and parses a media playlist
+func LoadMedia(ctx context.Context, url string, headers map[string]string) (*MediaPlaylist, error) {
+	data, base, err := fetch.Get(ctx, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	if IsMaster(data) {
+		return nil, fmt.Errorf("expected a media playlist, got a master playlist")
+	}
+	return ParseMedia(bytes.NewReader(data), base)
+}
@@ -0,0 +1,250 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Variant is an EXT-X-STREAM-INF entry of a master playlist
+type Variant struct {
+	URI              string  `json:"uri"`
+	Bandwidth        int64   `json:"bandwidth"`
+	AverageBandwidth int64   `json:"averageBandwidth,omitempty"`
+	Width            int     `json:"width,omitempty"`
+	Height           int     `json:"height,omitempty"`
+	Codecs           string  `json:"codecs,omitempty"`
+	FrameRate        float64 `json:"frameRate,omitempty"`
+	AudioGroup       string  `json:"audioGroup,omitempty"`
+}
+
+// Rendition is an EXT-X-MEDIA entry (alternate audio, subtitles, ...)
+type Rendition struct {
+	Type       string `json:"type"`
+	GroupID    string `json:"groupId"`
+	Name       string `json:"name"`
+	Language   string `json:"language,omitempty"`
+	URI        string `json:"uri,omitempty"`
+	Channels   string `json:"channels,omitempty"`
+	Default    bool   `json:"default"`
+	Autoselect bool   `json:"autoselect"`
+}
+
+// Master is a parsed master playlist
+type Master struct {
+	Variants   []Variant
+	Renditions []Rendition
+}
+
+// IsMaster reports whether playlist content is a master (vs media) playlist
+func IsMaster(data []byte) bool {
+	return strings.Contains(string(data), "#EXT-X-STREAM-INF")
+}
+
+// ParseMaster parses a master playlist, resolving URIs against base.
+// Variants are returned highest bandwidth first.
+func ParseMaster(r io.Reader, base *url.URL) (*Master, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	m := &Master{}
+	var pending *Variant
+	sawHeader := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case line == "#EXTM3U":
+			sawHeader = true
+
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := ParseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			v := Variant{
+				Codecs:     attrs["CODECS"],
+				AudioGroup: attrs["AUDIO"],
+			}
+			v.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			v.AverageBandwidth, _ = strconv.ParseInt(attrs["AVERAGE-BANDWIDTH"], 10, 64)
+			v.FrameRate, _ = strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+			if res := attrs["RESOLUTION"]; res != "" {
+				w, h, _ := strings.Cut(res, "x")
+				v.Width, _ = strconv.Atoi(w)
+				v.Height, _ = strconv.Atoi(h)
+			}
+			pending = &v
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			attrs := ParseAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+			r := Rendition{
+				Type:       attrs["TYPE"],
+				GroupID:    attrs["GROUP-ID"],
+				Name:       attrs["NAME"],
+				Language:   attrs["LANGUAGE"],
+				Channels:   attrs["CHANNELS"],
+				Default:    attrs["DEFAULT"] == "YES",
+				Autoselect: attrs["AUTOSELECT"] == "YES",
+			}
+			if uri := attrs["URI"]; uri != "" {
+				r.URI = resolve(base, uri)
+			}
+			m.Renditions = append(m.Renditions, r)
+
+		case strings.HasPrefix(line, "#"):
+			// Other tags don't matter for variant selection
+
+		default:
+			if pending != nil {
+				pending.URI = resolve(base, line)
+				m.Variants = append(m.Variants, *pending)
+				pending = nil
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !sawHeader {
+		return nil, fmt.Errorf("not an m3u8 playlist")
+	}
+
+	sort.SliceStable(m.Variants, func(i, k int) bool {
+		return m.Variants[i].Bandwidth > m.Variants[k].Bandwidth
+	})
+
+	return m, nil
+}
+
+// AudioRenditions returns the alternate audio renditions
+func (m *Master) AudioRenditions() []Rendition {
+	var out []Rendition
+	for _, r := range m.Renditions {
+		if r.Type == "AUDIO" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// AudioSelector picks an audio rendition; empty fields match anything
+type AudioSelector struct {
+	GroupID  string
+	Name     string
+	Language string
+}
+
+// SelectAudio returns the first audio rendition with a URI that matches sel
+func (m *Master) SelectAudio(sel AudioSelector) (*Rendition, error) {
+	for _, r := range m.AudioRenditions() {
+		if r.URI == "" {
+			// Muxed into the variant streams, nothing to fetch separately
+			continue
+		}
+		if sel.GroupID != "" && r.GroupID != sel.GroupID {
+			continue
+		}
+		if sel.Name != "" && r.Name != sel.Name {
+			continue
+		}
+		if sel.Language != "" && !strings.EqualFold(r.Language, sel.Language) {
+			continue
+		}
+		r := r
+		return &r, nil
+	}
+	return nil, fmt.Errorf("no audio rendition matches group=%q name=%q language=%q", sel.GroupID, sel.Name, sel.Language)
+}
+
+// BestVariant returns the highest bandwidth variant, restricted to those
+// referencing audioGroup when it is non-empty
+func (m *Master) BestVariant(audioGroup string) (*Variant, error) {
+	for _, v := range m.Variants {
+		if audioGroup == "" || v.AudioGroup == audioGroup {
+			v := v
+			return &v, nil
+		}
+	}
+	if audioGroup != "" {
+		return nil, fmt.Errorf("no variant uses audio group %q", audioGroup)
+	}
+	return nil, fmt.Errorf("playlist has no variants")
+}
+
+// VariantSelector picks a variant; zero fields match anything
+type VariantSelector struct {
+	URI       string
+	Bandwidth int64
+	Height    int
+}
+
+// SelectVariant returns the first (highest bandwidth) variant matching sel,
+// restricted to those referencing audioGroup when it is non-empty
+func (m *Master) SelectVariant(sel VariantSelector, audioGroup string) (*Variant, error) {
+	for _, v := range m.Variants {
+		if audioGroup != "" && v.AudioGroup != audioGroup {
+			continue
+		}
+		if sel.URI != "" && v.URI != sel.URI {
+			continue
+		}
+		if sel.Bandwidth != 0 && v.Bandwidth != sel.Bandwidth {
+			continue
+		}
+		if sel.Height != 0 && v.Height != sel.Height {
+			continue
+		}
+		v := v
+		return &v, nil
+	}
+	return nil, fmt.Errorf("no variant matches uri=%q bandwidth=%d height=%d", sel.URI, sel.Bandwidth, sel.Height)
+}
+
+// ParseAttributes parses an HLS attribute list (KEY=value,KEY="quoted,value")
+func ParseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.IndexByte(s, ','); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+
+		attrs[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
+
+func resolve(base *url.URL, ref string) string {
+	if base == nil {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
@@ -0,0 +1,213 @@
+package hls
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+)
+
+// Segment is one media segment of a media playlist
+type Segment struct {
+	URI      string
+	Duration float64
+	Sequence int64 // media sequence number
+	Key      *Key  // encryption in effect for the segment, nil if clear
+
+	// Range is the segment's slice of URI (EXT-X-BYTERANGE), nil if the
+	// segment is the whole resource
+	Range *fetch.Range
+}
+
+// Key is an EXT-X-KEY a segment is encrypted with
+type Key struct {
+	Method string // "AES-128" or "SAMPLE-AES"
+	URI    string
+	IV     []byte // 16 bytes, nil to derive it from the sequence number
+}
+
+// MediaPlaylist is a parsed media (segment) playlist
+type MediaPlaylist struct {
+	// Init is the EXT-X-MAP URI of fragmented MP4 (CMAF) streams. Segments
+	// aren't playable without it, so it has to be written first.
+	Init      string
+	InitRange *fetch.Range // BYTERANGE of the EXT-X-MAP, nil if none
+	Segments  []Segment
+	Encrypted bool // an EXT-X-KEY with a method other than NONE
+	Ended     bool // EXT-X-ENDLIST seen, i.e. not a live playlist
+}
+
+// Fragmented reports whether the playlist carries fMP4 rather than TS
+func (p *MediaPlaylist) Fragmented() bool {
+	return p.Init != ""
+}
+
+// Duration returns the playlist's total length in seconds
+func (p *MediaPlaylist) Duration() float64 {
+	var d float64
+	for _, s := range p.Segments {
+		d += s.Duration
+	}
+	return d
+}
+
+// Container returns the container the concatenated segments form
+func (p *MediaPlaylist) Container() string {
+	if p.Fragmented() {
+		return "mp4"
+	}
+	return "ts"
+}
+
+// ParseMedia parses a media playlist, resolving URIs against base
+func ParseMedia(r io.Reader, base *url.URL) (*MediaPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	p := &MediaPlaylist{}
+	var duration float64
+	var sequence int64
+	var key *Key
+	var byteRange *fetch.Range
+	var lastURI string // for byte ranges continuing the previous segment's
+	var lastEnd int64
+	sawHeader := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case line == "#EXTM3U":
+			sawHeader = true
+
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := ParseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			if p.Init == "" && attrs["URI"] != "" {
+				p.Init = resolve(base, attrs["URI"])
+				if attrs["BYTERANGE"] != "" {
+					r, err := parseByteRange(attrs["BYTERANGE"], 0)
+					if err != nil {
+						return nil, err
+					}
+					p.InitRange = r
+				}
+			}
+
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			r, err := parseByteRange(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"), -1)
+			if err != nil {
+				return nil, err
+			}
+			byteRange = r
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := ParseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			key = nil
+			if attrs["METHOD"] != "" && attrs["METHOD"] != "NONE" {
+				p.Encrypted = true
+				key = &Key{Method: attrs["METHOD"], URI: resolve(base, attrs["URI"]), IV: parseIV(attrs["IV"])}
+			}
+
+		case line == "#EXT-X-ENDLIST":
+			p.Ended = true
+
+		case strings.HasPrefix(line, "#"):
+			// Other tags don't matter for fetching segments
+
+		default:
+			seg := Segment{URI: resolve(base, line), Duration: duration, Sequence: sequence, Key: key, Range: byteRange}
+			if seg.Range != nil {
+				// Without an offset the range follows the previous
+				// segment's range of the same resource, else starts it
+				if seg.Range.Offset < 0 {
+					seg.Range.Offset = 0
+					if lastURI == seg.URI {
+						seg.Range.Offset = lastEnd
+					}
+				}
+				lastURI, lastEnd = seg.URI, seg.Range.Offset+seg.Range.Length
+			} else {
+				lastURI = ""
+			}
+			p.Segments = append(p.Segments, seg)
+			duration = 0
+			byteRange = nil
+			sequence++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !sawHeader {
+		return nil, fmt.Errorf("not an m3u8 playlist")
+	}
+	if len(p.Segments) == 0 {
+		return nil, fmt.Errorf("playlist has no segments")
+	}
+
+	return p, nil
+}
+
+// parseByteRange parses a "<length>[@<offset>]" byte range. A missing
+// offset is returned as def.
+func parseByteRange(s string, def int64) (*fetch.Range, error) {
+	length, offset, hasOffset := strings.Cut(strings.Trim(s, `"`), "@")
+	r := &fetch.Range{Offset: def}
+	var err error
+	if r.Length, err = strconv.ParseInt(length, 10, 64); err != nil || r.Length <= 0 {
+		return nil, fmt.Errorf("bad byte range %q", s)
+	}
+	if hasOffset {
+		if r.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil || r.Offset < 0 {
+			return nil, fmt.Errorf("bad byte range %q", s)
+		}
+	}
+	return r, nil
+}
+
+// parseIV decodes an IV attribute ("0x" and 32 hex digits), nil if absent
+// or malformed
+func parseIV(s string) []byte {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	iv, err := hex.DecodeString(s)
+	if err != nil || len(iv) != 16 {
+		return nil
+	}
+	return iv
+}
+
+// DefaultAudio returns the audio rendition a player would pick for a variant
+// when it has to be fetched separately: the group's default, else its first
+// entry with a URI. It returns nil when the audio is muxed into the variant.
+func (m *Master) DefaultAudio(group string) *Rendition {
+	var first *Rendition
+	for _, r := range m.AudioRenditions() {
+		if r.GroupID != group || r.URI == "" {
+			continue
+		}
+		r := r
+		if r.Default {
+			return &r
+		}
+		if first == nil {
+			first = &r
+		}
+	}
+	return first
+}
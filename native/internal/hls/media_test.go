@@ -0,0 +1,149 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+)
+
+// byteRangeFile is the single media file testdata/byterange.m3u8 slices
+func byteRangeFile() []byte {
+	data := make([]byte, 700)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+// byteRangeWant is the init segment and the media segments of
+// testdata/byterange.m3u8, as offset and length, in playlist order
+var byteRangeWant = [][2]int64{{0, 100}, {100, 200}, {300, 150}, {600, 50}, {450, 100}, {550, 50}}
+
+func parseByteRangeFixture(t *testing.T, base string) *MediaPlaylist {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "byterange.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseMedia(f, u)
+	if err != nil {
+		t.Fatalf("ParseMedia: %v", err)
+	}
+	return p
+}
+
+func TestParseMediaByteRange(t *testing.T) {
+	p := parseByteRangeFixture(t, "https://cdn.example/v/index.m3u8")
+
+	if p.Init != "https://cdn.example/v/main.mp4" {
+		t.Errorf("Init = %q", p.Init)
+	}
+	if p.InitRange == nil || p.InitRange.Offset != 0 || p.InitRange.Length != 100 {
+		t.Errorf("InitRange = %+v, want 100@0", p.InitRange)
+	}
+	if !p.Ended {
+		t.Error("Ended = false, want true")
+	}
+	if len(p.Segments) != len(byteRangeWant)-1 {
+		t.Fatalf("got %d segments, want %d", len(p.Segments), len(byteRangeWant)-1)
+	}
+	for i, s := range p.Segments {
+		want := byteRangeWant[i+1]
+		if s.URI != "https://cdn.example/v/main.mp4" {
+			t.Errorf("segment %d URI = %q", i, s.URI)
+		}
+		if s.Range == nil || s.Range.Offset != want[0] || s.Range.Length != want[1] {
+			t.Errorf("segment %d Range = %+v, want %d@%d", i, s.Range, want[1], want[0])
+		}
+		if s.Sequence != int64(5+i) {
+			t.Errorf("segment %d Sequence = %d, want %d", i, s.Sequence, 5+i)
+		}
+	}
+}
+
+// TestByteRangeDownload fetches the fixture's slices of one file, from a
+// server honoring Range and from one ignoring it, and checks they come out
+// in playlist order
+func TestByteRangeDownload(t *testing.T) {
+	data := byteRangeFile()
+	var want []byte
+	for _, r := range byteRangeWant {
+		want = append(want, data[r[0]:r[0]+r[1]]...)
+	}
+
+	servers := map[string]http.HandlerFunc{
+		"honors Range": func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "main.mp4", time.Time{}, bytes.NewReader(data))
+		},
+		"ignores Range": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		},
+	}
+
+	for name, handler := range servers {
+		for _, concurrency := range []int{1, 3} {
+			t.Run(name+", concurrency "+strconv.Itoa(concurrency), func(t *testing.T) {
+				var ranged, plain atomic.Int32
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if !strings.HasSuffix(r.URL.Path, "/main.mp4") {
+						http.NotFound(w, r)
+						return
+					}
+					if r.Header.Get("Range") != "" {
+						ranged.Add(1)
+					} else {
+						plain.Add(1)
+					}
+					handler(w, r)
+				}))
+				defer srv.Close()
+
+				p := parseByteRangeFixture(t, srv.URL+"/v/index.m3u8")
+				var uris []string
+				var ranges []*fetch.Range
+				for _, s := range p.Segments {
+					uris = append(uris, s.URI)
+					ranges = append(ranges, s.Range)
+				}
+
+				out := filepath.Join(t.TempDir(), "out.mp4")
+				err := fetch.DownloadSegments(context.Background(), p.Init, uris, out, fetch.SegmentOptions{
+					InitRange:   p.InitRange,
+					Ranges:      ranges,
+					Concurrency: concurrency,
+				})
+				if err != nil {
+					t.Fatalf("DownloadSegments: %v", err)
+				}
+
+				got, err := os.ReadFile(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("output is %d bytes and differs from the slices in playlist order (%d bytes)", len(got), len(want))
+				}
+				if plain.Load() != 0 || int(ranged.Load()) != len(byteRangeWant) {
+					t.Errorf("%d ranged and %d plain requests, want %d ranged", ranged.Load(), plain.Load(), len(byteRangeWant))
+				}
+			})
+		}
+	}
+}
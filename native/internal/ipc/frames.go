@@ -0,0 +1,77 @@
+package ipc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrFrameTimeout is returned when a message's first bytes arrived but the
+// rest of the frame didn't within the deadline. The stream can't be resynced
+// after this: the frame boundary is lost.
+var ErrFrameTimeout = errors.New("timed out reading message frame")
+
+type frameResult struct {
+	msg Msg
+	err error
+}
+
+// FrameReader reads messages on a background goroutine so that a frame the
+// sender abandoned halfway can time out instead of blocking forever. Waiting
+// for the next message to start is never timed.
+type FrameReader struct {
+	started chan struct{}
+	frames  chan frameResult
+}
+
+// NewFrameReader starts reading length-prefixed messages from r
+func NewFrameReader(r io.Reader) *FrameReader {
+	fr := &FrameReader{
+		started: make(chan struct{}),
+		frames:  make(chan frameResult),
+	}
+	go fr.loop(bufio.NewReader(r))
+	return fr
+}
+
+func (fr *FrameReader) loop(r *bufio.Reader) {
+	for {
+		// Block until a frame begins, then start the clock on the rest
+		if _, err := r.Peek(1); err == nil {
+			fr.started <- struct{}{}
+		}
+
+		msg, err := ReadMsg(r)
+		fr.frames <- frameResult{msg, err}
+		if err != nil && err != ErrEmptyFrame {
+			return
+		}
+	}
+}
+
+// Read returns the next message. Once a frame has started, the rest of it
+// must arrive within timeout (0 = no deadline) or ErrFrameTimeout is
+// returned; the reader must not be used after that.
+func (fr *FrameReader) Read(timeout time.Duration) (Msg, error) {
+	select {
+	case <-fr.started:
+	case res := <-fr.frames:
+		return res.msg, res.err
+	}
+
+	if timeout <= 0 {
+		res := <-fr.frames
+		return res.msg, res.err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-fr.frames:
+		return res.msg, res.err
+	case <-timer.C:
+		return nil, ErrFrameTimeout
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"os"
 	"sync"
 )
@@ -12,40 +15,145 @@ import (
 // Msg is a generic JSON message
 type Msg map[string]interface{}
 
-var sendMu sync.Mutex
+// Output state, guarded by sendMu. One message is written at a time;
+// writing marks the turn taken, and urgent counts priority messages
+// waiting for it, which normal ones let go first.
+var (
+	sendMu    sync.Mutex
+	sendTurn  = sync.NewCond(&sendMu)
+	writing   bool
+	urgent    int
+	detached  bool
+	quiescent bool
+)
 
-// Send writes a length-prefixed JSON message to stdout
-func Send(m Msg) error {
+// broken is closed by the first failed write to stdout, see Broken
+var broken = make(chan struct{})
+
+// stdout is where messages are written, the browser's end of the port
+var stdout io.Writer = os.Stdout
+
+// Detach stops all further output: Send becomes a no-op. Used once the
+// extension's port is gone so writes can't hit a closed pipe.
+func Detach() {
 	sendMu.Lock()
 	defer sendMu.Unlock()
+	detached = true
+}
 
+// Quiesce drops everything but priority messages from now on, for a
+// shutdown that still owes the extension its jobs' terminal events.
+// Detach ends output entirely once they are out.
+func Quiesce() {
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	quiescent = true
+}
+
+// Send writes a length-prefixed JSON message to stdout
+func Send(m Msg) error {
+	return send(m, false)
+}
+
+// SendPriority writes a message ahead of any normal ones still waiting
+// their turn, for the events that end a job (done, error, canceled): a
+// slow reader then gets those before the progress queued up behind the
+// message being written. They still go out after Quiesce.
+func SendPriority(m Msg) error {
+	return send(m, true)
+}
+
+func send(m Msg, priority bool) error {
 	b, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
+	// 4-byte little-endian length prefix, written with the payload so a
+	// failed write can't leave half a frame behind a whole one
+	frame := make([]byte, 4+len(b))
+	binary.LittleEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
 
-	// 4-byte little-endian length prefix
-	length := uint32(len(b))
-	if err := binary.Write(os.Stdout, binary.LittleEndian, length); err != nil {
-		return err
+	sendMu.Lock()
+	if priority {
+		urgent++
+	}
+	for writing || (!priority && urgent > 0) {
+		sendTurn.Wait()
+	}
+	if priority {
+		urgent--
+	}
+	if detached || (quiescent && !priority) {
+		sendMu.Unlock()
+		return nil
+	}
+	writing = true
+	sendMu.Unlock()
+
+	_, err = stdout.Write(frame)
+
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	writing = false
+	sendTurn.Broadcast()
+	if err != nil {
+		return outputFailed(err)
 	}
+	return nil
+}
 
-	// JSON payload
-	_, err = os.Stdout.Write(b)
+// outputFailed handles a failed write to stdout; sendMu must be held. The
+// browser closing the port shows up here as EPIPE, and a frame cut short
+// leaves the stream out of step anyway, so output stops for good and
+// Broken fires. Call sites don't check Send's error, this is where it's
+// acted on.
+func outputFailed(err error) error {
+	if detached {
+		return err
+	}
+	log.Printf("[IPC] Writing to stdout failed, output stopped: %v", err)
+	detached = true
+	close(broken)
 	return err
 }
 
-// ReadMsg reads a length-prefixed JSON message from reader
+// Broken is closed once writing to stdout has failed, i.e. nobody is
+// reading the host's messages anymore
+func Broken() <-chan struct{} {
+	return broken
+}
+
+// ErrTruncatedFrame is returned when stdin ends partway through a message,
+// its length prefix or its payload. Unlike a clean io.EOF between messages
+// this means the sender went away mid-write, most likely a crash.
+var ErrTruncatedFrame = errors.New("stdin ended mid-message")
+
+// ErrEmptyFrame is returned for a message with a zero length prefix. The
+// stream is still in step, so reading can go on.
+var ErrEmptyFrame = errors.New("zero-length message")
+
+// ReadMsg reads a length-prefixed JSON message from reader. It returns
+// io.EOF when the input ends cleanly between messages.
 func ReadMsg(r *bufio.Reader) (Msg, error) {
 	// Read 4-byte length prefix
 	var length uint32
 	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: partial length prefix", ErrTruncatedFrame)
+		}
 		return nil, err
 	}
+	if length == 0 {
+		return nil, ErrEmptyFrame
+	}
 
 	// Read JSON payload
 	buf := make([]byte, length)
-	if _, err := io.ReadFull(r, buf); err != nil {
+	if n, err := io.ReadFull(r, buf); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, fmt.Errorf("%w: got %d of %d bytes", ErrTruncatedFrame, n, length)
+		}
 		return nil, err
 	}
 
@@ -82,6 +190,29 @@ func GetInt64(m Msg, key string) int64 {
 	return 0
 }
 
+func GetFloat64(m Msg, key string) float64 {
+	if v, ok := m[key]; ok {
+		switch n := v.(type) {
+		case float64:
+			return n
+		case int64:
+			return float64(n)
+		case int:
+			return float64(n)
+		}
+	}
+	return 0
+}
+
+func GetBool(m Msg, key string) bool {
+	if v, ok := m[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return false
+}
+
 func GetMap(m Msg, key string) map[string]interface{} {
 	if v, ok := m[key]; ok {
 		if mm, ok := v.(map[string]interface{}); ok {
@@ -91,6 +222,18 @@ func GetMap(m Msg, key string) map[string]interface{} {
 	return make(map[string]interface{})
 }
 
+func GetStrings(m Msg, key string) []string {
+	var result []string
+	if list, ok := m[key].([]interface{}); ok {
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
 func GetStringMap(m map[string]interface{}) map[string]string {
 	result := make(map[string]string)
 	for k, v := range m {
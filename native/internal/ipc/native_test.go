@@ -0,0 +1,93 @@
+package ipc
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+// countingWriter counts the writes reaching w
+type countingWriter struct {
+	mu sync.Mutex
+	n  int
+	w  io.Writer
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+	return c.w.Write(p)
+}
+
+func (c *countingWriter) writes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// withStdout points output at w with fresh output state for the test
+func withStdout(t *testing.T, w io.Writer) {
+	t.Helper()
+	savedOut, savedBroken := stdout, broken
+	stdout, broken = w, make(chan struct{})
+	detached, quiescent, writing, urgent = false, false, false, 0
+	t.Cleanup(func() {
+		stdout, broken = savedOut, savedBroken
+		detached, quiescent, writing, urgent = false, false, false, 0
+	})
+}
+
+func TestSendClosedPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	defer w.Close()
+	out := &countingWriter{w: w}
+	withStdout(t, out)
+
+	// Several senders race into the closed pipe; a second close of broken
+	// would panic
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				errs[i] = Send(Msg{"type": "progress", "n": i})
+			} else {
+				errs[i] = SendPriority(Msg{"type": "done", "n": i})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case <-Broken():
+	default:
+		t.Fatal("Broken not closed after writing to a closed pipe")
+	}
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 1 || out.writes() != 1 {
+		t.Errorf("%d sends failed after %d writes, want the first write to fail and the rest dropped", failed, out.writes())
+	}
+
+	// Output stays stopped
+	for _, send := range []func(Msg) error{Send, SendPriority} {
+		if err := send(Msg{"type": "progress"}); err != nil {
+			t.Errorf("send after Broken = %v, want it dropped", err)
+		}
+	}
+	if out.writes() != 1 {
+		t.Errorf("%d writes after Broken, want none", out.writes()-1)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package ipc
+
+import (
+	"os/signal"
+	"syscall"
+)
+
+// IgnoreSIGPIPE keeps a write to the closed stdout of a gone browser from
+// killing the process: Go otherwise exits on SIGPIPE for fds 1 and 2. The
+// write fails with EPIPE instead, which Send reports through Broken.
+func IgnoreSIGPIPE() {
+	signal.Ignore(syscall.SIGPIPE)
+}
@@ -0,0 +1,5 @@
+package ipc
+
+// IgnoreSIGPIPE does nothing: Windows has no SIGPIPE, a write to a closed
+// pipe just fails
+func IgnoreSIGPIPE() {}
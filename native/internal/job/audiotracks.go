@@ -0,0 +1,109 @@
+package job
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// MaxAudioTracks bounds the AudioTracks of one download
+const MaxAudioTracks = 16
+
+// ParseAudioTracks parses the audioTracks option: [{url, language, title?,
+// default?}, ...], one soft audio track each, in output order
+func ParseAudioTracks(v interface{}) []ff.AudioTrack {
+	list, _ := v.([]interface{})
+	var tracks []ff.AudioTrack
+	for _, entry := range list {
+		m, ok := entry.(map[string]interface{})
+		if !ok {
+			// Kept so validation can say which entry is wrong
+			tracks = append(tracks, ff.AudioTrack{})
+			continue
+		}
+		tracks = append(tracks, ff.AudioTrack{
+			URL:      ipc.GetString(m, "url"),
+			Language: strings.TrimSpace(ipc.GetString(m, "language")),
+			Title:    ipc.GetString(m, "title"),
+			Default:  ipc.GetBool(m, "default"),
+		})
+	}
+	return tracks
+}
+
+// validateAudioTracks checks the AudioTracks option, which ffmpeg muxes
+// alongside the video of an HLS or HTTP source
+func (job *Job) validateAudioTracks() error {
+	if len(job.AudioTracks) == 0 {
+		return nil
+	}
+	switch {
+	case job.Mode != "hls" && job.Mode != "http":
+		return fmt.Errorf("audioTracks isn't supported in %s mode", job.Mode)
+	case job.Engine == "go":
+		return fmt.Errorf("audioTracks needs the ffmpeg engine")
+	case job.Audio != nil:
+		return fmt.Errorf("audioTracks and audioRendition can't be combined")
+	case len(job.AudioTracks) > MaxAudioTracks:
+		return fmt.Errorf("at most %d audioTracks, got %d", MaxAudioTracks, len(job.AudioTracks))
+	}
+	for i, t := range job.AudioTracks {
+		if t.URL == "" {
+			return fmt.Errorf("audioTracks entry %d has no url", i+1)
+		}
+	}
+	return nil
+}
+
+// audioTracksContainer checks that container can hold every audio track's
+// codec as is, and switches to mkv, which holds them all, when one can't.
+// A probe that fails leaves the track to ffmpeg.
+func (job *Job) audioTracksContainer(container, reason string) (string, string) {
+	if container == "mkv" {
+		return container, reason
+	}
+	for i, t := range job.AudioTracks {
+		probe, ok := ff.CachedProbe(t.URL, job.Headers)
+		if !ok {
+			var err error
+			if probe, err = ff.ProbeURLWithOptions(t.URL, job.Headers, job.probeOptions()); err != nil {
+				log.Printf("[JOB %s] Probe of audio track %d failed: %v", job.ID, i+1, err)
+				continue
+			}
+		}
+		_, acodec := ff.StreamCodecs(probe.Streams)
+		if acodec == "" || ff.Compatible(container, "", acodec) {
+			continue
+		}
+
+		msg := fmt.Sprintf("%s can't hold the %s audio of track %d, using mkv", container, acodec, i+1)
+		log.Printf("[JOB %s] %s", job.ID, msg)
+		job.send(ipc.Msg{
+			"type": "warning",
+			"id":   job.ID,
+			"code": "audio_tracks_container",
+			"msg":  msg,
+			"from": container,
+			"to":   "mkv",
+		})
+		return "mkv", reason + "; " + msg
+	}
+	return container, reason
+}
+
+// multiAudioArgs builds the download of videoURL with the AudioTracks and
+// reports the tracks included for the done event
+func (job *Job) multiAudioArgs(videoURL, output string) []string {
+	primary := ff.PrimaryAudioTrack(job.AudioTracks)
+	included := make([]ipc.Msg, len(job.AudioTracks))
+	for i, t := range job.AudioTracks {
+		included[i] = ipc.Msg{"language": t.Language, "title": t.Title, "default": i == primary}
+	}
+	job.report("audioTracks", included)
+
+	log.Printf("[JOB %s] Muxing %d audio track(s) with %s", job.ID, len(job.AudioTracks), ff.RedactURL(videoURL))
+	return job.withOutputs(ff.BuildMultiAudioArgs(videoURL, job.AudioTracks, output, job.container, job.Headers, job.AllowProtocols))
+}
@@ -0,0 +1,61 @@
+package job
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// retryInNearestContainer redoes a conversion that ffmpeg refused to mux
+// into the requested container, into the nearest one that holds the
+// output's codecs (see ff.NearestContainer), instead of throwing the
+// download away. It sends container_autoselected and updates job.Convert
+// so the rest of the run names the file after the new container. Any
+// other failure is returned as it is.
+func (job *Job) retryInNearestContainer(ctx context.Context, input, output string, convErr error) error {
+	if !job.Convert.AutoContainer || job.stopping() {
+		return convErr
+	}
+	reason, ok := ff.MuxIncompatibility(convErr)
+	if !ok {
+		return convErr
+	}
+
+	requested := job.Convert.Container
+	container := "mkv"
+	var vcodec, acodec string
+	if probe, err := ff.ProbeURL(input, nil); err == nil {
+		vcodec, acodec = ff.ConvertedCodecs(job.Convert.VCodec, job.Convert.ACodec, probe.Streams)
+		container = ff.NearestContainer(requested, vcodec, acodec)
+	}
+	if container == requested {
+		return convErr
+	}
+
+	codecs := strings.Trim(vcodec+"/"+acodec, "/")
+	log.Printf("[JOB %s] %s can't hold %s, converting to %s instead: %v", job.ID, requested, codecs, container, convErr)
+	substitution := ipc.Msg{
+		"requested": requested,
+		"container": container,
+		"codecs":    codecs,
+		"reason":    reason,
+	}
+	event := ipc.Msg{"type": "container_autoselected", "id": job.ID}
+	for k, v := range substitution {
+		event[k] = v
+	}
+	job.send(event)
+	job.report("containerAutoselected", substitution)
+
+	convert := *job.Convert
+	convert.Container = container
+	job.Convert = &convert
+
+	os.Remove(output)
+	err := job.runConvert(ctx, input, job.convertArgs(input, output))
+	return job.checkFFmpegResult("convert", output, err)
+}
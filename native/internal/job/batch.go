@@ -0,0 +1,152 @@
+package job
+
+import (
+	"fmt"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// batch groups jobs started together by downloadBatch
+type batch struct {
+	id        string
+	jobs      []*Job
+	remaining int
+	kept      []BatchFile // done by an earlier run of a resumed batch
+}
+
+// StartBatch starts all jobs as one batch. Either every job is started or,
+// if any ID is missing or already in use, none are. Until every job is
+// done the batch is kept in the journal, so it can be resumed.
+func (m *Manager) StartBatch(batchID string, jobs []*Job) error {
+	return m.startBatch(batchID, jobs, nil)
+}
+
+// ResumeBatch starts the files of a journaled batch that weren't done as
+// jobs, keeping the done ones in the batch
+func (m *Manager) ResumeBatch(rec BatchRecord, jobs []*Job) error {
+	var kept []BatchFile
+	for _, f := range rec.Files {
+		if f.State == StateDone {
+			kept = append(kept, f)
+		}
+	}
+	return m.startBatch(rec.ID, jobs, kept)
+}
+
+func (m *Manager) startBatch(batchID string, jobs []*Job, kept []BatchFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.batches[batchID]; ok {
+		return fmt.Errorf("batch %s already exists", batchID)
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("batch has no jobs")
+	}
+
+	seen := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		if job.ID == "" {
+			return fmt.Errorf("batch job without id")
+		}
+		if _, ok := m.jobs[job.ID]; ok || seen[job.ID] {
+			return fmt.Errorf("duplicate job id: %s", job.ID)
+		}
+		seen[job.ID] = true
+	}
+
+	b := &batch{
+		id:        batchID,
+		jobs:      jobs,
+		remaining: len(jobs),
+		kept:      kept,
+	}
+	m.batches[batchID] = b
+
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+
+	started := ipc.Msg{
+		"type":    "batch-started",
+		"batchId": batchID,
+		"ids":     ids,
+	}
+	if kept != nil {
+		done := make([]string, len(kept))
+		for i, f := range kept {
+			done[i] = f.ID
+		}
+		started["resumed"] = true
+		started["done"] = done
+	}
+	ipc.Send(started)
+
+	for _, job := range jobs {
+		job.BatchID = batchID
+		m.launch(job, b)
+	}
+	m.journalBatch(b)
+
+	return nil
+}
+
+// CancelBatch cancels every job of a batch that is still running and
+// returns their IDs
+func (m *Manager) CancelBatch(batchID string) ([]string, bool) {
+	m.mu.Lock()
+	b, ok := m.batches[batchID]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	canceled := []string{}
+	for _, job := range b.jobs {
+		if job.Snapshot().State == StateRunning {
+			m.Cancel(job.ID, false)
+			canceled = append(canceled, job.ID)
+		}
+	}
+	return canceled, true
+}
+
+// finishBatchJob records that one of the batch's jobs has returned and
+// reports the batch outcome once all of them have
+func (m *Manager) finishBatchJob(b *batch) {
+	m.mu.Lock()
+	b.remaining--
+	last := b.remaining == 0
+	if last {
+		delete(m.batches, b.id)
+	}
+	m.journalBatch(b)
+	m.mu.Unlock()
+
+	if !last {
+		return
+	}
+
+	counts := map[string]int{StateDone: len(b.kept)}
+	remaining := []string{}
+	for _, job := range b.jobs {
+		s := job.Snapshot().State
+		counts[s]++
+		if s != StateDone {
+			remaining = append(remaining, job.ID)
+		}
+	}
+
+	// Files that didn't finish can be downloaded later with resumeBatch
+	ipc.Send(ipc.Msg{
+		"type":      "batch-done",
+		"batchId":   b.id,
+		"done":      counts[StateDone],
+		"failed":    counts[StateError],
+		"canceled":  counts[StateCanceled],
+		"resumable": len(remaining) > 0,
+		"remaining": remaining,
+	})
+}
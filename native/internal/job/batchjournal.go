@@ -0,0 +1,162 @@
+package job
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/state"
+)
+
+// batchJournal is the state file recording batches that haven't finished
+// every file, so the rest can be downloaded after a cancel, failures or a
+// host restart. It holds the batch requests, headers included, which is
+// why state files are private to the user.
+const batchJournal = "batches.json"
+
+// BatchFile is one file of a journaled batch
+type BatchFile struct {
+	ID    string  `json:"id"`
+	State string  `json:"state"`
+	Final string  `json:"final,omitempty"`
+	Spec  ipc.Msg `json:"spec"` // the downloadBatch entry, to start it again
+}
+
+// BatchRecord is a batch's entry in the journal
+type BatchRecord struct {
+	ID      string      `json:"batchId"`
+	PID     int         `json:"pid"` // host process running the batch
+	Updated time.Time   `json:"updated"`
+	Files   []BatchFile `json:"files"`
+}
+
+// Summary describes the record for the extension: which files are done
+// and which are left, without the stored requests
+func (r BatchRecord) Summary() ipc.Msg {
+	done, remaining := []string{}, []string{}
+	files := make([]ipc.Msg, len(r.Files))
+	for i, f := range r.Files {
+		if f.State == StateDone {
+			done = append(done, f.ID)
+		} else {
+			remaining = append(remaining, f.ID)
+		}
+		files[i] = ipc.Msg{"id": f.ID, "state": f.State, "final": f.Final}
+	}
+	return ipc.Msg{
+		"batchId":   r.ID,
+		"updated":   r.Updated,
+		"done":      done,
+		"remaining": remaining,
+		"files":     files,
+	}
+}
+
+func readJournal() map[string]BatchRecord {
+	records := make(map[string]BatchRecord)
+	if err := state.ReadJSON(batchJournal, &records); err != nil && !os.IsNotExist(err) {
+		log.Printf("[BATCH] Can't read the batch journal: %v", err)
+	}
+	return records
+}
+
+func writeJournal(records map[string]BatchRecord) {
+	var err error
+	if len(records) == 0 {
+		err = state.Remove(batchJournal)
+	} else {
+		err = state.WriteJSON(batchJournal, records)
+	}
+	if err != nil {
+		log.Printf("[BATCH] Can't write the batch journal: %v", err)
+	}
+}
+
+// journalBatch records the state of b's files, dropping the batch from the
+// journal once all of them are done; m.mu must be held
+func (m *Manager) journalBatch(b *batch) {
+	rec := BatchRecord{ID: b.id, PID: os.Getpid(), Updated: time.Now()}
+	complete := true
+	rec.Files = append(rec.Files, b.kept...)
+	for _, job := range b.jobs {
+		snap := job.Snapshot()
+		rec.Files = append(rec.Files, BatchFile{ID: job.ID, State: snap.State, Final: snap.Final, Spec: job.Spec})
+		if snap.State != StateDone {
+			complete = false
+		}
+	}
+
+	records := readJournal()
+	if complete {
+		delete(records, b.id)
+	} else {
+		records[b.id] = rec
+	}
+	writeJournal(records)
+}
+
+// ResumableBatches returns the journaled batches with files left to
+// download, oldest first. Batches still running, here or in a detached
+// host, aren't included.
+func (m *Manager) ResumableBatches() []BatchRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []BatchRecord{}
+	for id, rec := range readJournal() {
+		if _, active := m.batches[id]; active {
+			continue
+		}
+		if rec.PID != os.Getpid() && processAlive(rec.PID) {
+			continue
+		}
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Updated.Before(list[j].Updated)
+	})
+	return list
+}
+
+// ResumableBatch returns one of ResumableBatches
+func (m *Manager) ResumableBatch(batchID string) (BatchRecord, bool) {
+	for _, rec := range m.ResumableBatches() {
+		if rec.ID == batchID {
+			return rec, true
+		}
+	}
+	return BatchRecord{}, false
+}
+
+// DiscardBatch drops a batch from the journal, reporting whether it was
+// there; its remaining files won't be offered again
+func (m *Manager) DiscardBatch(batchID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := readJournal()
+	if _, ok := records[batchID]; !ok {
+		return false
+	}
+	delete(records, batchID)
+	writeJournal(records)
+	return true
+}
+
+// processAlive reports whether a process with the given PID is running.
+// Windows only finds processes that exist.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
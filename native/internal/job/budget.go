@@ -0,0 +1,151 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/dash"
+	"github.com/thecturner/vidown-native/internal/hls"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// validateDataBudget checks the DataBudget option, which picks between the
+// qualities of a master playlist or manifest
+func (job *Job) validateDataBudget() error {
+	switch {
+	case job.DataBudget < 0:
+		return fmt.Errorf("dataBudgetMB must be positive")
+	case job.DataBudget > 0 && job.Mode != "hls" && job.Mode != "dash":
+		return fmt.Errorf("dataBudgetMB isn't supported in %s mode", job.Mode)
+	case job.DataBudget > 0 && job.Mode == "dash" && job.Engine == "ffmpeg":
+		return fmt.Errorf("dataBudgetMB for DASH needs the go engine")
+	}
+	return nil
+}
+
+// estimateSize is the data a stream of bps bits per second takes over
+// duration seconds
+func estimateSize(bps int64, duration float64) int64 {
+	return int64(float64(bps) / 8 * duration)
+}
+
+// variantBitrate is the bitrate a variant's size is estimated from: the
+// average when the playlist gives one, else the peak
+func variantBitrate(v hls.Variant) int64 {
+	if v.AverageBandwidth > 0 {
+		return v.AverageBandwidth
+	}
+	return v.Bandwidth
+}
+
+// pickVariant returns the variant of master to download, restricted to
+// those referencing audioGroup when it is non-empty and to those below a
+// quality fallback's ceiling: the best one, or with a DataBudget the best
+// one whose estimated size fits it. A variant named by Format is taken as is.
+func (job *Job) pickVariant(ctx context.Context, master *hls.Master, audioGroup string) (*hls.Variant, error) {
+	master = job.capVariants(master)
+	if job.chosen != nil && job.fallback.ceiling == 0 {
+		// Named by Format; a quality fallback moves below it like any pick
+		job.pickedVariant(job.chosen)
+		return job.chosen, nil
+	}
+	best, err := master.BestVariant(audioGroup)
+	if err != nil || job.DataBudget <= 0 {
+		job.pickedVariant(best)
+		return best, err
+	}
+
+	// Every variant covers the same stretch of time, so one playlist
+	// gives the duration of them all
+	media, err := hls.LoadMedia(ctx, best.URI, job.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("load variant playlist: %w", err)
+	}
+	if !media.Ended {
+		return nil, fmt.Errorf("dataBudgetMB needs a stream of known length, not a live one")
+	}
+	duration := media.Duration()
+
+	// Variants are sorted highest bandwidth first; the last candidate seen
+	// is the lowest, used when nothing fits
+	var pick *hls.Variant
+	for _, v := range master.Variants {
+		if audioGroup != "" && v.AudioGroup != audioGroup {
+			continue
+		}
+		v := v
+		pick = &v
+		if estimateSize(variantBitrate(v), duration) <= job.DataBudget {
+			break
+		}
+	}
+
+	job.pickedVariant(pick)
+	size := estimateSize(variantBitrate(*pick), duration)
+	job.reportBudget(duration, size, ipc.Msg{
+		"uri":       pick.URI,
+		"bandwidth": pick.Bandwidth,
+		"width":     pick.Width,
+		"height":    pick.Height,
+	})
+	return pick, nil
+}
+
+// budgetRepresentations picks the best video representation of m whose
+// estimated size, along with the audio's, fits the DataBudget. Audio is
+// small next to video and keeps its best quality.
+func (job *Job) budgetRepresentations(m *dash.Manifest, audio *dash.Representation) (*dash.Representation, error) {
+	if len(m.Video) == 0 {
+		return nil, nil
+	}
+	duration := m.Duration.Seconds()
+	if duration <= 0 {
+		return nil, fmt.Errorf("dataBudgetMB needs a stream of known length, the manifest gives none")
+	}
+	var audioSize int64
+	if audio != nil {
+		audioSize = estimateSize(audio.Bandwidth, duration)
+	}
+
+	// Representations are sorted highest bandwidth first
+	pick := &m.Video[len(m.Video)-1]
+	for i := range m.Video {
+		if audioSize+estimateSize(m.Video[i].Bandwidth, duration) <= job.DataBudget {
+			pick = &m.Video[i]
+			break
+		}
+	}
+
+	job.reportBudget(duration, audioSize+estimateSize(pick.Bandwidth, duration), ipc.Msg{
+		"id":        pick.ID,
+		"bandwidth": pick.Bandwidth,
+		"width":     pick.Width,
+		"height":    pick.Height,
+	})
+	return pick, nil
+}
+
+// reportBudget reports the quality picked for the DataBudget under
+// "dataBudget", with an over_data_budget warning when even the lowest
+// quality is estimated to exceed it. The download goes ahead either way.
+func (job *Job) reportBudget(duration float64, size int64, quality ipc.Msg) {
+	fits := size <= job.DataBudget
+	log.Printf("[JOB %s] Data budget %d bytes: picked %v, estimated %d bytes over %.0fs", job.ID, job.DataBudget, quality, size, duration)
+	job.report("dataBudget", ipc.Msg{
+		"budgetBytes":    job.DataBudget,
+		"estimatedBytes": size,
+		"durationSec":    duration,
+		"fits":           fits,
+		"quality":        quality,
+	})
+	if !fits {
+		job.send(ipc.Msg{
+			"type":           "warning",
+			"id":             job.ID,
+			"code":           "over_data_budget",
+			"msg":            fmt.Sprintf("even the lowest quality is estimated at %.1f MB, over the %.1f MB budget; downloading it anyway", float64(size)/(1<<20), float64(job.DataBudget)/(1<<20)),
+			"estimatedBytes": size,
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// clipPart is one part of a split clip, waiting in its temp file to be
+// moved into place
+type clipPart struct {
+	out         string // see ff.PartName
+	tmp         string
+	startSec    float64 // offset into the clip
+	durationSec float64
+}
+
+// splitClip cuts the extracted clip into SplitEvery-long parts, each tagged
+// with its track number and title. The whole clip stays the job's output;
+// finishParts moves the parts into place next to it.
+func (job *Job) splitClip(ctx context.Context, whole string, clip ff.ClipOptions) error {
+	job.enterPhase(PhasePostprocessing)
+
+	pattern := ff.SegmentPattern(whole)
+	log.Printf("[JOB %s] Splitting audio clip every %.3fs", job.ID, clip.SplitEvery)
+	err := job.runFFmpeg(ctx, ff.BuildAudioSplitArgs(whole, pattern, clip))
+
+	// The segment muxer numbers its files from 0
+	var segments []string
+	for i := 0; ; i++ {
+		name := fmt.Sprintf(pattern, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		segments = append(segments, name)
+	}
+	defer func() {
+		for _, s := range segments {
+			os.Remove(s)
+		}
+	}()
+
+	switch {
+	case job.stopping():
+		return nil // a graceful stop keeps the whole clip
+	case err != nil:
+		return fmt.Errorf("splitting the clip: %w", err)
+	case len(segments) == 0:
+		return fmt.Errorf("splitting the clip produced no parts")
+	}
+
+	title := strings.TrimSuffix(filepath.Base(job.Out), filepath.Ext(job.Out))
+	var start float64
+	for i, segment := range segments {
+		tags := ff.PartTags{
+			Title: fmt.Sprintf("%s (Part %d of %d)", title, i+1, len(segments)),
+			Index: i + 1,
+			Total: len(segments),
+		}
+		p := clipPart{out: ff.PartName(job.Out, i+1, len(segments)), startSec: start}
+		p.tmp = job.tempPath(p.out)
+
+		err := job.runFFmpeg(ctx, ff.BuildPartTagArgs(segment, whole, p.tmp, clip, tags))
+		if err == nil && job.stopping() {
+			err = context.Canceled
+		}
+		if err != nil {
+			os.Remove(p.tmp)
+			job.removePartTemps()
+			if job.stopping() {
+				return nil
+			}
+			return fmt.Errorf("tagging part %d: %w", i+1, err)
+		}
+
+		// Cuts land on packet boundaries, so the lengths are measured
+		p.durationSec = math.Min(clip.SplitEvery, clip.Duration()-start)
+		if d, err := ff.EstimateDuration(p.tmp, nil); err == nil && d > 0 {
+			p.durationSec = d.Seconds()
+		}
+		start += p.durationSec
+		job.parts = append(job.parts, p)
+	}
+	return nil
+}
+
+// removePartTemps discards the parts of a clip that won't be delivered
+func (job *Job) removePartTemps() {
+	for _, p := range job.parts {
+		os.Remove(p.tmp)
+	}
+	job.parts = nil
+}
+
+// finishParts moves a split clip's parts into place under the onExisting
+// policy and reports each one. Like an extra output, a part that can't be
+// moved is reported with its error rather than failing the job.
+func (job *Job) finishParts(ctx context.Context) {
+	if len(job.parts) == 0 {
+		return
+	}
+
+	var results []ipc.Msg
+	for i, p := range job.parts {
+		result := ipc.Msg{
+			"index":       i + 1,
+			"out":         p.out,
+			"startSec":    p.startSec,
+			"durationSec": p.durationSec,
+		}
+		results = append(results, result)
+
+		final, err := job.claimOutput(p.out)
+		if err == nil {
+			err = fsutil.Move(ctx, p.tmp, final, nil)
+		}
+		if err != nil {
+			os.Remove(p.tmp)
+			if final != "" && job.OnExisting == OnExistingRename {
+				os.Remove(final)
+			}
+			result["error"] = err.Error()
+			continue
+		}
+
+		result["final"] = final
+		if info, err := os.Stat(final); err == nil {
+			result["bytesWritten"] = info.Size()
+		}
+	}
+	job.parts = nil
+	job.report("parts", results)
+}
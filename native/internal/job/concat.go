@@ -0,0 +1,184 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// partProgress tracks where a concat job is in its list of parts
+type partProgress struct {
+	index int
+	count int
+	bytes int64 // written for the current part
+	total int64 // current part's size, 0 if unknown
+}
+
+// percent estimates overall progress when the combined size is unknown,
+// counting each part as an equal share
+func (p *partProgress) percent() int {
+	var frac float64
+	if p.total > 0 {
+		frac = float64(p.bytes) / float64(p.total)
+		if frac > 1 {
+			frac = 1
+		}
+	}
+	return int((float64(p.index) + frac) * 100 / float64(p.count))
+}
+
+// selectConcatContainer picks the joined file's container from the output
+// name, falling back to mkv which can hold whatever the parts carry. Live
+// captures use it too, having nothing to probe ahead of time.
+func (job *Job) selectConcatContainer() {
+	if job.Convert != nil && job.Convert.Container != "copy" {
+		job.container = "mkv"
+		return
+	}
+
+	job.container = ff.ContainerFromPath(job.Out)
+	if job.container == "" {
+		job.container = "mkv"
+		job.mu.Lock()
+		job.Out = ff.ReplaceExt(job.Out, job.container)
+		job.mu.Unlock()
+	}
+}
+
+// downloadConcat downloads every part in order, then joins them into
+// output. Parts with matching codecs are joined with the concat demuxer as
+// a straight copy; otherwise they are re-encoded with the concat filter.
+func (job *Job) downloadConcat(ctx context.Context, output string) error {
+	if len(job.Parts) < 2 {
+		return fmt.Errorf("concat needs at least two urls, got %d", len(job.Parts))
+	}
+
+	paths := make([]string, len(job.Parts))
+	for i := range job.Parts {
+		paths[i] = output + "." + strconv.Itoa(i+1)
+	}
+	list := output + ".list"
+	defer func() {
+		for _, p := range paths {
+			os.Remove(p)
+		}
+		os.Remove(list)
+	}()
+
+	var done int64
+	for i, url := range job.Parts {
+		if err := job.downloadPart(ctx, i, url, paths[i], done); err != nil {
+			return fmt.Errorf("part %d: %w", i+1, err)
+		}
+		if info, err := os.Stat(paths[i]); err == nil {
+			done += info.Size()
+		}
+	}
+
+	job.mu.Lock()
+	job.part = nil
+	job.mu.Unlock()
+
+	if job.stopping() {
+		return fmt.Errorf("stopped before joining parts")
+	}
+
+	probes := make([]*ff.ProbeResult, len(paths))
+	var duration time.Duration
+	for i, p := range paths {
+		probe, err := ff.ProbeURL(p, nil)
+		if err != nil {
+			return fmt.Errorf("probe part %d: %w", i+1, err)
+		}
+		probes[i] = probe
+		if secs, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+			duration += time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	var args []string
+	if ok, reason := ff.ConcatCompatible(probes); ok {
+		if err := ff.WriteConcatList(list, paths); err != nil {
+			return err
+		}
+		args = ff.BuildConcatCopyArgs(list, output, job.container)
+		job.report("concat", "copy")
+	} else {
+		log.Printf("[JOB %s] Parts differ (%s), re-encoding to join", job.ID, reason)
+		job.send(ipc.Msg{
+			"type": "warning",
+			"id":   job.ID,
+			"code": "concat_reencode",
+			"msg":  "parts differ (" + reason + "), re-encoding to join them",
+		})
+		args = ff.BuildConcatEncodeArgs(paths, output, job.container, concatLayout(probes))
+		job.report("concat", "reencode")
+	}
+
+	if duration <= 0 {
+		return job.runFFmpeg(ctx, args)
+	}
+	return job.runEncode(ctx, "join", duration, args)
+}
+
+// downloadPart fetches one part, reporting progress for the part and for
+// the job overall (offset is the size of the parts already downloaded)
+func (job *Job) downloadPart(ctx context.Context, index int, url, output string, offset int64) error {
+	job.mu.Lock()
+	job.part = &partProgress{index: index, count: len(job.Parts)}
+	job.mu.Unlock()
+
+	onProgress := func(written, total int64) {
+		job.mu.Lock()
+		job.part.bytes = written
+		job.part.total = total
+		job.mu.Unlock()
+		job.sendProgress(offset+written, job.ExpTotal)
+	}
+
+	if job.Engine == "go" {
+		return job.fetchGo(ctx, url, output, fetch.Options{OnProgress: onProgress})
+	}
+
+	// mkv holds whatever a part carries, the join step picks the real container
+	args := ff.BuildHTTPArgs(url, output, "mkv", job.Headers)
+	return ff.Run(ctx, args, job.runOptions(func(update ff.ProgressUpdate) {
+		if update.Reported.Has(ff.FieldSize) {
+			onProgress(update.BytesWritten, 0)
+		}
+	}))
+}
+
+// concatLayout keeps the stream types every part has and sizes the video
+// to the largest part, rounded to even dimensions for the encoders
+func concatLayout(probes []*ff.ProbeResult) ff.ConcatLayout {
+	layout := ff.ConcatLayout{Video: true, Audio: true}
+	for _, p := range probes {
+		vcodec, acodec := ff.StreamCodecs(p.Streams)
+		layout.Video = layout.Video && vcodec != ""
+		layout.Audio = layout.Audio && acodec != ""
+
+		for _, s := range p.Streams {
+			if s.CodecType != "video" {
+				continue
+			}
+			if s.Width > layout.Width {
+				layout.Width = s.Width
+			}
+			if s.Height > layout.Height {
+				layout.Height = s.Height
+			}
+			break
+		}
+	}
+	layout.Width += layout.Width % 2
+	layout.Height += layout.Height % 2
+	return layout
+}
@@ -0,0 +1,44 @@
+package job
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// creationTime parses the CreationTime option, zero when unset
+func (job *Job) creationTime() (time.Time, error) {
+	if job.CreationTime == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, job.CreationTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("creationTime must be an RFC 3339 timestamp: %w", err)
+	}
+	return t, nil
+}
+
+// applyCreationTime gives the finished file the CreationTime, on platforms
+// that keep one (Windows, macOS). Whether it was set is reported under
+// "creationTime"; not setting it doesn't fail the job.
+func (job *Job) applyCreationTime(final string) {
+	t, err := job.creationTime()
+	if err != nil || t.IsZero() {
+		return
+	}
+
+	result := ipc.Msg{"set": false}
+	switch err := fsutil.SetCreationTime(final, t); {
+	case !fsutil.CreationTimeSupported:
+		result["reason"] = "creation time can't be set on this platform"
+	case err != nil:
+		log.Printf("[JOB %s] Can't set creation time: %v", job.ID, err)
+		result["reason"] = err.Error()
+	default:
+		result["set"] = true
+	}
+	job.report("creationTime", result)
+}
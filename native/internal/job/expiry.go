@@ -0,0 +1,97 @@
+package job
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// expirySkew allows for the CDN's clock running ahead of ours when telling
+// an expired URL's 403 from any other
+const expirySkew = time.Minute
+
+// urlExpiry reads when a signed URL stops working from its query: a Unix
+// time in expires/expire/exp (CloudFront, YouTube and others), or S3's
+// X-Amz-Date plus X-Amz-Expires seconds. ok is false for unsigned URLs.
+func urlExpiry(rawURL string) (expires time.Time, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	q := u.Query()
+
+	if date, secs := q.Get("X-Amz-Date"), q.Get("X-Amz-Expires"); date != "" && secs != "" {
+		signed, err := time.Parse("20060102T150405Z", date)
+		n, nerr := strconv.ParseInt(secs, 10, 64)
+		if err == nil && nerr == nil && n > 0 {
+			return signed.Add(time.Duration(n) * time.Second), true
+		}
+	}
+	for _, key := range []string{"expires", "Expires", "expire", "exp"} {
+		n, err := strconv.ParseInt(q.Get(key), 10, 64)
+		// Anything before 2001 is a duration or a flag, not a timestamp
+		if err == nil && n > 1e9 {
+			return time.Unix(n, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// warnExpiry sends url_may_expire when the job's URL is signed with an
+// expiry the download is likely to outlast, going by its expected size and
+// the speed seen from the host before. Without both the risk isn't
+// guessed at, unless the URL has already expired.
+func (job *Job) warnExpiry(speed float64) {
+	expires, ok := urlExpiry(job.URL)
+	if !ok {
+		return
+	}
+	job.expires = expires
+	left := time.Until(expires)
+
+	var eta time.Duration
+	if speed > 0 && job.ExpTotal > 0 {
+		eta = time.Duration(float64(job.ExpTotal) / speed * float64(time.Second))
+	}
+	if left > 0 && (eta == 0 || eta < left) {
+		return
+	}
+
+	msg := fmt.Sprintf("the URL expires at %s, about %s before the download is expected to finish", expires.Format(time.RFC3339), (eta - left).Round(time.Second))
+	if left <= 0 {
+		msg = fmt.Sprintf("the URL expired at %s", expires.Format(time.RFC3339))
+	}
+	log.Printf("[JOB %s] %s", job.ID, msg)
+
+	warning := ipc.Msg{
+		"type":      "warning",
+		"id":        job.ID,
+		"code":      "url_may_expire",
+		"msg":       msg,
+		"expiresAt": expires.Format(time.RFC3339),
+	}
+	if eta > 0 {
+		warning["etaSec"] = int(eta.Seconds())
+	}
+	job.send(warning)
+}
+
+// urlExpired reports whether err is a 403 for a signed URL whose expiry
+// has passed, which a fresh URL rather than a retry fixes
+func (job *Job) urlExpired(err error) bool {
+	if job.expires.IsZero() || time.Now().Add(expirySkew).Before(job.expires) {
+		return false
+	}
+	status, _ := httpStatus(err)
+	return status == http.StatusForbidden
+}
+
+// expiredError says when the URL behind a url_expired failure stopped working
+func (job *Job) expiredError(err error) error {
+	return fmt.Errorf("signed URL expired at %s: %w", job.expires.Format(time.RFC3339), err)
+}
@@ -0,0 +1,99 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/thecturner/vidown-native/internal/hls"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// qualityFallback is the HLS variant state AutoFallbackQuality works from
+type qualityFallback struct {
+	master  *hls.Master  // as loaded, every variant
+	picked  *hls.Variant // the variant the last attempt downloaded
+	ceiling int64        // variants at or above this bandwidth are skipped, 0 = none
+	steps   []ipc.Msg    // downgrades made, for the done event
+}
+
+// capVariants returns master without the variants a quality fallback has
+// ruled out, remembering the full list for the next fallback
+func (job *Job) capVariants(master *hls.Master) *hls.Master {
+	if !job.AutoFallbackQuality {
+		return master
+	}
+	job.fallback.master = master
+	if job.fallback.ceiling <= 0 {
+		return master
+	}
+	capped := *master
+	capped.Variants = nil
+	for _, v := range master.Variants {
+		if v.Bandwidth < job.fallback.ceiling {
+			capped.Variants = append(capped.Variants, v)
+		}
+	}
+	return &capped
+}
+
+// pickedVariant records the variant an attempt downloads
+func (job *Job) pickedVariant(v *hls.Variant) {
+	if job.AutoFallbackQuality {
+		job.fallback.picked = v
+	}
+}
+
+// lowerVariant returns the best variant below the one that failed, in the
+// same audio group, nil if it was the lowest
+func (job *Job) lowerVariant() *hls.Variant {
+	fb := &job.fallback
+	if fb.master == nil || fb.picked == nil {
+		return nil
+	}
+	// Variants are sorted highest bandwidth first
+	for _, v := range fb.master.Variants {
+		if v.Bandwidth < fb.picked.Bandwidth && v.AudioGroup == fb.picked.AudioGroup {
+			v := v
+			return &v
+		}
+	}
+	return nil
+}
+
+// downloadWithFallback runs the download with its retries and, for an HLS
+// job with AutoFallbackQuality, tries the next lower variant each time the
+// picked one still fails, until one succeeds or none is left. Every
+// downgrade is announced with a quality_fallback warning and listed under
+// "qualityFallback" in the done event.
+func (job *Job) downloadWithFallback(ctx context.Context, output string) error {
+	for {
+		err := job.downloadWithRetry(ctx, output)
+		if err == nil || !job.AutoFallbackQuality || job.Mode != "hls" || job.stopping() || job.canceled() {
+			return err
+		}
+
+		from, to := job.fallback.picked, job.lowerVariant()
+		if to == nil {
+			return err
+		}
+		job.fallback.ceiling = from.Bandwidth
+
+		log.Printf("[JOB %s] Variant %s failed, falling back to %s: %v", job.ID, from.URI, to.URI, err)
+		step := ipc.Msg{"from": from, "to": to, "reason": err.Error()}
+		job.fallback.steps = append(job.fallback.steps, step)
+		job.report("qualityFallback", job.fallback.steps)
+		job.send(ipc.Msg{
+			"type": "warning",
+			"id":   job.ID,
+			"code": "quality_fallback",
+			"msg":  fmt.Sprintf("the %d bps variant kept failing, downloading the %d bps one instead", from.Bandwidth, to.Bandwidth),
+			"from": from,
+			"to":   to,
+		})
+
+		os.Remove(output)
+		job.removeOutputTemps()
+	}
+}
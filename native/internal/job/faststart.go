@@ -0,0 +1,52 @@
+package job
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// fixFaststart moves a finished MP4's index (moov atom) to the front when
+// it sits after the media data, so the file plays while it's still being
+// fetched. The rewrite copies the whole file, which is why it only runs
+// when the index really is at the end.
+func (job *Job) fixFaststart(ctx context.Context, path, container string) ipc.Msg {
+	result := ipc.Msg{"needed": false, "applied": false}
+
+	switch container {
+	case "mp4", "mov", "m4a":
+	default:
+		result["reason"] = "not an MP4 output"
+		return result
+	}
+
+	atEnd, err := ff.MoovAtEnd(path)
+	if err != nil {
+		log.Printf("[JOB %s] Can't check the moov atom: %v", job.ID, err)
+		result["reason"] = err.Error()
+		return result
+	}
+	if !atEnd {
+		return result
+	}
+	result["needed"] = true
+
+	fixed := path + ".faststart"
+	if err := job.runFFmpeg(ctx, ff.BuildFaststartArgs(path, fixed, container)); err != nil {
+		os.Remove(fixed)
+		log.Printf("[JOB %s] Faststart rewrite failed: %v", job.ID, err)
+		result["reason"] = err.Error()
+		return result
+	}
+	if err := os.Rename(fixed, path); err != nil {
+		os.Remove(fixed)
+		result["reason"] = err.Error()
+		return result
+	}
+
+	result["applied"] = true
+	return result
+}
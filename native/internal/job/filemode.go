@@ -0,0 +1,55 @@
+package job
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// fileMode parses the FileMode option, an octal string such as "0444";
+// ok is false when it's unset
+func (job *Job) fileMode() (mode os.FileMode, ok bool, err error) {
+	if job.FileMode == "" {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseUint(job.FileMode, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, false, fmt.Errorf("fileMode must be octal permission bits such as \"0444\", got %q", job.FileMode)
+	}
+	if n&0o400 == 0 {
+		return 0, false, fmt.Errorf("fileMode %s would leave the file unreadable by its owner", job.FileMode)
+	}
+	return os.FileMode(n), true, nil
+}
+
+// applyFileMode sets the finished file's permissions to FileMode, last, as
+// a read-only mode would stop anything after it from touching the file.
+// Windows only has a read-only attribute, set when the mode lacks owner
+// write. The outcome is reported under "fileMode"; failing doesn't fail
+// the job.
+func (job *Job) applyFileMode(final string) {
+	mode, ok, err := job.fileMode()
+	if err != nil || !ok {
+		return
+	}
+
+	result := ipc.Msg{"requested": fmt.Sprintf("%04o", mode), "set": false}
+	applied, err := fsutil.SetMode(final, mode)
+	if err != nil {
+		log.Printf("[JOB %s] Can't set file mode: %v", job.ID, err)
+		result["reason"] = err.Error()
+		job.report("fileMode", result)
+		return
+	}
+	result["set"] = true
+	result["applied"] = fmt.Sprintf("%04o", applied)
+	result["readOnly"] = applied&0o200 == 0
+	if !fsutil.PermissionBitsSupported && applied != mode {
+		result["reason"] = "only the read-only attribute can be set on this platform"
+	}
+	job.report("fileMode", result)
+}
@@ -0,0 +1,61 @@
+package job
+
+import (
+	"log"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// inputHook returns the callback that reports which demuxer ffmpeg used for
+// the job's source, so the extension can learn whether the mode it picked
+// for a site was right. Only the download step of the streaming modes
+// reports, once per job.
+func (job *Job) inputHook() ff.InputCallback {
+	switch job.Mode {
+	case "hls", "dash", "http":
+	default:
+		return nil
+	}
+
+	return func(index int, formats []string) {
+		if index != 0 {
+			return
+		}
+		job.mu.Lock()
+		seen := job.detected
+		job.detected = true
+		job.mu.Unlock()
+		if seen {
+			return
+		}
+
+		mode := modeForFormats(formats)
+		log.Printf("[JOB %s] ffmpeg detected input format %s (mode %s, detected %s)", job.ID, strings.Join(formats, ","), job.Mode, mode)
+		job.send(ipc.Msg{
+			"type":          "detected-format",
+			"id":            job.ID,
+			"format":        formats[0],
+			"formats":       formats,
+			"mode":          job.Mode,
+			"suggestedMode": mode,
+			"modeMatches":   mode == job.Mode,
+		})
+	}
+}
+
+// modeForFormats maps ffmpeg's demuxer names to the download mode that fits
+// them: the manifest formats have their own modes, anything else is a
+// progressive file
+func modeForFormats(formats []string) string {
+	for _, f := range formats {
+		switch f {
+		case "hls", "applehttp":
+			return "hls"
+		case "dash":
+			return "dash"
+		}
+	}
+	return "http"
+}
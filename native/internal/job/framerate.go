@@ -0,0 +1,71 @@
+package job
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// Convert frame rate choices for variable frame rate sources
+const (
+	FrameRateCFR = "cfr" // re-encode at a constant rate, against A/V drift
+	FrameRateVFR = "vfr" // keep the source's timestamps
+)
+
+// validateFrameRateMode checks the convert frameRateMode option
+func validateFrameRateMode(m string) error {
+	switch m {
+	case "", FrameRateCFR, FrameRateVFR:
+		return nil
+	}
+	return fmt.Errorf("frameRateMode must be %q or %q", FrameRateCFR, FrameRateVFR)
+}
+
+// withFrameRate handles a variable frame rate source in a conversion,
+// which re-encoded as is can drift out of sync with its audio. A detected
+// VFR source is reported under "vfr" either way; the frame rate mode only
+// applies when the video is re-encoded, a copied stream keeps its timing.
+func (job *Job) withFrameRate(streams []ff.ProbeStream, args []string) []string {
+	s := ff.VFRStream(streams)
+	if s == nil {
+		return args
+	}
+
+	c := job.Convert
+	reencode := c.VCodec != "" && c.VCodec != "copy"
+	report := ipc.Msg{
+		"detected":     true,
+		"rFrameRate":   s.RFrameRate,
+		"avgFrameRate": s.AvgFrameRate,
+		"handled":      "source",
+	}
+	defer job.report("vfr", report)
+
+	log.Printf("[JOB %s] Variable frame rate source: r_frame_rate %s, avg_frame_rate %s", job.ID, s.RFrameRate, s.AvgFrameRate)
+	if c.FrameRateMode == "" {
+		return args
+	}
+	if !reencode {
+		if c.FrameRateMode == FrameRateCFR {
+			msg := "the video is copied, so it keeps its variable frame rate; set convert.vcodec to make it constant"
+			log.Printf("[JOB %s] %s", job.ID, msg)
+			job.send(ipc.Msg{
+				"type": "warning",
+				"id":   job.ID,
+				"code": "frame_rate_not_applied",
+				"msg":  msg,
+			})
+		}
+		return args
+	}
+
+	cfr := c.FrameRateMode == FrameRateCFR
+	fps := ff.CFRTarget(*s)
+	report["handled"] = c.FrameRateMode
+	if cfr {
+		report["fps"] = fps
+	}
+	return ff.InsertOutputOptions(args, ff.BuildFrameRateArgs(cfr, fps)...)
+}
@@ -0,0 +1,45 @@
+package job
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// withHDR checks a conversion's source streams for HDR video that its
+// re-encode would flatten to SDR. With PreserveHDR and an encoder that can
+// carry it, the HDR color description is passed through; otherwise the
+// loss is reported with an hdr_lost warning. The outcome is reported under
+// "hdr".
+func (job *Job) withHDR(streams []ff.ProbeStream, args []string) []string {
+	c := job.Convert
+	if c.VCodec == "" || c.VCodec == "copy" {
+		return args
+	}
+	s := ff.HDRStream(streams)
+	if s == nil {
+		return args
+	}
+
+	if c.PreserveHDR && ff.HDRPreservable(c.VCodec) {
+		log.Printf("[JOB %s] Keeping %s through the %s re-encode", job.ID, s.HDR, c.VCodec)
+		job.report("hdr", ipc.Msg{"format": s.HDR, "preserved": true})
+		return ff.InsertOutputOptions(args, ff.BuildHDRArgs(*s)...)
+	}
+
+	msg := fmt.Sprintf("re-encoding %s video to %s drops its HDR, set convert.preserveHdr to keep it", s.HDR, c.VCodec)
+	if !ff.HDRPreservable(c.VCodec) {
+		msg = fmt.Sprintf("re-encoding %s video to %s drops its HDR, use hevc, vp9 or av1 with convert.preserveHdr to keep it", s.HDR, c.VCodec)
+	}
+	log.Printf("[JOB %s] %s", job.ID, msg)
+	job.send(ipc.Msg{
+		"type": "warning",
+		"id":   job.ID,
+		"code": "hdr_lost",
+		"msg":  msg,
+	})
+	job.report("hdr", ipc.Msg{"format": s.HDR, "preserved": false})
+	return args
+}
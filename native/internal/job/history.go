@@ -0,0 +1,74 @@
+package job
+
+import (
+	"os"
+	"time"
+)
+
+// DefaultHistorySize is how many finished jobs the manager remembers
+const DefaultHistorySize = 50
+
+// HistoryEntry records a job that has left the active map, so a
+// reconnecting extension can rebuild its list of recent downloads
+type HistoryEntry struct {
+	Snapshot
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	Finished time.Time `json:"finished"`
+}
+
+// retire moves a finished job from the active map into the history,
+// dropping the oldest entries beyond the configured size
+func (m *Manager) retire(job *Job) {
+	m.recordSpeed(job)
+
+	entry := HistoryEntry{
+		Snapshot: job.Snapshot(),
+		URL:      job.URL,
+		Finished: time.Now(),
+	}
+	if entry.Final != "" {
+		if info, err := os.Stat(entry.Final); err == nil {
+			entry.Size = info.Size()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A canceled job is already gone, and its ID may have been reused
+	if m.jobs[job.ID] == job {
+		delete(m.jobs, job.ID)
+	}
+
+	m.history = append(m.history, entry)
+	m.trimHistory()
+}
+
+// trimHistory drops the oldest entries beyond historySize; m.mu must be held
+func (m *Manager) trimHistory() {
+	if extra := len(m.history) - m.historySize; extra > 0 {
+		m.history = append([]HistoryEntry(nil), m.history[extra:]...)
+	}
+}
+
+// SetHistorySize changes how many finished jobs are remembered
+func (m *Manager) SetHistorySize(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.historySize = n
+	m.trimHistory()
+}
+
+// History returns the remembered finished jobs, most recent first
+func (m *Manager) History() []HistoryEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]HistoryEntry, len(m.history))
+	for i, e := range m.history {
+		entries[len(entries)-1-i] = e
+	}
+	return entries
+}
@@ -2,15 +2,26 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/thecturner/vidown-native/internal/dash"
+	"github.com/thecturner/vidown-native/internal/fdlimit"
 	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/hls"
 	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/ratelimit"
 )
 
 // Job represents a download job
@@ -22,156 +33,722 @@ type Job struct {
 	Headers   map[string]string
 	ExpTotal  int64
 	Convert   *ConvertOpts
+	Engine    string // "ffmpeg" or "go"; "" = go for http mode, ffmpeg otherwise
+	Debug     bool
+	Clip      *ff.ClipOptions // set for mode "audioclip"
+	Audio     *hls.AudioSelector // alternate HLS audio rendition to mux in
+	Thumbnail *ThumbnailOpts
+	BatchID   string   // set for jobs started through StartBatch
+	Spec      ipc.Msg  // the downloadBatch entry of a batch job, journaled
+	Captions  string   // "srt" or "vtt" to extract embedded captions, "" = off
+	Parts     []string // ordered source URLs for mode "concat"
+	Priority  string   // PriorityLow runs ffmpeg nice, anything else normal
+	Context   string   // opaque tag from the extension, e.g. its tab, see CancelContext
 
+	// ExitPolicy decides how ffmpeg's exit code and a probe of its output
+	// combine into success or failure (see ExitPolicyLenient)
+	ExitPolicy string
+
+	// OnExisting decides what happens when the final name is already taken
+	// (OnExistingOverwrite, OnExistingRename or OnExistingError)
+	OnExisting string
+
+	// Outputs (multiOutput) are more files written by the download's
+	// ffmpeg command, e.g. an mp3 alongside the video
+	Outputs []ExtraOutput
+
+	// Retry decides which HTTP failures are retried; nil = the defaults
+	Retry *RetryPolicy
+
+	// Proxy is the http(s) proxy for this job's requests, ffmpeg's and
+	// the Go engine's
+	Proxy string
+
+	// Subtitles adds a subtitle file as a track, or burned in
+	Subtitles *SubtitleOpts
+
+	// RawSegments saves an hls or dash stream's segments into the Out
+	// folder with a local playlist instead of muxing them; Decrypt removes
+	// AES-128 encryption from them on the way
+	RawSegments bool
+	Decrypt     bool
+
+	// Live is when a live RTMP/RTSP/SRT capture (mode live) stops
+	Live *LiveOpts
+
+	// AllowProtocols are URL schemes allowed beyond http(s) and local
+	// files, such as "ftp"; see ff.CheckProtocol
+	AllowProtocols []string
+
+	// CreateDirs creates a missing parent directory of Out instead of
+	// failing with parent_missing
+	CreateDirs bool
+
+	// Representations picks DASH representations by ID for the Go engine
+	// instead of the highest bandwidth ones
+	Representations *dash.Selection
+
+	// DataBudget (bytes) picks the best HLS variant or DASH video whose
+	// estimated size fits, for metered connections; 0 = no budget
+	DataBudget int64
+
+	// NormalizeAudio re-encodes the audio at an EBU R128 loudness target
+	NormalizeAudio *LoudnessOpts
+
+	// SourceAddress binds the download to a local IP address or interface
+	// name, for machines with several routes out (VPN + LAN)
+	SourceAddress string
+
+	// SourceFile writes a sidecar next to the output recording its source
+	// URL, in one of the SourceFile* formats; empty writes none
+	SourceFile string
+
+	// CreationTime (RFC 3339) is set as the output's creation time where
+	// the platform keeps one
+	CreationTime string
+
+	// KeepCoverArt carries a source's cover art (attached_pic) into an
+	// audio clip
+	KeepCoverArt bool
+
+	// FileMode (octal, e.g. "0444") is applied to the finished output;
+	// on Windows only its owner-write bit counts, as the read-only attribute
+	FileMode string
+
+	// SegmentConcurrency is how many segments the native engine fetches at
+	// once (0 or 1 = one at a time); MaxBufferBytes bounds those held in
+	// memory waiting for an earlier one (0 = fetch.DefaultMaxBufferBytes)
+	SegmentConcurrency int
+	MaxBufferBytes     int64
+
+	// AutoFallbackQuality moves an HLS download to the next lower variant
+	// when the picked one still fails after its retries
+	AutoFallbackQuality bool
+
+	// IncludeProbe adds the source's probe result to job-started, from the
+	// probe cache when the extension probed it just before
+	IncludeProbe bool
+
+	// Format picks what to download by the format IDs listMediaFormats
+	// gave: one, or a video-only and an audio-only one
+	Format []string
+
+	// WorkDir is the directory ffmpeg runs in, so relative local inputs
+	// (subtitles, a thumbnail image) resolve the same way whatever the
+	// browser started the host in. It must be inside one of InputRoots,
+	// the downloads and library folders. Empty = the output's folder.
+	WorkDir    string
+	InputRoots []string
+
+	// AudioTracks are audio sources muxed beside the video as separate
+	// selectable tracks, each with its language tag, instead of the
+	// source's own audio
+	AudioTracks []ff.AudioTrack
+
+	// FixFaststart rewrites a finished MP4 with its index (moov atom) at
+	// the front when it turns out to be at the end, e.g. a progressive
+	// file the go engine saved byte for byte; one that's already
+	// streamable is left alone
+	FixFaststart bool
+
+	// KeepTempOnError keeps a failed job's temp files (.part, .converted)
+	// for inspection instead of deleting them, listed in the error event
+	KeepTempOnError bool
+
+	// Preallocate reserves a go engine download's full size on disk before
+	// writing it, and the final file's when it's copied to another
+	// filesystem, to keep big files in one piece and fail early on a full
+	// disk
+	Preallocate bool
+
+	container string  // container written by the download step
+	result    ipc.Msg // extra fields for the done event
+	limiter   *ratelimit.Limiter
+	state     string
+	started   time.Time
+	final     string
+	errCode   string
+	errMsg    string
 	speedEMA  float64
 	lastBytes int64
+	lastTotal int64
 	lastTick  time.Time
+	part      *partProgress    // set while a concat part downloads
+	segments  *segmentProgress // set while the native engine runs
+	source    *fetch.Source    // resolved SourceAddress
+	scratch   ScratchDirs
+	sidecar   string // resume checkpoints of a resumable download
+	subsFile  string // local copy of Subtitles.Src
+	subsTemp  bool   // subsFile was downloaded and is removed afterwards
+	detected  bool   // detected-format was sent
+	tag       string // names the job's temp files, see tempPath
+	failedAt  int64  // furthest byte a failed attempt reached, see retry
+	sized     bool   // lookupLength asked the server for ExpTotal
+	expires   time.Time // when a signed URL stops working, see urlExpiry
+	phases    []PhaseSpan // timeline for the done and error events
+	fallback  qualityFallback // see AutoFallbackQuality
+	outTime   time.Duration   // media time of a running ffmpeg's output, -1 = none
+	chosen    *hls.Variant // named by Format, see applyFormat
+	parts     []clipPart   // a split clip's parts, see splitClip
+	keptTemps []string     // temps a failure left for inspection, see discardTemps
+	net       *netWatch    // set in debug mode, see watchNet
+	switcher  *variantSwitch // set while a switchable HLS variant downloads
+	progress  progressStream
 	cancel    context.CancelFunc
+	stop      chan struct{} // closed to request a graceful ffmpeg stop
+	stopOnce  sync.Once
+	graceful  bool
 	mu        sync.Mutex
 }
 
+// PriorityLow marks background jobs whose ffmpeg processes run at reduced
+// CPU and I/O priority
+const PriorityLow = "low"
+
 // ConvertOpts holds conversion options
 type ConvertOpts struct {
 	Container string
 	VCodec    string
 	ACodec    string
+
+	// KeepSmaller keeps the original download (remuxed into Container
+	// when its codecs fit) if the conversion came out bigger
+	KeepSmaller bool
+
+	// PreserveHDR keeps an HDR source's color description through a
+	// hevc, vp9 or av1 re-encode
+	PreserveHDR bool
+
+	// Threads caps the encoders' CPU threads, 0 = ffmpeg's default
+	Threads int
+
+	// Rotation is RotationBake or RotationPreserve for rotated sources
+	Rotation string
+
+	// FrameRateMode is FrameRateCFR or FrameRateVFR for re-encoding a
+	// variable frame rate source; empty leaves it to ffmpeg
+	FrameRateMode string
+
+	// MaxVideoBitrate and MaxAudioBitrate cap the re-encoded streams
+	// (bits per second, 0 = no cap), for streaming targets and upload
+	// limits
+	MaxVideoBitrate int64
+	MaxAudioBitrate int64
+
+	// AutoContainer retries a conversion that ffmpeg can't mux into
+	// Container in the nearest container that fits, mkv at worst
+	AutoContainer bool
+}
+
+// ThumbnailOpts requests cover art to be embedded into the output
+type ThumbnailOpts struct {
+	Image string // path or URL of the image; empty = grab a frame
 }
 
 // Manager manages all jobs
 type Manager struct {
-	jobs map[string]*Job
-	mu   sync.Mutex
+	jobs        map[string]*Job // running jobs; finished ones move to history
+	batches     map[string]*batch
+	history     []HistoryEntry // oldest first
+	historySize int
+	speeds      map[string]*HostSpeed // by host, see recordSpeed
+	limiter     *ratelimit.Limiter
+	debug       bool
+	deltas      bool // progress deltas negotiated
+	scratch     ScratchDirs
+	wg          sync.WaitGroup
+	mu          sync.Mutex
 }
 
 // NewManager creates a new job manager
 func NewManager() *Manager {
 	return &Manager{
-		jobs: make(map[string]*Job),
+		jobs:        make(map[string]*Job),
+		batches:     make(map[string]*batch),
+		historySize: DefaultHistorySize,
+		speeds:      make(map[string]*HostSpeed),
+		limiter:     ratelimit.New(),
 	}
 }
 
 // Start begins a new download job
-func (m *Manager) Start(id, mode, url, out string, headers map[string]string, convert *ConvertOpts, expTotal int64) {
+func (m *Manager) Start(job *Job) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.launch(job, nil)
+}
+
+// launch starts a job; m.mu must be held. When the job belongs to a batch,
+// its completion is recorded on the batch.
+func (m *Manager) launch(job *Job, b *batch) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	job := &Job{
-		ID:        id,
-		Mode:      mode,
-		URL:       url,
-		Out:       out,
-		Headers:   headers,
-		ExpTotal:  expTotal,
-		Convert:   convert,
-		cancel:    cancel,
-		lastTick:  time.Now(),
+	job.cancel = cancel
+	job.stop = make(chan struct{})
+	job.limiter = m.limiter
+	job.state = StateRunning
+	job.started = time.Now()
+	job.lastTick = job.started
+	job.outTime = -1
+	job.enterPhase(PhaseQueued)
+	if m.debug {
+		job.Debug = true
 	}
+	job.progress.setDeltas(m.deltas)
+	job.selectEngine()
+	job.scratch = m.scratch
+	job.tag = tempTag(job.ID)
+	// Seeding the speed average with the host's history keeps the ETA
+	// from jumping about until the job's own speed settles
+	job.speedEMA = m.hostSpeed(job.URL)
+	seed := job.speedEMA
 
-	m.jobs[id] = job
+	m.jobs[job.ID] = job
 
 	// Send job-started event
-	ipc.Send(ipc.Msg{
+	started := ipc.Msg{
 		"type": "job-started",
-		"id":   id,
-		"out":  out,
-	})
+		"id":   job.ID,
+		"out":  job.Out,
+	}
+	if job.Mode == "live" {
+		started["live"] = true
+	}
+	if seed > 0 {
+		started["historicalSpeedBps"] = int64(seed)
+		if job.ExpTotal > 0 {
+			started["etaSec"] = int(float64(job.ExpTotal) / seed)
+		}
+	}
+	if job.resumable() {
+		job.sidecar = job.downloadTemp() + ".resume"
+		if info := job.resumeInfo(); info != nil {
+			started["resume"] = info
+		}
+	}
+	lookup := job.Mode == "http" && job.ExpTotal <= 0
+	if !lookup && !job.IncludeProbe {
+		job.send(started)
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if lookup {
+			// Sent once the size is known, so percent and ETA work from
+			// the first progress event
+			if total := job.lookupLength(ctx); total > 0 {
+				started["expectedTotalBytes"] = total
+				if seed > 0 {
+					started["etaSec"] = int(float64(total) / seed)
+				}
+			}
+		}
+		if job.IncludeProbe {
+			job.addStartProbe(started)
+		}
+		if lookup || job.IncludeProbe {
+			job.send(started)
+		}
+		job.warnExpiry(seed)
+		job.run(ctx)
+		m.retire(job)
+		if b != nil {
+			m.finishBatchJob(b)
+		}
+	}()
+}
+
+// SetDebug turns on debug reporting for all jobs started from now on
+func (m *Manager) SetDebug(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.debug = enabled
+}
+
+// SetGlobalRateLimit caps the combined speed of all Go-based downloads.
+// 0 disables the cap.
+func (m *Manager) SetGlobalRateLimit(bps int64) {
+	m.limiter.SetRate(bps)
+}
+
+// GlobalRateLimit returns the cap, the number of jobs sharing it and the
+// per-job share (0 when unlimited)
+func (m *Manager) GlobalRateLimit() (limit int64, users int, share int64) {
+	return m.limiter.Rate(), m.limiter.Users(), m.limiter.Share()
+}
 
-	go job.run(ctx)
+// Cancel cancels a job. A graceful cancel lets ffmpeg finalize what it has
+// downloaded into a playable truncated file; the job then reports canceled
+// itself once the file is in place.
+func (m *Manager) Cancel(id string, graceful bool) {
+	m.cancel(id, graceful)
 }
 
-// Cancel cancels a job
-func (m *Manager) Cancel(id string) {
+// cancel is Cancel, reporting whether the job was still active
+func (m *Manager) cancel(id string, graceful bool) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if job, ok := m.jobs[id]; ok {
+	job, ok := m.jobs[id]
+	if ok {
+		if graceful && job.canStopGracefully() {
+			job.mu.Lock()
+			job.state = StateCanceled
+			job.graceful = true
+			job.mu.Unlock()
+
+			job.stopOnce.Do(func() { close(job.stop) })
+			delete(m.jobs, id)
+			return true
+		}
+
+		// Read how far the job got before it's stopped and forgotten
+		job.mu.Lock()
+		job.state = StateCanceled
+		job.mu.Unlock()
+		ack := ipc.Msg{"graceful": false, "forced": true}
+		if job.ffmpegActive() {
+			ack["ffmpegKilled"] = true
+		}
+		if job.keepPartial() && job.currentPhase() == PhaseDownloading {
+			ack["partial"] = job.downloadTemp()
+		}
+
 		job.cancel()
 		delete(m.jobs, id)
 
-		ipc.Send(ipc.Msg{
-			"type": "canceled",
-			"id":   id,
-		})
+		job.sendCanceled(ack)
 	}
+	return ok
+}
+
+// CancelAll cancels every active job, as Cancel does without graceful,
+// and returns how many there were
+func (m *Manager) CancelAll() int {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.jobs))
+	for id := range m.jobs {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		m.Cancel(id, false)
+	}
+	return len(ids)
+}
+
+// CancelContext cancels every active job tagged with the given context, as
+// Cancel does without graceful, and returns the IDs it canceled. A job that
+// finishes meanwhile isn't among them.
+func (m *Manager) CancelContext(tag string) []string {
+	m.mu.Lock()
+	var ids []string
+	for id, job := range m.jobs {
+		if job.Context == tag {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+
+	canceled := []string{}
+	for _, id := range ids {
+		if m.cancel(id, false) {
+			canceled = append(canceled, id)
+		}
+	}
+	return canceled
 }
 
 func (job *Job) run(ctx context.Context) {
+	defer job.cancel()
 	defer func() {
 		if r := recover(); r != nil {
-			ipc.Send(ipc.Msg{
-				"type": "error",
-				"id":   job.ID,
-				"code": "panic",
-				"msg":  fmt.Sprintf("%v", r),
-			})
+			job.fail("panic", fmt.Errorf("%v", r))
 		}
 	}()
 
-	// Create temp file
-	tmpOut := job.Out + ".part"
+	if ff.IsStdoutTarget(job.Out) {
+		job.fail("invalid_output", fmt.Errorf("%w: %q, give a file path", ff.ErrStdoutOutput, job.Out))
+		return
+	}
 
-	var err error
+	if err := job.checkProtocols(); err != nil {
+		job.fail("unsupported_protocol", err)
+		return
+	}
+	if job.Mode == "live" {
+		if err := job.checkLive(); err != nil {
+			job.fail("stop_condition_required", err)
+			return
+		}
+	}
+
+	// Reject impossible conversions before spending time on the download
+	if c := job.Convert; c != nil && c.Container != "copy" {
+		err := ff.ValidateConvert(c.Container, c.VCodec, c.ACodec)
+		if err == nil {
+			err = ff.ValidateThreads(c.Threads)
+		}
+		if err == nil {
+			err = validateRotation(c.Rotation)
+		}
+		if err == nil {
+			err = validateFrameRateMode(c.FrameRateMode)
+		}
+		if err == nil {
+			err = ff.ValidateBitrateCaps(c.VCodec, c.ACodec, c.MaxVideoBitrate, c.MaxAudioBitrate)
+		}
+		if err != nil {
+			job.fail("invalid_convert", err)
+			return
+		}
+		job.reportThreads()
+	}
+
+	if err := job.prepareOutputs(); err != nil {
+		job.fail("invalid_output", err)
+		return
+	}
+
+	if err := validateSourceFile(job.SourceFile); err != nil {
+		job.fail("invalid_source_file", err)
+		return
+	}
+
+	if err := job.validateDataBudget(); err != nil {
+		job.fail("invalid_data_budget", err)
+		return
+	}
+
+	if err := job.validateWorkDir(); err != nil {
+		job.fail("invalid_work_dir", err)
+		return
+	}
+
+	if err := job.validateAudioTracks(); err != nil {
+		job.fail("invalid_audio_tracks", err)
+		return
+	}
+
+	if err := job.validateFormat(); err != nil {
+		job.fail("invalid_format", err)
+		return
+	}
+
+	if err := job.validateConcurrency(); err != nil {
+		job.fail("invalid_concurrency", err)
+		return
+	}
+
+	if _, err := job.creationTime(); err != nil {
+		job.fail("invalid_creation_time", err)
+		return
+	}
+
+	if _, _, err := job.fileMode(); err != nil {
+		job.fail("invalid_file_mode", err)
+		return
+	}
+
+	if err := job.waitForFDs(ctx); err != nil {
+		if job.stopping() {
+			job.sendCanceled(ipc.Msg{"graceful": false, "forced": true})
+		}
+		return
+	}
+	job.enterPhase(PhaseStarted)
+
+	if code, err := job.checkOutputPath(); err != nil {
+		job.fail(code, err)
+		return
+	}
+
+	// Catch an unwritable destination here instead of deep inside ffmpeg
+	dir := filepath.Dir(job.Out)
+	if err := fsutil.CheckWritable(dir); err != nil {
+		job.fail("output_not_writable", fmt.Errorf("can't write to %s: %w", dir, err))
+		return
+	}
+
+	if err := job.checkScratch(); err != nil {
+		job.fail("scratch_not_writable", err)
+		return
+	}
+
+	if job.SourceAddress != "" {
+		src, err := fetch.ResolveSource(job.SourceAddress)
+		if err != nil {
+			job.fail("bind_failed", fmt.Errorf("can't bind to %s: %w", job.SourceAddress, err))
+			return
+		}
+		log.Printf("[JOB %s] Binding to %s (%s)", job.ID, src.IP, src.Interface)
+		job.source = src
+		job.report("source", ipc.Msg{"address": src.IP.String(), "interface": src.Interface})
+		ctx = fetch.WithSource(ctx, src)
+	}
+
+	if job.Proxy != "" {
+		proxy, err := fetch.ParseProxy(job.Proxy)
+		if err != nil {
+			job.fail("invalid_proxy", err)
+			return
+		}
+		ctx = fetch.WithProxy(ctx, proxy)
+	}
+
+	// Debug runs report connections, TTFB and reconnects as net-stats
+	if job.Debug {
+		ctx = job.watchNet(ctx)
+	}
+
+	if job.Subtitles != nil {
+		if err := job.prepareSubtitles(ctx); err != nil {
+			job.fail("invalid_subtitles", err)
+			return
+		}
+		defer job.removeSubtitles()
+	}
+
+	if job.Mode == "convert" {
+		job.enterPhase(PhaseConverting)
+	} else {
+		job.enterPhase(PhaseDownloading)
+	}
+
+	if job.RawSegments {
+		job.runRawSegments(ctx)
+		return
+	}
+
+	requestedOut := job.Out
 
-	// Run download based on mode
 	switch job.Mode {
-	case "hls":
-		err = job.downloadHLS(ctx, tmpOut)
-	case "dash":
-		err = job.downloadDASH(ctx, tmpOut)
-	case "http":
-		err = job.downloadHTTP(ctx, tmpOut)
-	default:
-		err = fmt.Errorf("unsupported mode: %s", job.Mode)
+	case "hls", "dash", "http":
+		job.selectContainer()
+	case "concat", "live":
+		job.selectConcatContainer()
 	}
 
+	// Create temp file (in the scratch dir if one is set)
+	tmpOut := job.downloadTemp()
+
+	err := job.downloadWithFallback(ctx, tmpOut)
 	if err != nil {
-		os.Remove(tmpOut)
-		ipc.Send(ipc.Msg{
-			"type": "error",
-			"id":   job.ID,
-			"code": "download_failed",
-			"msg":  err.Error(),
-		})
+		if !job.keepPartial() {
+			job.discardTemps(tmpOut)
+		}
+		job.removeOutputTemps()
+		if job.stopping() {
+			job.sendCanceled(ipc.Msg{"graceful": false, "forced": true})
+			return
+		}
+		switch {
+		case job.Mode == "convert":
+			job.fail("convert_failed", err)
+		case job.urlExpired(err):
+			job.fail("url_expired", job.expiredError(err))
+		default:
+			job.fail(downloadErrorCode(err), err)
+		}
 		return
 	}
 
-	// Convert if needed
+	job.finishOutputs(ctx)
+	job.finishParts(ctx)
+
+	// Convert if needed (a graceful stop keeps the unconverted download)
 	finalOut := job.Out
-	if job.Convert != nil && job.Convert.Container != "copy" {
-		convertedOut := tmpOut + ".converted"
-		args := ff.BuildConvertArgs(tmpOut, convertedOut, job.Convert.VCodec, job.Convert.ACodec)
 
-		err = ff.RunFFmpeg(ctx, args, func(update ff.ProgressUpdate) {
-			job.sendProgress(update.BytesWritten, job.ExpTotal)
-		})
+	if job.Captions != "" && !job.stopping() {
+		job.report("captions", job.extractCaptions(ctx, tmpOut, finalOut))
+	}
 
+	if job.Convert != nil && job.Convert.Container != "copy" && job.Mode != "convert" && !job.stopping() {
+		job.enterPhase(PhaseConverting)
+		convertedOut := job.convertTemp(tmpOut)
+		err = job.runConvert(ctx, tmpOut, job.convertArgs(tmpOut, convertedOut))
+		err = job.checkFFmpegResult("convert", convertedOut, err)
 		if err != nil {
-			os.Remove(tmpOut)
-			os.Remove(convertedOut)
-			ipc.Send(ipc.Msg{
-				"type": "error",
-				"id":   job.ID,
-				"code": "convert_failed",
-				"msg":  err.Error(),
-			})
+			err = job.retryInNearestContainer(ctx, tmpOut, convertedOut, err)
+		}
+
+		if err != nil {
+			job.discardTemps(tmpOut, convertedOut)
+			if job.stopping() {
+				job.sendCanceled(ipc.Msg{"graceful": false, "forced": true})
+				return
+			}
+			job.fail("convert_failed", err)
 			return
 		}
 
-		os.Remove(tmpOut)
+		container := job.Convert.Container
+		if job.Convert.KeepSmaller {
+			convertedOut, container = job.keepSmaller(ctx, tmpOut, convertedOut)
+		}
+		if convertedOut != tmpOut {
+			os.Remove(tmpOut)
+		}
 		tmpOut = convertedOut
+		finalOut = ff.FixExt(finalOut, container)
+	} else {
+		// Copy mode, or stopped before converting: name the file after
+		// what was actually written
+		finalOut = ff.FixExt(finalOut, job.writtenContainer(tmpOut))
 	}
 
-	// Atomic rename
-	if err := os.Rename(tmpOut, finalOut); err != nil {
-		os.Remove(tmpOut)
-		ipc.Send(ipc.Msg{
-			"type": "error",
-			"id":   job.ID,
-			"code": "rename_failed",
-			"msg":  err.Error(),
+	if !strings.EqualFold(filepath.Ext(finalOut), filepath.Ext(requestedOut)) {
+		job.send(ipc.Msg{
+			"type":      "corrected_extension",
+			"id":        job.ID,
+			"requested": requestedOut,
+			"out":       finalOut,
 		})
+	}
+
+	if (job.Subtitles != nil || job.NormalizeAudio != nil || job.Thumbnail != nil) && !job.stopping() {
+		job.enterPhase(PhasePostprocessing)
+	}
+
+	if job.Subtitles != nil && !job.stopping() {
+		job.report("subtitles", job.addSubtitles(ctx, tmpOut, ff.ContainerFromPath(finalOut)))
+	}
+
+	if job.NormalizeAudio != nil && !job.stopping() {
+		job.report("loudness", job.normalizeLoudness(ctx, tmpOut, ff.ContainerFromPath(finalOut)))
+	}
+
+	if job.Thumbnail != nil && !job.stopping() {
+		job.report("thumbnailEmbedded", job.embedThumbnail(ctx, tmpOut, ff.ContainerFromPath(finalOut)))
+	}
+
+	if job.FixFaststart && !job.stopping() {
+		job.report("faststart", job.fixFaststart(ctx, tmpOut, ff.ContainerFromPath(finalOut)))
+	}
+
+	job.enterPhase(PhaseFinalizing)
+	finalOut, err = job.claimOutput(finalOut)
+	if err != nil {
+		job.discardTemps(tmpOut)
+		job.fail("output_exists", err)
+		return
+	}
+
+	// Atomic rename (or a copy with progress across filesystems)
+	if err := job.moveIntoPlace(ctx, tmpOut, finalOut); err != nil {
+		job.discardTemps(tmpOut)
+		if job.OnExisting == OnExistingRename {
+			os.Remove(finalOut) // the empty placeholder claimOutput reserved
+		}
+		if fsutil.IsNoSpace(err) {
+			job.fail("insufficient_space", err)
+			return
+		}
+		job.fail("rename_failed", err)
 		return
 	}
 
@@ -182,59 +759,851 @@ func (job *Job) run(ctx context.Context) {
 		finalSize = stat.Size()
 	}
 
+	if job.stopping() {
+		job.mu.Lock()
+		job.final = finalOut
+		job.mu.Unlock()
+
+		job.sendCanceled(ipc.Msg{
+			"graceful":     true,
+			"final":        finalOut,
+			"bytesWritten": finalSize,
+		})
+		return
+	}
+
+	job.applyCreationTime(finalOut)
+
+	if job.SourceFile != "" {
+		if path := job.writeSourceFile(finalOut); path != "" {
+			job.report("sourceFile", path)
+		}
+	}
+
+	job.applyFileMode(finalOut)
+
+	job.mu.Lock()
+	job.state = StateDone
+	job.final = finalOut
+	job.mu.Unlock()
+
 	// Send done
-	ipc.Send(ipc.Msg{
+	done := ipc.Msg{
 		"type":         "done",
 		"id":           job.ID,
 		"final":        finalOut,
 		"bytesWritten": finalSize,
+		"timeline":     job.timeline(),
+	}
+	for k, v := range job.result {
+		done[k] = v
+	}
+	job.send(done)
+}
+
+// downloadErrorCode classifies a failed download step for the error event
+func downloadErrorCode(err error) string {
+	switch {
+	case IsUnauthorized(err):
+		return "auth_required"
+	case errors.Is(err, dash.ErrUnknownRepresentation):
+		return "unknown_representation"
+	case errors.Is(err, ErrUnknownFormat):
+		return "unknown_format"
+	case fsutil.IsNoSpace(err):
+		return "insufficient_space"
+	}
+	return "download_failed"
+}
+
+// download runs the download step for the job's mode into output
+func (job *Job) download(ctx context.Context, output string) error {
+	var err error
+	switch job.Mode {
+	case "hls":
+		err = job.downloadHLS(ctx, output)
+	case "dash":
+		err = job.downloadDASH(ctx, output)
+	case "http":
+		err = job.downloadHTTP(ctx, output)
+	case "audioclip":
+		err = job.extractAudioClip(ctx, output)
+	case "concat":
+		err = job.downloadConcat(ctx, output)
+	case "convert":
+		err = job.convertInput(ctx, output)
+	case "live":
+		err = job.captureLive(ctx, output)
+	default:
+		return fmt.Errorf("unsupported mode: %s", job.Mode)
+	}
+
+	if job.downloadUsesFFmpeg() {
+		err = job.checkFFmpegResult("download", output, err)
+	}
+	return err
+}
+
+// extractCaptions writes embedded closed captions from the downloaded file to
+// a sidecar next to the final output. Failing to extract only loses the
+// sidecar, so the result is reported rather than failing the job.
+func (job *Job) extractCaptions(ctx context.Context, input, finalOut string) ipc.Msg {
+	result := ipc.Msg{"found": false, "extracted": false}
+
+	probe, err := ff.ProbeURL(input, nil)
+	if err != nil {
+		log.Printf("[JOB %s] Caption probe failed: %v", job.ID, err)
+		return result
+	}
+	if !ff.HasClosedCaptions(probe.Streams) {
+		log.Printf("[JOB %s] No embedded captions found", job.ID)
+		return result
+	}
+	result["found"] = true
+
+	sidecar := strings.TrimSuffix(finalOut, filepath.Ext(finalOut)) + ".cc." + job.Captions
+	tmp := job.tempPath(sidecar)
+
+	if err := ff.Run(ctx, ff.BuildCaptionsArgs(input, tmp, job.Captions), ff.RunOptions{LowPriority: job.lowPriority(), Env: job.ffmpegEnv()}); err != nil {
+		os.Remove(tmp)
+		log.Printf("[JOB %s] Caption extraction failed: %v", job.ID, err)
+		return result
+	}
+	if err := os.Rename(tmp, sidecar); err != nil {
+		os.Remove(tmp)
+		log.Printf("[JOB %s] Caption sidecar rename failed: %v", job.ID, err)
+		return result
+	}
+
+	result["extracted"] = true
+	result["path"] = sidecar
+	return result
+}
+
+// writtenContainer returns the container of the download step's output.
+// Go engine downloads are stored verbatim, so those are probed.
+func (job *Job) writtenContainer(path string) string {
+	if job.container != "" {
+		return job.container
+	}
+	probe, err := ff.ProbeURL(path, nil)
+	if err != nil {
+		log.Printf("[JOB %s] Can't probe download to check its extension: %v", job.ID, err)
+		return ""
+	}
+	return ff.DetectContainer(probe)
+}
+
+// moveIntoPlace renames the finished temp file to its final name. When the
+// destination is on another filesystem the copy fallback can take a while
+// for big files, so it reports progress in the "finalize" phase.
+func (job *Job) moveIntoPlace(ctx context.Context, src, dst string) error {
+	var last time.Time
+	return fsutil.MoveWith(ctx, src, dst, fsutil.MoveOptions{
+		Preallocate:   job.Preallocate,
+		OnPreallocate: job.notePreallocation("final"),
+		OnProgress: func(copied, total int64) {
+			if now := time.Now(); now.Sub(last) >= 500*time.Millisecond || copied == total {
+				last = now
+
+				var percent int
+				if total > 0 {
+					percent = int(float64(copied) * 100.0 / float64(total))
+				}
+				job.sendProgressEvent(ipc.Msg{
+					"type":          "progress",
+					"id":            job.ID,
+					"phase":         "finalize",
+					"bytesReceived": copied,
+					"totalBytes":    total,
+					"percent":       percent,
+				})
+			}
+		},
 	})
 }
 
+// embedThumbnail attaches cover art to path in place. Failures only cost the
+// thumbnail, never the download, so they are logged and reported as false.
+func (job *Job) embedThumbnail(ctx context.Context, path, container string) bool {
+	if !ff.SupportsCoverArt(container) {
+		log.Printf("[JOB %s] Container %q can't carry a thumbnail, skipping", job.ID, container)
+		return false
+	}
+
+	probe, err := ff.ProbeURL(path, nil)
+	if err != nil {
+		log.Printf("[JOB %s] Thumbnail probe failed: %v", job.ID, err)
+		return false
+	}
+
+	videoStreams := 0
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" {
+			videoStreams++
+		}
+	}
+
+	image := job.localPath(job.Thumbnail.Image)
+	if image == "" {
+		if videoStreams == 0 {
+			log.Printf("[JOB %s] No image given and no video to grab a frame from", job.ID)
+			return false
+		}
+
+		// Grab a frame 10% in, which skips most black intro frames
+		var at float64
+		if d, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+			at = d / 10
+		}
+
+		image = path + ".thumb.jpg"
+		defer os.Remove(image)
+
+		if err := ff.Run(ctx, ff.BuildThumbnailArgs(path, image, at), ff.RunOptions{LowPriority: job.lowPriority(), LocalAddr: job.localAddr(), Env: job.ffmpegEnv()}); err != nil {
+			log.Printf("[JOB %s] Frame extraction failed: %v", job.ID, err)
+			return false
+		}
+	}
+
+	withThumb := path + ".thumb"
+	args := ff.BuildEmbedThumbnailArgs(path, image, withThumb, container, videoStreams)
+	if err := job.runFFmpeg(ctx, args); err != nil {
+		os.Remove(withThumb)
+		log.Printf("[JOB %s] Embedding thumbnail failed: %v", job.ID, err)
+		return false
+	}
+
+	if err := os.Rename(withThumb, path); err != nil {
+		os.Remove(withThumb)
+		log.Printf("[JOB %s] Replacing output with thumbnailed copy failed: %v", job.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// errStopped ends a wait cut short by a graceful cancel
+var errStopped = errors.New("job stopped")
+
+// waitForFDs holds the job, or its next segment, back while the process is
+// close to its file descriptor limit, so starting it doesn't fail with "too
+// many open files". A cancel, graceful or not, ends the wait.
+func (job *Job) waitForFDs(ctx context.Context) error {
+	reported := false
+	for fdlimit.UnderPressure() {
+		if !reported {
+			open, limit, _ := fdlimit.Usage()
+			log.Printf("[JOB %s] Waiting for file descriptors (%d/%d open)", job.ID, open, limit)
+			job.send(ipc.Msg{
+				"type":    "fd_pressure",
+				"id":      job.ID,
+				"openFds": open,
+				"limit":   limit,
+			})
+			reported = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-job.stop:
+			return errStopped
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// canStopGracefully reports whether the job's current work runs through
+// ffmpeg, which can be asked to finalize its output
+func (job *Job) canStopGracefully() bool {
+	return !(job.Mode == "http" && job.Engine == "go")
+}
+
+// stopping reports whether a graceful cancel was requested
+func (job *Job) stopping() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.graceful
+}
+
+// sendCanceled reports the end of a canceled job, with how far it got:
+// the bytes received when it was stopped, the phase it was in and its
+// timeline
+func (job *Job) sendCanceled(extra ipc.Msg) {
+	job.mu.Lock()
+	received, total := job.lastBytes, job.lastTotal
+	job.mu.Unlock()
+	if total <= 0 {
+		total = job.ExpTotal
+	}
+
+	msg := ipc.Msg{
+		"type":          "canceled",
+		"id":            job.ID,
+		"bytesReceived": received,
+		"phase":         job.currentPhase(),
+		"timeline":      job.timeline(),
+	}
+	if total > 0 {
+		msg["totalBytes"] = total
+		msg["percent"] = min(int(received*100/total), 100)
+	}
+	for k, v := range extra {
+		msg[k] = v
+	}
+	job.send(msg)
+}
+
+// fail records the error and reports it, unless the job was canceled (the
+// canceled event has already been sent and the kill error is just noise)
+func (job *Job) fail(code string, err error) {
+	job.mu.Lock()
+	if job.state == StateCanceled {
+		job.mu.Unlock()
+		return
+	}
+	job.state = StateError
+	job.errCode = code
+	job.errMsg = err.Error()
+	job.mu.Unlock()
+
+	event := ipc.Msg{
+		"type":     "error",
+		"id":       job.ID,
+		"code":     code,
+		"msg":      err.Error(),
+		"timeline": job.timeline(),
+	}
+	if len(job.keptTemps) > 0 {
+		job.rememberKeptTemps()
+		event["tempFiles"] = job.keptTemps
+	}
+	job.send(event)
+}
+
+// send emits an event for this job, tagged with its batch if it has one.
+// The events that end a job jump the queue of progress waiting to go out.
+func (job *Job) send(m ipc.Msg) {
+	if job.BatchID != "" {
+		m["batchId"] = job.BatchID
+	}
+	switch m["type"] {
+	case "done", "error", "canceled":
+		ipc.SendPriority(m)
+	default:
+		ipc.Send(m)
+	}
+}
+
+// report records an extra field to include in the done event
+func (job *Job) report(key string, value interface{}) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.result == nil {
+		job.result = ipc.Msg{}
+	}
+	job.result[key] = value
+}
+
 func (job *Job) downloadHLS(ctx context.Context, output string) error {
-	args := ff.BuildHLSArgs(job.URL, output, job.Headers)
+	if job.Engine == "go" {
+		return job.downloadHLSNative(ctx, output)
+	}
+	if job.Audio != nil || job.DataBudget > 0 || job.AutoFallbackQuality || !job.sourceFormat() || len(job.AudioTracks) > 0 {
+		return job.downloadHLSVariant(ctx, output)
+	}
+
+	args := job.withOutputs(ff.BuildHLSArgs(job.URL, output, job.container, job.Headers, job.AllowProtocols))
+
+	log.Printf("[JOB %s] Running ffmpeg for HLS: ffmpeg %s", job.ID, strings.Join(ff.RedactArgs(args), " "))
+
+	return job.fetchFFmpeg(ctx, args)
+}
+
+// downloadHLSVariant picks the variant to download instead of leaving it
+// to ffmpeg: to fit a DataBudget, to fall back from a failing variant, or
+// to mux the best variant of the requested audio group with the chosen
+// EXT-X-MEDIA audio rendition, which ffmpeg wouldn't pick on its own when
+// it isn't the default
+func (job *Job) downloadHLSVariant(ctx context.Context, output string) error {
+	master, err := hls.LoadMaster(ctx, job.URL, job.Headers)
+	if errors.Is(err, hls.ErrNotMaster) && !job.sourceFormat() {
+		return fmt.Errorf("%w: %q, a single media playlist only offers %q", ErrUnknownFormat, strings.Join(job.Format, "+"), SourceFormatID)
+	}
+	if errors.Is(err, hls.ErrNotMaster) && len(job.AudioTracks) > 0 {
+		return job.fetchFFmpeg(ctx, job.multiAudioArgs(job.URL, output))
+	}
+	if errors.Is(err, hls.ErrNotMaster) && job.Audio == nil {
+		// A single media playlist, there's no quality to choose
+		args := job.withOutputs(ff.BuildHLSArgs(job.URL, output, job.container, job.Headers, job.AllowProtocols))
+		return job.fetchFFmpeg(ctx, args)
+	}
+	if err != nil {
+		return fmt.Errorf("load master playlist: %w", err)
+	}
+	if err := job.applyFormat(master); err != nil {
+		return err
+	}
+
+	var audio *hls.Rendition
+	group := ""
+	if job.Audio != nil {
+		if audio, err = master.SelectAudio(*job.Audio); err != nil {
+			return err
+		}
+		group = audio.GroupID
+	}
+
+	variant, err := job.pickVariant(ctx, master, group)
+	if err != nil {
+		return err
+	}
 
-	log.Printf("[JOB %s] Running ffmpeg for HLS: ffmpeg %s", job.ID, strings.Join(args, " "))
+	if len(job.AudioTracks) > 0 {
+		return job.fetchFFmpeg(ctx, job.multiAudioArgs(variant.URI, output))
+	}
+	if audio == nil {
+		args := job.withOutputs(ff.BuildHLSArgs(variant.URI, output, job.container, job.Headers, job.AllowProtocols))
+		log.Printf("[JOB %s] Running ffmpeg for HLS variant %s", job.ID, ff.RedactURL(variant.URI))
+		return job.fetchFFmpeg(ctx, args)
+	}
 
-	return ff.RunFFmpeg(ctx, args, func(update ff.ProgressUpdate) {
-		job.sendProgress(update.BytesWritten, job.ExpTotal)
+	job.report("audioRendition", ipc.Msg{
+		"groupId":  audio.GroupID,
+		"name":     audio.Name,
+		"language": audio.Language,
 	})
+
+	args := job.withOutputs(ff.BuildHLSRenditionArgs(variant.URI, audio.URI, audio.Language, output, job.container, job.Headers, job.AllowProtocols))
+
+	log.Printf("[JOB %s] Running ffmpeg for HLS with audio rendition %q (%s)", job.ID, audio.Name, audio.Language)
+
+	return job.fetchFFmpeg(ctx, args)
 }
 
 func (job *Job) downloadDASH(ctx context.Context, output string) error {
-	args := ff.BuildDASHArgs(job.URL, output, job.Headers)
+	if job.Engine == "go" {
+		return job.downloadDASHNative(ctx, output)
+	}
+	if job.Representations != nil {
+		return fmt.Errorf("choosing DASH representations needs the go engine")
+	}
+
+	args := job.withOutputs(ff.BuildDASHArgs(job.URL, output, job.container, job.Headers))
 
-	log.Printf("[JOB %s] Running ffmpeg for DASH: ffmpeg %s", job.ID, strings.Join(args, " "))
+	log.Printf("[JOB %s] Running ffmpeg for DASH: ffmpeg %s", job.ID, strings.Join(ff.RedactArgs(args), " "))
+
+	return job.fetchFFmpeg(ctx, args)
+}
+
+// selectContainer decides which container the download step writes. When
+// converting, the intermediate is always mkv. In copy mode the source is
+// probed so the copied codecs land in a container that can hold them, and
+// the output extension is adjusted to match.
+func (job *Job) selectContainer() {
+	if job.Convert != nil && job.Convert.Container != "copy" {
+		job.container = "mkv"
+		return
+	}
+	if job.Mode == "http" && job.Engine == "go" {
+		// Bytes are stored verbatim, there is no muxing step
+		return
+	}
 
-	return ff.RunFFmpeg(ctx, args, func(update ff.ProgressUpdate) {
-		job.sendProgress(update.BytesWritten, job.ExpTotal)
+	requested := ff.ContainerFromPath(job.Out)
+	var container, reason string
+
+	probe, err := job.probeSource()
+	switch {
+	case err != nil && requested != "":
+		container = requested
+		reason = "probe failed, keeping requested container"
+	case err != nil:
+		container = "mkv"
+		reason = "probe failed, using mkv as universal fallback"
+	default:
+		vcodec, acodec := ff.StreamCodecs(probe.Streams)
+		if requested != "" && ff.Compatible(requested, vcodec, acodec) {
+			container = requested
+			reason = "source codecs fit requested container"
+		} else {
+			container, reason = ff.PickContainer(probe.Streams)
+			if requested != "" {
+				reason = requested + " cannot hold source codecs; " + reason
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("[JOB %s] Probe for container selection failed: %v", job.ID, err)
+	}
+	if len(job.AudioTracks) > 0 {
+		container, reason = job.audioTracksContainer(container, reason)
+	}
+
+	job.container = container
+	if container != requested {
+		job.mu.Lock()
+		job.Out = ff.ReplaceExt(job.Out, container)
+		job.mu.Unlock()
+	}
+
+	log.Printf("[JOB %s] Container: %s (%s)", job.ID, container, reason)
+
+	job.send(ipc.Msg{
+		"type":      "container-selected",
+		"id":        job.ID,
+		"container": container,
+		"requested": requested,
+		"reason":    reason,
+		"out":       job.Out,
 	})
 }
 
+// probeSource probes the job's URL, through the bound source address and
+// proxy if any, unless it was probed moments ago
+func (job *Job) probeSource() (*ff.ProbeResult, error) {
+	if probe, ok := ff.CachedProbe(job.URL, job.Headers); ok {
+		return probe, nil
+	}
+	return ff.ProbeURLWithOptions(job.URL, job.Headers, job.probeOptions())
+}
+
+// probeOptions routes a probe of a network input the way the job's ffmpeg
+// runs go
+func (job *Job) probeOptions() ff.ProbeOptions {
+	return ff.ProbeOptions{LocalAddr: job.localAddr(), Proxy: job.Proxy}
+}
+
+// addStartProbe puts the source's probe result on the job-started event,
+// or why there is none; the job goes ahead either way
+func (job *Job) addStartProbe(started ipc.Msg) {
+	probe, err := job.probeSource()
+	if err != nil {
+		log.Printf("[JOB %s] Probe for job-started failed: %v", job.ID, err)
+		started["probeError"] = err.Error()
+		return
+	}
+	started["probe"] = probe
+}
+
+// localAddr returns the address ffmpeg should bind network inputs to
+func (job *Job) localAddr() string {
+	if job.source == nil {
+		return ""
+	}
+	return job.source.IP.String()
+}
+
+func (job *Job) extractAudioClip(ctx context.Context, output string) error {
+	if job.Clip == nil {
+		return fmt.Errorf("audioclip job without clip options")
+	}
+
+	// Validate against the source duration when it can be determined
+	var sourceDuration float64
+	probe, err := job.probeSource()
+	if err == nil {
+		sourceDuration, err = strconv.ParseFloat(probe.Format.Duration, 64)
+	}
+	if err != nil {
+		log.Printf("[JOB %s] Could not determine source duration: %v", job.ID, err)
+	}
+	if err := job.Clip.Validate(sourceDuration); err != nil {
+		return fmt.Errorf("invalid clip: %w", err)
+	}
+
+	clip := *job.Clip
+	if job.KeepCoverArt {
+		// Without a probe there's no telling whether there is a cover
+		if probe != nil {
+			if i := ff.CoverArtStream(probe.Streams); i >= 0 {
+				clip.CoverArt = "0:" + strconv.Itoa(i)
+				clip.CoverCodec = probe.Streams[i].CodecName
+			}
+		}
+		job.report("coverArtKept", clip.CoverArt != "")
+	}
+
+	args := ff.BuildAudioClipArgs(job.URL, output, job.Headers, clip)
+
+	log.Printf("[JOB %s] Running ffmpeg for audio clip %.3f-%.3f", job.ID, job.Clip.Start, job.Clip.End)
+
+	if err := job.fetchFFmpeg(ctx, args); err != nil {
+		return err
+	}
+
+	// Report the real length of what was produced
+	clipDuration := job.Clip.Duration()
+	if probe, err := ff.ProbeURL(output, nil); err == nil {
+		if d, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+			clipDuration = d
+		}
+	}
+	job.report("clipDuration", clipDuration)
+
+	if clip.SplitEvery > 0 {
+		return job.splitClip(ctx, output, clip)
+	}
+	return nil
+}
+
 func (job *Job) downloadHTTP(ctx context.Context, output string) error {
+	if job.Engine == "go" {
+		return job.downloadHTTPGo(ctx, output)
+	}
+
+	// ffmpeg only reports what it has written, so percent and ETA need
+	// the size up front. The job-start lookup only covers an unknown size;
+	// a size the extension passed can be stale.
+	if !job.sized {
+		job.lookupLength(ctx)
+	}
+
+	if len(job.AudioTracks) > 0 {
+		return job.fetchFFmpeg(ctx, job.multiAudioArgs(job.URL, output))
+	}
+
 	// For HTTP, just use ffmpeg to download (handles cookies/headers)
-	args := []string{}
+	args := job.withOutputs(ff.BuildHTTPArgs(job.URL, output, job.container, job.Headers))
+
+	return job.fetchFFmpeg(ctx, args)
+}
+
+// selectEngine picks the engine when the request didn't name one. Plain
+// HTTP downloads default to Go, which counts the bytes it copies and so
+// reports exact progress, unless they need ffmpeg for extra outputs.
+func (job *Job) selectEngine() {
+	if job.Engine == "" && job.Mode == "http" && len(job.Outputs) == 0 && len(job.AudioTracks) == 0 && fetchable(job.URL) {
+		job.Engine = "go"
+	}
+	// Only the Go engine can pick representations
+	if job.Engine == "" && job.Mode == "dash" && (job.Representations != nil || job.DataBudget > 0 || !job.sourceFormat()) {
+		job.Engine = "go"
+	}
+}
 
-	if len(job.Headers) > 0 {
-		headers := ""
-		for k, v := range job.Headers {
-			if headers != "" {
-				headers += "\r\n"
+// downloadHTTPGo reports progress from the bytes actually copied. The
+// server's Content-Length is exact, so it wins over the extension's
+// expected total, which is only a fallback for responses without one.
+func (job *Job) downloadHTTPGo(ctx context.Context, output string) error {
+	return job.fetchGo(ctx, job.URL, output, fetch.Options{
+		OnProgress: func(written, total int64) {
+			if total <= 0 {
+				total = job.ExpTotal
 			}
-			headers += k + ": " + v
+			job.sendProgress(written, total)
+		},
+		Preallocate:   job.Preallocate,
+		ExpectedSize:  job.ExpTotal,
+		OnPreallocate: job.notePreallocation("download"),
+	})
+}
+
+// fetchGo downloads url with the Go engine under the global rate limit
+func (job *Job) fetchGo(ctx context.Context, url, output string, opts fetch.Options) error {
+	release := job.limiter.Acquire()
+	defer release()
+
+	log.Printf("[JOB %s] Running Go HTTP download", job.ID)
+
+	opts.Headers = job.Headers
+	opts.Limiter = job.limiter
+	return fetch.Download(ctx, url, output, opts)
+}
+
+// convertInput is the "download" step of mode convert: it re-encodes the
+// local file in job.URL straight into output
+func (job *Job) convertInput(ctx context.Context, output string) error {
+	if job.Convert == nil || job.Convert.Container == "copy" {
+		return fmt.Errorf("convert job without a target container")
+	}
+	job.container = job.Convert.Container
+
+	err := job.runConvert(ctx, job.URL, job.convertArgs(job.URL, output))
+	if err != nil {
+		err = job.retryInNearestContainer(ctx, job.URL, output, err)
+		job.container = job.Convert.Container
+	}
+	return err
+}
+
+// convertArgs builds the ffmpeg args converting input to output with the
+// job's convert options, handling bitrate caps and HDR, rotated and
+// variable frame rate sources
+func (job *Job) convertArgs(input, output string) []string {
+	c := job.Convert
+	args := ff.BuildConvertArgs(input, output, c.Container, c.VCodec, c.ACodec, c.Threads)
+	args = ff.ApplyBitrateCaps(args, c.MaxVideoBitrate, c.MaxAudioBitrate)
+	if probe, err := ff.ProbeURL(input, nil); err == nil {
+		args = job.withHDR(probe.Streams, args)
+		args = job.withRotation(probe.Streams, args)
+		args = job.withFrameRate(probe.Streams, args)
+	}
+	return args
+}
+
+// runFFmpeg runs ffmpeg reporting progress against the job's expected total,
+// and in debug mode emits the redacted command line before it starts
+func (job *Job) runFFmpeg(ctx context.Context, args []string) error {
+	return job.runFFmpegInput(ctx, args, nil)
+}
+
+// fetchFFmpeg is runFFmpeg for the ffmpeg reading the job's source off the
+// network, which also reports the demuxer it detected (see inputHook)
+func (job *Job) fetchFFmpeg(ctx context.Context, args []string) error {
+	return job.runFFmpegInput(ctx, args, job.inputHook())
+}
+
+func (job *Job) runFFmpegInput(ctx context.Context, args []string, onInput ff.InputCallback) error {
+	job.setOutTime(-1)
+	defer job.setOutTime(-1)
+	opts := job.runOptions(func(update ff.ProgressUpdate) {
+		if update.Reported.Has(ff.FieldOutTime) {
+			job.setOutTime(time.Duration(update.OutTimeMs) * time.Microsecond)
+		}
+		if update.Reported.Has(ff.FieldSize) {
+			job.sendProgress(update.BytesWritten, job.ExpTotal)
 		}
-		headers += "\r\n"
-		args = append(args, "-headers", headers)
+	})
+	opts.OnInput = onInput
+	return ff.Run(ctx, args, opts)
+}
+
+// setOutTime records the media time ffmpeg's output reached, -1 when no
+// ffmpeg step is reporting one
+func (job *Job) setOutTime(d time.Duration) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.outTime = d
+}
+
+// runConvert runs a conversion reporting progress in the "convert" phase.
+// Re-encodes (VP9 and AV1 especially) can run far slower than real time, so
+// percent and ETA come from the output timestamp against the source duration
+// rather than from bytes written.
+func (job *Job) runConvert(ctx context.Context, input string, args []string) error {
+	duration, err := ff.EstimateDuration(input, nil)
+	if err != nil || duration <= 0 {
+		return job.runFFmpeg(ctx, args)
 	}
+	return job.runEncode(ctx, "convert", duration, args)
+}
 
-	args = append(args,
-		"-i", job.URL,
-		"-c", "copy",
-		output,
-	)
+// runEncode runs an ffmpeg pass over duration worth of media, reporting
+// progress in the given phase from the output timestamp
+func (job *Job) runEncode(ctx context.Context, phase string, duration time.Duration, args []string) error {
+	start := time.Now()
+	var last time.Time
+	return ff.Run(ctx, args, job.runOptions(func(update ff.ProgressUpdate) {
+		if !update.Reported.Has(ff.FieldOutTime) {
+			return
+		}
+		now := time.Now()
+		if now.Sub(last) < 500*time.Millisecond {
+			return
+		}
+		last = now
 
-	return ff.RunFFmpeg(ctx, args, func(update ff.ProgressUpdate) {
-		job.sendProgress(update.BytesWritten, job.ExpTotal)
+		done := time.Duration(update.OutTimeMs) * time.Microsecond
+		if done > duration {
+			done = duration
+		}
+
+		// Average encode speed as a multiple of real time
+		var speed float64
+		var etaSec int
+		if elapsed := now.Sub(start); elapsed > 0 && done > 0 {
+			speed = done.Seconds() / elapsed.Seconds()
+			etaSec = int((duration - done).Seconds() / speed)
+		}
+
+		job.sendProgressEvent(ipc.Msg{
+			"type":          "progress",
+			"id":            job.ID,
+			"phase":         phase,
+			"bytesReceived": update.BytesWritten,
+			"percent":       int(done * 100 / duration),
+			"etaSec":        etaSec,
+			"speed":         speed,
+			"outTime":       ff.FormatTimecode(time.Duration(update.OutTimeMs) * time.Microsecond),
+		})
+	}))
+}
+
+// runOptions returns the ffmpeg options every job step shares: graceful
+// stop, debug command reporting and the job's priority
+func (job *Job) runOptions(onProgress ff.ProgressCallback) ff.RunOptions {
+	return ff.RunOptions{
+		OnProgress:  onProgress,
+		Stop:        job.stop,
+		OnCommand:   job.commandHook(),
+		LowPriority: job.lowPriority(),
+		LocalAddr:   job.localAddr(),
+		Reconnect:   job.reconnectStatuses(),
+		Env:         job.ffmpegEnv(),
+
+		OnFinalizing: job.finalizingHook,
+		OnNet:        job.netHook(),
+	}
+}
+
+// ffmpegEnv returns the job's parts of ffmpeg's environment; a debug job
+// keeps the host's FFREPORT so ffmpeg's own report can be collected
+func (job *Job) ffmpegEnv() ff.EnvOptions {
+	return ff.EnvOptions{Proxy: job.Proxy, Report: job.Debug, Dir: job.workDir()}
+}
+
+// reportThreads reports the conversion's thread limit, and how many CPUs
+// ffmpeg's automatic choice goes by when there is none
+func (job *Job) reportThreads() {
+	job.report("threads", ipc.Msg{
+		"count": job.Convert.Threads,
+		"auto":  job.Convert.Threads == 0,
+		"cpus":  runtime.NumCPU(),
+		"nice":  job.lowPriority(),
+	})
+}
+
+// lowPriority reports whether the job's ffmpeg processes should run nice
+func (job *Job) lowPriority() bool {
+	if job.Priority != PriorityLow {
+		return false
+	}
+	if !ff.LowPrioritySupported {
+		log.Printf("[JOB %s] Low priority isn't supported on this platform, running normally", job.ID)
+		return false
+	}
+	return true
+}
+
+// commandHook emits the redacted ffmpeg command line in debug mode
+func (job *Job) commandHook() func(path string, args []string) {
+	if !job.Debug {
+		return nil
+	}
+	return func(path string, args []string) {
+		job.send(ipc.Msg{
+			"type": "ffmpeg-command",
+			"id":   job.ID,
+			"path": path,
+			"args": ff.RedactArgs(args),
+		})
+	}
+}
+
+// finalizingHook tells the extension an ffmpeg step has entered its
+// faststart pass, during which progress stops until the file is rewritten
+func (job *Job) finalizingHook() {
+	log.Printf("[JOB %s] Moving the moov atom to the front", job.ID)
+	job.enterPhase(PhaseFaststart)
+	job.send(ipc.Msg{
+		"type":   "finalizing",
+		"id":     job.ID,
+		"reason": "faststart",
 	})
 }
 
@@ -289,10 +1658,10 @@ func (job *Job) sendProgress(bytesReceived, totalBytes int64) {
 	}
 
 	job.lastBytes = bytesReceived
+	job.lastTotal = totalBytes
 	job.lastTick = now
 
-	// Send progress event
-	ipc.Send(ipc.Msg{
+	msg := ipc.Msg{
 		"type":         "progress",
 		"id":           job.ID,
 		"bytesReceived": bytesReceived,
@@ -300,7 +1669,48 @@ func (job *Job) sendProgress(bytesReceived, totalBytes int64) {
 		"speedBps":     int64(job.speedEMA),
 		"etaSec":       etaSec,
 		"percent":      percent,
-	})
+	}
+
+	// How far into the media ffmpeg's output is, for editors
+	if job.outTime >= 0 {
+		msg["outTime"] = ff.FormatTimecode(job.outTime)
+	}
+
+	// Concat parts also report where they are in the sequence
+	if p := job.part; p != nil {
+		msg["part"] = p.index + 1
+		msg["parts"] = p.count
+		msg["partBytes"] = p.bytes
+		if p.total > 0 {
+			msg["partTotalBytes"] = p.total
+		}
+		if totalBytes <= 0 {
+			msg["percent"] = p.percent()
+		}
+	}
+
+	// Segmented downloads don't know their size up front, but do know
+	// how many segments are left
+	if s := job.segments; s != nil {
+		msg["segmentsDone"] = s.done
+		msg["segments"] = s.count
+		if totalBytes <= 0 && s.count > 0 {
+			msg["percent"] = s.done * 100 / s.count
+		}
+		if s.buffer != nil && job.Debug {
+			msg["reorderBuffer"] = *s.buffer
+		}
+	}
+
+	// Report the job's share of the global cap when it draws from it
+	if job.Engine == "go" {
+		if share := job.limiter.Share(); share > 0 {
+			msg["rateShareBps"] = share
+		}
+	}
+
+	// Send progress event
+	job.sendProgressEvent(msg)
 }
 
 // ParseConvertOpts extracts convert options from message
@@ -324,6 +1734,14 @@ func ParseConvertOpts(m map[string]interface{}) *ConvertOpts {
 	if v, ok := m["acodec"].(string); ok {
 		opts.ACodec = v
 	}
+	opts.KeepSmaller, _ = m["keepSmaller"].(bool)
+	opts.PreserveHDR, _ = m["preserveHdr"].(bool)
+	opts.Threads = int(ipc.GetInt64(m, "threads"))
+	opts.Rotation = ipc.GetString(m, "rotation")
+	opts.FrameRateMode = ipc.GetString(m, "frameRateMode")
+	opts.MaxVideoBitrate = ipc.GetInt64(m, "maxVideoBitrate")
+	opts.MaxAudioBitrate = ipc.GetInt64(m, "maxAudioBitrate")
+	opts.AutoContainer = ipc.GetBool(m, "autoContainer")
 
 	return opts
 }
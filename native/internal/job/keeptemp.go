@@ -0,0 +1,91 @@
+package job
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/thecturner/vidown-native/internal/state"
+)
+
+// keptTempsState is the state file listing the folders that hold temp
+// files kept by KeepTempOnError, so the startup stale scan looks in them
+// as well as in the downloads folder
+const keptTempsState = "kept-temps.json"
+
+// maxKeptTempDirs bounds the folders keptTempsState remembers, oldest
+// dropped first
+const maxKeptTempDirs = 50
+
+var keptTempsMu sync.Mutex
+
+// discardTemps removes a failed job's temp files, or with KeepTempOnError
+// keeps them for the error event to list. A canceled job's go either way.
+func (job *Job) discardTemps(paths ...string) {
+	job.mu.Lock()
+	canceled := job.state == StateCanceled || job.graceful
+	job.mu.Unlock()
+
+	for _, p := range paths {
+		if !job.KeepTempOnError || canceled {
+			os.Remove(p)
+			continue
+		}
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			job.keptTemps = append(job.keptTemps, p)
+		}
+	}
+}
+
+// rememberKeptTemps records the folders of the temps a failed job kept
+func (job *Job) rememberKeptTemps() {
+	if len(job.keptTemps) == 0 {
+		return
+	}
+	log.Printf("[JOB %s] Keeping %d temp file(s) of the failed job: %v", job.ID, len(job.keptTemps), job.keptTemps)
+
+	keptTempsMu.Lock()
+	defer keptTempsMu.Unlock()
+
+	dirs := readKeptTempDirs()
+	for _, p := range job.keptTemps {
+		dir := filepath.Dir(p)
+		for i, d := range dirs {
+			if d == dir {
+				dirs = append(dirs[:i], dirs[i+1:]...)
+				break
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	if len(dirs) > maxKeptTempDirs {
+		dirs = dirs[len(dirs)-maxKeptTempDirs:]
+	}
+	if err := state.WriteJSON(keptTempsState, dirs); err != nil {
+		log.Printf("[JOB %s] Can't record the kept temp files: %v", job.ID, err)
+	}
+}
+
+// KeptTempDirs returns the folders failed jobs kept temp files in, those
+// that still exist
+func KeptTempDirs() []string {
+	keptTempsMu.Lock()
+	defer keptTempsMu.Unlock()
+
+	var dirs []string
+	for _, d := range readKeptTempDirs() {
+		if info, err := os.Stat(d); err == nil && info.IsDir() {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+func readKeptTempDirs() []string {
+	var dirs []string
+	if err := state.ReadJSON(keptTempsState, &dirs); err != nil && !os.IsNotExist(err) {
+		log.Printf("[JOB] Can't read the kept temp folders: %v", err)
+	}
+	return dirs
+}
@@ -0,0 +1,35 @@
+package job
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+)
+
+// lengthTimeout bounds the size lookup, which delays the download
+const lengthTimeout = 5 * time.Second
+
+// lookupLength sets ExpTotal for an http download from the server's
+// Content-Length, which beats the extension's guess. It returns the size,
+// 0 when the server doesn't tell (chunked responses) or doesn't answer.
+func (job *Job) lookupLength(ctx context.Context) int64 {
+	ctx, cancel := context.WithTimeout(ctx, lengthTimeout)
+	defer cancel()
+
+	total, err := fetch.ContentLength(ctx, job.URL, job.Headers)
+	if err != nil {
+		log.Printf("[JOB %s] Size lookup failed: %v", job.ID, err)
+		return 0
+	}
+	job.sized = true
+	if total > 0 {
+		log.Printf("[JOB %s] Content-Length: %d bytes", job.ID, total)
+		if job.ExpTotal > 0 && job.ExpTotal != total {
+			log.Printf("[JOB %s] Expected %d bytes, using the server's size", job.ID, job.ExpTotal)
+		}
+		job.ExpTotal = total
+	}
+	return total
+}
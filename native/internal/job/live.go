@@ -0,0 +1,51 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// LiveOpts says when a live capture (mode live) stops. A live stream has
+// no end of its own, so one of the two is required.
+type LiveOpts struct {
+	Duration     time.Duration // stop after this much of the stream
+	UntilStopped bool          // run until a graceful cancel
+}
+
+// ParseLiveOpts parses the live option: {durationSec, untilStopped}
+func ParseLiveOpts(m map[string]interface{}) *LiveOpts {
+	if m == nil {
+		return nil
+	}
+	return &LiveOpts{
+		Duration:     time.Duration(ipc.GetFloat64(m, "durationSec") * float64(time.Second)),
+		UntilStopped: ipc.GetBool(m, "untilStopped"),
+	}
+}
+
+// checkLive rejects a live capture without a stop condition
+func (job *Job) checkLive() error {
+	if job.Live == nil || (job.Live.Duration <= 0 && !job.Live.UntilStopped) {
+		return fmt.Errorf("live streams need live.durationSec or live.untilStopped")
+	}
+	return nil
+}
+
+// captureLive records the live stream into output. A duration limit ends
+// it like any download; otherwise a graceful cancel stops ffmpeg, which
+// finalizes what it captured.
+func (job *Job) captureLive(ctx context.Context, output string) error {
+	args := ff.BuildLiveArgs(job.URL, output, job.container, job.Live.Duration)
+	log.Printf("[JOB %s] Capturing live stream: ffmpeg %s", job.ID, strings.Join(ff.RedactArgs(args), " "))
+
+	if job.Live.Duration > 0 {
+		return job.runEncode(ctx, "capture", job.Live.Duration, args)
+	}
+	return job.runFFmpeg(ctx, args)
+}
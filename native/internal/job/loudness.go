@@ -0,0 +1,86 @@
+package job
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// LoudnessOpts normalizes the audio to an EBU R128 loudness target
+type LoudnessOpts struct {
+	Target float64 // integrated loudness in LUFS; 0 = ff.DefaultLoudnessTarget
+}
+
+// ParseLoudnessOpts parses the normalizeAudio option: true for the default
+// target, or {targetLufs}. Anything else turns normalization off.
+func ParseLoudnessOpts(v interface{}) *LoudnessOpts {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return &LoudnessOpts{}
+		}
+	case map[string]interface{}:
+		return &LoudnessOpts{Target: ipc.GetFloat64(v, "targetLufs")}
+	}
+	return nil
+}
+
+// normalizeLoudness measures the audio of path and rewrites it in place at
+// the target loudness. Like subtitles it is reported rather than failing a
+// finished download.
+func (job *Job) normalizeLoudness(ctx context.Context, path, container string) ipc.Msg {
+	target := job.NormalizeAudio.Target
+	if target == 0 {
+		target = ff.DefaultLoudnessTarget
+	}
+	result := ipc.Msg{"normalized": false, "targetLufs": target}
+	if target < -70 || target > -5 {
+		result["error"] = "targetLufs must be between -70 and -5"
+		return result
+	}
+
+	fail := func(err error) ipc.Msg {
+		log.Printf("[JOB %s] Loudness normalization failed: %v", job.ID, err)
+		result["error"] = err.Error()
+		return result
+	}
+
+	probe, err := ff.ProbeURL(path, nil)
+	if err != nil {
+		return fail(err)
+	}
+	if _, acodec := ff.StreamCodecs(probe.Streams); acodec == "" {
+		result["error"] = "no audio to normalize"
+		return result
+	}
+
+	stats, err := ff.MeasureLoudness(ctx, path, target)
+	if err != nil {
+		return fail(err)
+	}
+	result["measured"] = stats
+	log.Printf("[JOB %s] Measured %.1f LUFS (TP %.1f dBTP, LRA %.1f LU), normalizing to %.1f LUFS",
+		job.ID, stats.InputI, stats.InputTP, stats.InputLRA, target)
+
+	var acodec string
+	if job.Convert != nil {
+		acodec = job.Convert.ACodec
+	}
+	normalized := path + ".loudnorm"
+	args := ff.BuildLoudnormArgs(path, normalized, container, acodec, target, stats)
+
+	err = job.runConvert(ctx, path, args)
+	if err == nil {
+		err = os.Rename(normalized, path)
+	}
+	if err != nil {
+		os.Remove(normalized)
+		return fail(err)
+	}
+
+	result["normalized"] = true
+	return result
+}
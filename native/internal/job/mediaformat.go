@@ -0,0 +1,319 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/dash"
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/hls"
+)
+
+// SourceFormatID is the one format of a source with nothing to choose
+// between: a progressive file or a single media playlist
+const SourceFormatID = "source"
+
+// ErrUnknownFormat is returned when a download's format ID isn't one its
+// source offers
+var ErrUnknownFormat = errors.New("format not offered by the source")
+
+// MediaFormat is one downloadable format of a source, a row of a yt-dlp -F
+// style table. Its ID goes back to download as format, joined with "+" to
+// take a video-only and an audio-only format together.
+type MediaFormat struct {
+	ID         string  `json:"formatId"`
+	Kind       string  `json:"kind"`       // "av", "video" or "audio"
+	Resolution string  `json:"resolution"` // "1920x1080", or "audio only"
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FPS        float64 `json:"fps,omitempty"`
+	VCodec     string  `json:"vcodec,omitempty"`
+	ACodec     string  `json:"acodec,omitempty"`
+	BitrateBps int64   `json:"bitrateBps,omitempty"`
+	Language   string  `json:"language,omitempty"`
+
+	// FilesizeApprox is estimated from the bitrate and duration, 0 when
+	// either is unknown
+	FilesizeApprox int64 `json:"filesizeApprox,omitempty"`
+}
+
+// MediaFormats is what listMediaFormats found for a source
+type MediaFormats struct {
+	Protocol    string        `json:"protocol"` // "hls", "dash" or "http"
+	DurationSec float64       `json:"durationSec,omitempty"`
+	Live        bool          `json:"live,omitempty"`
+	Formats     []MediaFormat `json:"formats"`
+}
+
+// ListMediaFormats lists the formats of a source for the mode a download
+// of it would use: the variants and audio renditions of an HLS master
+// playlist, the representations of a DASH manifest, or, for a progressive
+// file or a single media playlist, the one format its probe describes
+func ListMediaFormats(ctx context.Context, url string, headers map[string]string, mode string) (*MediaFormats, error) {
+	if mode == "http" {
+		return probeFormats(url, headers, "http")
+	}
+
+	data, base, err := fetch.Get(ctx, url, headers)
+	if err != nil {
+		return nil, err
+	}
+	if dash.IsMPD(data) {
+		m, err := dash.Parse(data, base)
+		if err != nil {
+			return nil, err
+		}
+		return dashFormats(m), nil
+	}
+	if !hls.IsMaster(data) {
+		return probeFormats(url, headers, "hls")
+	}
+	master, err := hls.ParseMaster(bytes.NewReader(data), base)
+	if err != nil {
+		return nil, err
+	}
+	return hlsFormats(ctx, master, headers), nil
+}
+
+// hlsFormats lists a master playlist's variants and the audio renditions
+// fetched on their own. Sizes come from the first variant's duration; a
+// live playlist has none.
+func hlsFormats(ctx context.Context, master *hls.Master, headers map[string]string) *MediaFormats {
+	list := &MediaFormats{Protocol: "hls", Formats: []MediaFormat{}}
+	if len(master.Variants) > 0 {
+		media, err := hls.LoadMedia(ctx, master.Variants[0].URI, headers)
+		switch {
+		case err != nil:
+			log.Printf("[FORMATS] Could not load %s for the duration: %v", ff.RedactURL(master.Variants[0].URI), err)
+		case !media.Ended:
+			list.Live = true
+		default:
+			list.DurationSec = media.Duration()
+		}
+	}
+
+	separateAudio := make(map[string]bool)
+	for _, r := range master.AudioRenditions() {
+		if r.URI != "" {
+			separateAudio[r.GroupID] = true
+		}
+	}
+
+	ids := master.VariantFormatIDs()
+	for i, v := range master.Variants {
+		vcodec, acodec := splitCodecs(v.Codecs)
+		f := MediaFormat{
+			ID:         ids[i],
+			Kind:       "av",
+			Width:      v.Width,
+			Height:     v.Height,
+			FPS:        v.FrameRate,
+			VCodec:     vcodec,
+			ACodec:     acodec,
+			BitrateBps: variantBitrate(v),
+		}
+		if separateAudio[v.AudioGroup] {
+			f.Kind = "video"
+		}
+		f.Resolution = resolution(f.Width, f.Height, f.Kind)
+		f.FilesizeApprox = estimateSize(f.BitrateBps, list.DurationSec)
+		list.Formats = append(list.Formats, f)
+	}
+	for _, r := range master.AudioRenditions() {
+		if r.URI == "" {
+			continue
+		}
+		list.Formats = append(list.Formats, MediaFormat{
+			ID:         r.FormatID(),
+			Kind:       "audio",
+			Resolution: resolution(0, 0, "audio"),
+			Language:   r.Language,
+		})
+	}
+	return list
+}
+
+// dashFormats lists a manifest's video and audio representations
+func dashFormats(m *dash.Manifest) *MediaFormats {
+	list := &MediaFormats{Protocol: "dash", DurationSec: m.Duration.Seconds(), Live: m.Live, Formats: []MediaFormat{}}
+	add := func(reps []dash.Representation, kind string) {
+		for i := range reps {
+			r := &reps[i]
+			f := MediaFormat{
+				ID:         r.FormatID(),
+				Kind:       kind,
+				Resolution: resolution(r.Width, r.Height, kind),
+				Width:      r.Width,
+				Height:     r.Height,
+				BitrateBps: r.Bandwidth,
+				Language:   r.Language,
+			}
+			// A representation carries the one codec
+			if kind == "video" {
+				f.VCodec = r.Codecs
+			} else {
+				f.ACodec = r.Codecs
+			}
+			if !m.Live {
+				f.FilesizeApprox = estimateSize(r.Bandwidth, list.DurationSec)
+			}
+			list.Formats = append(list.Formats, f)
+		}
+	}
+	add(m.Video, "video")
+	add(m.Audio, "audio")
+	return list
+}
+
+// probeFormats describes a source with a single format from its probe,
+// reusing one the extension ran moments ago
+func probeFormats(url string, headers map[string]string, protocol string) (*MediaFormats, error) {
+	probe, ok := ff.CachedProbe(url, headers)
+	if !ok {
+		var err error
+		if probe, err = ff.ProbeURL(url, headers); err != nil {
+			return nil, err
+		}
+	}
+
+	f := MediaFormat{ID: SourceFormatID}
+	f.VCodec, f.ACodec = ff.StreamCodecs(probe.Streams)
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" && (s.Disposition == nil || s.Disposition.AttachedPic == 0) {
+			f.Width, f.Height = s.Width, s.Height
+			break
+		}
+	}
+	switch {
+	case f.VCodec != "" && f.ACodec != "":
+		f.Kind = "av"
+	case f.VCodec != "":
+		f.Kind = "video"
+	default:
+		f.Kind = "audio"
+	}
+	f.Resolution = resolution(f.Width, f.Height, f.Kind)
+
+	list := &MediaFormats{Protocol: protocol, Formats: []MediaFormat{f}}
+	list.DurationSec, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	f.BitrateBps, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	if size, err := strconv.ParseInt(probe.Format.Size, 10, 64); err == nil && size > 0 {
+		f.FilesizeApprox = size
+	} else {
+		f.FilesizeApprox = estimateSize(f.BitrateBps, list.DurationSec)
+	}
+	list.Formats[0] = f
+	return list, nil
+}
+
+// resolution is the table's resolution column
+func resolution(width, height int, kind string) string {
+	switch {
+	case kind == "audio":
+		return "audio only"
+	case width > 0 && height > 0:
+		return fmt.Sprintf("%dx%d", width, height)
+	}
+	return "unknown"
+}
+
+// splitCodecs splits an RFC 6381 codecs attribute ("avc1.64001f,mp4a.40.2")
+// into its video and audio codec
+func splitCodecs(codecs string) (vcodec, acodec string) {
+	for _, c := range strings.Split(codecs, ",") {
+		c = strings.TrimSpace(c)
+		family, _, _ := strings.Cut(c, ".")
+		switch strings.ToLower(family) {
+		case "avc1", "avc3", "hvc1", "hev1", "vp8", "vp09", "vp9", "av01", "dvh1", "dvhe":
+			if vcodec == "" {
+				vcodec = c
+			}
+		case "mp4a", "ac-3", "ec-3", "opus", "flac", "vorbis", "ac-4", "mp3":
+			if acodec == "" {
+				acodec = c
+			}
+		}
+	}
+	return vcodec, acodec
+}
+
+// validateFormat checks the Format option: at most a video and an audio
+// format, in a mode that has formats to pick between
+func (job *Job) validateFormat() error {
+	if len(job.Format) == 0 {
+		return nil
+	}
+	if len(job.Format) > 2 {
+		return fmt.Errorf("format takes at most a video and an audio format, got %d", len(job.Format))
+	}
+	for _, id := range job.Format {
+		if id == "" {
+			return fmt.Errorf("format has an empty format ID")
+		}
+	}
+	switch job.Mode {
+	case "hls", "dash":
+	default:
+		if len(job.Format) != 1 || job.Format[0] != SourceFormatID {
+			return fmt.Errorf("%s mode only offers the %q format", job.Mode, SourceFormatID)
+		}
+	}
+	if job.Mode == "dash" && job.Engine == "ffmpeg" && !job.sourceFormat() {
+		return fmt.Errorf("choosing DASH formats needs the go engine")
+	}
+	return nil
+}
+
+// sourceFormat reports whether Format names nothing beyond the source as is
+func (job *Job) sourceFormat() bool {
+	return len(job.Format) == 0 || (len(job.Format) == 1 && job.Format[0] == SourceFormatID)
+}
+
+// applyFormat resolves Format against a master playlist: the variant it
+// names becomes the job's pick (see pickVariant), the audio rendition its
+// Audio. Retries resolve it again, so a format that's already the pick
+// counts as applied.
+func (job *Job) applyFormat(master *hls.Master) error {
+	for _, id := range job.Format {
+		if id == SourceFormatID {
+			continue
+		}
+		if v, ok := master.FormatVariant(id); ok && (job.chosen == nil || job.chosen.URI == v.URI) {
+			job.chosen = v
+			continue
+		}
+		if r, ok := master.FormatAudio(id); ok && (job.Audio == nil || (job.Audio.GroupID == r.GroupID && job.Audio.Name == r.Name)) {
+			job.Audio = &hls.AudioSelector{GroupID: r.GroupID, Name: r.Name}
+			continue
+		}
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, id)
+	}
+	return nil
+}
+
+// formatSelection resolves Format against a DASH manifest into the
+// representations to download
+func (job *Job) formatSelection(m *dash.Manifest) (dash.Selection, error) {
+	var sel dash.Selection
+	for _, id := range job.Format {
+		if id == SourceFormatID {
+			continue
+		}
+		rep, video, ok := m.FormatRepresentation(id)
+		switch {
+		case ok && video && sel.Video == "":
+			sel.Video = rep.ID
+		case ok && !video && sel.Audio == "":
+			sel.Audio = rep.ID
+		default:
+			return sel, fmt.Errorf("%w: %q", ErrUnknownFormat, id)
+		}
+	}
+	return sel, nil
+}
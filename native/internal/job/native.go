@@ -0,0 +1,370 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/dash"
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/hls"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// track is one segmented stream the native engine downloads
+type track struct {
+	kind      string // "video", "audio" or "" for muxed
+	init      string // fMP4/CMAF init segment, written before the media
+	initRange *fetch.Range
+	segments  []string
+	container string // what the concatenated segments form
+	language  string
+	encrypted bool
+
+	// master and variant are set on the variant track of a master
+	// playlist, which switchVariant can move to another variant
+	master  *hls.Master
+	variant *hls.Variant
+
+	// media has each segment's timing and key, for raw segment downloads
+	// that write a playlist of their own
+	media []hls.Segment
+}
+
+// segmentProgress counts segments across all tracks of a native download
+type segmentProgress struct {
+	done  int
+	count int
+
+	// buffer is the reorder buffer of a concurrent download
+	buffer *fetch.BufferStats
+}
+
+// downloadHLSNative downloads an HLS stream with the Go segment engine
+func (job *Job) downloadHLSNative(ctx context.Context, output string) error {
+	tracks, err := job.hlsTracks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		if t.encrypted {
+			return fmt.Errorf("encrypted HLS streams need the ffmpeg engine")
+		}
+	}
+	return job.downloadTracks(ctx, tracks, output, nil)
+}
+
+// downloadDASHNative downloads a DASH stream with the Go segment engine
+func (job *Job) downloadDASHNative(ctx context.Context, output string) error {
+	tracks, err := job.dashTracks(ctx)
+	if err != nil {
+		return err
+	}
+	return job.downloadTracks(ctx, tracks, output, job.loadResume())
+}
+
+// dashTracks loads the job's manifest and picks the representations to
+// fetch: the ones the job names, else the best video and the best audio
+func (job *Job) dashTracks(ctx context.Context) ([]track, error) {
+	m, err := dash.Load(ctx, job.URL, job.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	if m.Live {
+		return nil, fmt.Errorf("live DASH streams need the ffmpeg engine")
+	}
+
+	var sel dash.Selection
+	if job.Representations != nil {
+		sel = *job.Representations
+	} else if sel, err = job.formatSelection(m); err != nil {
+		return nil, err
+	}
+	v, a, err := m.Select(sel)
+	if err != nil {
+		return nil, err
+	}
+	if job.DataBudget > 0 && sel.Video == "" {
+		if v, err = job.budgetRepresentations(m, a); err != nil {
+			return nil, err
+		}
+	}
+
+	var tracks []track
+	picked := ipc.Msg{}
+	if v != nil {
+		tracks = append(tracks, track{kind: "video", init: v.Init, segments: v.Segments, container: v.Container()})
+		picked["video"] = v.ID
+	}
+	if a != nil {
+		tracks = append(tracks, track{kind: "audio", init: a.Init, segments: a.Segments, container: a.Container(), language: a.Language})
+		picked["audio"] = a.ID
+	}
+	job.report("representations", picked)
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("manifest has no audio or video representations")
+	}
+
+	// The manifest only times the whole period; spread it evenly
+	for i := range tracks {
+		t := &tracks[i]
+		each := m.Duration.Seconds() / float64(len(t.segments))
+		for n, uri := range t.segments {
+			t.media = append(t.media, hls.Segment{URI: uri, Duration: each, Sequence: int64(n)})
+		}
+	}
+	return tracks, nil
+}
+
+// hlsTracks resolves the job URL to the media playlists to fetch: the best
+// variant, plus its separate audio rendition when it has one
+func (job *Job) hlsTracks(ctx context.Context) ([]track, error) {
+	data, base, err := fetch.Get(ctx, job.URL, job.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("load playlist: %w", err)
+	}
+
+	if !hls.IsMaster(data) {
+		if !job.sourceFormat() {
+			return nil, fmt.Errorf("%w: %q, a single media playlist only offers %q", ErrUnknownFormat, strings.Join(job.Format, "+"), SourceFormatID)
+		}
+		media, err := hls.ParseMedia(bytes.NewReader(data), base)
+		if err != nil {
+			return nil, err
+		}
+		t, err := mediaTrack("", media)
+		if err != nil {
+			return nil, err
+		}
+		return []track{t}, nil
+	}
+
+	master, err := hls.ParseMaster(bytes.NewReader(data), base)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := job.applyFormat(master); err != nil {
+		return nil, err
+	}
+
+	var audio *hls.Rendition
+	var variant *hls.Variant
+	if job.Audio != nil {
+		if audio, err = master.SelectAudio(*job.Audio); err != nil {
+			return nil, err
+		}
+		job.report("audioRendition", ipc.Msg{
+			"groupId":  audio.GroupID,
+			"name":     audio.Name,
+			"language": audio.Language,
+		})
+		variant, err = job.pickVariant(ctx, master, audio.GroupID)
+	} else {
+		variant, err = job.pickVariant(ctx, master, "")
+		if err == nil && variant.AudioGroup != "" {
+			audio = master.DefaultAudio(variant.AudioGroup)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kind := ""
+	if audio != nil {
+		kind = "video"
+	}
+	media, err := hls.LoadMedia(ctx, variant.URI, job.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("load variant playlist: %w", err)
+	}
+	t, err := mediaTrack(kind, media)
+	if err != nil {
+		return nil, err
+	}
+	t.master, t.variant = master, variant
+	tracks := []track{t}
+
+	if audio != nil {
+		media, err := hls.LoadMedia(ctx, audio.URI, job.Headers)
+		if err != nil {
+			return nil, fmt.Errorf("load audio playlist: %w", err)
+		}
+		t, err := mediaTrack("audio", media)
+		if err != nil {
+			return nil, err
+		}
+		t.language = audio.Language
+		tracks = append(tracks, t)
+	}
+
+	return tracks, nil
+}
+
+func mediaTrack(kind string, media *hls.MediaPlaylist) (track, error) {
+	if !media.Ended {
+		return track{}, fmt.Errorf("live HLS streams need the ffmpeg engine")
+	}
+
+	t := track{kind: kind, init: media.Init, initRange: media.InitRange, container: media.Container(), encrypted: media.Encrypted, media: media.Segments}
+	for _, s := range media.Segments {
+		t.segments = append(t.segments, s.URI)
+	}
+	return t, nil
+}
+
+// ranges returns the byte range of each segment, nil when no segment is a
+// slice of a larger file
+func (t track) ranges() []*fetch.Range {
+	var ranges []*fetch.Range
+	for i, s := range t.media {
+		if s.Range != nil && ranges == nil {
+			ranges = make([]*fetch.Range, len(t.media))
+		}
+		if ranges != nil {
+			ranges[i] = s.Range
+		}
+	}
+	return ranges
+}
+
+// downloadTracks fetches every track's segments. A single track is stored
+// as is, in the container its segments form; separate video and audio
+// tracks are muxed into the job's container with ffmpeg. With a resume
+// state, tracks continue from their checkpoints and the partial files are
+// kept on failure.
+func (job *Job) downloadTracks(ctx context.Context, tracks []track, output string, resume *resumeState) (err error) {
+	release := job.limiter.Acquire()
+	defer release()
+
+	count := 0
+	fragmented := false
+	for _, t := range tracks {
+		count += len(t.segments)
+		fragmented = fragmented || t.init != ""
+	}
+	job.report("fragmented", fragmented)
+
+	job.mu.Lock()
+	job.segments = &segmentProgress{count: count}
+	job.mu.Unlock()
+	defer func() {
+		job.mu.Lock()
+		job.segments = nil
+		job.mu.Unlock()
+	}()
+
+	log.Printf("[JOB %s] Native engine: %d track(s), %d segments, fragmented=%v", job.ID, len(tracks), count, fragmented)
+
+	paths := make([]string, len(tracks))
+	for i, t := range tracks {
+		paths[i] = output
+		if len(tracks) > 1 {
+			paths[i] = output + "." + t.kind
+		}
+	}
+	defer func() {
+		if len(tracks) > 1 && (err == nil || resume == nil) {
+			for _, p := range paths {
+				os.Remove(p)
+			}
+		}
+	}()
+
+	var offset int64
+	done := 0
+	for i, t := range tracks {
+		trackDone := done
+		opts := fetch.SegmentOptions{
+			Options: fetch.Options{
+				Headers: job.Headers,
+				Limiter: job.limiter,
+				OnProgress: func(written, _ int64) {
+					job.sendProgress(offset+written, job.ExpTotal)
+				},
+			},
+			OnSegment: func(n, _ int) {
+				job.mu.Lock()
+				job.segments.done = trackDone + n
+				job.mu.Unlock()
+			},
+			InitRange: t.initRange,
+			Ranges:    t.ranges(),
+
+			Concurrency:    job.SegmentConcurrency,
+			MaxBufferBytes: job.MaxBufferBytes,
+			OnBuffer:       job.recordBuffer,
+			BeforeSegment:  job.waitForFDs,
+		}
+		if resume != nil {
+			job.resumeTrack(resume, &opts, t, paths[i])
+		}
+
+		var err error
+		if t.variant != nil {
+			err = job.downloadSwitchable(ctx, t, paths[i], opts)
+		} else {
+			err = fetch.DownloadSegments(ctx, t.init, t.segments, paths[i], opts)
+		}
+		if errors.Is(err, fetch.ErrResumeMismatch) {
+			log.Printf("[JOB %s] %v, restarting %s", job.ID, err, paths[i])
+			opts.Resume = nil
+			err = fetch.DownloadSegments(ctx, t.init, t.segments, paths[i], opts)
+		}
+		if err != nil {
+			if len(tracks) > 1 {
+				return fmt.Errorf("%s track: %w", t.kind, err)
+			}
+			return err
+		}
+
+		// A variant switch changes the track's segment count
+		job.mu.Lock()
+		done = job.segments.done
+		job.mu.Unlock()
+		if info, err := os.Stat(paths[i]); err == nil {
+			offset += info.Size()
+		}
+	}
+
+	if len(tracks) == 1 {
+		job.container = tracks[0].container
+		job.clearResume()
+		return nil
+	}
+
+	if job.container == "" {
+		job.container = "mkv"
+	}
+	args := ff.BuildMuxTracksArgs(paths[0], paths[1], tracks[1].language, output, job.container)
+	if err := job.runFFmpeg(ctx, args); err != nil {
+		return err
+	}
+	job.clearResume()
+	return nil
+}
+
+// resumeTrack points opts at the track's checkpoint, when the sidecar has
+// one for the same segment list, and records new checkpoints as they come
+func (job *Job) resumeTrack(resume *resumeState, opts *fetch.SegmentOptions, t track, path string) {
+	fp := t.fingerprint()
+	if saved, ok := resume.Tracks[path]; ok {
+		if saved.Fingerprint == fp {
+			checkpoint := saved.Checkpoint
+			opts.Resume = &checkpoint
+			log.Printf("[JOB %s] Resuming %s after %d entries (%d bytes)", job.ID, path, checkpoint.Done, checkpoint.Bytes)
+		} else {
+			log.Printf("[JOB %s] Segment list of %s changed, restarting it", job.ID, path)
+		}
+	}
+
+	opts.OnCheckpoint = func(c fetch.Checkpoint) {
+		resume.Tracks[path] = trackResume{Fingerprint: fp, Checkpoint: c}
+		job.saveResume(resume)
+	}
+}
@@ -0,0 +1,132 @@
+package job
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// netStatsInterval is how often a debug job reports its network stats
+const netStatsInterval = 2 * time.Second
+
+// netWatch collects a debug job's network stats: the Go engine's requests
+// traced as they're made, ffmpeg's read from its verbose log, which tells
+// of requests and connections but not what each connection carried
+type netWatch struct {
+	fetch *fetch.NetStats
+
+	mu          sync.Mutex
+	requests    int
+	connections int
+	reconnects  int
+	remotes     map[string]int // connections per server address
+}
+
+// ffmpegNetStats is what the net-stats event reports for ffmpeg
+type ffmpegNetStats struct {
+	Requests          int           `json:"requests"`
+	ConnectionsOpened int           `json:"connectionsOpened"`
+	Reconnects        int           `json:"reconnects"`
+	Remotes           []remoteConns `json:"remotes"`
+}
+
+type remoteConns struct {
+	Remote      string `json:"remote"`
+	Connections int    `json:"connections"`
+}
+
+// watchNet starts reporting net-stats events for a debug job until ctx
+// ends, and returns ctx with the Go engine's requests traced
+func (job *Job) watchNet(ctx context.Context) context.Context {
+	w := &netWatch{fetch: fetch.NewNetStats(), remotes: make(map[string]int)}
+	job.net = w
+
+	go func() {
+		ticker := time.NewTicker(netStatsInterval)
+		defer ticker.Stop()
+
+		var lastGo, lastFFmpeg [2]int64
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// Only what moved since the last report is sent
+			if snap := w.fetch.Snapshot(); snap.Requests > 0 {
+				now := [2]int64{int64(snap.Requests), snap.Bytes}
+				if now != lastGo || snap.ActiveConnections > 0 {
+					lastGo = now
+					job.sendNetStats("go", snap)
+				}
+			}
+			if snap := w.ffmpegSnapshot(); snap.Requests > 0 || snap.ConnectionsOpened > 0 {
+				now := [2]int64{int64(snap.Requests), int64(snap.ConnectionsOpened + snap.Reconnects)}
+				if now != lastFFmpeg {
+					lastFFmpeg = now
+					job.sendNetStats("ffmpeg", snap)
+				}
+			}
+		}
+	}()
+
+	return fetch.WithNetStats(ctx, w.fetch)
+}
+
+func (job *Job) sendNetStats(engine string, stats interface{}) {
+	job.send(ipc.Msg{
+		"type":   "net-stats",
+		"id":     job.ID,
+		"engine": engine,
+		"stats":  stats,
+	})
+}
+
+// netHook feeds ffmpeg's network log lines to the job's netWatch, nil
+// outside debug mode so ffmpeg isn't made verbose for nothing
+func (job *Job) netHook() func(ff.NetEvent) {
+	if job.net == nil {
+		return nil
+	}
+	return job.net.record
+}
+
+func (w *netWatch) record(event ff.NetEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch event.Kind {
+	case ff.NetEventRequest:
+		w.requests++
+	case ff.NetEventConnected:
+		w.connections++
+		w.remotes[event.Addr]++
+	case ff.NetEventReconnect:
+		w.reconnects++
+	}
+}
+
+func (w *netWatch) ffmpegSnapshot() ffmpegNetStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	snap := ffmpegNetStats{
+		Requests:          w.requests,
+		ConnectionsOpened: w.connections,
+		Reconnects:        w.reconnects,
+		Remotes:           []remoteConns{},
+	}
+	for addr, n := range w.remotes {
+		snap.Remotes = append(snap.Remotes, remoteConns{Remote: addr, Connections: n})
+	}
+	sort.Slice(snap.Remotes, func(i, j int) bool {
+		return snap.Remotes[i].Connections > snap.Remotes[j].Connections
+	})
+	return snap
+}
@@ -0,0 +1,106 @@
+package job
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/fsutil"
+)
+
+// onExisting policies for a final name that's already taken
+const (
+	OnExistingOverwrite = "overwrite" // replace it (the default)
+	OnExistingRename    = "rename"    // use "name (1).ext" etc.
+	OnExistingError     = "error"     // fail with output_exists
+)
+
+// tempPath returns a temp name next to path that is unique to this job, so
+// concurrent jobs aiming at the same output don't clobber each other's
+// .part files. The tag is fixed when the job launches so every temp of a
+// job carries the same one. The "vidown-" marker sets the host's temps
+// apart from other programs' .part files, see staleName.
+func (job *Job) tempPath(path string) string {
+	return path + tempMarker(job.tag)
+}
+
+// tempMarker is what tempPath appends for a job's tag
+func tempMarker(tag string) string {
+	return ".vidown-" + tag + ".part"
+}
+
+// tempTag makes a job ID safe for use in a filename, falling back to a
+// random tag for IDs that are empty, long or mostly unsafe characters
+func tempTag(id string) string {
+	tag := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, id)
+
+	if tag == "" || len(tag) > 40 || len(tag) < len(id)/2 {
+		b := make([]byte, 4)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	}
+	return tag
+}
+
+// claimOutput applies the job's onExisting policy to the final name and
+// returns the name to move the finished file to
+func (job *Job) claimOutput(path string) (string, error) {
+	switch job.OnExisting {
+	case OnExistingRename:
+		unique, err := fsutil.ReserveUnique(path)
+		if err != nil {
+			return "", err
+		}
+		if unique != path {
+			log.Printf("[JOB %s] %s exists, saving as %s", job.ID, path, unique)
+			job.report("renamedFrom", path)
+		}
+		return unique, nil
+
+	case OnExistingError:
+		if _, err := os.Lstat(path); err == nil {
+			return "", fmt.Errorf("%s already exists", path)
+		}
+	}
+	return path, nil
+}
+
+// checkOutputPath rejects an Out that names a directory, which ffmpeg and
+// the final rename would otherwise fail on confusingly, and handles a
+// missing parent directory: created with CreateDirs, else an error. It
+// returns the error code along with the error.
+func (job *Job) checkOutputPath() (string, error) {
+	// A raw segment download writes a folder, so only the parent matters
+	if !job.RawSegments {
+		if job.Out == "" || os.IsPathSeparator(job.Out[len(job.Out)-1]) {
+			return "output_is_directory", fmt.Errorf("output %q has no filename", job.Out)
+		}
+		if info, err := os.Stat(job.Out); err == nil && info.IsDir() {
+			return "output_is_directory", fmt.Errorf("%s is a directory, give a file path", job.Out)
+		}
+	}
+
+	dir := filepath.Dir(filepath.Clean(job.Out))
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return "", nil
+	}
+	if !job.CreateDirs {
+		return "parent_missing", fmt.Errorf("directory %s doesn't exist", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "output_not_writable", fmt.Errorf("can't create %s: %w", dir, err)
+	}
+	log.Printf("[JOB %s] Created %s", job.ID, dir)
+	job.report("createdDir", dir)
+	return "", nil
+}
@@ -0,0 +1,147 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// ExtraOutput is an additional file produced by the download's ffmpeg
+// command (multiOutput), e.g. an mp3 alongside the video
+type ExtraOutput struct {
+	Out       string
+	Container string // from Out's extension when empty
+	VCodec    string
+	ACodec    string
+
+	tmp string
+}
+
+// prepareOutputs validates the extra outputs before anything is downloaded:
+// they need the ffmpeg engine, distinct writable paths and a container that
+// can hold the requested codecs
+func (job *Job) prepareOutputs() error {
+	if len(job.Outputs) == 0 {
+		return nil
+	}
+
+	switch {
+	case job.Mode != "hls" && job.Mode != "dash" && job.Mode != "http":
+		return fmt.Errorf("multiOutput isn't supported in %s mode", job.Mode)
+	case job.Engine == "go":
+		return fmt.Errorf("multiOutput needs the ffmpeg engine")
+	}
+
+	seen := map[string]bool{filepath.Clean(job.Out): true}
+	for i := range job.Outputs {
+		o := &job.Outputs[i]
+
+		if o.Out == "" {
+			return fmt.Errorf("output %d: missing path", i+1)
+		}
+		if ff.IsStdoutTarget(o.Out) {
+			return fmt.Errorf("output %d: %w", i+1, ff.ErrStdoutOutput)
+		}
+		if seen[filepath.Clean(o.Out)] {
+			return fmt.Errorf("output %d: %s is already an output of this job", i+1, o.Out)
+		}
+		seen[filepath.Clean(o.Out)] = true
+
+		if o.Container == "" {
+			o.Container = ff.ContainerFromPath(o.Out)
+		}
+		if o.Container == "" {
+			return fmt.Errorf("output %d: can't tell the container of %s", i+1, o.Out)
+		}
+
+		spec := o.spec()
+		if err := ff.ValidateConvert(o.Container, spec.VCodec, spec.ACodec); err != nil {
+			return fmt.Errorf("output %d: %w", i+1, err)
+		}
+
+		dir := filepath.Dir(o.Out)
+		if err := fsutil.CheckWritable(dir); err != nil {
+			return fmt.Errorf("output %d: can't write to %s: %w", i+1, dir, err)
+		}
+
+		o.tmp = job.tempPath(o.Out)
+	}
+	return nil
+}
+
+func (o *ExtraOutput) spec() ff.OutputSpec {
+	spec := ff.OutputSpec{Path: o.tmp, Container: o.Container, VCodec: o.VCodec, ACodec: o.ACodec}
+	if spec.ACodec == "" {
+		spec.ACodec = spec.DefaultACodec()
+	}
+	if spec.AudioOnly() {
+		spec.VCodec = ""
+	}
+	return spec
+}
+
+// withOutputs appends the extra outputs to a download command
+func (job *Job) withOutputs(args []string) []string {
+	if len(job.Outputs) == 0 {
+		return args
+	}
+	specs := make([]ff.OutputSpec, len(job.Outputs))
+	for i := range job.Outputs {
+		specs[i] = job.Outputs[i].spec()
+	}
+	return ff.AppendOutputs(args, specs)
+}
+
+// removeOutputTemps discards the extra outputs of a failed download
+func (job *Job) removeOutputTemps() {
+	for _, o := range job.Outputs {
+		if o.tmp != "" {
+			os.Remove(o.tmp)
+		}
+	}
+}
+
+// finishOutputs moves each extra output into place under the onExisting
+// policy and reports what was produced. A failed extra output doesn't fail
+// the job; it's reported with its error instead.
+func (job *Job) finishOutputs(ctx context.Context) {
+	if len(job.Outputs) == 0 {
+		return
+	}
+
+	var results []ipc.Msg
+	for _, o := range job.Outputs {
+		result := ipc.Msg{"out": o.Out}
+		results = append(results, result)
+
+		if err := ff.ValidateOutput(o.tmp); err != nil && err != ff.ErrNoValidator {
+			os.Remove(o.tmp)
+			result["error"] = err.Error()
+			continue
+		}
+
+		final, err := job.claimOutput(o.Out)
+		if err == nil {
+			err = fsutil.Move(ctx, o.tmp, final, nil)
+		}
+		if err != nil {
+			os.Remove(o.tmp)
+			if final != "" && job.OnExisting == OnExistingRename {
+				os.Remove(final)
+			}
+			result["error"] = err.Error()
+			continue
+		}
+
+		result["final"] = final
+		if info, err := os.Stat(final); err == nil {
+			result["bytesWritten"] = info.Size()
+		}
+	}
+	job.report("outputs", results)
+}
@@ -0,0 +1,31 @@
+package job
+
+import (
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/fsutil"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// notePreallocation returns the callback that reports how reserving the
+// space for a step ("download" or "final") went, under "preallocation" in
+// the done event; nil without Preallocate
+func (job *Job) notePreallocation(step string) func(fsutil.PreallocateResult) {
+	if !job.Preallocate {
+		return nil
+	}
+	return func(r fsutil.PreallocateResult) {
+		if !r.Applied {
+			log.Printf("[JOB %s] No preallocation for the %s file: %s", job.ID, step, r.Reason)
+		}
+
+		job.mu.Lock()
+		steps, _ := job.result["preallocation"].(ipc.Msg)
+		job.mu.Unlock()
+		if steps == nil {
+			steps = ipc.Msg{}
+		}
+		steps[step] = r
+		job.report("preallocation", steps)
+	}
+}
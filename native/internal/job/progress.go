@@ -0,0 +1,84 @@
+package job
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// CapProgressDeltas is the hello capability for compact progress: after a
+// full progress event, each one carries only the fields that changed
+// (removed fields as null), marked "delta": true, with a per-job "seq" so
+// the extension can spot gaps.
+const CapProgressDeltas = "progressDeltas"
+
+// progressStream turns a job's progress events into deltas when enabled
+type progressStream struct {
+	mu     sync.Mutex
+	deltas bool
+	seq    int64
+	last   ipc.Msg
+}
+
+func (p *progressStream) setDeltas(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deltas = enabled
+	p.last = nil // the next event is a full one
+}
+
+// encode returns the event to send for the full progress event msg
+func (p *progressStream) encode(msg ipc.Msg) ipc.Msg {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.deltas {
+		return msg
+	}
+	p.seq++
+
+	out := ipc.Msg{}
+	if p.last == nil {
+		for k, v := range msg {
+			out[k] = v
+		}
+	} else {
+		out["type"] = msg["type"]
+		out["id"] = msg["id"]
+		out["delta"] = true
+		for k, v := range msg {
+			if prev, ok := p.last[k]; !ok || !reflect.DeepEqual(prev, v) {
+				out[k] = v
+			}
+		}
+		for k := range p.last {
+			if _, ok := msg[k]; !ok {
+				out[k] = nil
+			}
+		}
+	}
+	out["seq"] = p.seq
+
+	p.last = msg
+	return out
+}
+
+// sendProgressEvent emits a progress event, as a delta when the extension
+// negotiated CapProgressDeltas
+func (job *Job) sendProgressEvent(msg ipc.Msg) {
+	job.send(job.progress.encode(msg))
+}
+
+// SetProgressDeltas switches compact progress on or off for running jobs
+// and those started later
+func (m *Manager) SetProgressDeltas(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deltas = enabled
+	for _, job := range m.jobs {
+		job.progress.setDeltas(enabled)
+	}
+}
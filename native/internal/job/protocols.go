@@ -0,0 +1,52 @@
+package job
+
+import (
+	"fmt"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+)
+
+// fetchable reports whether the Go engine can fetch url itself
+func fetchable(url string) bool {
+	scheme := ff.URLScheme(url)
+	return scheme == "http" || scheme == "https"
+}
+
+// checkProtocols rejects source URLs with a scheme the job doesn't allow
+// before anything runs, instead of leaving ffmpeg to fail on its protocol
+// whitelist with a generic error
+func (job *Job) checkProtocols() error {
+	if err := ff.ValidateProtocols(job.AllowProtocols); err != nil {
+		return err
+	}
+
+	urls := []string{job.URL}
+	switch job.Mode {
+	case "convert":
+		// The input is a local file, checked by the handler
+		return nil
+	case "concat":
+		urls = job.Parts
+	}
+	// Extra audio tracks are ffmpeg inputs as much as the source; one
+	// without a URL is left to validateAudioTracks
+	for _, t := range job.AudioTracks {
+		if t.URL != "" {
+			urls = append(urls, t.URL)
+		}
+	}
+	allow := job.AllowProtocols
+	if job.Mode == "live" && ff.IsLiveURL(job.URL) {
+		// Asking for a live capture opts into the stream's protocol
+		allow = append([]string{ff.URLScheme(job.URL)}, allow...)
+	}
+	for _, url := range urls {
+		if err := ff.CheckProtocol(url, allow); err != nil {
+			return err
+		}
+		if job.Engine == "go" && !fetchable(url) {
+			return fmt.Errorf("the Go engine only fetches http and https URLs, not %s", ff.URLScheme(url))
+		}
+	}
+	return nil
+}
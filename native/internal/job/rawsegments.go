@@ -0,0 +1,352 @@
+package job
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/hls"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// rawPlaylist is the playlist a raw segment download writes into its folder
+const rawPlaylist = "index.m3u8"
+
+// runRawSegments saves every segment of the job's HLS or DASH stream as its
+// own file in the output folder, with a local playlist referencing them,
+// instead of joining them into one file. The folder is filled under a temp
+// name and renamed into place once complete.
+func (job *Job) runRawSegments(ctx context.Context) {
+	dir := job.Out
+	if _, err := os.Lstat(dir); err == nil {
+		job.fail("output_exists", fmt.Errorf("%s already exists", dir))
+		return
+	}
+
+	tmp := job.tempPath(dir)
+	if err := os.Mkdir(tmp, 0o755); err != nil {
+		job.fail("output_not_writable", err)
+		return
+	}
+
+	written, count, err := job.saveRawSegments(ctx, tmp)
+	if err == nil {
+		job.enterPhase(PhaseFinalizing)
+		err = os.Rename(tmp, dir)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		switch {
+		case job.stopping():
+			job.sendCanceled(ipc.Msg{"graceful": false, "forced": true})
+		default:
+			job.fail(downloadErrorCode(err), err)
+		}
+		return
+	}
+
+	job.mu.Lock()
+	job.state = StateDone
+	job.final = dir
+	job.mu.Unlock()
+
+	done := ipc.Msg{
+		"type":         "done",
+		"id":           job.ID,
+		"final":        dir,
+		"playlist":     filepath.Join(dir, rawPlaylist),
+		"segments":     count,
+		"decrypted":    job.Decrypt,
+		"bytesWritten": written,
+		"timeline":     job.timeline(),
+	}
+	for k, v := range job.result {
+		done[k] = v
+	}
+	job.send(done)
+}
+
+// rawTrack is one track's files in a raw segment folder
+type rawTrack struct {
+	track
+	playlist string
+	bytes    int64
+}
+
+// saveRawSegments fetches every track's segments into dir and writes the
+// playlists, returning the bytes written and the number of segments
+func (job *Job) saveRawSegments(ctx context.Context, dir string) (int64, int, error) {
+	var tracks []track
+	var err error
+	switch job.Mode {
+	case "hls":
+		tracks, err = job.hlsTracks(ctx)
+	case "dash":
+		tracks, err = job.dashTracks(ctx)
+	default:
+		return 0, 0, fmt.Errorf("raw segments need mode hls or dash, not %s", job.Mode)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	release := job.limiter.Acquire()
+	defer release()
+
+	count := 0
+	for _, t := range tracks {
+		count += len(t.media)
+	}
+	job.mu.Lock()
+	job.segments = &segmentProgress{count: count}
+	job.mu.Unlock()
+	defer func() {
+		job.mu.Lock()
+		job.segments = nil
+		job.mu.Unlock()
+	}()
+
+	log.Printf("[JOB %s] Raw segments: %d track(s), %d segments into %s", job.ID, len(tracks), count, dir)
+
+	var written int64
+	keys := &rawKeys{data: make(map[string][]byte), files: make(map[string]string)}
+	raw := make([]rawTrack, len(tracks))
+	for i, t := range tracks {
+		prefix := t.kind
+		if prefix == "" {
+			prefix = "media"
+		}
+		r := &raw[i]
+		r.track = t
+		r.playlist = prefix + ".m3u8"
+		if len(tracks) == 1 {
+			r.playlist = rawPlaylist
+		}
+
+		fetchFile := func(url string, rng *fetch.Range, name string) error {
+			if err := job.waitForFDs(ctx); err != nil {
+				return err
+			}
+			offset := written
+			err := fetch.Download(ctx, url, filepath.Join(dir, name), fetch.Options{
+				Headers: job.Headers,
+				Limiter: job.limiter,
+				Range:   rng,
+				OnProgress: func(n, _ int64) {
+					job.sendProgress(offset+n, job.ExpTotal)
+				},
+			})
+			if info, statErr := os.Stat(filepath.Join(dir, name)); statErr == nil {
+				written = offset + info.Size()
+				r.bytes += info.Size()
+			}
+			return err
+		}
+
+		var lines []string
+		if t.init != "" {
+			name := prefix + "_init." + initExt(t.container)
+			if err := fetchFile(t.init, t.initRange, name); err != nil {
+				return 0, 0, fmt.Errorf("%s init segment: %w", prefix, err)
+			}
+			lines = append(lines, fmt.Sprintf("#EXT-X-MAP:URI=%q", name))
+		}
+
+		segExt := t.container
+		if t.init != "" {
+			segExt = "m4s"
+		}
+		var key *hls.Key
+		for n, seg := range t.media {
+			if job.stopping() {
+				return 0, 0, fmt.Errorf("stopped")
+			}
+
+			name := fmt.Sprintf("%s_%05d.%s", prefix, n+1, segExt)
+			if err := fetchFile(seg.URI, seg.Range, name); err != nil {
+				return 0, 0, fmt.Errorf("%s segment %d of %d: %w", prefix, n+1, len(t.media), err)
+			}
+
+			if seg.Key != key {
+				key = seg.Key
+				line, err := job.rawKey(ctx, dir, key, keys)
+				if err != nil {
+					return 0, 0, err
+				}
+				if line != "" {
+					lines = append(lines, line)
+				}
+			}
+			if key != nil && job.Decrypt {
+				shrunk, err := decryptFile(filepath.Join(dir, name), keys.data[key.URI], seg.IV())
+				if err != nil {
+					return 0, 0, fmt.Errorf("%s segment %d: %w", prefix, n+1, err)
+				}
+				written -= shrunk
+				r.bytes -= shrunk
+			}
+
+			lines = append(lines, fmt.Sprintf("#EXTINF:%.3f,", seg.Duration), name)
+
+			job.mu.Lock()
+			job.segments.done++
+			job.mu.Unlock()
+		}
+
+		if err := writeMediaPlaylist(filepath.Join(dir, r.playlist), t, lines); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if len(raw) > 1 {
+		if err := writeMasterPlaylist(filepath.Join(dir, rawPlaylist), raw); err != nil {
+			return 0, 0, err
+		}
+	}
+	return written, count, nil
+}
+
+// rawKeys are the encryption keys a raw segment download has fetched, by
+// URI, and the files they were saved to when segments stay encrypted
+type rawKeys struct {
+	data  map[string][]byte
+	files map[string]string
+}
+
+// rawKey fetches an encryption key the first time it's used and returns
+// the EXT-X-KEY line for the local playlist: none for clear or decrypted
+// segments, else one pointing at the key saved into dir
+func (job *Job) rawKey(ctx context.Context, dir string, key *hls.Key, keys *rawKeys) (string, error) {
+	if key == nil {
+		if job.Decrypt {
+			return "", nil
+		}
+		return "#EXT-X-KEY:METHOD=NONE", nil
+	}
+	if job.Decrypt && key.Method != "AES-128" {
+		return "", fmt.Errorf("%s segments can't be decrypted, download them without decrypt", key.Method)
+	}
+
+	if _, ok := keys.data[key.URI]; !ok {
+		data, _, err := fetch.Get(ctx, key.URI, job.Headers)
+		if err != nil {
+			return "", fmt.Errorf("load key: %w", err)
+		}
+		keys.data[key.URI] = data
+	}
+	if job.Decrypt {
+		return "", nil
+	}
+
+	name, ok := keys.files[key.URI]
+	if !ok {
+		name = fmt.Sprintf("key%d.key", len(keys.files)+1)
+		if err := os.WriteFile(filepath.Join(dir, name), keys.data[key.URI], 0o600); err != nil {
+			return "", err
+		}
+		keys.files[key.URI] = name
+	}
+
+	line := fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=%q", key.Method, name)
+	if key.IV != nil {
+		line += ",IV=0x" + hex.EncodeToString(key.IV)
+	}
+	return line, nil
+}
+
+// decryptFile decrypts an AES-128 segment in place, returning how many
+// bytes of padding were dropped
+func decryptFile(path string, key, iv []byte) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	plain, err := hls.Decrypt(data, key, iv)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data) - len(plain)), os.WriteFile(path, plain, 0o644)
+}
+
+// initExt is the extension of a track's init segment
+func initExt(container string) string {
+	if container == "webm" {
+		return "webm"
+	}
+	return "mp4"
+}
+
+// writeMediaPlaylist writes a VOD media playlist of a track's local files.
+// The media sequence is the source's, so IVs derived from it still match.
+func writeMediaPlaylist(path string, t track, entries []string) error {
+	target := 1.0
+	for _, seg := range t.media {
+		target = math.Max(target, seg.Duration)
+	}
+	version := 3
+	if t.init != "" {
+		version = 7
+	}
+	var first int64
+	if len(t.media) > 0 {
+		first = t.media[0].Sequence
+	}
+
+	lines := []string{
+		"#EXTM3U",
+		fmt.Sprintf("#EXT-X-VERSION:%d", version),
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%d", int(math.Ceil(target))),
+		fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", first),
+		"#EXT-X-PLAYLIST-TYPE:VOD",
+	}
+	lines = append(lines, entries...)
+	lines = append(lines, "#EXT-X-ENDLIST")
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+// writeMasterPlaylist ties a video track and an audio track together
+func writeMasterPlaylist(path string, tracks []rawTrack) error {
+	var seconds float64
+	var bytes int64
+	var video, audio *rawTrack
+	for i := range tracks {
+		t := &tracks[i]
+		bytes += t.bytes
+		switch t.kind {
+		case "video":
+			video = t
+			for _, seg := range t.media {
+				seconds += seg.Duration
+			}
+		case "audio":
+			audio = t
+		}
+	}
+	if video == nil || audio == nil {
+		return fmt.Errorf("expected a video and an audio track")
+	}
+
+	bandwidth := int64(1)
+	if seconds > 0 {
+		bandwidth = int64(float64(bytes*8) / seconds)
+	}
+
+	media := `#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="audio",DEFAULT=YES,AUTOSELECT=YES`
+	if audio.language != "" {
+		media += fmt.Sprintf(",LANGUAGE=%q", audio.language)
+	}
+	lines := []string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:7",
+		media + fmt.Sprintf(",URI=%q", audio.playlist),
+		fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%d,AUDIO="audio"`, bandwidth),
+		video.playlist,
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
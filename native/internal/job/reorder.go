@@ -0,0 +1,33 @@
+package job
+
+import (
+	"fmt"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+)
+
+// MaxSegmentConcurrency caps how many segments one job fetches at once
+const MaxSegmentConcurrency = 16
+
+// validateConcurrency checks the native engine's segmentConcurrency and
+// maxBufferMB options
+func (job *Job) validateConcurrency() error {
+	switch {
+	case job.SegmentConcurrency < 0 || job.SegmentConcurrency > MaxSegmentConcurrency:
+		return fmt.Errorf("segmentConcurrency must be between 1 and %d", MaxSegmentConcurrency)
+	case job.MaxBufferBytes < 0:
+		return fmt.Errorf("maxBufferMB must be positive")
+	case job.MaxBufferBytes > 0 && job.MaxBufferBytes < 1<<20:
+		return fmt.Errorf("maxBufferMB must be at least 1")
+	}
+	return nil
+}
+
+// recordBuffer keeps the reorder buffer's occupancy for debug progress
+func (job *Job) recordBuffer(stats fetch.BufferStats) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.segments != nil {
+		job.segments.buffer = &stats
+	}
+}
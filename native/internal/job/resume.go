@@ -0,0 +1,113 @@
+package job
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// resumeState is the sidecar a native DASH download keeps next to its
+// .part file, so a canceled, failed or crashed run started again with the
+// same job ID carries on from the last complete segment instead of
+// starting over
+type resumeState struct {
+	URL    string                 `json:"url"`
+	Tracks map[string]trackResume `json:"tracks"` // by track file
+}
+
+type trackResume struct {
+	// Fingerprint identifies the track's segment list, so a manifest that
+	// changed in between isn't resumed at the wrong segment
+	Fingerprint string `json:"fingerprint"`
+	fetch.Checkpoint
+}
+
+// resumable reports whether the job's engine can resume a partial download
+func (job *Job) resumable() bool {
+	return job.Mode == "dash" && job.Engine == "go"
+}
+
+// loadResume reads the job's sidecar, returning an empty state when there
+// is none or it belongs to another URL
+func (job *Job) loadResume() *resumeState {
+	state := &resumeState{URL: job.URL, Tracks: make(map[string]trackResume)}
+
+	data, err := os.ReadFile(job.sidecar)
+	if err != nil {
+		return state
+	}
+	var saved resumeState
+	if err := json.Unmarshal(data, &saved); err != nil || saved.URL != job.URL || saved.Tracks == nil {
+		log.Printf("[JOB %s] Ignoring unusable resume sidecar %s", job.ID, job.sidecar)
+		return state
+	}
+	return &saved
+}
+
+// resumeInfo summarizes what a restarted job can pick up, for job-started.
+// Tracks whose file is shorter than recorded are left out; they restart.
+func (job *Job) resumeInfo() ipc.Msg {
+	state := job.loadResume()
+
+	var done int
+	var bytes int64
+	for path, t := range state.Tracks {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() < t.Bytes {
+			continue
+		}
+		done += t.Done
+		bytes += t.Bytes
+	}
+	if done == 0 {
+		return nil
+	}
+	return ipc.Msg{"segments": done, "bytes": bytes}
+}
+
+// saveResume writes the sidecar, replacing it atomically so a crash while
+// saving leaves the previous checkpoint intact
+func (job *Job) saveResume(state *resumeState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	tmp := job.sidecar + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[JOB %s] Can't save resume point: %v", job.ID, err)
+		return
+	}
+	if err := os.Rename(tmp, job.sidecar); err != nil {
+		os.Remove(tmp)
+		log.Printf("[JOB %s] Can't save resume point: %v", job.ID, err)
+	}
+}
+
+// clearResume removes the sidecar once the download no longer needs it
+func (job *Job) clearResume() {
+	if job.sidecar != "" {
+		os.Remove(job.sidecar)
+	}
+}
+
+// keepPartial reports whether a failed or canceled download's partial
+// files should be kept for a later resume
+func (job *Job) keepPartial() bool {
+	return job.resumable()
+}
+
+// fingerprint identifies a track's segment list
+func (t *track) fingerprint() string {
+	h := sha256.New()
+	h.Write([]byte(t.init))
+	for _, s := range t.segments {
+		h.Write([]byte{'\n'})
+		h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,303 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// DefaultRetryStatuses are the HTTP statuses retried when a download doesn't
+// name its own: timeouts, rate limiting and transient server errors. Auth
+// and not-found answers (401, 403, 404) won't change on a retry.
+var DefaultRetryStatuses = []int{408, 429, 500, 502, 503, 504}
+
+const (
+	// DefaultRetryMax is how many times a failed download is retried
+	DefaultRetryMax = 2
+
+	// DefaultRetryResetBytes is how much further than any earlier attempt
+	// a failed attempt has to get for the retry count to start over
+	DefaultRetryResetBytes = 10 << 20
+
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+
+	// maxRetryAfter caps a server's Retry-After, which can ask for hours
+	maxRetryAfter = 2 * time.Minute
+)
+
+// RetryPolicy decides which failed downloads are tried again
+type RetryPolicy struct {
+	Max      int // retries after the first attempt, 0 = never retry
+	Statuses map[int]bool
+
+	// ResetBytes restarts the retry count when a failed attempt got at
+	// least this much further than every earlier one, so a flaky but
+	// progressing download isn't given up on while a stuck one still is.
+	// 0 = never reset.
+	ResetBytes int64
+
+	// Reconnect lists the HTTP statuses ("503", "5xx") ffmpeg retries a
+	// single request on before the download fails; nil = the Statuses
+	Reconnect []string
+}
+
+// ParseRetryPolicy parses the retry option: {max, statuses: [...],
+// resetAfterMB, reconnectOnHttpError: [...]}. A nil map gives the default
+// policy.
+func ParseRetryPolicy(m map[string]interface{}) *RetryPolicy {
+	p := &RetryPolicy{Max: DefaultRetryMax, Statuses: make(map[int]bool), ResetBytes: DefaultRetryResetBytes}
+	for _, s := range DefaultRetryStatuses {
+		p.Statuses[s] = true
+	}
+	if m == nil {
+		return p
+	}
+
+	if _, ok := m["max"]; ok {
+		if p.Max = int(ipc.GetInt64(m, "max")); p.Max < 0 {
+			p.Max = 0
+		}
+	}
+	if _, ok := m["resetAfterMB"]; ok {
+		if p.ResetBytes = int64(ipc.GetFloat64(m, "resetAfterMB") * (1 << 20)); p.ResetBytes < 0 {
+			p.ResetBytes = 0
+		}
+	}
+	if list, ok := m["reconnectOnHttpError"].([]interface{}); ok {
+		p.Reconnect = []string{}
+		for _, v := range list {
+			if s, ok := reconnectStatus(v); ok {
+				p.Reconnect = append(p.Reconnect, s)
+			}
+		}
+	}
+	if list, ok := m["statuses"].([]interface{}); ok {
+		p.Statuses = make(map[int]bool)
+		for _, v := range list {
+			if code, ok := v.(float64); ok && code >= 100 && code <= 599 {
+				p.Statuses[int(code)] = true
+			}
+		}
+	}
+	return p
+}
+
+// reconnectStatus reads an entry of reconnectOnHttpError: a 4xx or 5xx
+// code, as a number or string, or the class "4xx" or "5xx"
+func reconnectStatus(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case float64:
+		if s >= 400 && s <= 599 && s == float64(int(s)) {
+			return strconv.Itoa(int(s)), true
+		}
+	case string:
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "4xx" || s == "5xx" {
+			return s, true
+		}
+		if n, err := strconv.Atoi(s); err == nil && n >= 400 && n <= 599 {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// reconnectStatuses returns the statuses ffmpeg retries requests on for
+// this job: the policy's own list, else its job-level retry statuses so
+// ffmpeg tries the failing request again before the whole download is.
+// A job that never retries doesn't reconnect either.
+func (job *Job) reconnectStatuses() []string {
+	policy := job.Retry
+	if policy == nil {
+		policy = ParseRetryPolicy(nil)
+	}
+	if policy.Max == 0 {
+		return nil
+	}
+	if policy.Reconnect != nil {
+		return policy.Reconnect
+	}
+
+	var codes []int
+	for code := range policy.Statuses {
+		if code >= 400 {
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	statuses := make([]string, len(codes))
+	for i, code := range codes {
+		statuses[i] = strconv.Itoa(code)
+	}
+	return statuses
+}
+
+// httpStatus returns the HTTP status behind a download error and any
+// Retry-After the server sent. The status comes from the Go engine's
+// response or, for ffmpeg, from what it printed to stderr; 0 if neither.
+func httpStatus(err error) (int, time.Duration) {
+	var statusErr *fetch.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode, statusErr.RetryAfter
+	}
+	var exitErr *ff.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.HTTPStatus(), 0
+	}
+	return 0, 0
+}
+
+// networkFailure reports whether err is the connection failing rather than
+// the server answering: reset, refused or timed out, or a response cut
+// short. The Go engine's errors are checked by type, ffmpeg's by what it
+// printed. A host name that doesn't resolve won't on a retry either.
+func networkFailure(err error) bool {
+	var exitErr *ff.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.NetworkFailure()
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// The server closing the connection before answering
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF)
+}
+
+// IsUnauthorized reports whether err is the server refusing the request's
+// credentials (HTTP 401)
+func IsUnauthorized(err error) bool {
+	status, _ := httpStatus(err)
+	return status == http.StatusUnauthorized
+}
+
+// retryDelay doubles the wait with every attempt, unless the server said
+// how long to wait
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > maxRetryAfter {
+			return maxRetryAfter
+		}
+		return retryAfter
+	}
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay || delay <= 0 {
+		return retryMaxDelay
+	}
+	return delay
+}
+
+// downloadWithRetry runs the download step into output, trying again after
+// a backoff while it fails with one of the policy's HTTP statuses or on a
+// network failure. Every retry is announced with a retrying event naming
+// the status, or flagged network when there was none. An attempt
+// that got ResetBytes further than any before it starts the count (and the
+// backoff) over, flagged with progressReset on the event.
+func (job *Job) downloadWithRetry(ctx context.Context, output string) error {
+	policy := job.Retry
+	if policy == nil {
+		policy = ParseRetryPolicy(nil)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := job.download(ctx, output)
+		if err == nil || job.stopping() || job.canceled() {
+			return err
+		}
+
+		reset := job.progressedSinceFailure(output, policy.ResetBytes)
+		if reset {
+			log.Printf("[JOB %s] Attempt %d made progress, resetting retry count", job.ID, attempt)
+			attempt = 1
+		}
+		if attempt > policy.Max {
+			return err
+		}
+
+		status, retryAfter := httpStatus(err)
+		network := status == 0 && networkFailure(err)
+		if !policy.Statuses[status] && !network {
+			return err
+		}
+
+		delay := retryDelay(attempt, retryAfter)
+		if network {
+			log.Printf("[JOB %s] Network failure, retrying in %s (%d/%d): %v", job.ID, delay, attempt, policy.Max, err)
+		} else {
+			log.Printf("[JOB %s] HTTP %d, retrying in %s (%d/%d): %v", job.ID, status, delay, attempt, policy.Max, err)
+		}
+		msg := ipc.Msg{
+			"type":       "retrying",
+			"id":         job.ID,
+			"attempt":    attempt,
+			"maxRetries": policy.Max,
+			"status":     status,
+			"delaySec":   delay.Seconds(),
+			"msg":        err.Error(),
+
+			"progressReset": reset,
+		}
+		if network {
+			msg["network"] = true
+		}
+		job.send(msg)
+
+		if !job.keepPartial() {
+			os.Remove(output)
+		}
+		job.removeOutputTemps()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-job.stop:
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// progressedSinceFailure records how far a failed attempt got, from its
+// progress or the partial output, and reports whether that's at least
+// threshold bytes beyond the furthest earlier failure
+func (job *Job) progressedSinceFailure(output string, threshold int64) bool {
+	reached := fileSize(output)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.lastBytes > reached {
+		reached = job.lastBytes
+	}
+	progressed := threshold > 0 && reached-job.failedAt >= threshold
+	if reached > job.failedAt {
+		job.failedAt = reached
+	}
+	return progressed
+}
@@ -0,0 +1,62 @@
+package job
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// Convert rotation choices for rotated (mostly phone) sources
+const (
+	RotationBake     = "bake"     // turn the frames upright (the default)
+	RotationPreserve = "preserve" // keep the frames and the rotation metadata
+)
+
+// validateRotation checks the convert rotation option
+func validateRotation(r string) error {
+	switch r {
+	case "", RotationBake, RotationPreserve:
+		return nil
+	}
+	return fmt.Errorf("rotation must be %q or %q", RotationBake, RotationPreserve)
+}
+
+// withRotation handles a rotated source in a conversion, so the output
+// doesn't come out sideways. Filters need a re-encode, so with the video
+// copied the rotation can only stay metadata. The outcome is reported
+// under "rotation".
+func (job *Job) withRotation(streams []ff.ProbeStream, args []string) []string {
+	rotation := ff.VideoRotation(streams)
+	if rotation == 0 {
+		return args
+	}
+
+	c := job.Convert
+	reencode := c.VCodec != "" && c.VCodec != "copy"
+	bake := reencode && c.Rotation != RotationPreserve
+	if !reencode && c.Rotation == RotationBake {
+		msg := fmt.Sprintf("the video is copied, so its %d° rotation stays as metadata; set convert.vcodec to bake it in", rotation)
+		log.Printf("[JOB %s] %s", job.ID, msg)
+		job.send(ipc.Msg{
+			"type": "warning",
+			"id":   job.ID,
+			"code": "rotation_not_applied",
+			"msg":  msg,
+		})
+	}
+
+	handled := RotationPreserve
+	if bake {
+		handled = RotationBake
+	}
+	job.report("rotation", ipc.Msg{"degrees": rotation, "handled": handled})
+
+	if !reencode {
+		// Stream copy carries the display matrix over by itself
+		return args
+	}
+	in, out := ff.BuildRotationArgs(rotation, bake)
+	return ff.InsertOutputOptions(ff.InsertInputOptions(args, in...), out...)
+}
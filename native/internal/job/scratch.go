@@ -0,0 +1,53 @@
+package job
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/thecturner/vidown-native/internal/fsutil"
+)
+
+// ScratchDirs are where a job's intermediate files go instead of next to
+// the output, e.g. a fast SSD in front of a slow HDD or network share.
+// Empty fields keep the default of writing beside the output.
+type ScratchDirs struct {
+	Download string // the download's .part file and its pieces
+	Convert  string // the .converted output of the conversion step
+}
+
+// SetScratchDirs changes the scratch directories for jobs started from now on
+func (m *Manager) SetScratchDirs(dirs ScratchDirs) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scratch = dirs
+}
+
+// checkScratch verifies the job's scratch directories are still writable,
+// since they are set once and may since have been unmounted
+func (job *Job) checkScratch() error {
+	for _, dir := range []string{job.scratch.Download, job.scratch.Convert} {
+		if dir == "" {
+			continue
+		}
+		if err := fsutil.CheckWritable(dir); err != nil {
+			return fmt.Errorf("can't write to scratch dir %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// downloadTemp returns the temp file the download step writes
+func (job *Job) downloadTemp() string {
+	if job.scratch.Download == "" {
+		return job.tempPath(job.Out)
+	}
+	return job.tempPath(filepath.Join(job.scratch.Download, filepath.Base(job.Out)))
+}
+
+// convertTemp returns the file the conversion of the download temp writes
+func (job *Job) convertTemp(tmpOut string) string {
+	if job.scratch.Convert == "" {
+		return tmpOut + ".converted"
+	}
+	return filepath.Join(job.scratch.Convert, filepath.Base(tmpOut)+".converted")
+}
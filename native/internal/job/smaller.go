@@ -0,0 +1,53 @@
+package job
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// keepSmaller compares a conversion with the download it came from and
+// returns the file to keep and its container. When the conversion is
+// bigger, the original is remuxed into the target container (if its
+// codecs fit) and kept instead; the bloated conversion is removed.
+func (job *Job) keepSmaller(ctx context.Context, original, converted string) (string, string) {
+	target := job.Convert.Container
+	origSize, convSize := fileSize(original), fileSize(converted)
+	result := ipc.Msg{
+		"kept":           "converted",
+		"originalBytes":  origSize,
+		"convertedBytes": convSize,
+		"deltaBytes":     convSize - origSize, // > 0: the conversion grew
+	}
+	defer job.report("keepSmaller", result)
+
+	if convSize <= origSize || origSize == 0 {
+		return converted, target
+	}
+	log.Printf("[JOB %s] Conversion grew the file from %d to %d bytes, keeping the original", job.ID, origSize, convSize)
+	os.Remove(converted)
+
+	remuxed := converted + ".remux"
+	args := ff.BuildConvertArgs(original, remuxed, target, "copy", "copy", 0)
+	err := job.checkFFmpegResult("remux", remuxed, job.runFFmpeg(ctx, args))
+	if err != nil {
+		os.Remove(remuxed)
+		log.Printf("[JOB %s] Can't remux the original into %s, keeping it as is: %v", job.ID, target, err)
+		result["kept"] = "original"
+		return original, job.writtenContainer(original)
+	}
+
+	result["kept"] = "remuxed"
+	return remuxed, target
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,285 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/dash"
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/hls"
+)
+
+// smartPeekSize is how much of a source smartDownload reads to tell a
+// playlist or manifest from a media file
+const smartPeekSize = 4 << 10
+
+// SmartPreference is what smartDownload picks the formats by
+type SmartPreference struct {
+	Worst         bool   // the lowest quality instead of the highest
+	MaxHeight     int    // the tallest video to consider, 0 = any
+	AudioLanguage string // audio to prefer over the default, e.g. "de"
+}
+
+// ParseSmartPreference reads smartDownload's quality ("best", "worst" or
+// a height such as "720p"; best by default) and audioLanguage
+func ParseSmartPreference(m map[string]interface{}) (SmartPreference, error) {
+	pref := SmartPreference{}
+	if lang, ok := m["audioLanguage"].(string); ok {
+		pref.AudioLanguage = strings.TrimSpace(lang)
+	}
+
+	quality, _ := m["quality"].(string)
+	quality = strings.ToLower(strings.TrimSpace(quality))
+	switch quality {
+	case "", "best":
+	case "worst":
+		pref.Worst = true
+	default:
+		height, err := strconv.Atoi(strings.TrimSuffix(quality, "p"))
+		if err != nil || height <= 0 {
+			return pref, fmt.Errorf("quality must be best, worst or a height like 720p, got %q", quality)
+		}
+		pref.MaxHeight = height
+	}
+	return pref, nil
+}
+
+// SmartPlan is how smartDownload fetches a source, with the reasons for
+// each choice in Decisions
+type SmartPlan struct {
+	Protocol  string       `json:"protocol"` // "hls", "dash" or "http"
+	Mode      string       `json:"mode"`
+	Engine    string       `json:"engine,omitempty"`
+	Format    []string     `json:"format,omitempty"`
+	Video     *MediaFormat `json:"video,omitempty"`
+	Audio     *MediaFormat `json:"audio,omitempty"`
+	Decisions []string     `json:"decisions"`
+}
+
+func (p *SmartPlan) decide(format string, args ...interface{}) {
+	p.Decisions = append(p.Decisions, fmt.Sprintf(format, args...))
+}
+
+// PlanSmartDownload looks at a source and decides how to download it in
+// one file: the variant of an HLS master playlist (with its audio
+// rendition when the audio is separate) or the video and audio
+// representations of a DASH manifest that best fit pref, or, for a single
+// media playlist or a progressive file, the source as it is
+func PlanSmartDownload(ctx context.Context, url string, headers map[string]string, pref SmartPreference) (*SmartPlan, error) {
+	head, err := fetch.Peek(ctx, url, headers, smartPeekSize)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &SmartPlan{Decisions: []string{}}
+	switch {
+	case dash.IsMPD(head):
+		return plan, plan.planDASH(ctx, url, headers, pref)
+	case bytes.HasPrefix(bytes.TrimLeft(head, "\xef\xbb\xbf \t\r\n"), []byte("#EXTM3U")):
+		return plan, plan.planHLS(ctx, url, headers, pref)
+	}
+
+	plan.Protocol, plan.Mode = "http", "http"
+	plan.decide("a progressive media file, downloaded as it is")
+	return plan, nil
+}
+
+func (p *SmartPlan) planHLS(ctx context.Context, url string, headers map[string]string, pref SmartPreference) error {
+	p.Protocol, p.Mode = "hls", "hls"
+
+	data, base, err := fetch.Get(ctx, url, headers)
+	if err != nil {
+		return err
+	}
+	if !hls.IsMaster(data) {
+		p.decide("a single media playlist, there is no quality to choose")
+		return nil
+	}
+	master, err := hls.ParseMaster(bytes.NewReader(data), base)
+	if err != nil {
+		return err
+	}
+	if len(master.Variants) == 0 {
+		return fmt.Errorf("master playlist has no variants")
+	}
+
+	list := hlsFormats(ctx, master, headers)
+	variants := list.Formats[:len(master.Variants)]
+	i := pickVideoFormat(p, variants, pref, "variant")
+	video := variants[i]
+	p.Video = &video
+	p.Format = []string{video.ID}
+
+	if video.Kind == "av" {
+		p.decide("%s carries its own audio", video.ID)
+		return nil
+	}
+
+	group := master.Variants[i].AudioGroup
+	rendition, why := hlsAudio(master, group, pref.AudioLanguage)
+	if rendition == nil {
+		p.decide("audio group %q has no separate rendition to add", group)
+		return nil
+	}
+	for _, f := range list.Formats {
+		if f.ID == rendition.FormatID() {
+			audio := f
+			p.Audio = &audio
+		}
+	}
+	p.Format = append(p.Format, rendition.FormatID())
+	p.decide("muxing in audio %s (%s): %s", rendition.FormatID(), rendition.Name, why)
+	return nil
+}
+
+// hlsAudio picks the audio rendition of a variant's group: one in the
+// preferred language, else the group's default
+func hlsAudio(master *hls.Master, group, language string) (*hls.Rendition, string) {
+	if language != "" {
+		for _, r := range master.AudioRenditions() {
+			if r.GroupID == group && r.URI != "" && languageMatches(r.Language, language) {
+				r := r
+				return &r, "matches audioLanguage " + language
+			}
+		}
+	}
+	r := master.DefaultAudio(group)
+	if r == nil {
+		return nil, ""
+	}
+	if language != "" {
+		return r, "no " + language + " audio, the group's default"
+	}
+	return r, "the group's default"
+}
+
+func (p *SmartPlan) planDASH(ctx context.Context, url string, headers map[string]string, pref SmartPreference) error {
+	p.Protocol, p.Mode = "dash", "dash"
+
+	m, err := dash.Load(ctx, url, headers)
+	if err != nil {
+		return err
+	}
+	list := dashFormats(m)
+
+	var videos, audios []MediaFormat
+	for _, f := range list.Formats {
+		switch f.Kind {
+		case "video", "av":
+			videos = append(videos, f)
+		case "audio":
+			audios = append(audios, f)
+		}
+	}
+	if len(videos) > 0 {
+		video := videos[pickVideoFormat(p, videos, pref, "representation")]
+		p.Video = &video
+		p.Format = append(p.Format, video.ID)
+	}
+	if len(audios) > 0 {
+		audio, why := dashAudio(audios, pref.AudioLanguage)
+		p.Audio = &audio
+		p.Format = append(p.Format, audio.ID)
+		p.decide("audio %s: %s", audio.ID, why)
+	}
+	if len(p.Format) == 0 {
+		return fmt.Errorf("manifest has no video or audio representations")
+	}
+
+	// Only the go engine downloads chosen representations
+	p.Engine = "go"
+	p.decide("downloading the representations with the go engine and muxing them into one file")
+	return nil
+}
+
+// dashAudio picks the highest bitrate audio in the preferred language, or
+// the highest bitrate of all
+func dashAudio(audios []MediaFormat, language string) (MediaFormat, string) {
+	sort.SliceStable(audios, func(i, j int) bool { return audios[i].BitrateBps > audios[j].BitrateBps })
+	if language != "" {
+		for _, f := range audios {
+			if languageMatches(f.Language, language) {
+				return f, "the best in " + language
+			}
+		}
+		return audios[0], "no " + language + " audio, the best of all"
+	}
+	return audios[0], "the best of all"
+}
+
+// pickVideoFormat picks the video that best fits pref: the tallest (then
+// highest bitrate) at or under MaxHeight, or the lowest with Worst. With
+// nothing under MaxHeight the smallest is taken. Unknown heights count as
+// fitting.
+func pickVideoFormat(p *SmartPlan, formats []MediaFormat, pref SmartPreference, what string) int {
+	var fits []int
+	for i, f := range formats {
+		if pref.MaxHeight == 0 || f.Height == 0 || f.Height <= pref.MaxHeight {
+			fits = append(fits, i)
+		}
+	}
+	worst, fallback := pref.Worst, len(fits) == 0
+	if fallback {
+		for i := range formats {
+			fits = append(fits, i)
+		}
+		worst = true
+	}
+
+	sort.SliceStable(fits, func(a, b int) bool {
+		fa, fb := formats[fits[a]], formats[fits[b]]
+		if fa.Height != fb.Height {
+			return fa.Height > fb.Height
+		}
+		return fa.BitrateBps > fb.BitrateBps
+	})
+	pick := fits[0]
+	if worst {
+		pick = fits[len(fits)-1]
+	}
+
+	f := formats[pick]
+	desc := fmt.Sprintf("%s %s (%s, %d kbps)", what, f.ID, f.Resolution, f.BitrateBps/1000)
+	switch {
+	case fallback:
+		p.decide("%s: none of %d is %dp or lower, so the smallest", desc, len(formats), pref.MaxHeight)
+	case pref.Worst:
+		p.decide("%s: the lowest of %d", desc, len(formats))
+	case pref.MaxHeight > 0:
+		p.decide("%s: the best of %d at %dp or lower", desc, len(fits), pref.MaxHeight)
+	default:
+		p.decide("%s: the best of %d", desc, len(formats))
+	}
+	return pick
+}
+
+// languageMatches compares language tags by their primary subtag, so
+// "en" takes "en-US"
+func languageMatches(tag, want string) bool {
+	primary := func(s string) string {
+		s, _, _ = strings.Cut(strings.ToLower(s), "-")
+		return s
+	}
+	return tag != "" && primary(tag) == primary(want)
+}
+
+// Apply sets up a download job to follow the plan. An explicitly asked
+// for ffmpeg engine can't download chosen DASH representations, so it
+// keeps the engine and leaves the choice to ffmpeg instead.
+func (p *SmartPlan) Apply(job *Job) {
+	job.Mode = p.Mode
+	job.Format = p.Format
+	if p.Engine == "" {
+		return
+	}
+	if job.Engine != "" && job.Engine != p.Engine {
+		job.Format = nil
+		p.decide("engine %s was asked for, which picks its own representations", job.Engine)
+		return
+	}
+	job.Engine = p.Engine
+}
@@ -0,0 +1,121 @@
+package job
+
+import (
+	"sort"
+)
+
+// Job states
+const (
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateError    = "error"
+	StateCanceled = "canceled"
+)
+
+// Snapshot is a point-in-time view of a job, used by listJobs and to hand
+// job state over to a reattaching host process
+type Snapshot struct {
+	ID            string `json:"id"`
+	Mode          string `json:"mode"`
+	Out           string `json:"out"`
+	State         string `json:"state"`
+	BytesReceived int64  `json:"bytesReceived"`
+	TotalBytes    int64  `json:"totalBytes"`
+	Percent       int    `json:"percent"`
+	Final         string `json:"final,omitempty"`
+	Code          string `json:"code,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Context       string `json:"context,omitempty"`
+}
+
+// Snapshot returns the job's current state
+func (job *Job) Snapshot() Snapshot {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	var percent int
+	if job.lastTotal > 0 {
+		percent = int(float64(job.lastBytes) * 100.0 / float64(job.lastTotal))
+		if percent > 100 {
+			percent = 100
+		}
+	}
+	if job.state == StateDone {
+		percent = 100
+	}
+
+	return Snapshot{
+		ID:            job.ID,
+		Mode:          job.Mode,
+		Out:           job.Out,
+		State:         job.state,
+		BytesReceived: job.lastBytes,
+		TotalBytes:    job.lastTotal,
+		Percent:       percent,
+		Final:         job.final,
+		Code:          job.errCode,
+		Error:         job.errMsg,
+		Context:       job.Context,
+	}
+}
+
+// Snapshots returns the state of every active job in start order
+func (m *Manager) Snapshots() []Snapshot {
+	m.mu.Lock()
+	jobs := m.activeJobs()
+	m.mu.Unlock()
+
+	return snapshots(jobs)
+}
+
+// activeJobs returns the active jobs in start order; m.mu must be held
+func (m *Manager) activeJobs() []*Job {
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].started.Before(jobs[k].started)
+	})
+	return jobs
+}
+
+func snapshots(jobs []*Job) []Snapshot {
+	snaps := make([]Snapshot, len(jobs))
+	for i, job := range jobs {
+		snaps[i] = job.Snapshot()
+	}
+	return snaps
+}
+
+// AllSnapshots returns the active jobs in start order followed by the
+// remembered finished ones. Both are read together so a job that retires
+// meanwhile isn't missed.
+func (m *Manager) AllSnapshots() []Snapshot {
+	m.mu.Lock()
+	jobs := m.activeJobs()
+	history := make([]Snapshot, len(m.history))
+	for i, e := range m.history {
+		history[i] = e.Snapshot
+	}
+	m.mu.Unlock()
+
+	return append(snapshots(jobs), history...)
+}
+
+// Active returns the number of jobs still running
+func (m *Manager) Active() int {
+	n := 0
+	for _, snap := range m.Snapshots() {
+		if snap.State == StateRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// Wait blocks until every started job has finished
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
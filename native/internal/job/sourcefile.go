@@ -0,0 +1,76 @@
+package job
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// Source sidecar formats, recording where a download came from
+const (
+	SourceFileAuto    = "auto"    // .url on Windows, .desktop on Linux, else .source.txt
+	SourceFileURL     = "url"     // Windows internet shortcut
+	SourceFileDesktop = "desktop" // freedesktop link
+	SourceFileText    = "txt"     // plain text, readable anywhere
+)
+
+// validateSourceFile checks the sourceFile option
+func validateSourceFile(format string) error {
+	switch format {
+	case "", SourceFileAuto, SourceFileURL, SourceFileDesktop, SourceFileText:
+		return nil
+	}
+	return fmt.Errorf("sourceFile must be %q, %q, %q or %q", SourceFileAuto, SourceFileURL, SourceFileDesktop, SourceFileText)
+}
+
+// writeSourceFile writes the SourceFile sidecar next to final, recording
+// the source URL (with credentials masked) and when the download started.
+// It returns the sidecar's path; a failure only costs the sidecar, so it's
+// a source_file_failed warning rather than a failed job.
+func (job *Job) writeSourceFile(final string) string {
+	format := job.SourceFile
+	if format == SourceFileAuto {
+		switch runtime.GOOS {
+		case "windows":
+			format = SourceFileURL
+		case "linux":
+			format = SourceFileDesktop
+		default:
+			format = SourceFileText
+		}
+	}
+
+	src := ff.RedactURL(job.URL)
+	fetched := job.started.UTC().Format(time.RFC3339)
+	var path, content string
+	switch format {
+	case SourceFileURL:
+		path = final + ".url"
+		content = "[InternetShortcut]\r\nURL=" + src + "\r\n\r\n[Vidown]\r\nFetched=" + fetched + "\r\n"
+	case SourceFileDesktop:
+		path = final + ".desktop"
+		content = "[Desktop Entry]\nType=Link\nName=" + filepath.Base(final) + "\nURL=" + src + "\nComment=Downloaded " + fetched + "\n"
+	default:
+		path = strings.TrimSuffix(final, filepath.Ext(final)) + ".source.txt"
+		content = "URL: " + src + "\nFetched: " + fetched + "\n"
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		log.Printf("[JOB %s] Can't write source file: %v", job.ID, err)
+		job.send(ipc.Msg{
+			"type": "warning",
+			"id":   job.ID,
+			"code": "source_file_failed",
+			"msg":  err.Error(),
+		})
+		return ""
+	}
+	return path
+}
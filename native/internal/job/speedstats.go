@@ -0,0 +1,88 @@
+package job
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Weight of a finished job's speed in its host's running average
+const speedStatsWeight = 0.3
+
+// Jobs that moved less than this, or for less than a second, say more
+// about setup time than about the host's speed
+const minSpeedSampleBytes = 1 << 20
+
+// HostSpeed is the running average download speed of finished jobs from
+// one host, used to seed the speed estimate of new jobs from the same host
+type HostSpeed struct {
+	Host     string    `json:"host"`
+	SpeedBps int64     `json:"speedBps"`
+	Jobs     int       `json:"jobs"`
+	Updated  time.Time `json:"updated"`
+}
+
+// speedHost returns the host a job's speed is recorded under, empty for
+// local files
+func speedHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// hostSpeed returns the average speed seen from rawURL's host, 0 if no
+// job from it has finished yet; m.mu must be held
+func (m *Manager) hostSpeed(rawURL string) float64 {
+	if s, ok := m.speeds[speedHost(rawURL)]; ok {
+		return float64(s.SpeedBps)
+	}
+	return 0
+}
+
+// recordSpeed folds the average speed a retiring job achieved into its
+// host's running average
+func (m *Manager) recordSpeed(job *Job) {
+	host := speedHost(job.URL)
+	if host == "" {
+		return
+	}
+	job.mu.Lock()
+	bytes, elapsed := job.lastBytes, job.lastTick.Sub(job.started).Seconds()
+	job.mu.Unlock()
+	if bytes < minSpeedSampleBytes || elapsed < 1 {
+		return
+	}
+	speed := float64(bytes) / elapsed
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.speeds[host]
+	if !ok {
+		s = &HostSpeed{Host: host}
+		m.speeds[host] = s
+	} else {
+		speed = speedStatsWeight*speed + (1-speedStatsWeight)*float64(s.SpeedBps)
+	}
+	s.SpeedBps = int64(speed)
+	s.Jobs++
+	s.Updated = time.Now()
+}
+
+// SpeedStats returns the average download speed per host, fastest first
+func (m *Manager) SpeedStats() []HostSpeed {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]HostSpeed, 0, len(m.speeds))
+	for _, s := range m.speeds {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].SpeedBps > stats[j].SpeedBps
+	})
+	return stats
+}
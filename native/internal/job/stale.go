@@ -0,0 +1,115 @@
+package job
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultStaleAge is how old a leftover temp file must be before it counts
+// as abandoned. Running jobs touch theirs constantly, and a resumable
+// download is rarely picked up again after a day.
+const DefaultStaleAge = 24 * time.Hour
+
+// staleName matches the temp files jobs leave behind when the host crashes
+// or is killed: "<out>.vidown-<tag>.part" and everything derived from it
+// (.converted, .resume, .subs.*, track and part pieces), plus ffmpeg's
+// default two-pass logs. The marker keeps other download managers' .part
+// files out.
+var staleName = regexp.MustCompile(`(\.vidown-[A-Za-z0-9_-]+\.part(\..+)?$)|(^ffmpeg2pass-\d+\.log(\.mbtree)?$)`)
+
+// StaleFile is a leftover temp file found by FindStale
+type StaleFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// FindStale lists the temp files in dirs (not their subdirectories) that
+// haven't been modified for olderThan and don't belong to a job running in
+// this process
+func (m *Manager) FindStale(dirs []string, olderThan time.Duration) []StaleFile {
+	tags := m.activeTags()
+	cutoff := time.Now().Add(-olderThan)
+
+	var stale []StaleFile
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || !staleName.MatchString(name) || inUse(name, tags) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			stale = append(stale, StaleFile{
+				Path:     filepath.Join(dir, name),
+				Size:     info.Size(),
+				Modified: info.ModTime(),
+			})
+		}
+	}
+	return stale
+}
+
+// RemoveStale deletes the given paths, each of which must still be one of
+// the stale files FindStale reports for dirs, so a path that was named
+// stale earlier but has since been picked up by a job is left alone. It
+// returns the removed paths and why the others weren't.
+func (m *Manager) RemoveStale(paths, dirs []string, olderThan time.Duration) (removed []string, failed map[string]error) {
+	current := make(map[string]bool)
+	for _, f := range m.FindStale(dirs, olderThan) {
+		current[f.Path] = true
+	}
+
+	failed = make(map[string]error)
+	for _, path := range paths {
+		if !current[path] {
+			failed[path] = fmt.Errorf("not a stale temp file")
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			failed[path] = err
+			continue
+		}
+		removed = append(removed, path)
+	}
+	return removed, failed
+}
+
+// activeTags returns the temp tags of the jobs still running
+func (m *Manager) activeTags() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tags []string
+	for _, job := range m.jobs {
+		if job.tag != "" {
+			tags = append(tags, job.tag)
+		}
+	}
+	return tags
+}
+
+func inUse(name string, tags []string) bool {
+	for _, tag := range tags {
+		if strings.Contains(name, tempMarker(tag)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// SubtitleOpts adds a subtitle file to the output, as a selectable track by
+// default or burned into the picture for players without soft subs
+type SubtitleOpts struct {
+	Src      string // local path or http(s) URL of an SRT/WebVTT/ASS file
+	Language string // ISO 639 code for the track
+	Burn     bool   // render into the video, which forces a re-encode
+	Style    string // ASS force_style for burning; "" = ff.DefaultSubtitleStyle
+}
+
+// prepareSubtitles makes sure the subtitle file can be read before any time
+// is spent downloading: local files must exist, remote ones are fetched
+// next to the temp output with the job's headers
+func (job *Job) prepareSubtitles(ctx context.Context) error {
+	src := job.localPath(job.Subtitles.Src)
+	if src == "" {
+		return fmt.Errorf("no subtitle file given")
+	}
+
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		info, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("subtitle file: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("subtitle file %s is not a regular file", src)
+		}
+		job.subsFile = src
+		return nil
+	}
+
+	// Keep the extension; the subtitles filter picks the parser from it
+	ext := strings.ToLower(path.Ext(u.Path))
+	switch ext {
+	case ".srt", ".vtt", ".ass", ".ssa":
+	default:
+		ext = ".srt"
+	}
+	local := job.downloadTemp() + ".subs" + ext
+	if err := fetch.Download(ctx, src, local, fetch.Options{Headers: job.Headers}); err != nil {
+		os.Remove(local)
+		return fmt.Errorf("fetch subtitles: %w", err)
+	}
+	job.subsFile = local
+	job.subsTemp = true
+	return nil
+}
+
+// removeSubtitles deletes a subtitle file prepareSubtitles downloaded
+func (job *Job) removeSubtitles() {
+	if job.subsTemp {
+		os.Remove(job.subsFile)
+	}
+}
+
+// addSubtitles adds the prepared subtitles to path in place. Like thumbnails
+// they are reported rather than failing a finished download.
+func (job *Job) addSubtitles(ctx context.Context, path, container string) ipc.Msg {
+	opts := job.Subtitles
+	result := ipc.Msg{"mode": "embedded", "added": false}
+
+	withSubs := path + ".subbed"
+	var args []string
+	if opts.Burn {
+		result["mode"] = "burned"
+
+		probe, err := ff.ProbeURL(path, nil)
+		if err == nil {
+			if vcodec, _ := ff.StreamCodecs(probe.Streams); vcodec == "" {
+				err = fmt.Errorf("no video to burn subtitles into")
+			}
+		}
+		if err != nil {
+			log.Printf("[JOB %s] Can't burn subtitles: %v", job.ID, err)
+			result["error"] = err.Error()
+			return result
+		}
+
+		var vcodec string
+		if job.Convert != nil {
+			vcodec = job.Convert.VCodec
+		}
+		args = ff.BuildBurnSubtitlesArgs(path, job.subsFile, withSubs, container, vcodec, opts.Style)
+
+		job.send(ipc.Msg{
+			"type": "warning",
+			"id":   job.ID,
+			"code": "subtitles_reencode",
+			"msg":  "burning subtitles into the video requires re-encoding it",
+		})
+		result["reencoded"] = true
+	} else {
+		var err error
+		if args, err = ff.BuildSubtitleArgs(path, job.subsFile, withSubs, container, opts.Language); err != nil {
+			log.Printf("[JOB %s] Can't embed subtitles: %v", job.ID, err)
+			result["error"] = err.Error()
+			return result
+		}
+	}
+
+	var err error
+	if opts.Burn {
+		err = job.runConvert(ctx, path, args)
+	} else {
+		err = job.runFFmpeg(ctx, args)
+	}
+	if err == nil {
+		err = os.Rename(withSubs, path)
+	}
+	if err != nil {
+		os.Remove(withSubs)
+		log.Printf("[JOB %s] Adding subtitles failed: %v", job.ID, err)
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["added"] = true
+	return result
+}
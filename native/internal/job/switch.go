@@ -0,0 +1,299 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/thecturner/vidown-native/internal/fetch"
+	"github.com/thecturner/vidown-native/internal/hls"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// ErrUnknownJob is returned for commands naming a job that isn't running
+var ErrUnknownJob = errors.New("no active job with this id")
+
+// variantSwitch is the state of an HLS variant track that switchVariant
+// can move to another variant while it downloads
+type variantSwitch struct {
+	master    *hls.Master
+	current   *hls.Variant
+	pending   *hls.Variant // requested, taken at the next segment boundary
+	container string       // what the segments form, "ts" or "mp4"
+}
+
+// SwitchVariant asks a running HLS job to continue its download from the
+// variant sel picks. The switch happens once the segment in flight is
+// written; the job reports it with a variant-switched event. Only MPEG-TS
+// streams switch, to a variant of the same codecs: the parts are joined by
+// appending them, which players follow across a change of resolution or
+// bitrate but not of codec, and fragmented MP4 parts can't be joined
+// without re-encoding.
+func (m *Manager) SwitchVariant(id string, sel hls.VariantSelector) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	sw := job.switcher
+	if sw == nil {
+		return fmt.Errorf("job isn't downloading an HLS variant with the Go engine")
+	}
+	if sw.container != "ts" {
+		return fmt.Errorf("switching variants needs an MPEG-TS stream, this one is fragmented MP4")
+	}
+	// A separate audio track was picked for the current variant's group
+	v, err := sw.master.SelectVariant(sel, sw.current.AudioGroup)
+	if err != nil {
+		return err
+	}
+	if v.URI == sw.current.URI {
+		return fmt.Errorf("already downloading that variant")
+	}
+	if !sameCodecs(v.Codecs, sw.current.Codecs) {
+		return fmt.Errorf("variant codecs %q differ from the current %q", v.Codecs, sw.current.Codecs)
+	}
+	sw.pending = v
+	return nil
+}
+
+// takeSwitch returns and clears the pending switch, nil if there is none
+func (job *Job) takeSwitch() *hls.Variant {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.switcher == nil {
+		return nil
+	}
+	v := job.switcher.pending
+	job.switcher.pending = nil
+	return v
+}
+
+// sameCodecs reports whether two CODECS attributes name the same codecs,
+// ignoring profile and level ("avc1.64001f" and "avc1.4d401e" match). An
+// undeclared list matches anything.
+func sameCodecs(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+	families := func(codecs string) map[string]bool {
+		set := make(map[string]bool)
+		for _, c := range strings.Split(codecs, ",") {
+			family, _, _ := strings.Cut(strings.TrimSpace(c), ".")
+			set[family] = true
+		}
+		return set
+	}
+	fa, fb := families(a), families(b)
+	if len(fa) != len(fb) {
+		return false
+	}
+	for f := range fa {
+		if !fb[f] {
+			return false
+		}
+	}
+	return true
+}
+
+func (job *Job) switchPending() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.switcher != nil && job.switcher.pending != nil
+}
+
+// downloadSwitchable downloads an HLS variant track into output, moving to
+// another variant when switchVariant asks. The segments written so far are
+// kept and the new variant continues from the segment starting nearest the
+// same point of the stream, into a part file of its own; the parts are
+// joined into output at the end.
+func (job *Job) downloadSwitchable(ctx context.Context, t track, output string, opts fetch.SegmentOptions) error {
+	job.mu.Lock()
+	job.switcher = &variantSwitch{master: t.master, current: t.variant, container: t.container}
+	job.mu.Unlock()
+	defer func() {
+		job.mu.Lock()
+		job.switcher = nil
+		job.mu.Unlock()
+	}()
+
+	parts := []string{output}
+	defer func() {
+		for _, p := range parts[1:] {
+			os.Remove(p)
+		}
+	}()
+
+	onSegment, onProgress := opts.OnSegment, opts.OnProgress
+	kept := 0           // segments written by earlier parts
+	var keptBytes int64 // and their size
+	var start float64   // stream time the current part starts at
+	for {
+		partCtx, cancel := context.WithCancel(ctx)
+		var at fetch.Checkpoint
+		stopped := false
+		opts.OnCheckpoint = func(c fetch.Checkpoint) {
+			at = c
+			if job.switchPending() {
+				stopped = true
+				cancel()
+			}
+		}
+		opts.OnSegment = func(n, _ int) {
+			onSegment(kept+n, 0)
+		}
+		opts.OnProgress = func(written, _ int64) {
+			onProgress(keptBytes+written, 0)
+		}
+
+		err := fetch.DownloadSegments(partCtx, t.init, t.segments, parts[len(parts)-1], opts)
+		cancel()
+		next := job.takeSwitch()
+		if err == nil {
+			if next != nil {
+				job.switchFailed(next, fmt.Errorf("the variant finished downloading first"))
+			}
+			break
+		}
+		if next == nil || !stopped || ctx.Err() != nil {
+			return err
+		}
+
+		written := at.Done
+		if t.init != "" {
+			written--
+		}
+		pos := start
+		for _, seg := range t.media[:written] {
+			pos += seg.Duration
+		}
+
+		nt, from, err := job.switchTrack(ctx, t, next, pos)
+		if err != nil {
+			// Carry on with the current variant where it stopped
+			job.switchFailed(next, err)
+			opts.Resume = &at
+			continue
+		}
+
+		if err := os.Truncate(parts[len(parts)-1], at.Bytes); err != nil {
+			return err
+		}
+		job.mu.Lock()
+		if job.segments != nil {
+			job.segments.count += len(nt.segments) - (len(t.segments) - written)
+		}
+		prev := job.switcher.current
+		job.switcher.current = nt.variant
+		job.mu.Unlock()
+
+		log.Printf("[JOB %s] Switched variant at %.3fs: %s -> %s", job.ID, pos, prev.URI, nt.variant.URI)
+		job.send(ipc.Msg{
+			"type":         "variant-switched",
+			"id":           job.ID,
+			"from":         prev,
+			"to":           nt.variant,
+			"atSec":        pos,
+			"resumeSec":    from,
+			"segmentsKept": kept + written,
+		})
+
+		kept += written
+		keptBytes += at.Bytes
+		start = from
+		t = nt
+		opts.Resume = nil
+		if len(t.segments) == 0 {
+			break
+		}
+		parts = append(parts, output+".v"+strconv.Itoa(len(parts)+1))
+	}
+
+	if len(parts) == 1 {
+		return nil
+	}
+	return joinVariantParts(parts)
+}
+
+// switchTrack loads variant v's playlist and returns its track from the
+// segment starting nearest to stream time pos, along with that segment's
+// start time
+func (job *Job) switchTrack(ctx context.Context, cur track, v *hls.Variant, pos float64) (track, float64, error) {
+	media, err := hls.LoadMedia(ctx, v.URI, job.Headers)
+	if err != nil {
+		return track{}, 0, fmt.Errorf("load variant playlist: %w", err)
+	}
+	nt, err := mediaTrack(cur.kind, media)
+	if err != nil {
+		return track{}, 0, err
+	}
+	if nt.encrypted {
+		return track{}, 0, fmt.Errorf("encrypted HLS streams need the ffmpeg engine")
+	}
+	if nt.container != cur.container {
+		return track{}, 0, fmt.Errorf("variant is %s, the download so far is %s", nt.container, cur.container)
+	}
+
+	k, from := len(nt.media), 0.0
+	best := math.Inf(1)
+	var segStart float64
+	for i, seg := range nt.media {
+		if d := math.Abs(segStart - pos); d < best {
+			k, from, best = i, segStart, d
+		}
+		segStart += seg.Duration
+	}
+
+	nt.master, nt.variant = cur.master, v
+	nt.media = nt.media[k:]
+	nt.segments = nt.segments[k:]
+	return nt, from, nil
+}
+
+// switchFailed reports a switch that couldn't be made; the download goes on
+// with the current variant
+func (job *Job) switchFailed(v *hls.Variant, err error) {
+	log.Printf("[JOB %s] Can't switch to %s: %v", job.ID, v.URI, err)
+	job.send(ipc.Msg{
+		"type": "warning",
+		"id":   job.ID,
+		"code": "switch_failed",
+		"msg":  err.Error(),
+		"to":   v,
+	})
+}
+
+// joinVariantParts joins the MPEG-TS part files of a switched download by
+// appending them to the first, as players handle a variant change
+// mid-stream
+func joinVariantParts(parts []string) error {
+	out, err := os.OpenFile(parts[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts[1:] {
+		in, err := os.Open(p)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
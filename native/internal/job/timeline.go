@@ -0,0 +1,75 @@
+package job
+
+import "time"
+
+// Job phases, in the order a job passes through them. Phases a job skips
+// (no conversion, no faststart pass) are left out of its timeline.
+const (
+	PhaseQueued         = "queued"         // waiting for file descriptors
+	PhaseStarted        = "started"        // checking options, preparing inputs
+	PhaseDownloading    = "downloading"    // the download step, retries included
+	PhaseFaststart      = "faststart"      // ffmpeg moving the moov atom to the front
+	PhaseConverting     = "converting"     // the convert step
+	PhasePostprocessing = "postprocessing" // subtitles, loudness, thumbnail, clip parts
+	PhaseFinalizing     = "finalizing"     // moving into place, file attributes
+)
+
+// PhaseSpan is one phase of a job's timeline
+type PhaseSpan struct {
+	Phase      string `json:"phase"`
+	StartMs    int64  `json:"startMs"` // since the job started
+	DurationMs int64  `json:"durationMs"`
+}
+
+// enterPhase ends the current phase and starts the next. Consecutive calls
+// with the same phase are one span.
+func (job *Job) enterPhase(phase string) {
+	now := time.Now()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if n := len(job.phases); n > 0 {
+		last := &job.phases[n-1]
+		if last.Phase == phase {
+			return
+		}
+		last.DurationMs = now.Sub(job.started).Milliseconds() - last.StartMs
+	}
+	job.phases = append(job.phases, PhaseSpan{Phase: phase, StartMs: now.Sub(job.started).Milliseconds()})
+}
+
+// timeline returns the phases so far, the current one running up to now,
+// for the done and error events
+func (job *Job) timeline() []PhaseSpan {
+	now := time.Now()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	spans := append([]PhaseSpan{}, job.phases...)
+	if n := len(spans); n > 0 {
+		spans[n-1].DurationMs = now.Sub(job.started).Milliseconds() - spans[n-1].StartMs
+	}
+	return spans
+}
+
+// currentPhase returns the phase the job is in
+func (job *Job) currentPhase() string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if len(job.phases) == 0 {
+		return ""
+	}
+	return job.phases[len(job.phases)-1].Phase
+}
+
+// ffmpegActive reports whether the job's current phase runs ffmpeg, which
+// a cancel then kills
+func (job *Job) ffmpegActive() bool {
+	switch job.currentPhase() {
+	case PhaseDownloading:
+		return job.downloadUsesFFmpeg()
+	case PhaseConverting, PhaseFaststart, PhasePostprocessing:
+		return true
+	}
+	return false
+}
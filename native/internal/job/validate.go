@@ -0,0 +1,88 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/thecturner/vidown-native/internal/ff"
+	"github.com/thecturner/vidown-native/internal/ipc"
+)
+
+// Exit policies decide what an ffmpeg step's exit code and a probe of its
+// output mean together. ffmpeg sometimes exits non-zero over a benign
+// trailing error with a good file, and sometimes exits 0 with a broken one.
+const (
+	// ExitPolicyLenient (the default) fails only when both say the step
+	// failed; when they disagree the job continues with a warning
+	ExitPolicyLenient = "lenient"
+	// ExitPolicyStrict fails when either says the step failed
+	ExitPolicyStrict = "strict"
+	// ExitPolicyExitCode trusts the exit code alone
+	ExitPolicyExitCode = "exitCode"
+)
+
+// downloadUsesFFmpeg reports whether the download step's output was
+// written by ffmpeg, as opposed to stored verbatim by the Go engine
+func (job *Job) downloadUsesFFmpeg() bool {
+	switch job.Mode {
+	case "audioclip", "concat":
+		return true
+	}
+	return job.Engine != "go"
+}
+
+// canceled reports whether the job was hard-canceled, in which case ffmpeg
+// was killed and its output is incomplete whatever a probe says
+func (job *Job) canceled() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.state == StateCanceled
+}
+
+// checkFFmpegResult combines an ffmpeg step's error with validation of its
+// output according to the job's exit policy, returning the error the step
+// should be treated as having. Disagreements that don't fail the job are
+// reported as completed_with_warnings.
+func (job *Job) checkFFmpegResult(step, output string, runErr error) error {
+	policy := job.ExitPolicy
+	if policy == "" {
+		policy = ExitPolicyLenient
+	}
+	if policy == ExitPolicyExitCode || job.stopping() || job.canceled() {
+		return runErr
+	}
+
+	validErr := ff.ValidateOutput(output)
+	if errors.Is(validErr, ff.ErrNoValidator) {
+		return runErr
+	}
+
+	switch {
+	case runErr == nil && validErr == nil:
+		return nil
+	case runErr != nil && validErr != nil:
+		return runErr
+	case policy == ExitPolicyStrict:
+		if runErr != nil {
+			return runErr
+		}
+		return fmt.Errorf("ffmpeg exited cleanly but %v", validErr)
+	}
+
+	msg := ipc.Msg{
+		"type": "completed_with_warnings",
+		"id":   job.ID,
+		"step": step,
+	}
+	if runErr != nil {
+		log.Printf("[JOB %s] %s: ffmpeg failed (%v) but the output validates, continuing", job.ID, step, runErr)
+		msg["msg"] = "ffmpeg reported an error but the output is valid: " + runErr.Error()
+	} else {
+		log.Printf("[JOB %s] %s: ffmpeg succeeded but the output doesn't validate (%v), continuing", job.ID, step, validErr)
+		msg["msg"] = "ffmpeg succeeded but the output looks broken: " + validErr.Error()
+	}
+	job.send(msg)
+	job.report("completedWithWarnings", true)
+	return nil
+}
@@ -0,0 +1,73 @@
+package job
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validateWorkDir checks the WorkDir option: an existing directory inside
+// one of the InputRoots, symlinks resolved so they can't point out
+func (job *Job) validateWorkDir() error {
+	if job.WorkDir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(job.WorkDir) {
+		return fmt.Errorf("workDir must be an absolute path")
+	}
+	resolved, err := filepath.EvalSymlinks(job.WorkDir)
+	if err != nil {
+		return fmt.Errorf("workDir: %w", err)
+	}
+	if info, err := os.Stat(resolved); err != nil {
+		return fmt.Errorf("workDir: %w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("workDir %s is not a directory", job.WorkDir)
+	}
+
+	for _, dir := range job.InputRoots {
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			job.WorkDir = resolved
+			return nil
+		}
+	}
+	return fmt.Errorf("workDir %s is outside the downloads and library folders", job.WorkDir)
+}
+
+// workDir returns the directory ffmpeg runs in and relative local inputs
+// resolve against: WorkDir, else the output's folder once it exists. ""
+// leaves ffmpeg in the host's directory, wherever the browser started it.
+func (job *Job) workDir() string {
+	if job.WorkDir != "" {
+		return job.WorkDir
+	}
+	dir := filepath.Dir(job.Out)
+	if !filepath.IsAbs(dir) {
+		return ""
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
+
+// localPath resolves a relative local input, such as a subtitle file,
+// against the job's working directory. URLs and absolute paths are kept.
+func (job *Job) localPath(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	if u, err := url.Parse(p); err == nil && len(u.Scheme) > 1 {
+		return p
+	}
+	if dir := job.workDir(); dir != "" {
+		return filepath.Join(dir, p)
+	}
+	return p
+}
@@ -0,0 +1,138 @@
+// Package logfile mirrors the host's log into size-rotated files, the only
+// practical way to collect logs from a host the browser launched (stdout is
+// the messaging channel and stderr usually goes nowhere).
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultMaxSize is the size a log file is rotated at
+	DefaultMaxSize = 5 * 1024 * 1024
+	// DefaultKeep is how many rotated files are kept besides the live one
+	DefaultKeep = 3
+)
+
+// Settings configures the log file
+type Settings struct {
+	Path    string // "" = no log file
+	MaxSize int64  // bytes
+	Keep    int    // rotated files kept: path.1 (newest) .. path.Keep
+}
+
+// Writer is an io.Writer that appends to the configured file, rotating it
+// when it would grow past MaxSize. With no path set, writes are dropped.
+// It is safe for concurrent use and can be reconfigured at any time.
+type Writer struct {
+	mu   sync.Mutex
+	cfg  Settings
+	f    *os.File
+	size int64
+}
+
+// New returns a writer with no file configured
+func New() *Writer {
+	return &Writer{}
+}
+
+// Configure switches to new settings, closing the current file if the path
+// changed. Zero MaxSize and negative Keep take the defaults.
+func (w *Writer) Configure(cfg Settings) error {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+	if cfg.Keep < 0 {
+		cfg.Keep = DefaultKeep
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if cfg.Path != w.cfg.Path {
+		w.close()
+		if cfg.Path != "" {
+			if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o700); err != nil {
+				return err
+			}
+			if err := w.open(cfg.Path); err != nil {
+				return err
+			}
+		}
+	}
+	w.cfg = cfg
+	return nil
+}
+
+// Settings returns the current settings
+func (w *Writer) Settings() Settings {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cfg
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return len(p), nil
+	}
+	if w.size > 0 && w.size+int64(len(p)) > w.cfg.MaxSize {
+		if err := w.rotate(); err != nil {
+			// Keep logging to stderr even if the file is unusable
+			return len(p), nil
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		return len(p), nil
+	}
+	return n, nil
+}
+
+func (w *Writer) open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = info.Size()
+	return nil
+}
+
+func (w *Writer) close() {
+	if w.f != nil {
+		w.f.Close()
+		w.f = nil
+	}
+}
+
+// rotate shifts path.N-1 to path.N down to path to path.1, dropping the
+// oldest, and starts a fresh file
+func (w *Writer) rotate() error {
+	path := w.cfg.Path
+	w.close()
+
+	if w.cfg.Keep == 0 {
+		os.Remove(path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", path, w.cfg.Keep))
+		for i := w.cfg.Keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+		}
+		os.Rename(path, path+".1")
+	}
+
+	return w.open(path)
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// ChunkSize is the largest read a limited reader performs at once. Keeping
+// reads small lets concurrent jobs interleave their reservations fairly.
+const ChunkSize = 32 * 1024
+
+// Limiter is a token bucket shared by every job that downloads through it.
+// A rate of 0 disables limiting.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+	users  int
+}
+
+// New creates a disabled limiter
+func New() *Limiter {
+	return &Limiter{}
+}
+
+// SetRate changes the cap in bytes per second; 0 disables it
+func (l *Limiter) SetRate(bps int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bps < 0 {
+		bps = 0
+	}
+	l.rate = float64(bps)
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// Rate returns the current cap in bytes per second
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// Users returns the number of jobs currently drawing from the bucket
+func (l *Limiter) Users() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.users
+}
+
+// Share returns the fair per-job share of the cap, or 0 when unlimited
+func (l *Limiter) Share() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0
+	}
+	if l.users <= 1 {
+		return int64(l.rate)
+	}
+	return int64(l.rate / float64(l.users))
+}
+
+// Acquire registers a job as a user of the bucket. The returned func must be
+// called when the job stops downloading.
+func (l *Limiter) Acquire() func() {
+	l.mu.Lock()
+	l.users++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.users--
+			l.mu.Unlock()
+		})
+	}
+}
+
+// WaitN blocks until n bytes may be consumed. Callers reserve their slot in
+// arrival order, so jobs reading in ChunkSize pieces share the cap evenly.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.last = now
+
+	// Allow at most 100ms of burst (but always at least one chunk)
+	burst := l.rate / 10
+	if burst < ChunkSize {
+		burst = ChunkSize
+	}
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reader wraps r so every read draws from the bucket
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > ChunkSize {
+		p = p[:ChunkSize]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,211 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+
+	"github.com/thecturner/vidown-native/internal/ipc"
+	"github.com/thecturner/vidown-native/internal/job"
+	"github.com/thecturner/vidown-native/internal/state"
+)
+
+const fileName = "session.json"
+
+// How often a detached host mirrors job state, and how long the file may go
+// without an update before its running jobs are considered orphaned
+const (
+	saveInterval = time.Second
+	staleAfter   = 10 * time.Second
+)
+
+// File is the job state a detached host hands over to its successor
+type File struct {
+	Session string         `json:"session"`
+	PID     int            `json:"pid"`
+	Updated time.Time      `json:"updated"`
+	Jobs    []job.Snapshot `json:"jobs"`
+}
+
+// NewToken returns a random session token for the hello message
+func NewToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Detach keeps the host alive after the extension's port drops. Output is
+// switched off and job state is mirrored to the session file until every
+// job has finished, so a new host process can pick it up via Reattach.
+func Detach(token string, jobs *job.Manager) {
+	ipc.Detach()
+
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		save(token, jobs)
+		select {
+		case <-done:
+			save(token, jobs)
+			log.Println("[SESSION] All detached jobs finished")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func save(token string, jobs *job.Manager) {
+	err := state.WriteJSON(fileName, File{
+		Session: token,
+		PID:     os.Getpid(),
+		Updated: time.Now(),
+		Jobs:    jobs.AllSnapshots(),
+	})
+	if err != nil {
+		log.Println("[SESSION] Failed to save session:", err)
+	}
+}
+
+// Reattach handles a reattach request from a reconnected extension. On a
+// token match the previous host's jobs are replayed as list-jobs and their
+// events are forwarded until they finish; otherwise they're reported orphaned.
+func Reattach(token string) {
+	var f File
+	if err := state.ReadJSON(fileName, &f); err != nil {
+		ipc.Send(ipc.Msg{
+			"type":   "reattach-result",
+			"ok":     false,
+			"reason": "no_session",
+		})
+		return
+	}
+
+	if token == "" || f.Session != token {
+		orphaned := []string{}
+		for _, snap := range f.Jobs {
+			if snap.State == job.StateRunning {
+				orphaned = append(orphaned, snap.ID)
+			}
+		}
+		ipc.Send(ipc.Msg{
+			"type":     "reattach-result",
+			"ok":       false,
+			"reason":   "session_mismatch",
+			"orphaned": orphaned,
+		})
+		return
+	}
+
+	log.Printf("[SESSION] Reattached to session with %d job(s)", len(f.Jobs))
+
+	ipc.Send(ipc.Msg{
+		"type": "list-jobs",
+		"jobs": f.Jobs,
+	})
+	ipc.Send(ipc.Msg{
+		"type": "reattach-result",
+		"ok":   true,
+	})
+
+	go follow(f)
+}
+
+// follow polls the session file and turns job state changes into the same
+// events the detached host would have sent
+func follow(f File) {
+	last := make(map[string]job.Snapshot, len(f.Jobs))
+	for _, snap := range f.Jobs {
+		last[snap.ID] = snap
+	}
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		running := 0
+		for _, snap := range f.Jobs {
+			if prev, ok := last[snap.ID]; !ok || prev != snap {
+				emit(snap)
+				last[snap.ID] = snap
+			}
+			if snap.State == job.StateRunning {
+				running++
+			}
+		}
+
+		if running == 0 {
+			state.Remove(fileName)
+			return
+		}
+
+		if time.Since(f.Updated) > staleAfter {
+			for _, snap := range f.Jobs {
+				if snap.State == job.StateRunning {
+					ipc.Send(ipc.Msg{
+						"type": "error",
+						"id":   snap.ID,
+						"code": "orphaned",
+						"msg":  "previous host stopped before the job finished",
+					})
+				}
+			}
+			state.Remove(fileName)
+			return
+		}
+
+		<-ticker.C
+
+		var next File
+		if err := state.ReadJSON(fileName, &next); err != nil || next.Session != f.Session {
+			return
+		}
+		f = next
+	}
+}
+
+func emit(snap job.Snapshot) {
+	switch snap.State {
+	case job.StateRunning:
+		ipc.Send(ipc.Msg{
+			"type":          "progress",
+			"id":            snap.ID,
+			"bytesReceived": snap.BytesReceived,
+			"totalBytes":    snap.TotalBytes,
+			"percent":       snap.Percent,
+		})
+	case job.StateDone:
+		var size int64
+		if stat, err := os.Stat(snap.Final); err == nil {
+			size = stat.Size()
+		}
+		ipc.Send(ipc.Msg{
+			"type":         "done",
+			"id":           snap.ID,
+			"final":        snap.Final,
+			"bytesWritten": size,
+		})
+	case job.StateError:
+		ipc.Send(ipc.Msg{
+			"type": "error",
+			"id":   snap.ID,
+			"code": snap.Code,
+			"msg":  snap.Error,
+		})
+	case job.StateCanceled:
+		ipc.Send(ipc.Msg{
+			"type": "canceled",
+			"id":   snap.ID,
+		})
+	}
+}